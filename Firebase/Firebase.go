@@ -65,6 +65,8 @@ func ServeFirebase(keypath string) error {
 			}
 		case wasabee.FbccLinkAssignmentChange:
 			_ = linkAssignmentChange(ctx, msg, fb)
+		case wasabee.FbccWaveCountdown:
+			_ = waveCountdown(ctx, msg, fb)
 		case wasabee.FbccSubscribeTeam:
 			_ = subscribeToTeam(ctx, msg, fb)
 		default:
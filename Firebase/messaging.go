@@ -215,6 +215,44 @@ func linkAssignmentChange(ctx context.Context, c *messaging.Client, fb wasabee.F
 	return nil
 }
 
+// waveCountdown tells an agent how many seconds until a wave they have links in, 0 being throw now
+func waveCountdown(ctx context.Context, c *messaging.Client, fb wasabee.FirebaseCmd) error {
+	if fb.Gid == "" {
+		return nil
+	}
+
+	tokens, err := fb.Gid.FirebaseTokens()
+	if err != nil {
+		wasabee.Log.Error(err)
+		return err
+	}
+
+	for _, token := range tokens {
+		if token == "" {
+			continue
+		}
+
+		data := map[string]string{
+			"opID":   string(fb.OpID),
+			"waveID": fb.ObjID,
+			"msg":    fb.Msg,
+			"cmd":    fb.Cmd.String(),
+		}
+
+		msg := messaging.Message{
+			Token: token,
+			Data:  data,
+		}
+
+		_, err = c.Send(ctx, &msg)
+		if err != nil {
+			wasabee.Log.Error(err)
+			return err
+		}
+	}
+	return nil
+}
+
 func subscribeToTeam(ctx context.Context, c *messaging.Client, fb wasabee.FirebaseCmd) error {
 	if fb.Gid == "" {
 		return nil
@@ -61,6 +61,9 @@ func assignmentKeyboard(gid wasabee.GoogleID) tgbotapi.InlineKeyboardMarkup {
 				var row []tgbotapi.InlineKeyboardButton
 				var action, reject tgbotapi.InlineKeyboardButton
 				title := fmt.Sprintf("%s %s - Complete", marker.Type, a.Portals[marker.PortalID].Name)
+				if p, ok := a.Phases[marker.Phase]; ok {
+					title = fmt.Sprintf("%s (%s)", title, p.Name)
+				}
 				cmd := fmt.Sprintf("marker/complete/%s", marker.ID)
 				rcmd := fmt.Sprintf("marker/reject/%s", marker.ID)
 				action = tgbotapi.NewInlineKeyboardButtonData(title, cmd)
@@ -9,9 +9,12 @@ import (
 func BackgroundTasks(c chan os.Signal) {
 	Log.Debug("running initial tasks")
 	locationClean()
+	waveSchedule()
 
 	ticker := time.NewTicker(time.Hour)
 	defer ticker.Stop()
+	minutes := time.NewTicker(time.Minute)
+	defer minutes.Stop()
 
 	for {
 		select {
@@ -20,29 +23,25 @@ func BackgroundTasks(c chan os.Signal) {
 			return
 		case <-ticker.C:
 			locationClean()
+			eventStatsLog()
+		case <-minutes.C:
+			phaseReminders()
+			waveSchedule()
 		}
 	}
 }
 
 func locationClean() {
-	r, err := db.Query("SELECT gid FROM locations WHERE loc != POINTFROMTEXT(?) AND upTime < DATE_SUB(NOW(), INTERVAL 3 HOUR)", "POINT(0 0)")
-	if err != nil {
+	if err := store.ExpireLocations(3 * time.Hour); err != nil {
 		Log.Error(err)
-		return
 	}
-	defer r.Close()
+}
 
-	var gid GoogleID
-	for r.Next() {
-		err = r.Scan(&gid)
-		if err != nil {
-			Log.Error(err)
-			continue
-		}
-		_, err = db.Exec("UPDATE locations SET loc = POINTFROMTEXT(?), upTime = NOW() WHERE gid = ?", "POINT(0 0)", gid)
-		if err != nil {
-			Log.Error(err)
-			continue
+// eventStatsLog reports event subscribers which have been dropping events
+func eventStatsLog() {
+	for _, s := range EventStats() {
+		if s.Dropped > 0 {
+			Log.Noticef("event subscriber %s: %d delivered, %d dropped, %d queued", s.Name, s.Delivered, s.Dropped, s.Queued)
 		}
 	}
 }
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/op/go-logging"
+	"github.com/urfave/cli"
+	"github.com/wasabee-project/Wasabee-Server"
+)
+
+var flags = []cli.Flag{
+	cli.StringFlag{
+		Name: "database, d", EnvVar: "DATABASE", Value: "wasabee:GoodPassword@tcp(localhost)/wasabee",
+		Usage: "MySQL/MariaDB connection string. It is recommended to pass this parameter as an environment variable."},
+	cli.BoolFlag{
+		Name:  "apply, a",
+		Usage: "Apply the pending migrations. Without this only a dry-run is performed."},
+	cli.BoolFlag{
+		Name:  "verbose, v",
+		Usage: "Show the SQL for each pending migration."},
+	cli.BoolFlag{
+		Name: "debug", EnvVar: "DEBUG",
+		Usage: "Show (a lot) more output."},
+	cli.BoolFlag{
+		Name:  "help, h",
+		Usage: "Shows this help, then exits."},
+}
+
+func main() {
+	app := cli.NewApp()
+
+	app.Name = "wasabee-migrate"
+	app.Version = "0.0.1"
+	app.Usage = "WASABI database schema migrations"
+	app.Authors = []cli.Author{
+		{
+			Name:  "Scot C. Bontrager",
+			Email: "scot@indievisible.org",
+		},
+	}
+	app.Copyright = "© Scot C. Bontrager"
+	app.HelpName = "wasabee-migrate"
+	app.Flags = flags
+	app.HideHelp = true
+	cli.AppHelpTemplate = strings.Replace(cli.AppHelpTemplate, "GLOBAL OPTIONS:", "OPTIONS:", 1)
+
+	app.Action = run
+
+	_ = app.Run(os.Args)
+}
+
+func run(c *cli.Context) error {
+	if c.Bool("help") {
+		_ = cli.ShowAppHelp(c)
+		return nil
+	}
+
+	if c.Bool("debug") {
+		wasabee.SetLogLevel(logging.DEBUG)
+	}
+
+	// do not let Connect apply anything on its own
+	wasabee.SetAutoMigrate(false)
+	err := wasabee.Connect(c.String("database"))
+	if err != nil {
+		wasabee.Log.Errorf("Error connecting to database: %s", err)
+		return err
+	}
+	defer wasabee.Disconnect()
+
+	current, err := wasabee.SchemaVersion()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("schema version: %d (latest: %d)\n", current, wasabee.LatestSchemaVersion())
+
+	dryrun := !c.Bool("apply")
+	migrations, err := wasabee.Migrate(dryrun)
+	for _, m := range migrations {
+		if dryrun {
+			fmt.Printf("pending: %d %s\n", m.Version, m.Description)
+		} else {
+			fmt.Printf("applied: %d %s\n", m.Version, m.Description)
+		}
+		if c.Bool("verbose") {
+			for _, s := range m.Steps {
+				fmt.Printf("\t%s\n", s)
+			}
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if len(migrations) == 0 {
+		fmt.Println("nothing to do")
+	}
+	return nil
+}
@@ -24,7 +24,7 @@ import (
 var flags = []cli.Flag{
 	cli.StringFlag{
 		Name: "database, d", EnvVar: "DATABASE", Value: "wasabee:GoodPassword@tcp(localhost)/wasabee",
-		Usage: "MySQL/MariaDB connection string. It is recommended to pass this parameter as an environment variable"},
+		Usage: "MySQL/MariaDB connection string, or \"memory\" to keep everything in memory (development only). It is recommended to pass this parameter as an environment variable"},
 	cli.StringFlag{
 		Name: "certs", EnvVar: "CERTDIR", Value: "./certs/",
 		Usage: "Directory where HTTPS certificates are stored"},
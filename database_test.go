@@ -20,3 +20,22 @@ func TestQuery(t *testing.T) {
 		t.Error("did not find the correct gid for deviousness")
 	}
 }
+
+func TestSchemaVersion(t *testing.T) {
+	// TestMain's Connect should have applied everything
+	v, err := wasabee.SchemaVersion()
+	if err != nil {
+		t.Error(err.Error())
+	}
+	if v != wasabee.LatestSchemaVersion() {
+		t.Errorf("schema version %d, expected %d", v, wasabee.LatestSchemaVersion())
+	}
+
+	pending, err := wasabee.PendingMigrations()
+	if err != nil {
+		t.Error(err.Error())
+	}
+	if len(pending) != 0 {
+		t.Errorf("%d migrations still pending", len(pending))
+	}
+}
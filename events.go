@@ -0,0 +1,207 @@
+package wasabee
+
+import (
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// EventType says what changed
+type EventType string
+
+// EventAgentLocation et al. are the events published on the event bus
+const (
+	EventAgentLocation    EventType = "agentLocation"
+	EventTeamState        EventType = "teamState"
+	EventTeamJoin         EventType = "teamJoin"
+	EventTeamLeave        EventType = "teamLeave"
+	EventTeamAnnounce     EventType = "teamAnnounce"
+	EventFirebaseToken    EventType = "firebaseToken"
+	EventOpChange         EventType = "opChange"
+	EventOpMeta           EventType = "opMeta"
+	EventOpTeam           EventType = "opTeam"
+	EventOpDeleted        EventType = "opDeleted"
+	EventOpPhase          EventType = "opPhase"
+	EventLinkAssignment   EventType = "linkAssignment"
+	EventLinkDescription  EventType = "linkDescription"
+	EventLinkStatus       EventType = "linkStatus"
+	EventLinkOrder        EventType = "linkOrder"
+	EventLinkColor        EventType = "linkColor"
+	EventLinkSwap         EventType = "linkSwap"
+	EventLinkDeleted      EventType = "linkDeleted"
+	EventLinkPhase        EventType = "linkPhase"
+	EventMarkerAssignment EventType = "markerAssignment"
+	EventMarkerComment    EventType = "markerComment"
+	EventMarkerStatus     EventType = "markerStatus"
+	EventMarkerOrder      EventType = "markerOrder"
+	EventMarkerDeleted    EventType = "markerDeleted"
+	EventMarkerPhase      EventType = "markerPhase"
+	EventPortalHardness   EventType = "portalHardness"
+	EventPortalComment    EventType = "portalComment"
+	EventPortalKeys       EventType = "portalKeys"
+	EventBlockerReported  EventType = "blockerReported"
+	EventBlockerCleared   EventType = "blockerCleared"
+	EventBlockerDeleted   EventType = "blockerDeleted"
+	EventWaveChanged      EventType = "waveChanged"
+	EventWaveCountdown    EventType = "waveCountdown"
+)
+
+// Event is something which happened in the model; subscribers each get their own copy
+type Event struct {
+	ID       int64 // set when published, in order
+	Type     EventType
+	OpID     OperationID
+	TeamID   TeamID
+	ObjectID string
+	Gid      GoogleID    // the agent who made the change
+	Agent    GoogleID    // the agent it was done to, e.g. the new assignee
+	Action   string      // one word for the audit log
+	Detail   string      // a short description for the audit log
+	Data     interface{} // what changed, sent to clients as JSON
+}
+
+// object is the kind of thing the event is about: operation, portal, link (blockers are links), wave, marker, team or agent
+func (t EventType) object() string {
+	s := string(t)
+	switch {
+	case strings.HasPrefix(s, "link"), strings.HasPrefix(s, "blocker"):
+		return "link"
+	case strings.HasPrefix(s, "wave"):
+		// kept apart so the link history is only links
+		return "wave"
+	case strings.HasPrefix(s, "marker"):
+		return "marker"
+	case strings.HasPrefix(s, "portal"):
+		return "portal"
+	case t == EventOpTeam || strings.HasPrefix(s, "team"):
+		return "team"
+	case strings.HasPrefix(s, "op"):
+		return "operation"
+	default:
+		return "agent"
+	}
+}
+
+// EventSubscription receives a copy of every event published after it was created
+type EventSubscription struct {
+	delivered uint64 // first, so the atomic counters are 64-bit aligned on 32-bit platforms
+	dropped   uint64
+	name      string
+	c         chan Event
+	wait      bool // publishing waits for room rather than dropping
+}
+
+var bus struct {
+	mu     sync.RWMutex
+	subs   []*EventSubscription
+	lastID int64
+}
+
+// SubscribeEvents adds a subscriber to the event bus. Publishing never waits: once buffer events are queued
+// for a subscriber, further events are dropped for it (and counted) until it catches up.
+func SubscribeEvents(name string, buffer int) *EventSubscription {
+	s := &EventSubscription{
+		name: name,
+		c:    make(chan Event, buffer),
+	}
+
+	bus.mu.Lock()
+	defer bus.mu.Unlock()
+	bus.subs = append(bus.subs, s)
+	return s
+}
+
+// SubscribeEventsWait adds a subscriber which must not miss anything, such as the messages to agents.
+// Once buffer events are queued for it, publishing waits for it to catch up.
+func SubscribeEventsWait(name string, buffer int) *EventSubscription {
+	s := SubscribeEvents(name, buffer)
+	s.wait = true
+	return s
+}
+
+// Events is the channel the subscriber reads from; it is closed by Close
+func (s *EventSubscription) Events() <-chan Event {
+	return s.c
+}
+
+// Close removes the subscriber from the bus
+func (s *EventSubscription) Close() {
+	bus.mu.Lock()
+	defer bus.mu.Unlock()
+
+	for i, x := range bus.subs {
+		if x == s {
+			bus.subs = append(bus.subs[:i], bus.subs[i+1:]...)
+			close(s.c)
+			return
+		}
+	}
+}
+
+// Dropped is the number of events this subscriber has missed because it was not keeping up
+func (s *EventSubscription) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+// EventStat is the delivery count for one subscriber
+type EventStat struct {
+	Name      string `json:"name"`
+	Queued    int    `json:"queued"`
+	Delivered uint64 `json:"delivered"`
+	Dropped   uint64 `json:"dropped"`
+}
+
+// EventStats reports how each subscriber is keeping up
+func EventStats() []EventStat {
+	bus.mu.RLock()
+	defer bus.mu.RUnlock()
+
+	stats := make([]EventStat, 0, len(bus.subs))
+	for _, s := range bus.subs {
+		stats = append(stats, EventStat{
+			Name:      s.name,
+			Queued:    len(s.c),
+			Delivered: atomic.LoadUint64(&s.delivered),
+			Dropped:   atomic.LoadUint64(&s.dropped),
+		})
+	}
+	return stats
+}
+
+// publishEvent hands the event to every subscriber without waiting on any of them
+func publishEvent(e Event) {
+	bus.mu.RLock()
+	defer bus.mu.RUnlock()
+
+	e.ID = atomic.AddInt64(&bus.lastID, 1)
+
+	for _, s := range bus.subs {
+		if s.wait {
+			s.c <- e
+			atomic.AddUint64(&s.delivered, 1)
+			continue
+		}
+		select {
+		case s.c <- e:
+			atomic.AddUint64(&s.delivered, 1)
+		default:
+			// log the first and then every hundredth so a stuck subscriber does not flood the log
+			if n := atomic.AddUint64(&s.dropped, 1); n == 1 || n%100 == 0 {
+				Log.Warningf("event subscriber %s is not keeping up: %d events dropped", s.name, n)
+			}
+		}
+	}
+}
+
+// lastEventID is the ID of the most recently published event
+func lastEventID() int64 {
+	return atomic.LoadInt64(&bus.lastID)
+}
+
+// emit records the event in the op's audit log and publishes it
+func (opID OperationID) emit(e Event) {
+	e.OpID = opID
+	// written here rather than by a subscriber, so a busy bus cannot lose audit entries
+	auditEvent(e)
+	publishEvent(e)
+}
@@ -0,0 +1,61 @@
+package wasabee_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/wasabee-project/Wasabee-Server"
+)
+
+func TestEventBus(t *testing.T) {
+	fast := wasabee.SubscribeEvents("test fast", 10)
+	defer fast.Close()
+	stuck := wasabee.SubscribeEvents("test stuck", 0)
+	defer stuck.Close()
+
+	// publishing must not wait on a subscriber which is not reading
+	if err := gid.AgentLocation("35.5", "139.5"); err != nil {
+		t.Error(err.Error())
+	}
+
+	select {
+	case e := <-fast.Events():
+		if e.Type != wasabee.EventAgentLocation || e.Gid != gid {
+			t.Errorf("unexpected event: %v", e)
+		}
+	case <-time.After(time.Second):
+		t.Error("no event received")
+	}
+	if stuck.Dropped() != 1 {
+		t.Errorf("expected 1 dropped event, got %d", stuck.Dropped())
+	}
+}
+
+func TestEventBusWait(t *testing.T) {
+	wait := wasabee.SubscribeEventsWait("test wait", 1)
+	defer wait.Close()
+
+	// more events than the buffer holds: publishing waits instead of dropping
+	go func() {
+		for i := 0; i < 3; i++ {
+			if err := gid.AgentLocation("35.5", "139.5"); err != nil {
+				t.Error(err.Error())
+			}
+		}
+	}()
+	for i := 0; i < 3; i++ {
+		select {
+		case <-wait.Events():
+		case <-time.After(time.Second):
+			t.Fatalf("event %d not received", i)
+		}
+	}
+	if wait.Dropped() != 0 {
+		t.Errorf("expected no dropped events, got %d", wait.Dropped())
+	}
+	// keep reading until closed, so nothing else published meanwhile waits on it
+	go func() {
+		for range wait.Events() {
+		}
+	}()
+}
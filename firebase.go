@@ -1,12 +1,12 @@
 package wasabee
 
 import (
-	"database/sql"
+	"strconv"
 )
 
 var fb struct {
-	running bool
-	c       chan FirebaseCmd
+	c   chan FirebaseCmd
+	sub *EventSubscription
 }
 
 // FirebaseCommandCode is the command codes used for communicating with the Firebase module
@@ -23,6 +23,7 @@ const (
 	FbccLinkStatusChange
 	FbccLinkAssignmentChange
 	FbccSubscribeTeam
+	FbccWaveCountdown
 )
 
 // FirebaseCmd is the struct passed to the Firebase module to take actions -- required params depend on the FBCC
@@ -35,170 +36,93 @@ type FirebaseCmd struct {
 	Msg    string
 }
 
-// FirebaseInit creates the channel used to pass messages to the Firebase subsystem
+// FirebaseInit subscribes to the event bus and returns the channel used to pass messages to the Firebase subsystem
 func FirebaseInit() <-chan FirebaseCmd {
 	out := make(chan FirebaseCmd, 3)
 
 	fb.c = out
-	fb.running = true
+	fb.sub = SubscribeEvents("firebase", 256)
+	go firebaseEvents(fb.sub, out)
 	return out
 }
 
 // FirebaseClose shuts down the channel when done
 func FirebaseClose() {
-	if fb.running {
+	if fb.sub != nil {
 		Log.Debug("shutting down firebase")
-		fb.running = false
-		close(fb.c)
+		fb.sub.Close()
+		fb.sub = nil
 	}
 }
 
 func (cc FirebaseCommandCode) String() string {
-	return [...]string{"Quit", "Generic Message", "Agent Location Change", "Map Change", "Marker Status Change", "Marker Assignment Change", "Link Status Change", "Link Assignment Change", "Subscribe"}[cc]
+	return [...]string{"Quit", "Generic Message", "Agent Location Change", "Map Change", "Marker Status Change", "Marker Assignment Change", "Link Status Change", "Link Assignment Change", "Subscribe", "Wave Countdown"}[cc]
 }
 
-// Functions called from Wasabee to message the firebase subsystem
-func fbPush(fbc FirebaseCmd) {
-	if !fb.running {
-		Log.Debug("Firebase is not running, not sending msg")
-		return
-	}
-	fb.c <- fbc
-}
-
-// notifiy all subscribers to the agent's teams that they have moved
-// do not share the location since it is possible to subscribe to firebase topics without being on the team
-func (gid GoogleID) firebaseAgentLocation() {
-	if !fb.running {
-		return
-	}
-
-	for _, tid := range gid.teamList() {
-		fbPush(FirebaseCmd{
-			Cmd:    FbccAgentLocationChange,
-			TeamID: tid,
-			Gid:    gid,
-			Msg:    tid.String(),
-		})
-	}
-}
-
-// notifiy the agent that they have a new assigned marker in a given op
-func (opID OperationID) firebaseAssignMarker(gid GoogleID, markerID MarkerID) {
-	if !fb.running {
-		return
-	}
-
-	fbPush(FirebaseCmd{
-		Cmd:   FbccMarkerAssignmentChange,
-		OpID:  opID,
-		ObjID: string(markerID),
-		Gid:   gid,
-		Msg:   "assigned",
-	})
-}
-
-// notify a team that a marker's status has changed
-func (o *Operation) firebaseMarkerStatus(markerID MarkerID, status string) {
-	if !fb.running {
-		return
-	}
-
-	if len(o.Teams) == 0 {
-		_ = o.PopulateTeams()
-	}
-	for _, t := range o.Teams {
-		fbPush(FirebaseCmd{
-			Cmd:    FbccMarkerStatusChange,
-			TeamID: t.TeamID,
-			OpID:   o.ID,
-			ObjID:  string(markerID),
-			Msg:    status,
-		})
-	}
-}
-
-// notifiy the agent that they have a new assigned marker in a given op
-func (opID OperationID) firebaseAssignLink(gid GoogleID, linkID LinkID) {
-	if !fb.running {
-		return
-	}
-
-	fbPush(FirebaseCmd{
-		Cmd:   FbccLinkAssignmentChange,
-		OpID:  opID,
-		ObjID: string(linkID),
-		Gid:   gid,
-		Msg:   "assigned",
-	})
-}
-
-func (o *Operation) firebaseLinkStatus(linkID LinkID, completed bool) {
-	if !fb.running {
-		return
-	}
-
-	msg := "complete"
-	if !completed {
-		msg = "incomplete"
-	}
-
-	if len(o.Teams) == 0 {
-		_ = o.PopulateTeams()
-	}
-	for _, t := range o.Teams {
-		fbPush(FirebaseCmd{
-			Cmd:    FbccLinkStatusChange,
-			TeamID: t.TeamID,
-			OpID:   o.ID,
-			ObjID:  string(linkID),
-			Msg:    msg,
-		})
-	}
-}
-
-func (o *Operation) firebaseMapChange() {
-	if !fb.running {
-		return
-	}
+// firebaseEvents turns events into Firebase commands; a slow Firebase only holds up this goroutine, the bus drops what it cannot queue
+func firebaseEvents(sub *EventSubscription, out chan<- FirebaseCmd) {
+	defer close(out)
 
-	if len(o.Teams) == 0 {
-		_ = o.PopulateTeams()
-	}
-	for _, t := range o.Teams {
-		fbPush(FirebaseCmd{
-			Cmd:    FbccMapChange,
-			TeamID: t.TeamID,
-			OpID:   o.ID,
-			Msg:    "changed",
-		})
-	}
-}
-
-func (gid GoogleID) firebaseSubscribeTeam(teamID TeamID) {
-	if !fb.running {
-		return
-	}
-
-	fbPush(FirebaseCmd{
-		Cmd:    FbccSubscribeTeam,
-		Gid:    gid,
-		TeamID: teamID,
-		Msg:    "subscribe",
-	})
-}
+	for e := range sub.Events() {
+		switch e.Type {
+		case EventAgentLocation:
+			// do not share the location since it is possible to subscribe to firebase topics without being on the team
+			for _, tid := range e.Gid.teamList() {
+				out <- FirebaseCmd{Cmd: FbccAgentLocationChange, TeamID: tid, Gid: e.Gid, Msg: tid.String()}
+			}
+			continue
+		case EventTeamState:
+			msg := "subscribe"
+			if e.Detail != "On" {
+				msg = "unsubscribe"
+			}
+			out <- FirebaseCmd{Cmd: FbccSubscribeTeam, Gid: e.Gid, TeamID: e.TeamID, Msg: msg}
+			continue
+		case EventFirebaseToken:
+			for _, tid := range e.Gid.teamList() {
+				out <- FirebaseCmd{Cmd: FbccSubscribeTeam, Gid: e.Gid, TeamID: tid, Msg: "subscribe"}
+			}
+			continue
+		case EventMarkerAssignment:
+			// notifiy the agent that they have a new assigned marker in a given op
+			if e.Agent != "" {
+				out <- FirebaseCmd{Cmd: FbccMarkerAssignmentChange, OpID: e.OpID, ObjID: e.ObjectID, Gid: e.Agent, Msg: "assigned"}
+			}
+		case EventLinkAssignment:
+			if e.Agent != "" {
+				out <- FirebaseCmd{Cmd: FbccLinkAssignmentChange, OpID: e.OpID, ObjID: e.ObjectID, Gid: e.Agent, Msg: "assigned"}
+			}
+		case EventWaveCountdown:
+			// only the agents throwing in the wave; the map has not changed
+			if c, ok := e.Data.(WaveCountdown); ok {
+				for _, gid := range c.Agents {
+					out <- FirebaseCmd{Cmd: FbccWaveCountdown, OpID: e.OpID, ObjID: string(c.Wave), Gid: gid, Msg: strconv.Itoa(c.Seconds)}
+				}
+			}
+			continue
+		}
+		if e.OpID == "" || e.Type == EventOpDeleted {
+			continue
+		}
 
-func (gid GoogleID) firebaseUnsubscribeTeam(teamID TeamID) {
-	if !fb.running {
-		return
+		teams, err := store.OperationTeams(e.OpID)
+		if err != nil {
+			Log.Error(err)
+			continue
+		}
+		for _, t := range teams {
+			switch e.Type {
+			case EventMarkerStatus:
+				// notify a team that a marker's status has changed
+				if data, ok := e.Data.(map[string]string); ok {
+					out <- FirebaseCmd{Cmd: FbccMarkerStatusChange, TeamID: t.TeamID, OpID: e.OpID, ObjID: e.ObjectID, Msg: data["state"]}
+				}
+			case EventLinkStatus:
+				out <- FirebaseCmd{Cmd: FbccLinkStatusChange, TeamID: t.TeamID, OpID: e.OpID, ObjID: e.ObjectID, Msg: e.Action}
+			}
+			out <- FirebaseCmd{Cmd: FbccMapChange, TeamID: t.TeamID, OpID: e.OpID, Msg: "changed"}
+		}
 	}
-
-	fbPush(FirebaseCmd{
-		Cmd:    FbccSubscribeTeam,
-		Gid:    gid,
-		TeamID: teamID,
-		Msg:    "unsubscribe",
-	})
 }
 
 // Functions called from Firebase to use Wasabee resources
@@ -206,28 +130,11 @@ func (gid GoogleID) firebaseUnsubscribeTeam(teamID TeamID) {
 // FirebaseTokens gets an agents FirebaseToken from the database
 // token may be "" if it has not been set for a user
 func (gid GoogleID) FirebaseTokens() ([]string, error) {
-	var token string
-	var toks []string
-
-	rows, err := db.Query("SELECT DISTINCT token FROM firebase WHERE gid = ?", gid)
-	if err != nil && err != sql.ErrNoRows {
+	toks, err := store.FirebaseTokens(gid)
+	if err != nil {
 		Log.Error(err)
 		return toks, err
 	}
-	// this is technically redundant with the main return, but be explicit about what we want
-	if err != nil && err == sql.ErrNoRows {
-		return toks, nil
-	}
-
-	for rows.Next() {
-		err := rows.Scan(&token)
-		if err != nil {
-			Log.Error(err)
-			continue
-		}
-		toks = append(toks, token)
-	}
-
 	return toks, nil
 }
 
@@ -235,23 +142,21 @@ func (gid GoogleID) FirebaseTokens() ([]string, error) {
 // gid is not unique, an agent may have any number of tokens (e.g. multiple devices/browsers) -- need a cleaning mechanism
 func (gid GoogleID) FirebaseInsertToken(token string) error {
 	// XXX ensure the token is unique, maybe add a unique key for it?
-	_, err := db.Exec("INSERT INTO firebase (gid, token) VALUES (?, ?)", gid, token)
+	err := store.InsertFirebaseToken(gid, token)
 	if err != nil {
 		Log.Error(err)
 		return err
 	}
 
-	tl := gid.teamList()
-	for _, teamID := range tl {
-		gid.firebaseSubscribeTeam(teamID)
-	}
+	// subscribe the new token to the agent's teams
+	publishEvent(Event{Type: EventFirebaseToken, Gid: gid})
 
 	return nil
 }
 
 // FirebaseRemoveToken removes known token for a given user
 func (gid GoogleID) FirebaseRemoveToken(token string) error {
-	_, err := db.Exec("DELETE FROM firebase WHERE gid = ? AND token = ?", gid, token)
+	err := store.DeleteFirebaseToken(gid, token)
 	if err != nil {
 		Log.Error(err)
 		return err
@@ -260,7 +165,7 @@ func (gid GoogleID) FirebaseRemoveToken(token string) error {
 }
 
 func (gid GoogleID) FirebaseRemoveAllTokens() error {
-	_, err := db.Exec("DELETE FROM firebase WHERE gid = ?", gid)
+	err := store.DeleteFirebaseTokens(gid)
 	if err != nil {
 		Log.Error(err)
 		return err
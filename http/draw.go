@@ -2,6 +2,7 @@ package wasabeehttps
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
@@ -63,6 +64,39 @@ func pDrawUploadRoute(res http.ResponseWriter, req *http.Request) {
 	fmt.Fprint(res, string(data))
 }
 
+// pDrawImportRoute takes the file as the body and the format and op name as query parameters
+func pDrawImportRoute(res http.ResponseWriter, req *http.Request) {
+	res.Header().Set("Content-Type", jsonType)
+
+	gid, err := getAgentID(req)
+	if err != nil {
+		wasabee.Log.Notice(err)
+		http.Error(res, jsonError(err), http.StatusInternalServerError)
+		return
+	}
+
+	data, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		wasabee.Log.Notice(err)
+		http.Error(res, jsonError(err), http.StatusInternalServerError)
+		return
+	}
+	if len(data) == 0 {
+		wasabee.Log.Notice("empty import")
+		http.Error(res, jsonStatusEmpty, http.StatusNotAcceptable)
+		return
+	}
+
+	report, err := wasabee.DrawImport(data, req.URL.Query().Get("format"), req.URL.Query().Get("name"), gid)
+	if err != nil {
+		wasabee.Log.Notice(err)
+		http.Error(res, jsonError(err), http.StatusNotAcceptable)
+		return
+	}
+	out, _ := json.Marshal(report)
+	fmt.Fprint(res, string(out))
+}
+
 func pDrawGetRoute(res http.ResponseWriter, req *http.Request) {
 	vars := mux.Vars(req)
 	id := vars["document"]
@@ -96,9 +130,22 @@ func pDrawGetRoute(res http.ResponseWriter, req *http.Request) {
 	}
 	res.Header().Set("Last-Modified", lastModified.Format(time.RFC1123))
 
+	etag := o.ETag()
+	res.Header().Set("ETag", etag)
+
 	var skipsending bool
+	inm := req.Header.Get("If-None-Match")
+	if inm != "" {
+		for _, t := range strings.Split(inm, ",") {
+			t = strings.TrimPrefix(strings.TrimSpace(t), "W/")
+			if t == etag || t == "*" {
+				skipsending = true
+			}
+		}
+	}
+	// If-Modified-Since only counts when there is no If-None-Match (RFC 7232 3.3)
 	ims := req.Header.Get("If-Modified-Since")
-	if ims != "" && ims != "null" { // yes, the string "null", seen in the wild
+	if inm == "" && ims != "" && ims != "null" { // yes, the string "null", seen in the wild
 		modifiedSince, err := time.ParseInLocation(time.RFC1123, ims, time.UTC)
 		if err != nil {
 			wasabee.Log.Error(err)
@@ -215,17 +262,83 @@ func pDrawUpdateRoute(res http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	pre, err := updatePrecondition(req)
+	if err == errNoPrecondition {
+		wasabee.Log.Notice(err)
+		http.Error(res, jsonError(err), http.StatusPreconditionRequired)
+		return
+	}
+	if err != nil {
+		wasabee.Log.Notice(err)
+		http.Error(res, jsonError(err), http.StatusBadRequest)
+		return
+	}
+
 	jRaw := json.RawMessage(jBlob)
 
 	// wasabee.Log.Debug(string(jBlob))
-	if err = wasabee.DrawUpdate(wasabee.OperationID(id), jRaw, gid); err != nil {
-		wasabee.Log.Notice(err)
+	if err = wasabee.DrawUpdate(wasabee.OperationID(id), jRaw, gid, pre); err != nil {
+		wasabee.Log.Notice(err)
+		// both sides changed the same things, send the list so the agent can sort it out
+		if me, ok := err.(*wasabee.OpMergeError); ok {
+			data, _ := json.Marshal(struct {
+				Status    string                   `json:"status"`
+				Error     string                   `json:"error"`
+				Conflicts []wasabee.RejectedObject `json:"conflicts"`
+			}{"error", me.Error(), me.Conflicts})
+			http.Error(res, string(data), http.StatusConflict)
+			return
+		}
+		// someone else got there first and the server could not merge, send the current stat so the client can refetch
+		if me, ok := err.(*wasabee.OpModifiedError); ok {
+			res.Header().Set("ETag", me.Current.ETag())
+			data, _ := json.Marshal(me.Current)
+			http.Error(res, string(data), http.StatusPreconditionFailed)
+			return
+		}
+		// nothing was changed, tell the client what was wrong with the update
+		if ue, ok := err.(*wasabee.OpUpdateError); ok {
+			data, _ := json.Marshal(struct {
+				Status   string                   `json:"status"`
+				Error    string                   `json:"error"`
+				Rejected []wasabee.RejectedObject `json:"rejected"`
+			}{"error", ue.Error(), ue.Rejected})
+			http.Error(res, string(data), http.StatusUnprocessableEntity)
+			return
+		}
 		http.Error(res, jsonError(err), http.StatusInternalServerError)
 		return
 	}
+	if stat, err := wasabee.OperationID(id).Stat(); err == nil {
+		res.Header().Set("ETag", stat.ETag())
+	}
 	fmt.Fprint(res, jsonStatusOK)
 }
 
+var errNoPrecondition = errors.New("updates require If-Match or If-Unmodified-Since")
+
+// updatePrecondition returns the version (from If-Match) or the time (from If-Unmodified-Since) of the client's copy of the op.
+// "If-Match: *" is an explicit request to overwrite whatever is there, which gives the empty precondition.
+// It returns errNoPrecondition when neither header is sent, any other error means a header could not be parsed.
+func updatePrecondition(req *http.Request) (wasabee.OpPrecondition, error) {
+	if im := strings.TrimSpace(req.Header.Get("If-Match")); im != "" {
+		if im == "*" {
+			return wasabee.OpPrecondition{}, nil
+		}
+		return wasabee.ParseOpETag(im)
+	}
+
+	if ius := req.Header.Get("If-Unmodified-Since"); ius != "" {
+		t, err := time.ParseInLocation(time.RFC1123, ius, time.UTC)
+		if err != nil {
+			return wasabee.OpPrecondition{}, fmt.Errorf("invalid If-Unmodified-Since: %s", ius)
+		}
+		return wasabee.OpPrecondition{UnmodifiedSince: t}, nil
+	}
+
+	return wasabee.OpPrecondition{}, errNoPrecondition
+}
+
 func pDrawChownRoute(res http.ResponseWriter, req *http.Request) {
 	res.Header().Set("Content-Type", jsonType)
 
@@ -335,7 +448,7 @@ func pDrawLinkAssignRoute(res http.ResponseWriter, req *http.Request) {
 	if op.WriteAccess(gid) {
 		link := wasabee.LinkID(vars["link"])
 		agent := wasabee.GoogleID(req.FormValue("agent"))
-		err := op.AssignLink(link, agent)
+		err := op.AssignLink(link, agent, gid)
 		if err != nil {
 			wasabee.Log.Notice(err)
 			http.Error(res, jsonError(err), http.StatusInternalServerError)
@@ -368,7 +481,7 @@ func pDrawLinkDescRoute(res http.ResponseWriter, req *http.Request) {
 	if op.WriteAccess(gid) {
 		link := wasabee.LinkID(vars["link"])
 		desc := req.FormValue("desc")
-		err := op.LinkDescription(link, desc)
+		err := op.LinkDescription(link, desc, gid)
 		if err != nil {
 			wasabee.Log.Notice(err)
 			http.Error(res, jsonError(err), http.StatusInternalServerError)
@@ -401,7 +514,7 @@ func pDrawLinkColorRoute(res http.ResponseWriter, req *http.Request) {
 	if op.WriteAccess(gid) {
 		link := wasabee.LinkID(vars["link"])
 		color := req.FormValue("color")
-		err := op.LinkColor(link, color)
+		err := op.LinkColor(link, color, gid)
 		if err != nil {
 			wasabee.Log.Notice(err)
 			http.Error(res, jsonError(err), http.StatusInternalServerError)
@@ -433,7 +546,7 @@ func pDrawLinkSwapRoute(res http.ResponseWriter, req *http.Request) {
 
 	if op.WriteAccess(gid) {
 		link := wasabee.LinkID(vars["link"])
-		err := op.LinkSwap(link)
+		err := op.LinkSwap(link, gid)
 		if err != nil {
 			wasabee.Log.Notice(err)
 			http.Error(res, jsonError(err), http.StatusInternalServerError)
@@ -479,7 +592,7 @@ func pDrawLinkCompRoute(res http.ResponseWriter, req *http.Request, complete boo
 		return
 	}
 
-	err = op.LinkCompleted(link, complete)
+	err = op.LinkCompleted(link, complete, gid)
 	if err != nil {
 		wasabee.Log.Notice(err)
 		http.Error(res, jsonError(err), http.StatusInternalServerError)
@@ -506,7 +619,7 @@ func pDrawMarkerAssignRoute(res http.ResponseWriter, req *http.Request) {
 	if op.WriteAccess(gid) {
 		marker := wasabee.MarkerID(vars["marker"])
 		agent := wasabee.GoogleID(req.FormValue("agent"))
-		err := op.AssignMarker(marker, agent)
+		err := op.AssignMarker(marker, agent, gid)
 		if err != nil {
 			wasabee.Log.Notice(err)
 			http.Error(res, jsonError(err), http.StatusInternalServerError)
@@ -539,7 +652,7 @@ func pDrawMarkerCommentRoute(res http.ResponseWriter, req *http.Request) {
 	if op.WriteAccess(gid) {
 		marker := wasabee.MarkerID(vars["marker"])
 		comment := req.FormValue("comment")
-		err := op.MarkerComment(marker, comment)
+		err := op.MarkerComment(marker, comment, gid)
 		if err != nil {
 			wasabee.Log.Notice(err)
 			http.Error(res, jsonError(err), http.StatusInternalServerError)
@@ -572,7 +685,7 @@ func pDrawPortalCommentRoute(res http.ResponseWriter, req *http.Request) {
 	if op.WriteAccess(gid) {
 		portalID := wasabee.PortalID(vars["portal"])
 		comment := req.FormValue("comment")
-		err := op.PortalComment(portalID, comment)
+		err := op.PortalComment(portalID, comment, gid)
 		if err != nil {
 			wasabee.Log.Notice(err)
 			http.Error(res, jsonError(err), http.StatusInternalServerError)
@@ -605,7 +718,7 @@ func pDrawPortalHardnessRoute(res http.ResponseWriter, req *http.Request) {
 	if op.WriteAccess(gid) {
 		portalID := wasabee.PortalID(vars["portal"])
 		hardness := req.FormValue("hardness")
-		err := op.PortalHardness(portalID, hardness)
+		err := op.PortalHardness(portalID, hardness, gid)
 		if err != nil {
 			wasabee.Log.Notice(err)
 			http.Error(res, jsonError(err), http.StatusInternalServerError)
@@ -883,6 +996,11 @@ func pDrawStatRoute(res http.ResponseWriter, req *http.Request) {
 		http.Error(res, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	if err := s.AddFields(); err != nil {
+		wasabee.Log.Notice(err)
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+		return
+	}
 	data, _ := json.Marshal(s)
 
 	fmt.Fprint(res, string(data))
@@ -900,40 +1018,82 @@ func pDrawMyRouteRoute(res http.ResponseWriter, req *http.Request) {
 	var op wasabee.Operation
 	op.ID = wasabee.OperationID(vars["document"])
 
-	var a wasabee.Assignments
-	err = gid.Assignments(op.ID, &a)
+	route, err := gid.Route(op.ID, req.FormValue("lat"), req.FormValue("lng"))
 	if err != nil {
 		wasabee.Log.Notice(err)
 		http.Error(res, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	lls := "https://maps.google.com/maps/dir/?api=1"
-	stops := len(a.Links) - 1
+	switch req.FormValue("format") {
+	case "json":
+		res.Header().Set("Content-Type", jsonType)
+		data, _ := json.Marshal(route)
+		fmt.Fprint(res, string(data))
+		return
+	case "gpx":
+		res.Header().Set("Content-Type", "application/gpx+xml")
+		res.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s-route.gpx\"", op.ID))
+		if err := route.GPX(res); err != nil {
+			wasabee.Log.Notice(err)
+		}
+		return
+	}
 
-	if stops < 1 {
+	if len(route.MapURLs) == 0 {
 		res.Header().Set("Content-Type", jsonType)
 		fmt.Fprint(res, `{ "status": "no assignments" }`)
 		return
 	}
+	// the first leg; the rest are in the json
+	http.Redirect(res, req, route.MapURLs[0], http.StatusFound)
+}
 
-	for i, l := range a.Links {
-		if i == 0 {
-			lls = fmt.Sprintf("%s&origin=%s,%s&waypoints=", lls, a.Portals[l.From].Lat, a.Portals[l.From].Lon)
-		}
-		// Google only allows 9 waypoints
-		// we could do something fancy and show every (n) link based on len(a.Links) / 8
-		// this is good enough for now
-		if i < 7 {
-			lls = fmt.Sprintf("%s|%s,%s", lls, a.Portals[l.From].Lat, a.Portals[l.From].Lon)
-		}
-		if i == stops { // last one -- even if > 10 in list
-			lls = fmt.Sprintf("%s&destination=%s,%s", lls, a.Portals[l.From].Lat, a.Portals[l.From].Lon)
-		}
+var exportTypes = map[string]string{
+	wasabee.ExportKML:     "application/vnd.google-earth.kml+xml",
+	wasabee.ExportGPX:     "application/gpx+xml",
+	wasabee.ExportGeoJSON: "application/geo+json",
+}
+
+func pDrawExportRoute(res http.ResponseWriter, req *http.Request) {
+	gid, err := getAgentID(req)
+	if err != nil {
+		wasabee.Log.Notice(err)
+		http.Error(res, jsonError(err), http.StatusInternalServerError)
+		return
+	}
+
+	vars := mux.Vars(req)
+	var op wasabee.Operation
+	op.ID = wasabee.OperationID(vars["document"])
+
+	format := req.FormValue("format")
+	contentType, ok := exportTypes[format]
+	if !ok {
+		err = fmt.Errorf("format must be kml, gpx or geojson")
+		wasabee.Log.Notice(err)
+		http.Error(res, jsonError(err), http.StatusNotAcceptable)
+		return
+	}
+
+	// assigned-only agents get just their own links and markers from Populate
+	if !op.ReadAccess(gid) && !op.AssignedOnlyAccess(gid) {
+		err = fmt.Errorf("permission denied")
+		wasabee.Log.Notice(err)
+		http.Error(res, jsonError(err), http.StatusUnauthorized)
+		return
+	}
+	if err := op.Populate(gid); err != nil {
+		wasabee.Log.Notice(err)
+		http.Error(res, jsonError(err), http.StatusInternalServerError)
+		return
 	}
-	// wasabee.Log.Debug(lls)
 
-	http.Redirect(res, req, lls, http.StatusFound)
+	res.Header().Set("Content-Type", contentType)
+	res.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s.%s\"", op.ID, format))
+	if err := op.Export(res, format); err != nil {
+		wasabee.Log.Notice(err)
+	}
 }
 
 type friendlyPerms struct {
@@ -1094,11 +1254,6 @@ func pDrawCopyRoute(res http.ResponseWriter, req *http.Request) {
 	vars := mux.Vars(req)
 	var op wasabee.Operation
 	op.ID = wasabee.OperationID(vars["document"])
-	c, ok := vars["complete"]
-	var complete bool
-	if ok && c == "true" {
-		complete = true
-	}
 	if !op.ID.IsOwner(gid) {
 		err := fmt.Errorf("permission to duplicate operation denied")
 		wasabee.Log.Notice(err)
@@ -1113,7 +1268,26 @@ func pDrawCopyRoute(res http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	newid, err := op.Copy(gid, complete)
+	// complete=true keeps assignments, progress and teams; colors=a,b and bounds=south,west,north,east copy only part of the op
+	opts := wasabee.CopyOptions{
+		Name:     req.FormValue("name"),
+		Complete: req.FormValue("complete") == "true",
+	}
+	if c := req.FormValue("colors"); c != "" {
+		opts.Colors = strings.Split(c, ",")
+	}
+	if b := req.FormValue("bounds"); b != "" {
+		var r wasabee.CopyRegion
+		if _, err := fmt.Sscanf(b, "%g,%g,%g,%g", &r.South, &r.West, &r.North, &r.East); err != nil || r.South > r.North {
+			err := fmt.Errorf("bounds must be south,west,north,east")
+			wasabee.Log.Notice(err)
+			http.Error(res, jsonError(err), http.StatusNotAcceptable)
+			return
+		}
+		opts.Region = &r
+	}
+
+	newid, err := op.Copy(gid, opts)
 	if err != nil {
 		wasabee.Log.Notice(err)
 		http.Error(res, jsonError(err), http.StatusInternalServerError)
@@ -1122,9 +1296,1104 @@ func pDrawCopyRoute(res http.ResponseWriter, req *http.Request) {
 
 	res.Header().Set("Cache-Control", "no-store")
 	if wantsJSON(req) {
-		fmt.Fprint(res, jsonStatusOK)
+		fmt.Fprintf(res, "{\"status\":\"ok\",\"ID\":\"%s\"}", newid)
 		return
 	}
 	url := fmt.Sprintf("%s/draw/%s", apipath, newid)
 	http.Redirect(res, req, url, http.StatusFound)
 }
+
+func pDrawHistoryRoute(res http.ResponseWriter, req *http.Request) {
+	res.Header().Set("Content-Type", jsonType)
+
+	gid, err := getAgentID(req)
+	if err != nil {
+		wasabee.Log.Notice(err)
+		http.Error(res, jsonError(err), http.StatusInternalServerError)
+		return
+	}
+
+	vars := mux.Vars(req)
+	var op wasabee.Operation
+	op.ID = wasabee.OperationID(vars["document"])
+
+	if !op.WriteAccess(gid) {
+		err = fmt.Errorf("write access required to view history")
+		wasabee.Log.Notice(err)
+		http.Error(res, jsonError(err), http.StatusUnauthorized)
+		return
+	}
+
+	history, err := op.History(gid)
+	if err != nil {
+		wasabee.Log.Notice(err)
+		http.Error(res, jsonError(err), http.StatusInternalServerError)
+		return
+	}
+	data, _ := json.Marshal(history)
+	res.Header().Set("Cache-Control", "no-store")
+	fmt.Fprint(res, string(data))
+}
+
+func pDrawRevisionRoute(res http.ResponseWriter, req *http.Request) {
+	res.Header().Set("Content-Type", jsonType)
+
+	gid, err := getAgentID(req)
+	if err != nil {
+		wasabee.Log.Notice(err)
+		http.Error(res, jsonError(err), http.StatusInternalServerError)
+		return
+	}
+
+	vars := mux.Vars(req)
+	var op wasabee.Operation
+	op.ID = wasabee.OperationID(vars["document"])
+
+	if !op.WriteAccess(gid) {
+		err = fmt.Errorf("write access required to view history")
+		wasabee.Log.Notice(err)
+		http.Error(res, jsonError(err), http.StatusUnauthorized)
+		return
+	}
+
+	revision, err := strconv.Atoi(vars["revision"])
+	if err != nil {
+		wasabee.Log.Notice(err)
+		http.Error(res, jsonError(err), http.StatusNotAcceptable)
+		return
+	}
+
+	r, err := op.Revision(gid, revision)
+	if err != nil {
+		wasabee.Log.Notice(err)
+		http.Error(res, jsonError(err), http.StatusNotFound)
+		return
+	}
+	data, _ := json.Marshal(r)
+	res.Header().Set("Cache-Control", "no-store")
+	fmt.Fprint(res, string(data))
+}
+
+func pDrawRestoreRoute(res http.ResponseWriter, req *http.Request) {
+	res.Header().Set("Content-Type", jsonType)
+
+	gid, err := getAgentID(req)
+	if err != nil {
+		wasabee.Log.Notice(err)
+		http.Error(res, jsonError(err), http.StatusInternalServerError)
+		return
+	}
+
+	vars := mux.Vars(req)
+	var op wasabee.Operation
+	op.ID = wasabee.OperationID(vars["document"])
+
+	if !op.WriteAccess(gid) {
+		err = fmt.Errorf("write access required to restore a revision")
+		wasabee.Log.Notice(err)
+		http.Error(res, jsonError(err), http.StatusUnauthorized)
+		return
+	}
+
+	revision, err := strconv.Atoi(vars["revision"])
+	if err != nil {
+		wasabee.Log.Notice(err)
+		http.Error(res, jsonError(err), http.StatusNotAcceptable)
+		return
+	}
+
+	if err := op.Restore(gid, revision); err != nil {
+		wasabee.Log.Notice(err)
+		http.Error(res, jsonError(err), http.StatusInternalServerError)
+		return
+	}
+	if stat, err := op.ID.Stat(); err == nil {
+		res.Header().Set("ETag", stat.ETag())
+	}
+	fmt.Fprint(res, jsonStatusOK)
+}
+
+// pDrawAuditRoute returns a page of the op's audit log; agent, type, object, limit and offset narrow it down
+func pDrawAuditRoute(res http.ResponseWriter, req *http.Request) {
+	res.Header().Set("Content-Type", jsonType)
+
+	gid, err := getAgentID(req)
+	if err != nil {
+		wasabee.Log.Notice(err)
+		http.Error(res, jsonError(err), http.StatusInternalServerError)
+		return
+	}
+
+	vars := mux.Vars(req)
+	var op wasabee.Operation
+	op.ID = wasabee.OperationID(vars["document"])
+
+	if !op.ID.IsOwner(gid) {
+		err = fmt.Errorf("only the owner can view the audit log")
+		wasabee.Log.Notice(err)
+		http.Error(res, jsonError(err), http.StatusUnauthorized)
+		return
+	}
+
+	filter := wasabee.AuditFilter{
+		Type:     req.FormValue("type"),
+		ObjectID: req.FormValue("object"),
+	}
+	if agent := req.FormValue("agent"); agent != "" {
+		filter.Gid, err = wasabee.ToGid(agent)
+		if err != nil {
+			wasabee.Log.Notice(err)
+			http.Error(res, jsonError(err), http.StatusNotAcceptable)
+			return
+		}
+	}
+	if limit := req.FormValue("limit"); limit != "" {
+		filter.Limit, err = strconv.Atoi(limit)
+		if err != nil {
+			wasabee.Log.Notice(err)
+			http.Error(res, jsonError(err), http.StatusNotAcceptable)
+			return
+		}
+	}
+	if offset := req.FormValue("offset"); offset != "" {
+		filter.Offset, err = strconv.Atoi(offset)
+		if err != nil {
+			wasabee.Log.Notice(err)
+			http.Error(res, jsonError(err), http.StatusNotAcceptable)
+			return
+		}
+	}
+
+	entries, err := op.Audit(gid, filter)
+	if err != nil {
+		wasabee.Log.Notice(err)
+		http.Error(res, jsonError(err), http.StatusInternalServerError)
+		return
+	}
+	data, _ := json.Marshal(entries)
+	res.Header().Set("Cache-Control", "no-store")
+	fmt.Fprint(res, string(data))
+}
+
+// pDrawStreamRoute pushes changes to the op as Server-Sent Events until the client goes away.
+// The server's write timeout ends every response, so the stream is closed just before it and the client
+// reconnects with Last-Event-ID to pick up where it left off.
+func pDrawStreamRoute(res http.ResponseWriter, req *http.Request) {
+	gid, err := getAgentID(req)
+	if err != nil {
+		wasabee.Log.Notice(err)
+		http.Error(res, jsonError(err), http.StatusInternalServerError)
+		return
+	}
+
+	vars := mux.Vars(req)
+	var op wasabee.Operation
+	op.ID = wasabee.OperationID(vars["document"])
+
+	if !op.ReadAccess(gid) {
+		err = fmt.Errorf("forbidden: you must be on a team with read access to this op")
+		wasabee.Log.Notice(err)
+		http.Error(res, jsonError(err), http.StatusUnauthorized)
+		return
+	}
+
+	flusher, ok := res.(http.Flusher)
+	if !ok {
+		err = fmt.Errorf("streaming not supported")
+		wasabee.Log.Error(err)
+		http.Error(res, jsonError(err), http.StatusInternalServerError)
+		return
+	}
+
+	var lastID int64
+	if last := req.Header.Get("Last-Event-ID"); last != "" {
+		lastID, _ = strconv.ParseInt(last, 10, 64)
+	}
+	events, replay, cancel := op.ID.Subscribe(lastID)
+	defer cancel()
+
+	res.Header().Set("Content-Type", "text/event-stream")
+	res.Header().Set("Cache-Control", "no-store")
+	res.Header().Set("X-Accel-Buffering", "no")
+	fmt.Fprint(res, "retry: 1000\n\n")
+	for _, e := range replay {
+		writeEvent(res, e)
+	}
+	flusher.Flush()
+
+	lifetime := time.NewTimer(streamLifetime())
+	defer lifetime.Stop()
+	keepalive := time.NewTicker(5 * time.Second)
+	defer keepalive.Stop()
+
+	for {
+		select {
+		case <-req.Context().Done():
+			return
+		case <-lifetime.C:
+			return
+		case <-keepalive.C:
+			fmt.Fprint(res, ": keepalive\n\n")
+			flusher.Flush()
+		case e, ok := <-events:
+			if !ok {
+				return
+			}
+			writeEvent(res, e)
+			flusher.Flush()
+			if e.Type == "opDeleted" {
+				return
+			}
+		}
+	}
+}
+
+func writeEvent(res http.ResponseWriter, e wasabee.OpEvent) {
+	data, _ := json.Marshal(e)
+	fmt.Fprintf(res, "id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Type, data)
+}
+
+// streamLifetime is how long a stream may stay open before the server's write timeout would cut it off
+func streamLifetime() time.Duration {
+	if config.srv == nil || config.srv.WriteTimeout == 0 {
+		return 10 * time.Minute
+	}
+	if config.srv.WriteTimeout <= 3*time.Second {
+		return config.srv.WriteTimeout / 2
+	}
+	return config.srv.WriteTimeout - 2*time.Second
+}
+
+func pDrawValidateRoute(res http.ResponseWriter, req *http.Request) {
+	res.Header().Set("Content-Type", jsonType)
+
+	gid, err := getAgentID(req)
+	if err != nil {
+		wasabee.Log.Notice(err)
+		http.Error(res, jsonError(err), http.StatusInternalServerError)
+		return
+	}
+
+	vars := mux.Vars(req)
+	var op wasabee.Operation
+	op.ID = wasabee.OperationID(vars["document"])
+
+	if !op.ReadAccess(gid) {
+		err = fmt.Errorf("permission denied")
+		wasabee.Log.Notice(err)
+		http.Error(res, jsonError(err), http.StatusUnauthorized)
+		return
+	}
+
+	if req.Method == "POST" {
+		// check the client's working copy instead of what is stored
+		contentType := strings.Split(strings.Replace(strings.ToLower(req.Header.Get("Content-Type")), " ", "", -1), ";")[0]
+		if contentType != jsonTypeShort {
+			http.Error(res, "Invalid request (needs to be application/json)", http.StatusNotAcceptable)
+			return
+		}
+		jBlob, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			wasabee.Log.Notice(err)
+			http.Error(res, jsonError(err), http.StatusInternalServerError)
+			return
+		}
+		if err := json.Unmarshal(jBlob, &op); err != nil {
+			wasabee.Log.Notice(err)
+			http.Error(res, jsonError(err), http.StatusNotAcceptable)
+			return
+		}
+		if op.ID != wasabee.OperationID(vars["document"]) {
+			err = fmt.Errorf("document ID does not match operation ID")
+			wasabee.Log.Notice(err)
+			http.Error(res, jsonError(err), http.StatusNotAcceptable)
+			return
+		}
+	} else if err := op.Populate(gid); err != nil {
+		wasabee.Log.Notice(err)
+		http.Error(res, jsonError(err), http.StatusInternalServerError)
+		return
+	}
+
+	data, _ := json.Marshal(op.Validate())
+	fmt.Fprint(res, string(data))
+}
+
+func pDrawFieldsRoute(res http.ResponseWriter, req *http.Request) {
+	res.Header().Set("Content-Type", jsonType)
+
+	gid, err := getAgentID(req)
+	if err != nil {
+		wasabee.Log.Notice(err)
+		http.Error(res, jsonError(err), http.StatusInternalServerError)
+		return
+	}
+
+	vars := mux.Vars(req)
+	var op wasabee.Operation
+	op.ID = wasabee.OperationID(vars["document"])
+
+	// agents who only see their assignments would get a partial answer
+	if !op.ReadAccess(gid) {
+		err = fmt.Errorf("permission denied")
+		wasabee.Log.Notice(err)
+		http.Error(res, jsonError(err), http.StatusUnauthorized)
+		return
+	}
+	if err := op.Populate(gid); err != nil {
+		wasabee.Log.Notice(err)
+		http.Error(res, jsonError(err), http.StatusInternalServerError)
+		return
+	}
+
+	data, _ := json.Marshal(op.Fields())
+	fmt.Fprint(res, string(data))
+}
+
+func pDrawOrderCheckRoute(res http.ResponseWriter, req *http.Request) {
+	res.Header().Set("Content-Type", jsonType)
+
+	gid, err := getAgentID(req)
+	if err != nil {
+		wasabee.Log.Notice(err)
+		http.Error(res, jsonError(err), http.StatusInternalServerError)
+		return
+	}
+
+	vars := mux.Vars(req)
+	var op wasabee.Operation
+	op.ID = wasabee.OperationID(vars["document"])
+
+	if !op.ReadAccess(gid) {
+		err = fmt.Errorf("permission denied")
+		wasabee.Log.Notice(err)
+		http.Error(res, jsonError(err), http.StatusUnauthorized)
+		return
+	}
+	if err := op.Populate(gid); err != nil {
+		wasabee.Log.Notice(err)
+		http.Error(res, jsonError(err), http.StatusInternalServerError)
+		return
+	}
+
+	// check an order before sending it to /order, or the current one
+	data, _ := json.Marshal(op.CheckThrowOrder(req.FormValue("order")))
+	fmt.Fprint(res, string(data))
+}
+
+func pDrawOrderOptimizeRoute(res http.ResponseWriter, req *http.Request) {
+	res.Header().Set("Content-Type", jsonType)
+
+	gid, err := getAgentID(req)
+	if err != nil {
+		wasabee.Log.Notice(err)
+		http.Error(res, jsonError(err), http.StatusInternalServerError)
+		return
+	}
+
+	vars := mux.Vars(req)
+	var op wasabee.Operation
+	op.ID = wasabee.OperationID(vars["document"])
+
+	if !op.ReadAccess(gid) {
+		err = fmt.Errorf("permission denied")
+		wasabee.Log.Notice(err)
+		http.Error(res, jsonError(err), http.StatusUnauthorized)
+		return
+	}
+	if err := op.Populate(gid); err != nil {
+		wasabee.Log.Notice(err)
+		http.Error(res, jsonError(err), http.StatusInternalServerError)
+		return
+	}
+
+	// GET proposes, POST applies
+	var p wasabee.ProposedOrder
+	if req.Method == "POST" {
+		p, err = op.ApplyThrowOrder(gid)
+		if err != nil {
+			wasabee.Log.Notice(err)
+			http.Error(res, jsonError(err), http.StatusUnauthorized)
+			return
+		}
+	} else {
+		p = op.OptimizeThrowOrder()
+	}
+	data, _ := json.Marshal(p)
+	fmt.Fprint(res, string(data))
+}
+
+// pDrawAssignPlanRoute previews a plan; nothing is assigned until it is sent back to pDrawAssignPlanCommitRoute
+func pDrawAssignPlanRoute(res http.ResponseWriter, req *http.Request) {
+	res.Header().Set("Content-Type", jsonType)
+
+	gid, err := getAgentID(req)
+	if err != nil {
+		wasabee.Log.Notice(err)
+		http.Error(res, jsonError(err), http.StatusInternalServerError)
+		return
+	}
+
+	vars := mux.Vars(req)
+	var op wasabee.Operation
+	op.ID = wasabee.OperationID(vars["document"])
+
+	if !op.WriteAccess(gid) {
+		err = fmt.Errorf("write access required to plan assignments")
+		wasabee.Log.Notice(err)
+		http.Error(res, jsonError(err), http.StatusUnauthorized)
+		return
+	}
+	if err := op.Populate(gid); err != nil {
+		wasabee.Log.Notice(err)
+		http.Error(res, jsonError(err), http.StatusInternalServerError)
+		return
+	}
+
+	r := wasabee.AssignmentRequest{
+		Team:     wasabee.TeamID(req.FormValue("team")),
+		Links:    req.FormValue("links") != "false",
+		Markers:  req.FormValue("markers") != "false",
+		Reassign: req.FormValue("reassign") == "true",
+	}
+	for _, a := range strings.Split(req.FormValue("agents"), ",") {
+		if a = strings.TrimSpace(a); a == "" {
+			continue
+		}
+		agent, err := wasabee.ToGid(a)
+		if err != nil {
+			wasabee.Log.Notice(err)
+			http.Error(res, jsonError(err), http.StatusNotAcceptable)
+			return
+		}
+		r.Agents = append(r.Agents, agent)
+	}
+	if max := req.FormValue("max"); max != "" {
+		r.MaxPerAgent, err = strconv.Atoi(max)
+		if err != nil {
+			wasabee.Log.Notice(err)
+			http.Error(res, jsonError(err), http.StatusNotAcceptable)
+			return
+		}
+	}
+
+	plan, err := op.PlanAssignments(gid, r)
+	if err != nil {
+		wasabee.Log.Notice(err)
+		http.Error(res, jsonError(err), http.StatusNotAcceptable)
+		return
+	}
+	data, _ := json.Marshal(plan)
+	fmt.Fprint(res, string(data))
+}
+
+// pDrawAssignPlanCommitRoute takes the plan the owner previewed as the body and makes exactly those assignments
+func pDrawAssignPlanCommitRoute(res http.ResponseWriter, req *http.Request) {
+	res.Header().Set("Content-Type", jsonType)
+
+	gid, err := getAgentID(req)
+	if err != nil {
+		wasabee.Log.Notice(err)
+		http.Error(res, jsonError(err), http.StatusInternalServerError)
+		return
+	}
+
+	vars := mux.Vars(req)
+	var op wasabee.Operation
+	op.ID = wasabee.OperationID(vars["document"])
+
+	if !op.WriteAccess(gid) {
+		err = fmt.Errorf("write access required to assign")
+		wasabee.Log.Notice(err)
+		http.Error(res, jsonError(err), http.StatusUnauthorized)
+		return
+	}
+	contentType := strings.Split(strings.Replace(strings.ToLower(req.Header.Get("Content-Type")), " ", "", -1), ";")[0]
+	if contentType != jsonTypeShort {
+		http.Error(res, "Invalid request (needs to be application/json)", http.StatusNotAcceptable)
+		return
+	}
+	var plan wasabee.AssignmentPlan
+	if err := json.NewDecoder(req.Body).Decode(&plan); err != nil {
+		wasabee.Log.Notice(err)
+		http.Error(res, jsonError(err), http.StatusNotAcceptable)
+		return
+	}
+	if err := op.Populate(gid); err != nil {
+		wasabee.Log.Notice(err)
+		http.Error(res, jsonError(err), http.StatusInternalServerError)
+		return
+	}
+
+	if err := op.CommitAssignmentPlan(gid, plan); err != nil {
+		wasabee.Log.Notice(err)
+		// something changed after the preview, send what so the owner can plan again
+		if pe, ok := err.(*wasabee.PlanChangedError); ok {
+			data, _ := json.Marshal(struct {
+				Status  string   `json:"status"`
+				Error   string   `json:"error"`
+				Changed []string `json:"changed"`
+			}{"error", pe.Error(), pe.Changed})
+			http.Error(res, string(data), http.StatusConflict)
+			return
+		}
+		http.Error(res, jsonError(err), http.StatusNotAcceptable)
+		return
+	}
+	fmt.Fprint(res, jsonStatusOK)
+}
+
+func pDrawKeyReportRoute(res http.ResponseWriter, req *http.Request) {
+	gid, err := getAgentID(req)
+	if err != nil {
+		wasabee.Log.Notice(err)
+		http.Error(res, jsonError(err), http.StatusInternalServerError)
+		return
+	}
+
+	vars := mux.Vars(req)
+	var op wasabee.Operation
+	op.ID = wasabee.OperationID(vars["document"])
+
+	if !op.ReadAccess(gid) {
+		err = fmt.Errorf("permission denied")
+		wasabee.Log.Notice(err)
+		http.Error(res, jsonError(err), http.StatusUnauthorized)
+		return
+	}
+	if err := op.Populate(gid); err != nil {
+		wasabee.Log.Notice(err)
+		http.Error(res, jsonError(err), http.StatusInternalServerError)
+		return
+	}
+
+	report, err := op.KeyReport(gid, req.FormValue("defensive") == "true")
+	if err != nil {
+		wasabee.Log.Notice(err)
+		http.Error(res, jsonError(err), http.StatusInternalServerError)
+		return
+	}
+
+	if req.FormValue("format") == "csv" {
+		res.Header().Set("Content-Type", "text/csv; charset=UTF-8")
+		res.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s-keys.csv\"", op.ID))
+		if req.FormValue("by") == "agent" {
+			err = report.AgentCSV(res)
+		} else {
+			err = report.PortalCSV(res)
+		}
+		if err != nil {
+			wasabee.Log.Notice(err)
+		}
+		return
+	}
+
+	res.Header().Set("Content-Type", jsonType)
+	data, _ := json.Marshal(report)
+	fmt.Fprint(res, string(data))
+}
+
+func pDrawKeyTransfersRoute(res http.ResponseWriter, req *http.Request) {
+	res.Header().Set("Content-Type", jsonType)
+	gid, err := getAgentID(req)
+	if err != nil {
+		wasabee.Log.Notice(err)
+		http.Error(res, jsonError(err), http.StatusInternalServerError)
+		return
+	}
+
+	vars := mux.Vars(req)
+	var op wasabee.Operation
+	op.ID = wasabee.OperationID(vars["document"])
+
+	transfers, err := op.KeyTransfers(gid)
+	if err != nil {
+		wasabee.Log.Notice(err)
+		http.Error(res, jsonError(err), http.StatusUnauthorized)
+		return
+	}
+	data, _ := json.Marshal(transfers)
+	fmt.Fprint(res, string(data))
+}
+
+func pDrawKeyTransferRequestRoute(res http.ResponseWriter, req *http.Request) {
+	res.Header().Set("Content-Type", jsonType)
+	gid, err := getAgentID(req)
+	if err != nil {
+		wasabee.Log.Notice(err)
+		http.Error(res, jsonError(err), http.StatusInternalServerError)
+		return
+	}
+
+	vars := mux.Vars(req)
+	var op wasabee.Operation
+	op.ID = wasabee.OperationID(vars["document"])
+
+	portalID := wasabee.PortalID(req.FormValue("portal"))
+	count, err := strconv.Atoi(req.FormValue("count"))
+	if err != nil {
+		wasabee.Log.Notice(err)
+		http.Error(res, jsonError(err), http.StatusNotAcceptable)
+		return
+	}
+	var from wasabee.GoogleID
+	if f := req.FormValue("from"); f != "" {
+		from, err = wasabee.ToGid(f)
+		if err != nil {
+			wasabee.Log.Notice(err)
+			http.Error(res, jsonError(err), http.StatusNotAcceptable)
+			return
+		}
+	}
+
+	t, err := op.RequestKeys(gid, portalID, int32(count), from)
+	if err != nil {
+		wasabee.Log.Notice(err)
+		http.Error(res, jsonError(err), http.StatusNotAcceptable)
+		return
+	}
+	data, _ := json.Marshal(t)
+	fmt.Fprint(res, string(data))
+}
+
+func pDrawKeyTransferActionRoute(res http.ResponseWriter, req *http.Request) {
+	res.Header().Set("Content-Type", jsonType)
+	gid, err := getAgentID(req)
+	if err != nil {
+		wasabee.Log.Notice(err)
+		http.Error(res, jsonError(err), http.StatusInternalServerError)
+		return
+	}
+
+	vars := mux.Vars(req)
+	transferID := vars["transfer"]
+
+	var t wasabee.KeyTransfer
+	switch vars["action"] {
+	case "accept":
+		t, err = gid.AcceptKeyTransfer(transferID)
+	case "complete":
+		t, err = gid.CompleteKeyTransfer(transferID)
+	case "cancel":
+		t, err = gid.CancelKeyTransfer(transferID)
+	default:
+		err = fmt.Errorf("unknown key transfer action %s", vars["action"])
+	}
+	if err == wasabee.ErrKeyTransferState {
+		http.Error(res, jsonError(err), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		wasabee.Log.Notice(err)
+		http.Error(res, jsonError(err), http.StatusNotAcceptable)
+		return
+	}
+	data, _ := json.Marshal(t)
+	fmt.Fprint(res, string(data))
+}
+
+func pDrawBlockersRoute(res http.ResponseWriter, req *http.Request) {
+	res.Header().Set("Content-Type", jsonType)
+	gid, err := getAgentID(req)
+	if err != nil {
+		wasabee.Log.Notice(err)
+		http.Error(res, jsonError(err), http.StatusInternalServerError)
+		return
+	}
+
+	vars := mux.Vars(req)
+	var op wasabee.Operation
+	op.ID = wasabee.OperationID(vars["document"])
+
+	blockers, err := op.ListBlockers(gid)
+	if err != nil {
+		wasabee.Log.Notice(err)
+		http.Error(res, jsonError(err), http.StatusUnauthorized)
+		return
+	}
+	data, _ := json.Marshal(blockers)
+	fmt.Fprint(res, string(data))
+}
+
+// pDrawBlockerReportRoute takes the portal IDs as from and to; portals not in the op need fromName, fromLat, fromLng and the same for to
+func pDrawBlockerReportRoute(res http.ResponseWriter, req *http.Request) {
+	res.Header().Set("Content-Type", jsonType)
+	gid, err := getAgentID(req)
+	if err != nil {
+		wasabee.Log.Notice(err)
+		http.Error(res, jsonError(err), http.StatusInternalServerError)
+		return
+	}
+
+	vars := mux.Vars(req)
+	var op wasabee.Operation
+	op.ID = wasabee.OperationID(vars["document"])
+
+	from := wasabee.Portal{
+		ID:   wasabee.PortalID(req.FormValue("from")),
+		Name: req.FormValue("fromName"),
+		Lat:  req.FormValue("fromLat"),
+		Lon:  req.FormValue("fromLng"),
+	}
+	to := wasabee.Portal{
+		ID:   wasabee.PortalID(req.FormValue("to")),
+		Name: req.FormValue("toName"),
+		Lat:  req.FormValue("toLat"),
+		Lon:  req.FormValue("toLng"),
+	}
+	if from.ID == "" || to.ID == "" {
+		err = fmt.Errorf("from and to portals required")
+		wasabee.Log.Notice(err)
+		http.Error(res, jsonError(err), http.StatusNotAcceptable)
+		return
+	}
+
+	b, err := op.ReportBlocker(gid, from, to)
+	if err != nil {
+		wasabee.Log.Notice(err)
+		http.Error(res, jsonError(err), http.StatusNotAcceptable)
+		return
+	}
+	data, _ := json.Marshal(b)
+	fmt.Fprint(res, string(data))
+}
+
+func pDrawBlockerClearedRoute(res http.ResponseWriter, req *http.Request) {
+	res.Header().Set("Content-Type", jsonType)
+	gid, err := getAgentID(req)
+	if err != nil {
+		wasabee.Log.Notice(err)
+		http.Error(res, jsonError(err), http.StatusInternalServerError)
+		return
+	}
+
+	vars := mux.Vars(req)
+	var op wasabee.Operation
+	op.ID = wasabee.OperationID(vars["document"])
+
+	b, err := op.ClearBlocker(gid, wasabee.LinkID(vars["blocker"]))
+	if err != nil {
+		wasabee.Log.Notice(err)
+		http.Error(res, jsonError(err), http.StatusNotAcceptable)
+		return
+	}
+	data, _ := json.Marshal(b)
+	fmt.Fprint(res, string(data))
+}
+
+func pDrawBlockerDeleteRoute(res http.ResponseWriter, req *http.Request) {
+	res.Header().Set("Content-Type", jsonType)
+	gid, err := getAgentID(req)
+	if err != nil {
+		wasabee.Log.Notice(err)
+		http.Error(res, jsonError(err), http.StatusInternalServerError)
+		return
+	}
+
+	vars := mux.Vars(req)
+	var op wasabee.Operation
+	op.ID = wasabee.OperationID(vars["document"])
+
+	if err := op.DeleteBlocker(gid, wasabee.LinkID(vars["blocker"])); err != nil {
+		wasabee.Log.Notice(err)
+		http.Error(res, jsonError(err), http.StatusUnauthorized)
+		return
+	}
+	fmt.Fprint(res, jsonStatusOK)
+}
+
+func pDrawPhasesRoute(res http.ResponseWriter, req *http.Request) {
+	res.Header().Set("Content-Type", jsonType)
+	gid, err := getAgentID(req)
+	if err != nil {
+		wasabee.Log.Notice(err)
+		http.Error(res, jsonError(err), http.StatusInternalServerError)
+		return
+	}
+
+	vars := mux.Vars(req)
+	var op wasabee.Operation
+	op.ID = wasabee.OperationID(vars["document"])
+
+	phases, err := op.ListPhases(gid)
+	if err != nil {
+		wasabee.Log.Notice(err)
+		http.Error(res, jsonError(err), http.StatusUnauthorized)
+		return
+	}
+	data, _ := json.Marshal(phases)
+	fmt.Fprint(res, string(data))
+}
+
+// pDrawPhaseSetRoute takes name, start and end, as UTC "2006-01-02 15:04:05" or RFC3339; with an ID it changes that phase
+func pDrawPhaseSetRoute(res http.ResponseWriter, req *http.Request) {
+	res.Header().Set("Content-Type", jsonType)
+	gid, err := getAgentID(req)
+	if err != nil {
+		wasabee.Log.Notice(err)
+		http.Error(res, jsonError(err), http.StatusInternalServerError)
+		return
+	}
+
+	vars := mux.Vars(req)
+	var op wasabee.Operation
+	op.ID = wasabee.OperationID(vars["document"])
+
+	p := wasabee.Phase{
+		ID:    wasabee.PhaseID(vars["phase"]),
+		Name:  req.FormValue("name"),
+		Start: req.FormValue("start"),
+		End:   req.FormValue("end"),
+	}
+	p, err = op.SetPhase(gid, p)
+	if err != nil {
+		wasabee.Log.Notice(err)
+		http.Error(res, jsonError(err), http.StatusNotAcceptable)
+		return
+	}
+	data, _ := json.Marshal(p)
+	fmt.Fprint(res, string(data))
+}
+
+func pDrawPhaseDeleteRoute(res http.ResponseWriter, req *http.Request) {
+	res.Header().Set("Content-Type", jsonType)
+	gid, err := getAgentID(req)
+	if err != nil {
+		wasabee.Log.Notice(err)
+		http.Error(res, jsonError(err), http.StatusInternalServerError)
+		return
+	}
+
+	vars := mux.Vars(req)
+	var op wasabee.Operation
+	op.ID = wasabee.OperationID(vars["document"])
+
+	if err := op.DeletePhase(gid, wasabee.PhaseID(vars["phase"])); err != nil {
+		wasabee.Log.Notice(err)
+		http.Error(res, jsonError(err), http.StatusUnauthorized)
+		return
+	}
+	fmt.Fprint(res, jsonStatusOK)
+}
+
+// pDrawLinkPhaseRoute takes the phase ID as phase; empty takes the link out of its phase
+func pDrawLinkPhaseRoute(res http.ResponseWriter, req *http.Request) {
+	res.Header().Set("Content-Type", jsonType)
+
+	gid, err := getAgentID(req)
+	if err != nil {
+		wasabee.Log.Notice(err)
+		http.Error(res, jsonError(err), http.StatusInternalServerError)
+		return
+	}
+
+	vars := mux.Vars(req)
+	var op wasabee.Operation
+	op.ID = wasabee.OperationID(vars["document"])
+
+	if !op.WriteAccess(gid) {
+		err = fmt.Errorf("write access required to set link phase")
+		wasabee.Log.Notice(err)
+		http.Error(res, jsonError(err), http.StatusUnauthorized)
+		return
+	}
+	link := wasabee.LinkID(vars["link"])
+	if err := op.LinkPhase(link, wasabee.PhaseID(req.FormValue("phase")), gid); err != nil {
+		wasabee.Log.Notice(err)
+		http.Error(res, jsonError(err), http.StatusNotAcceptable)
+		return
+	}
+	fmt.Fprint(res, jsonStatusOK)
+}
+
+// pDrawMarkerPhaseRoute takes the phase ID as phase; empty takes the marker out of its phase
+func pDrawMarkerPhaseRoute(res http.ResponseWriter, req *http.Request) {
+	res.Header().Set("Content-Type", jsonType)
+
+	gid, err := getAgentID(req)
+	if err != nil {
+		wasabee.Log.Notice(err)
+		http.Error(res, jsonError(err), http.StatusInternalServerError)
+		return
+	}
+
+	vars := mux.Vars(req)
+	var op wasabee.Operation
+	op.ID = wasabee.OperationID(vars["document"])
+
+	if !op.WriteAccess(gid) {
+		err = fmt.Errorf("write access required to set marker phase")
+		wasabee.Log.Notice(err)
+		http.Error(res, jsonError(err), http.StatusUnauthorized)
+		return
+	}
+	marker := wasabee.MarkerID(vars["marker"])
+	if err := op.MarkerPhase(marker, wasabee.PhaseID(req.FormValue("phase")), gid); err != nil {
+		wasabee.Log.Notice(err)
+		http.Error(res, jsonError(err), http.StatusNotAcceptable)
+		return
+	}
+	fmt.Fprint(res, jsonStatusOK)
+}
+
+func pDrawProgressRoute(res http.ResponseWriter, req *http.Request) {
+	res.Header().Set("Content-Type", jsonType)
+	gid, err := getAgentID(req)
+	if err != nil {
+		wasabee.Log.Notice(err)
+		http.Error(res, jsonError(err), http.StatusInternalServerError)
+		return
+	}
+
+	vars := mux.Vars(req)
+	var op wasabee.Operation
+	op.ID = wasabee.OperationID(vars["document"])
+
+	progress, err := op.Progress(gid)
+	if err != nil {
+		wasabee.Log.Notice(err)
+		http.Error(res, jsonError(err), http.StatusUnauthorized)
+		return
+	}
+	res.Header().Set("Cache-Control", "no-store")
+	data, _ := json.Marshal(progress)
+	fmt.Fprint(res, string(data))
+}
+
+func pDrawWavesRoute(res http.ResponseWriter, req *http.Request) {
+	res.Header().Set("Content-Type", jsonType)
+	gid, err := getAgentID(req)
+	if err != nil {
+		wasabee.Log.Notice(err)
+		http.Error(res, jsonError(err), http.StatusInternalServerError)
+		return
+	}
+
+	vars := mux.Vars(req)
+	var op wasabee.Operation
+	op.ID = wasabee.OperationID(vars["document"])
+
+	waves, err := op.ListWaves(gid)
+	if err != nil {
+		wasabee.Log.Notice(err)
+		http.Error(res, jsonError(err), http.StatusUnauthorized)
+		return
+	}
+	data, _ := json.Marshal(waves)
+	fmt.Fprint(res, string(data))
+}
+
+// pDrawWaveSetRoute takes name and at, as UTC "2006-01-02 15:04:05" or RFC3339, and the links as either
+// links=a,b,c or the throw order range from and to; with neither, a wave being changed keeps its links
+func pDrawWaveSetRoute(res http.ResponseWriter, req *http.Request) {
+	res.Header().Set("Content-Type", jsonType)
+	gid, err := getAgentID(req)
+	if err != nil {
+		wasabee.Log.Notice(err)
+		http.Error(res, jsonError(err), http.StatusInternalServerError)
+		return
+	}
+
+	vars := mux.Vars(req)
+	var op wasabee.Operation
+	op.ID = wasabee.OperationID(vars["document"])
+
+	w := wasabee.Wave{
+		ID:    wasabee.WaveID(vars["wave"]),
+		Name:  req.FormValue("name"),
+		At:    req.FormValue("at"),
+		Links: []wasabee.LinkID{},
+	}
+	switch {
+	case req.FormValue("links") != "":
+		for _, l := range strings.Split(req.FormValue("links"), ",") {
+			if l = strings.TrimSpace(l); l != "" {
+				w.Links = append(w.Links, wasabee.LinkID(l))
+			}
+		}
+	case req.FormValue("from") != "" || req.FormValue("to") != "":
+		from, err := strconv.ParseInt(req.FormValue("from"), 10, 32)
+		if err != nil {
+			wasabee.Log.Notice(err)
+			http.Error(res, jsonError(err), http.StatusNotAcceptable)
+			return
+		}
+		to, err := strconv.ParseInt(req.FormValue("to"), 10, 32)
+		if err != nil {
+			wasabee.Log.Notice(err)
+			http.Error(res, jsonError(err), http.StatusNotAcceptable)
+			return
+		}
+		if w.Links, err = op.LinksByThrowOrder(int32(from), int32(to)); err != nil {
+			wasabee.Log.Notice(err)
+			http.Error(res, jsonError(err), http.StatusInternalServerError)
+			return
+		}
+	case w.ID != "":
+		waves, err := op.ListWaves(gid)
+		if err != nil {
+			wasabee.Log.Notice(err)
+			http.Error(res, jsonError(err), http.StatusUnauthorized)
+			return
+		}
+		for _, cur := range waves {
+			if cur.ID == w.ID {
+				w.Links = cur.Links
+			}
+		}
+	}
+
+	w, err = op.SetWave(gid, w)
+	if err != nil {
+		wasabee.Log.Notice(err)
+		http.Error(res, jsonError(err), http.StatusNotAcceptable)
+		return
+	}
+	data, _ := json.Marshal(w)
+	fmt.Fprint(res, string(data))
+}
+
+func pDrawWaveDeleteRoute(res http.ResponseWriter, req *http.Request) {
+	res.Header().Set("Content-Type", jsonType)
+	gid, err := getAgentID(req)
+	if err != nil {
+		wasabee.Log.Notice(err)
+		http.Error(res, jsonError(err), http.StatusInternalServerError)
+		return
+	}
+
+	vars := mux.Vars(req)
+	var op wasabee.Operation
+	op.ID = wasabee.OperationID(vars["document"])
+
+	if err := op.DeleteWave(gid, wasabee.WaveID(vars["wave"])); err != nil {
+		wasabee.Log.Notice(err)
+		http.Error(res, jsonError(err), http.StatusUnauthorized)
+		return
+	}
+	fmt.Fprint(res, jsonStatusOK)
+}
+
+func pDrawWaveReportRoute(res http.ResponseWriter, req *http.Request) {
+	res.Header().Set("Content-Type", jsonType)
+	gid, err := getAgentID(req)
+	if err != nil {
+		wasabee.Log.Notice(err)
+		http.Error(res, jsonError(err), http.StatusInternalServerError)
+		return
+	}
+
+	vars := mux.Vars(req)
+	var op wasabee.Operation
+	op.ID = wasabee.OperationID(vars["document"])
+
+	report, err := op.WaveReport(gid, wasabee.WaveID(vars["wave"]))
+	if err != nil {
+		wasabee.Log.Notice(err)
+		http.Error(res, jsonError(err), http.StatusUnauthorized)
+		return
+	}
+	res.Header().Set("Cache-Control", "no-store")
+	data, _ := json.Marshal(report)
+	fmt.Fprint(res, string(data))
+}
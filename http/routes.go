@@ -75,6 +75,7 @@ func setupRouter() *mux.Router {
 func setupAuthRoutes(r *mux.Router) {
 	// This block requires authentication
 	r.HandleFunc("/draw", pDrawUploadRoute).Methods("POST")
+	r.HandleFunc("/draw/import", pDrawImportRoute).Methods("POST")
 	r.HandleFunc("/draw/{document}", pDrawGetRoute).Methods("GET", "HEAD")
 	r.HandleFunc("/draw/{document}", pDrawDeleteRoute).Methods("DELETE")
 	r.HandleFunc("/draw/{document}", pDrawUpdateRoute).Methods("PUT")
@@ -82,6 +83,14 @@ func setupAuthRoutes(r *mux.Router) {
 	r.HandleFunc("/draw/{document}/chown", pDrawChownRoute).Methods("GET").Queries("to", "{to}")
 	r.HandleFunc("/draw/{document}/stock", pDrawStockRoute).Methods("GET")
 	r.HandleFunc("/draw/{document}/order", pDrawOrderRoute).Methods("POST")
+	r.HandleFunc("/draw/{document}/order/check", pDrawOrderCheckRoute).Methods("GET", "POST")
+	r.HandleFunc("/draw/{document}/order/optimize", pDrawOrderOptimizeRoute).Methods("GET", "POST")
+	r.HandleFunc("/draw/{document}/assign/plan", pDrawAssignPlanRoute).Methods("POST")
+	r.HandleFunc("/draw/{document}/assign/plan/commit", pDrawAssignPlanCommitRoute).Methods("POST")
+	r.HandleFunc("/draw/{document}/keys/report", pDrawKeyReportRoute).Methods("GET")
+	r.HandleFunc("/draw/{document}/keys/transfer", pDrawKeyTransfersRoute).Methods("GET")
+	r.HandleFunc("/draw/{document}/keys/transfer", pDrawKeyTransferRequestRoute).Methods("POST")
+	r.HandleFunc("/draw/{document}/keys/transfer/{transfer}/{action}", pDrawKeyTransferActionRoute).Methods("POST")
 	r.HandleFunc("/draw/{document}/info", pDrawInfoRoute).Methods("POST")
 	r.HandleFunc("/draw/{document}/stat", pDrawStatRoute).Methods("GET")
 	r.HandleFunc("/draw/{document}/perms", pDrawPermsRoute).Methods("GET")
@@ -89,15 +98,44 @@ func setupAuthRoutes(r *mux.Router) {
 	r.HandleFunc("/draw/{document}/perms", pDrawPermsDeleteRoute).Methods("DELETE")
 	r.HandleFunc("/draw/{document}/delperm", pDrawPermsDeleteRoute).Methods("GET") // .Queries("team", "{team}", "role", "{role}")
 	r.HandleFunc("/draw/{document}/myroute", pDrawMyRouteRoute).Methods("GET")
+	r.HandleFunc("/draw/{document}/export", pDrawExportRoute).Methods("GET")
+	r.HandleFunc("/draw/{document}/blockers", pDrawBlockersRoute).Methods("GET")
+	r.HandleFunc("/draw/{document}/blockers", pDrawBlockerReportRoute).Methods("POST")
+	r.HandleFunc("/draw/{document}/blockers/{blocker}/cleared", pDrawBlockerClearedRoute).Methods("POST")
+	r.HandleFunc("/draw/{document}/blockers/{blocker}", pDrawBlockerDeleteRoute).Methods("DELETE")
+	r.HandleFunc("/draw/{document}/phases", pDrawPhasesRoute).Methods("GET")
+	r.HandleFunc("/draw/{document}/phases", pDrawPhaseSetRoute).Methods("POST")
+	r.HandleFunc("/draw/{document}/phases/{phase}", pDrawPhaseSetRoute).Methods("POST")
+	r.HandleFunc("/draw/{document}/phases/{phase}", pDrawPhaseDeleteRoute).Methods("DELETE")
+	r.HandleFunc("/draw/{document}/waves", pDrawWavesRoute).Methods("GET")
+	r.HandleFunc("/draw/{document}/waves", pDrawWaveSetRoute).Methods("POST")
+	r.HandleFunc("/draw/{document}/waves/{wave}", pDrawWaveSetRoute).Methods("POST")
+	r.HandleFunc("/draw/{document}/waves/{wave}", pDrawWaveDeleteRoute).Methods("DELETE")
+	r.HandleFunc("/draw/{document}/waves/{wave}/report", pDrawWaveReportRoute).Methods("GET")
 	r.HandleFunc("/draw/{document}/copy", pDrawCopyRoute).Methods("GET")
+	r.HandleFunc("/draw/{document}/history", pDrawHistoryRoute).Methods("GET")
+	r.HandleFunc("/draw/{document}/history/{revision}", pDrawRevisionRoute).Methods("GET")
+	r.HandleFunc("/draw/{document}/history/{revision}/restore", pDrawRestoreRoute).Methods("POST")
+	r.HandleFunc("/draw/{document}/audit", pDrawAuditRoute).Methods("GET")
+	r.HandleFunc("/draw/{document}/progress", pDrawProgressRoute).Methods("GET")
+	r.HandleFunc("/draw/{document}/stream", pDrawStreamRoute).Methods("GET")
+	r.HandleFunc("/draw/{document}/validate", pDrawValidateRoute).Methods("GET", "POST")
+	r.HandleFunc("/draw/{document}/fields", pDrawFieldsRoute).Methods("GET")
+	r.HandleFunc("/draw/{document}/webhook", getWebhooksRoute).Methods("GET")
+	r.HandleFunc("/draw/{document}/webhook", addWebhookRoute).Methods("POST")
+	r.HandleFunc("/draw/{document}/webhook/{hook}", deleteWebhookRoute).Methods("DELETE")
+	r.HandleFunc("/draw/{document}/webhook/{hook}/deliveries", webhookDeliveriesRoute).Methods("GET")
+	r.HandleFunc("/draw/{document}/webhook/{hook}/test", testWebhookRoute).Methods("POST")
 	r.HandleFunc("/draw/{document}/link/{link}/assign", pDrawLinkAssignRoute).Methods("POST")
 	r.HandleFunc("/draw/{document}/link/{link}/color", pDrawLinkColorRoute).Methods("POST")
 	r.HandleFunc("/draw/{document}/link/{link}/desc", pDrawLinkDescRoute).Methods("POST")
+	r.HandleFunc("/draw/{document}/link/{link}/phase", pDrawLinkPhaseRoute).Methods("POST")
 	r.HandleFunc("/draw/{document}/link/{link}/complete", pDrawLinkCompleteRoute).Methods("GET")
 	r.HandleFunc("/draw/{document}/link/{link}/incomplete", pDrawLinkIncompleteRoute).Methods("GET")
 	r.HandleFunc("/draw/{document}/link/{link}/swap", pDrawLinkSwapRoute).Methods("GET")
 	r.HandleFunc("/draw/{document}/marker/{marker}/assign", pDrawMarkerAssignRoute).Methods("POST")
 	r.HandleFunc("/draw/{document}/marker/{marker}/comment", pDrawMarkerCommentRoute).Methods("POST")
+	r.HandleFunc("/draw/{document}/marker/{marker}/phase", pDrawMarkerPhaseRoute).Methods("POST")
 	// agent acknowledge the assignment
 	r.HandleFunc("/draw/{document}/marker/{marker}/acknowledge", pDrawMarkerAcknowledgeRoute).Methods("GET")
 	// agent mark as complete
@@ -150,6 +188,12 @@ func setupAuthRoutes(r *mux.Router) {
 	r.HandleFunc("/team/{team}/rockscfg", rocksCfgTeamRoute).Methods("GET").Queries("rockscomm", "{rockscomm}", "rockskey", "{rockskey}")
 	// broadcast a message to the team
 	r.HandleFunc("/team/{team}/announce", announceTeamRoute).Methods("POST")
+	r.HandleFunc("/team/{team}/webhook", getWebhooksRoute).Methods("GET")
+	r.HandleFunc("/team/{team}/webhook", addWebhookRoute).Methods("POST")
+	r.HandleFunc("/team/{team}/webhook/{hook}", deleteWebhookRoute).Methods("DELETE")
+	r.HandleFunc("/team/{team}/webhook/{hook}/deliveries", webhookDeliveriesRoute).Methods("GET")
+	r.HandleFunc("/team/{team}/webhook/{hook}/test", testWebhookRoute).Methods("POST")
+	r.HandleFunc("/team/{team}/blockers", teamBlockersRoute).Methods("GET")
 	r.HandleFunc("/team/{team}/{key}", addAgentToTeamRoute).Methods("GET", "POST")
 	r.HandleFunc("/team/{team}/{gid}/squad", setAgentTeamSquadRoute).Methods("POST")
 	r.HandleFunc("/team/{team}/{gid}/displayname", setAgentTeamDisplaynameRoute).Methods("POST")
@@ -209,7 +209,8 @@ func headersMW(next http.Handler) http.Handler {
 		res.Header().Add("Access-Control-Allow-Origin", "https://intel.ingress.com")
 		res.Header().Add("Access-Control-Allow-Methods", "POST, GET, PUT, OPTIONS, HEAD, DELETE")
 		res.Header().Add("Access-Control-Allow-Credentials", "true")
-		res.Header().Add("Access-Control-Allow-Headers", "Content-Type, Accept, If-Modified-Since")
+		res.Header().Add("Access-Control-Allow-Headers", "Content-Type, Accept, If-Modified-Since, If-None-Match, If-Match, If-Unmodified-Since, Last-Event-ID")
+		res.Header().Add("Access-Control-Expose-Headers", "ETag, Last-Modified")
 		next.ServeHTTP(res, req)
 	})
 }
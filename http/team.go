@@ -368,3 +368,24 @@ func setAgentTeamDisplaynameRoute(res http.ResponseWriter, req *http.Request) {
 	}
 	fmt.Fprint(res, jsonStatusOK)
 }
+
+func teamBlockersRoute(res http.ResponseWriter, req *http.Request) {
+	res.Header().Add("Content-Type", jsonType)
+	gid, err := getAgentID(req)
+	if err != nil {
+		wasabee.Log.Notice(err)
+		http.Error(res, jsonError(err), http.StatusInternalServerError)
+		return
+	}
+
+	vars := mux.Vars(req)
+	team := wasabee.TeamID(vars["team"])
+	blockers, err := team.Blockers(gid)
+	if err != nil {
+		wasabee.Log.Notice(err)
+		http.Error(res, jsonError(err), http.StatusUnauthorized)
+		return
+	}
+	data, _ := json.Marshal(blockers)
+	fmt.Fprint(res, string(data))
+}
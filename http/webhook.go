@@ -0,0 +1,178 @@
+package wasabeehttps
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/wasabee-project/Wasabee-Server"
+	"net/http"
+)
+
+// webhookTarget is the op or team named in the path
+type webhookTarget struct {
+	opID   wasabee.OperationID
+	teamID wasabee.TeamID
+}
+
+func getWebhookTarget(req *http.Request) webhookTarget {
+	vars := mux.Vars(req)
+	return webhookTarget{
+		opID:   wasabee.OperationID(vars["document"]),
+		teamID: wasabee.TeamID(vars["team"]),
+	}
+}
+
+// hook loads the webhook named in the path, making sure it belongs to the op or team in the path
+func (t webhookTarget) hook(gid wasabee.GoogleID, req *http.Request) (wasabee.Webhook, int, error) {
+	hookID := mux.Vars(req)["hook"]
+	h, err := gid.Webhook(hookID)
+	if err != nil {
+		return h, http.StatusUnauthorized, err
+	}
+	if h.OpID != t.opID || h.TeamID != t.teamID {
+		err := fmt.Errorf("webhook %s not found", hookID)
+		return h, http.StatusNotFound, err
+	}
+	return h, http.StatusOK, nil
+}
+
+func getWebhooksRoute(res http.ResponseWriter, req *http.Request) {
+	res.Header().Set("Content-Type", jsonType)
+
+	gid, err := getAgentID(req)
+	if err != nil {
+		wasabee.Log.Notice(err)
+		http.Error(res, jsonError(err), http.StatusInternalServerError)
+		return
+	}
+
+	var hooks []wasabee.Webhook
+	t := getWebhookTarget(req)
+	if t.opID != "" {
+		hooks, err = t.opID.Webhooks(gid)
+	} else {
+		hooks, err = t.teamID.Webhooks(gid)
+	}
+	if err != nil {
+		wasabee.Log.Notice(err)
+		http.Error(res, jsonError(err), http.StatusUnauthorized)
+		return
+	}
+	if hooks == nil {
+		hooks = []wasabee.Webhook{}
+	}
+	data, _ := json.Marshal(hooks)
+	res.Header().Set("Cache-Control", "no-store")
+	fmt.Fprint(res, string(data))
+}
+
+func addWebhookRoute(res http.ResponseWriter, req *http.Request) {
+	res.Header().Set("Content-Type", jsonType)
+
+	gid, err := getAgentID(req)
+	if err != nil {
+		wasabee.Log.Notice(err)
+		http.Error(res, jsonError(err), http.StatusInternalServerError)
+		return
+	}
+
+	url := req.FormValue("url")
+	secret := req.FormValue("secret")
+	events := req.FormValue("events")
+
+	var h wasabee.Webhook
+	t := getWebhookTarget(req)
+	if t.opID != "" {
+		h, err = t.opID.AddWebhook(gid, url, secret, events)
+	} else {
+		h, err = t.teamID.AddWebhook(gid, url, secret, events)
+	}
+	if err != nil {
+		wasabee.Log.Notice(err)
+		http.Error(res, jsonError(err), http.StatusNotAcceptable)
+		return
+	}
+	data, _ := json.Marshal(h)
+	res.Header().Set("Cache-Control", "no-store")
+	fmt.Fprint(res, string(data))
+}
+
+func deleteWebhookRoute(res http.ResponseWriter, req *http.Request) {
+	res.Header().Set("Content-Type", jsonType)
+
+	gid, err := getAgentID(req)
+	if err != nil {
+		wasabee.Log.Notice(err)
+		http.Error(res, jsonError(err), http.StatusInternalServerError)
+		return
+	}
+
+	h, status, err := getWebhookTarget(req).hook(gid, req)
+	if err != nil {
+		wasabee.Log.Notice(err)
+		http.Error(res, jsonError(err), status)
+		return
+	}
+	if err := gid.DeleteWebhook(h.ID); err != nil {
+		wasabee.Log.Notice(err)
+		http.Error(res, jsonError(err), http.StatusInternalServerError)
+		return
+	}
+	fmt.Fprint(res, jsonStatusOK)
+}
+
+func webhookDeliveriesRoute(res http.ResponseWriter, req *http.Request) {
+	res.Header().Set("Content-Type", jsonType)
+
+	gid, err := getAgentID(req)
+	if err != nil {
+		wasabee.Log.Notice(err)
+		http.Error(res, jsonError(err), http.StatusInternalServerError)
+		return
+	}
+
+	h, status, err := getWebhookTarget(req).hook(gid, req)
+	if err != nil {
+		wasabee.Log.Notice(err)
+		http.Error(res, jsonError(err), status)
+		return
+	}
+	deliveries, err := gid.WebhookDeliveries(h.ID)
+	if err != nil {
+		wasabee.Log.Notice(err)
+		http.Error(res, jsonError(err), http.StatusInternalServerError)
+		return
+	}
+	if deliveries == nil {
+		deliveries = []wasabee.WebhookDelivery{}
+	}
+	data, _ := json.Marshal(deliveries)
+	res.Header().Set("Cache-Control", "no-store")
+	fmt.Fprint(res, string(data))
+}
+
+func testWebhookRoute(res http.ResponseWriter, req *http.Request) {
+	res.Header().Set("Content-Type", jsonType)
+
+	gid, err := getAgentID(req)
+	if err != nil {
+		wasabee.Log.Notice(err)
+		http.Error(res, jsonError(err), http.StatusInternalServerError)
+		return
+	}
+
+	h, status, err := getWebhookTarget(req).hook(gid, req)
+	if err != nil {
+		wasabee.Log.Notice(err)
+		http.Error(res, jsonError(err), status)
+		return
+	}
+	d, err := gid.TestWebhook(h.ID)
+	if err != nil {
+		wasabee.Log.Notice(err)
+		http.Error(res, jsonError(err), http.StatusInternalServerError)
+		return
+	}
+	data, _ := json.Marshal(d)
+	fmt.Fprint(res, string(data))
+}
@@ -8,9 +8,7 @@ type LocKey string
 
 // Gid converts a location share key to a agent's gid
 func (lockey LocKey) Gid() (GoogleID, error) {
-	var gid GoogleID
-
-	err := db.QueryRow("SELECT gid FROM agent WHERE lockey = ?", lockey).Scan(&gid)
+	gid, err := store.LocKeyGid(lockey)
 	if err != nil {
 		Log.Notice(err)
 		return "", err
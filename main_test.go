@@ -13,7 +13,12 @@ func TestMain(m *testing.M) {
 	gid = wasabee.GoogleID("118281765050946915735")
 
 	wasabee.SetLogLevel(logging.DEBUG)
-	err := wasabee.Connect(os.Getenv("DATABASE"))
+	// without a DATABASE the tests run against the in-memory store
+	uri := os.Getenv("DATABASE")
+	if uri == "" {
+		uri = wasabee.MemoryStoreURI
+	}
+	err := wasabee.Connect(uri)
 	if err != nil {
 		wasabee.Log.Error(err)
 	}
@@ -24,6 +29,10 @@ func TestMain(m *testing.M) {
 		APIKey: os.Getenv("ENLROCKS_API_KEY"),
 	})
 
+	if uri == wasabee.MemoryStoreURI {
+		seedMemoryStore()
+	}
+
 	// flag.Parse()
 	exitCode := m.Run()
 	wasabee.Disconnect()
@@ -36,3 +45,20 @@ func TestLoadWordsFile(t *testing.T) {
 		t.Error(err.Error())
 	}
 }
+
+// seedMemoryStore adds the fixtures the MariaDB test database is loaded with, as enl.rocks would report them
+func seedMemoryStore() {
+	if _, err := gid.InitAgent(); err != nil {
+		wasabee.Log.Error(err)
+	}
+	rocks := wasabee.RocksAgent{
+		Gid:      gid,
+		TGId:     240908008,
+		Agent:    "deviousness",
+		Verified: true,
+		Fullname: "Scot Bontrager",
+	}
+	if err := wasabee.RocksUpdate(gid, &rocks); err != nil {
+		wasabee.Log.Error(err)
+	}
+}
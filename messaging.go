@@ -25,7 +25,7 @@ func (gid GoogleID) SendMessage(message string) (bool, error) {
 	// pick optimal
 	bus := "Telegram"
 
-	_, err := db.Exec("INSERT INTO messagelog (gid, message) VALUES (?, ?)", gid, message)
+	err := store.LogMessage(gid, message)
 	if err != nil {
 		return false, err
 	}
@@ -62,15 +62,12 @@ func (gid GoogleID) SendMessageVia(message, bus string) (bool, error) {
 // CanSendTo checks to see if a message is permitted to be sent between these users
 func (gid GoogleID) CanSendTo(to GoogleID) bool {
 	// sender must own at least one team on which the receiver is enabled
-	var count int
-	if err := db.QueryRow("SELECT COUNT(x.gid) FROM agentteams=x, team=t WHERE t.teamID = x.teamID AND t.owner = ? AND x.state != 'Off' AND x.gid = ?", gid, to).Scan(&count); err != nil {
+	ok, err := store.CanSendTo(gid, to)
+	if err != nil {
 		Log.Error(err)
 		return false
 	}
-	if count < 1 {
-		return false
-	}
-	return true
+	return ok
 }
 
 // SendAnnounce sends a message to everyone on the team, determining what is the best route per agent
@@ -81,23 +78,16 @@ func (teamID TeamID) SendAnnounce(sender GoogleID, message string) error {
 		return err
 	}
 
-	rows, err := db.Query("SELECT gid FROM agentteams WHERE teamID = ? AND state != 'Off'", teamID)
+	members, err := store.TeamMembers(teamID, true)
 	if err != nil {
 		Log.Error(err)
 		return err
 	}
-	defer rows.Close()
 
-	var gid GoogleID
-	for rows.Next() {
-		err := rows.Scan(&gid)
+	for _, gid := range members {
 		if !sender.CanSendTo(gid) {
 			continue
 		}
-		if err != nil {
-			Log.Error(err)
-			return err
-		}
 		ok, err := gid.SendMessage(message)
 		if err != nil {
 			Log.Error(err)
@@ -109,6 +99,7 @@ func (teamID TeamID) SendAnnounce(sender GoogleID, message string) error {
 		}
 	}
 
+	publishEvent(Event{Type: EventTeamAnnounce, TeamID: teamID, Gid: sender, Action: "announce", Detail: message})
 	return nil
 }
 
@@ -122,4 +113,34 @@ func RegisterMessageBus(name string, f func(GoogleID, string) (bool, error)) err
 func init() {
 	mc.senders = make(map[string]func(GoogleID, string) (bool, error))
 	mc.inited = true
+	// assignment messages must not be dropped, so the bus waits for this one when it falls behind
+	go notifyEvents(SubscribeEventsWait("messaging", 1024))
+}
+
+// notifyEvents messages agents about events which concern them, so a slow messaging service never holds up the change itself
+func notifyEvents(sub *EventSubscription) {
+	for e := range sub.Events() {
+		if e.Type != EventMarkerAssignment || e.Agent == "" {
+			continue
+		}
+
+		marker := struct {
+			OpID     OperationID
+			MarkerID MarkerID
+		}{
+			OpID:     e.OpID,
+			MarkerID: MarkerID(e.ObjectID),
+		}
+
+		msg, err := e.Agent.ExecuteTemplate("assignMarker", marker)
+		if err != nil {
+			Log.Error(err)
+			msg = fmt.Sprintf("assigned a marker for op %s", e.OpID)
+			// do not report send errors up the chain, just log
+		}
+		_, err = e.Agent.SendMessage(msg)
+		if err != nil {
+			Log.Errorf("%s %s %s", e.Agent, err, msg)
+		}
+	}
 }
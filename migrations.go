@@ -0,0 +1,279 @@
+package wasabee
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+)
+
+// Migration is a single, ordered change to the database schema.
+// Steps must be idempotent: MariaDB commits implicitly on DDL, so a migration which fails part way through is simply re-run.
+type Migration struct {
+	Version     int
+	Description string
+	Steps       []string
+}
+
+// autoMigrate determines if Connect applies pending migrations
+var autoMigrate = true
+
+// migrations is the ordered list of schema changes; never edit or reorder an entry once it has shipped, append a new one
+var migrations = []Migration{
+	{
+		Version:     1,
+		Description: "initial schema",
+		// agent must come first, team must come second, operation must come third, the rest can be in alphabetical order
+		Steps: []string{
+			`CREATE TABLE IF NOT EXISTS agent ( gid varchar(32) NOT NULL, iname varchar(64) DEFAULT NULL, level tinyint(4) NOT NULL DEFAULT '1', lockey varchar(64) DEFAULT NULL, VVerified tinyint(1) NOT NULL DEFAULT '0', Vblacklisted tinyint(1) NOT NULL DEFAULT '0', Vid varchar(40) DEFAULT NULL, RocksVerified tinyint(1) NOT NULL DEFAULT '0', RAID tinyint(1) NOT NULL DEFAULT '0', RISC tinyint(1) NOT NULL DEFAULT '0', PRIMARY KEY (gid), UNIQUE KEY iname (iname), UNIQUE KEY lockey (lockey), UNIQUE KEY Vid (Vid)) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;`,
+			`CREATE TABLE IF NOT EXISTS team ( teamID varchar(64) NOT NULL, owner varchar(32) NOT NULL, name varchar(64) DEFAULT NULL, rockskey varchar(32) DEFAULT NULL, rockscomm varchar(32) DEFAULT NULL, PRIMARY KEY (teamID), KEY fk_team_owner (owner), CONSTRAINT fk_team_owner FOREIGN KEY (owner) REFERENCES agent (gid) ON DELETE CASCADE) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;`,
+			`CREATE TABLE IF NOT EXISTS operation ( ID varchar(64) NOT NULL, name varchar(128) NOT NULL DEFAULT 'new op', gid varchar(32) NOT NULL, color varchar(16) NOT NULL DEFAULT 'groupa', teamID varchar(64) NOT NULL DEFAULT '', modified datetime NOT NULL DEFAULT CURRENT_TIMESTAMP, comment text, PRIMARY KEY (ID), KEY gid (gid), KEY teamID (teamID), CONSTRAINT fk_operation_agent FOREIGN KEY (gid) REFERENCES agent (gid) ON DELETE CASCADE) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;`,
+			`CREATE TABLE IF NOT EXISTS agentextras ( gid varchar(32) NOT NULL, picurl text, UNIQUE KEY gid (gid), CONSTRAINT fk_extra_agent FOREIGN KEY (gid) REFERENCES agent (gid) ON DELETE CASCADE) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;`,
+			`CREATE TABLE IF NOT EXISTS agentteams ( teamID varchar(64) NOT NULL, gid varchar(32) NOT NULL, state enum('Off','On') NOT NULL DEFAULT 'Off', color varchar(32) NOT NULL DEFAULT 'boots', displayname varchar(32) DEFAULT NULL,  PRIMARY KEY (teamID,gid), KEY GIDKEY (gid), CONSTRAINT fk_agent_teams FOREIGN KEY (gid) REFERENCES agent (gid) ON DELETE CASCADE, CONSTRAINT fk_t_teams FOREIGN KEY (teamID) REFERENCES team (teamID) ON DELETE CASCADE) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;`,
+			`CREATE TABLE IF NOT EXISTS anchor ( opID varchar(64) DEFAULT NULL, portalID varchar(64) DEFAULT NULL, PRIMARY KEY anchor (opID,portalID), CONSTRAINT fk_operation_id_anchor FOREIGN KEY (opID) REFERENCES operation (ID) ON DELETE CASCADE) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;`,
+			`CREATE TABLE IF NOT EXISTS firebase ( gid varchar(32) NOT NULL, token varchar(4092) NOT NULL, KEY fk_gid (gid), CONSTRAINT fk_gid FOREIGN KEY (gid) REFERENCES agent (gid) ON DELETE CASCADE) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;`,
+			`CREATE TABLE IF NOT EXISTS link ( ID varchar(64) NOT NULL, fromPortalID varchar(64) NOT NULL, toPortalID varchar(64) NOT NULL, opID varchar(64) NOT NULL, description text, gid varchar(32) DEFAULT NULL, throworder int(11) DEFAULT '0', completed tinyint(1) NOT NULL DEFAULT '0', color varchar(16) NOT NULL DEFAULT 'main', PRIMARY KEY (ID,opID), KEY fk_operation_id_link (opID), KEY fk_link_gid (gid), CONSTRAINT fk_link_gid FOREIGN KEY (gid) REFERENCES agent (gid) ON DELETE SET NULL, CONSTRAINT fk_operation_id_link FOREIGN KEY (opID) REFERENCES operation (ID) ON DELETE CASCADE) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;`,
+			`CREATE TABLE IF NOT EXISTS locations ( gid varchar(32) NOT NULL, upTime datetime NOT NULL DEFAULT CURRENT_TIMESTAMP, loc point NOT NULL, PRIMARY KEY (gid), SPATIAL KEY sp (loc)) ENGINE=Aria DEFAULT CHARSET=utf8mb4 PAGE_CHECKSUM=1;`,
+			`CREATE TABLE IF NOT EXISTS marker ( ID varchar(64) NOT NULL, opID varchar(64) NOT NULL, portalID varchar(64) NOT NULL, type varchar(128) NOT NULL, gid varchar(32) DEFAULT NULL, comment text, complete tinyint(1) NOT NULL DEFAULT '0', state enum('pending','assigned','acknowledged','completed') NOT NULL DEFAULT 'pending', completedBy varchar(32) DEFAULT NULL, oporder int NOT NULL DEFAULT 0, PRIMARY KEY (ID,opID), KEY fk_operation_marker (opID), KEY fk_marker_gid (gid), CONSTRAINT fk_marker_gid FOREIGN KEY (gid) REFERENCES agent (gid) ON DELETE SET NULL, CONSTRAINT fk_operation_marker FOREIGN KEY (opID) REFERENCES operation (ID) ON DELETE CASCADE) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;`,
+			`CREATE TABLE IF NOT EXISTS messagelog ( timestamp datetime NOT NULL DEFAULT CURRENT_TIMESTAMP, gid varchar(32) NOT NULL, message text NOT NULL) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;`,
+			`CREATE TABLE IF NOT EXISTS opkeys ( opID varchar(64) NOT NULL, portalID varchar(64) NOT NULL, gid varchar(32) NOT NULL, onhand int(11) NOT NULL DEFAULT '0', UNIQUE KEY key_unique (opID,portalID,gid), KEY fk_operation_id_keys (opID), CONSTRAINT fk_operation_id_keys FOREIGN KEY (opID) REFERENCES operation (ID) ON DELETE CASCADE) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;`,
+			`CREATE TABLE IF NOT EXISTS portal ( ID varchar(64) NOT NULL, opID varchar(64) NOT NULL, name varchar(128) NOT NULL, loc point NOT NULL, comment text, hardness varchar(64) DEFAULT NULL, PRIMARY KEY ID (ID,opID), KEY fk_operation_id (opID), SPATIAL KEY sp_portal (loc)) ENGINE=Aria DEFAULT CHARSET=utf8mb4 PAGE_CHECKSUM=1;`,
+			`CREATE TABLE IF NOT EXISTS telegram ( telegramID bigint(20) NOT NULL, telegramName varchar(32) NOT NULL, gid varchar(32) NOT NULL, verified tinyint(1) NOT NULL DEFAULT '0', authtoken varchar(32) DEFAULT NULL, PRIMARY KEY (telegramID), UNIQUE KEY gid (gid), CONSTRAINT fk_agent_telegram FOREIGN KEY (gid) REFERENCES agent (gid) ON DELETE CASCADE) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;`,
+			`CREATE TABLE IF NOT EXISTS opteams (teamID varchar(64) NOT NULL, opID varchar(64) NOT NULL, permission enum('read','write','assignedonly') NOT NULL DEFAULT 'read', KEY opID (opID), KEY teamID (teamID), CONSTRAINT fk_ops_teamID FOREIGN KEY (opID) REFERENCES operation (ID) ON DELETE CASCADE, CONSTRAINT fk_teamIDs_op FOREIGN KEY (teamID) REFERENCES team (teamID) ON DELETE CASCADE) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`,
+		},
+	},
+	{
+		Version:     2,
+		Description: "add capsule to opkeys",
+		Steps: []string{
+			`ALTER TABLE opkeys ADD COLUMN IF NOT EXISTS capsule varchar(16) DEFAULT NULL;`,
+		},
+	},
+	{
+		Version:     3,
+		Description: "create defensivekeys",
+		Steps: []string{
+			`CREATE TABLE IF NOT EXISTS defensivekeys ( gid varchar(32) NOT NULL, portalID varchar(64) NOT NULL, capID varchar(16) DEFAULT NULL, count int(11) NOT NULL DEFAULT '0', PRIMARY KEY (gid,portalID), CONSTRAINT fk_dk_gid FOREIGN KEY (gid) REFERENCES agent (gid) ON DELETE CASCADE) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;`,
+		},
+	},
+	{
+		Version:     4,
+		Description: "move portal to InnoDB so op updates can be transactional",
+		Steps: []string{
+			`ALTER TABLE portal ENGINE=InnoDB;`,
+		},
+	},
+	{
+		Version:     5,
+		Description: "create oprevision",
+		Steps: []string{
+			`CREATE TABLE IF NOT EXISTS oprevision ( ID int(11) NOT NULL AUTO_INCREMENT, opID varchar(64) NOT NULL, gid varchar(32) NOT NULL, description varchar(128) NOT NULL DEFAULT '', recorded datetime NOT NULL DEFAULT CURRENT_TIMESTAMP, op mediumtext NOT NULL, PRIMARY KEY (ID), KEY fk_operation_id_revision (opID), CONSTRAINT fk_operation_id_revision FOREIGN KEY (opID) REFERENCES operation (ID) ON DELETE CASCADE) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;`,
+		},
+	},
+	{
+		Version:     6,
+		Description: "create opaudit",
+		// no foreign keys: the audit log has to outlive deleted ops and agents
+		Steps: []string{
+			`CREATE TABLE IF NOT EXISTS opaudit ( ID int(11) NOT NULL AUTO_INCREMENT, opID varchar(64) NOT NULL, gid varchar(32) NOT NULL, recorded datetime NOT NULL DEFAULT CURRENT_TIMESTAMP, action varchar(32) NOT NULL, objtype varchar(16) NOT NULL, objID varchar(64) NOT NULL DEFAULT '', detail text, PRIMARY KEY (ID), KEY opID (opID), KEY gid (gid)) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;`,
+		},
+	},
+	{
+		Version:     7,
+		Description: "create webhook and webhookdelivery",
+		Steps: []string{
+			`CREATE TABLE IF NOT EXISTS webhook ( ID varchar(64) NOT NULL, opID varchar(64) DEFAULT NULL, teamID varchar(64) DEFAULT NULL, gid varchar(32) NOT NULL, url varchar(512) NOT NULL, secret varchar(128) NOT NULL, events varchar(512) NOT NULL DEFAULT '', created datetime NOT NULL DEFAULT CURRENT_TIMESTAMP, PRIMARY KEY (ID), KEY opID (opID), KEY teamID (teamID)) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;`,
+			`CREATE TABLE IF NOT EXISTS webhookdelivery ( ID int(11) NOT NULL AUTO_INCREMENT, hookID varchar(64) NOT NULL, eventID bigint(20) NOT NULL DEFAULT '0', event varchar(32) NOT NULL, attempt int(11) NOT NULL DEFAULT '1', status int(11) NOT NULL DEFAULT '0', error text, delivered datetime NOT NULL DEFAULT CURRENT_TIMESTAMP, PRIMARY KEY (ID), KEY hookID (hookID), CONSTRAINT fk_webhook_delivery FOREIGN KEY (hookID) REFERENCES webhook (ID) ON DELETE CASCADE) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;`,
+		},
+	},
+	{
+		Version:     8,
+		Description: "create keytransfer",
+		Steps: []string{
+			`CREATE TABLE IF NOT EXISTS keytransfer ( ID varchar(64) NOT NULL, opID varchar(64) NOT NULL, portalID varchar(64) NOT NULL, fromgid varchar(32) DEFAULT NULL, togid varchar(32) NOT NULL, count int(11) NOT NULL DEFAULT '1', state enum('requested','accepted','completed','cancelled') NOT NULL DEFAULT 'requested', created datetime NOT NULL DEFAULT CURRENT_TIMESTAMP, updated datetime NOT NULL DEFAULT CURRENT_TIMESTAMP, PRIMARY KEY (ID), KEY opID (opID), KEY fromgid (fromgid), KEY togid (togid), CONSTRAINT fk_operation_id_keytransfer FOREIGN KEY (opID) REFERENCES operation (ID) ON DELETE CASCADE) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;`,
+		},
+	},
+	{
+		Version:     9,
+		Description: "create blocker",
+		Steps: []string{
+			`CREATE TABLE IF NOT EXISTS blocker ( ID varchar(64) NOT NULL, opID varchar(64) NOT NULL, fromPortalID varchar(64) NOT NULL, toPortalID varchar(64) NOT NULL, fromName varchar(128) NOT NULL DEFAULT '', fromLat varchar(24) NOT NULL DEFAULT '', fromLon varchar(24) NOT NULL DEFAULT '', toName varchar(128) NOT NULL DEFAULT '', toLat varchar(24) NOT NULL DEFAULT '', toLon varchar(24) NOT NULL DEFAULT '', reportedBy varchar(32) DEFAULT NULL, reported datetime NOT NULL DEFAULT CURRENT_TIMESTAMP, state enum('active','destroyed') NOT NULL DEFAULT 'active', clearedBy varchar(32) DEFAULT NULL, cleared datetime DEFAULT NULL, PRIMARY KEY (ID,opID), KEY opID (opID), KEY portals (fromPortalID,toPortalID), CONSTRAINT fk_operation_id_blocker FOREIGN KEY (opID) REFERENCES operation (ID) ON DELETE CASCADE) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;`,
+		},
+	},
+	{
+		Version:     10,
+		Description: "create phase, add phase to link and marker",
+		Steps: []string{
+			`CREATE TABLE IF NOT EXISTS phase ( ID varchar(64) NOT NULL, opID varchar(64) NOT NULL, name varchar(128) NOT NULL DEFAULT '', start datetime NOT NULL, end datetime NOT NULL, reminded tinyint(1) NOT NULL DEFAULT '0', PRIMARY KEY (ID,opID), KEY opID (opID), KEY start (start), CONSTRAINT fk_operation_id_phase FOREIGN KEY (opID) REFERENCES operation (ID) ON DELETE CASCADE) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;`,
+			`ALTER TABLE link ADD COLUMN IF NOT EXISTS phase varchar(64) DEFAULT NULL;`,
+			`ALTER TABLE marker ADD COLUMN IF NOT EXISTS phase varchar(64) DEFAULT NULL;`,
+		},
+	},
+	{
+		Version:     11,
+		Description: "create wave and wavelink",
+		Steps: []string{
+			`CREATE TABLE IF NOT EXISTS wave ( ID varchar(64) NOT NULL, opID varchar(64) NOT NULL, name varchar(128) NOT NULL DEFAULT '', at datetime NOT NULL, notified int(11) DEFAULT NULL, PRIMARY KEY (ID,opID), KEY opID (opID), KEY at (at), CONSTRAINT fk_operation_id_wave FOREIGN KEY (opID) REFERENCES operation (ID) ON DELETE CASCADE) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;`,
+			`CREATE TABLE IF NOT EXISTS wavelink ( opID varchar(64) NOT NULL, linkID varchar(64) NOT NULL, waveID varchar(64) NOT NULL, completed datetime DEFAULT NULL, PRIMARY KEY (opID,linkID), KEY wave (opID,waveID), CONSTRAINT fk_operation_id_wavelink FOREIGN KEY (opID) REFERENCES operation (ID) ON DELETE CASCADE) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;`,
+		},
+	},
+	{
+		Version:     12,
+		Description: "add version to operation and oprevision",
+		Steps: []string{
+			`ALTER TABLE operation ADD COLUMN IF NOT EXISTS version int(11) NOT NULL DEFAULT 1;`,
+			`ALTER TABLE oprevision ADD COLUMN IF NOT EXISTS version int(11) NOT NULL DEFAULT 0;`,
+		},
+	},
+}
+
+// SetAutoMigrate determines if Connect applies pending migrations; the default is true.
+// wasabee-migrate turns it off so it can report on and apply migrations itself.
+func SetAutoMigrate(auto bool) {
+	autoMigrate = auto
+}
+
+// LatestSchemaVersion is the version the database will be at once all migrations are applied
+func LatestSchemaVersion() int {
+	return migrations[len(migrations)-1].Version
+}
+
+// SchemaVersion returns the version of the most recently applied migration, 0 if none have been applied
+// The in-memory store has no schema and is always current.
+func SchemaVersion() (int, error) {
+	if db == nil {
+		return LatestSchemaVersion(), nil
+	}
+
+	// only looked for, not created, so a dry run changes nothing
+	var tables int
+	if err := db.QueryRow("SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = DATABASE() AND table_name = 'schema_version'").Scan(&tables); err != nil {
+		Log.Error(err)
+		return 0, err
+	}
+	if tables == 0 {
+		return 0, nil
+	}
+
+	var version sql.NullInt64
+	if err := db.QueryRow("SELECT MAX(version) FROM schema_version").Scan(&version); err != nil {
+		Log.Error(err)
+		return 0, err
+	}
+	if !version.Valid {
+		return 0, nil
+	}
+	return int(version.Int64), nil
+}
+
+// PendingMigrations returns the migrations which have not yet been applied, in the order they will be applied
+func PendingMigrations() ([]Migration, error) {
+	var pending []Migration
+
+	current, err := SchemaVersion()
+	if err != nil {
+		Log.Error(err)
+		return pending, err
+	}
+
+	for _, m := range migrations {
+		if m.Version > current {
+			pending = append(pending, m)
+		}
+	}
+	return pending, nil
+}
+
+// Migrate applies all pending migrations in order and returns those which were applied.
+// If dryrun is set nothing is changed and the migrations which would have been applied are returned.
+func Migrate(dryrun bool) ([]Migration, error) {
+	var applied []Migration
+
+	pending, err := PendingMigrations()
+	if err != nil {
+		Log.Error(err)
+		return applied, err
+	}
+
+	if len(pending) > 0 && !dryrun {
+		if err := setupSchemaVersion(); err != nil {
+			Log.Error(err)
+			return applied, err
+		}
+	}
+
+	for _, m := range pending {
+		if dryrun {
+			Log.Infof("would apply schema migration %d: %s", m.Version, m.Description)
+			applied = append(applied, m)
+			continue
+		}
+		Log.Noticef("applying schema migration %d: %s", m.Version, m.Description)
+		if err := m.apply(); err != nil {
+			Log.Critical(err)
+			return applied, err
+		}
+		applied = append(applied, m)
+	}
+	return applied, nil
+}
+
+// setupSchemaVersion creates the schema_version table if it does not exist yet
+func setupSchemaVersion() error {
+	_, err := db.Exec("CREATE TABLE IF NOT EXISTS schema_version ( version int(11) NOT NULL, description varchar(128) NOT NULL, applied datetime NOT NULL DEFAULT CURRENT_TIMESTAMP, PRIMARY KEY (version)) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;")
+	return err
+}
+
+// apply runs the steps of a single migration and records it in schema_version
+func (m Migration) apply() error {
+	// a connection of its own so FOREIGN_KEY_CHECKS=0 holds for every step and never goes back to the pool
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		Log.Error(err)
+		return err
+	}
+	defer conn.Close()
+
+	if _, err = conn.ExecContext(ctx, "SET FOREIGN_KEY_CHECKS=0"); err != nil {
+		Log.Error(err)
+		return err
+	}
+	// restored on every path, failure included
+	defer func() {
+		if _, err := conn.ExecContext(ctx, "SET FOREIGN_KEY_CHECKS=1"); err != nil {
+			Log.Critical(err)
+			// do not let the pool reuse a connection which still has the checks off
+			_ = conn.Raw(func(interface{}) error { return driver.ErrBadConn })
+		}
+	}()
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		Log.Error(err)
+		return err
+	}
+	defer func() {
+		err := tx.Rollback()
+		if err != nil && err != sql.ErrTxDone {
+			Log.Critical(err)
+		}
+	}()
+
+	for i, s := range m.Steps {
+		if _, err = tx.Exec(s); err != nil {
+			err = fmt.Errorf("migration %d step %d failed: %s", m.Version, i+1, err.Error())
+			Log.Error(err)
+			return err
+		}
+	}
+	if _, err = tx.Exec("INSERT INTO schema_version (version, description) VALUES (?, ?)", m.Version, m.Description); err != nil {
+		Log.Error(err)
+		return err
+	}
+	if err = tx.Commit(); err != nil { // the defer'd rollback will not have anything to rollback...
+		Log.Error(err)
+		return err
+	}
+	return nil
+}
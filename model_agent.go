@@ -45,7 +45,8 @@ type AgentData struct {
 		Verified  bool
 		Authtoken string
 	}
-	Assignments []Assignment
+	Assignments  []Assignment
+	KeyTransfers []KeyTransfer
 }
 
 // AdOwnedTeam is a sub-struct of AgentData
@@ -181,14 +182,17 @@ func (gid GoogleID) InitAgent() (bool, error) {
 			Log.Error(err)
 			return false, err
 		}
-		_, err = db.Exec("INSERT IGNORE INTO agent (gid, iname, level, lockey, VVerified, VBlacklisted, Vid, RocksVerified, RAID, RISC) VALUES (?,?,?,?,?,?,?,?,?,0)",
-			gid, MakeNullString(tmpName), vdata.Data.Level, lockey, vdata.Data.Verified, vdata.Data.Blacklisted, MakeNullString(vdata.Data.EnlID), rocks.Verified, 0)
-		if err != nil {
-			Log.Error(err)
-			return false, err
+		ad := AgentData{
+			GoogleID:      gid,
+			IngressName:   tmpName,
+			Level:         vdata.Data.Level,
+			LocationKey:   lockey,
+			VVerified:     vdata.Data.Verified,
+			VBlacklisted:  vdata.Data.Blacklisted,
+			Vid:           vdata.Data.EnlID,
+			RocksVerified: rocks.Verified,
 		}
-		_, err = db.Exec("INSERT IGNORE INTO locations (gid, upTime, loc) VALUES (?,NOW(),POINT(0,0))", gid)
-		if err != nil {
+		if err = store.InsertAgent(&ad); err != nil {
 			Log.Error(err)
 			return false, err
 		}
@@ -215,8 +219,7 @@ func (gid GoogleID) Gid() (GoogleID, error) {
 func (gid GoogleID) GetAgentData(ud *AgentData) error {
 	ud.GoogleID = gid
 
-	var Vid sql.NullString
-	err := db.QueryRow("SELECT u.iname, u.level, u.lockey, u.VVerified, u.VBlacklisted, u.Vid, u.RocksVerified, u.RAID, u.RISC FROM agent=u WHERE u.gid = ?", gid).Scan(&ud.IngressName, &ud.Level, &ud.LocationKey, &ud.VVerified, &ud.VBlacklisted, &Vid, &ud.RocksVerified, &ud.RAID, &ud.RISC)
+	err := store.AgentData(gid, ud)
 	if err != nil && err == sql.ErrNoRows {
 		// if you delete yourself and don't wait for your session cookie to expire to rejoin...
 		err = fmt.Errorf("unknown GoogleID: [%s] try restarting your browser", gid)
@@ -228,10 +231,6 @@ func (gid GoogleID) GetAgentData(ud *AgentData) error {
 		return err
 	}
 
-	if Vid.Valid {
-		ud.Vid = EnlID(Vid.String)
-	}
-
 	if err = gid.adTeams(ud); err != nil {
 		Log.Error(err)
 		return err
@@ -256,142 +255,68 @@ func (gid GoogleID) GetAgentData(ud *AgentData) error {
 		Log.Error(err)
 	}
 
+	if err = gid.adKeyTransfers(ud); err != nil {
+		Log.Error(err)
+	}
+
 	return nil
 }
 
 func (gid GoogleID) adTeams(ud *AgentData) error {
-	rows, err := db.Query("SELECT t.teamID, t.name, x.state, t.rockscomm FROM team=t, agentteams=x WHERE x.gid = ? AND x.teamID = t.teamID ORDER BY t.name", gid)
+	teams, err := store.AgentTeams(gid)
 	if err != nil {
 		Log.Error(err)
 		return err
 	}
-	defer rows.Close()
-	var adteam AdTeam
-	var rc sql.NullString
-	for rows.Next() {
-		err := rows.Scan(&adteam.ID, &adteam.Name, &adteam.State, &rc)
-		if err != nil {
-			Log.Error(err)
-			return err
-		}
-		if rc.Valid {
-			adteam.RocksComm = rc.String
-		} else {
-			adteam.RocksComm = ""
-		}
-		ud.Teams = append(ud.Teams, adteam)
-	}
+	ud.Teams = append(ud.Teams, teams...)
 	return nil
 }
 
 func (gid GoogleID) adOwnedTeams(ud *AgentData) error {
-	var ownedTeam AdOwnedTeam
-	row, err := db.Query("SELECT teamID, name, rockscomm, rockskey FROM team WHERE owner = ? ORDER BY name", gid)
+	teams, err := store.AgentOwnedTeams(gid)
 	if err != nil {
 		Log.Error(err)
 		return err
 	}
-	defer row.Close()
-	var rc, rockskey sql.NullString
-	for row.Next() {
-		err := row.Scan(&ownedTeam.ID, &ownedTeam.Name, &rc, &rockskey)
-		if err != nil {
-			Log.Error(err)
-			return err
-		}
-		if rc.Valid {
-			ownedTeam.RocksComm = rc.String
-		} else {
-			ownedTeam.RocksComm = ""
-		}
-		if rockskey.Valid {
-			ownedTeam.RocksKey = rockskey.String
-		} else {
-			ownedTeam.RocksKey = ""
-		}
-		ud.OwnedTeams = append(ud.OwnedTeams, ownedTeam)
-	}
+	ud.OwnedTeams = append(ud.OwnedTeams, teams...)
 	return nil
 }
 
 func (gid GoogleID) adTelegram(ud *AgentData) error {
-	var authtoken sql.NullString
-	err := db.QueryRow("SELECT telegramID, verified, authtoken FROM telegram WHERE gid = ?", gid).Scan(&ud.Telegram.ID, &ud.Telegram.Verified, &authtoken)
+	tgid, verified, authtoken, err := store.AgentTelegram(gid)
 	if err != nil && err == sql.ErrNoRows {
 		ud.Telegram.ID = 0
 		ud.Telegram.Verified = false
 		ud.Telegram.Authtoken = ""
+		return nil
 	} else if err != nil {
 		Log.Error(err)
 		return err
 	}
-	if authtoken.Valid {
-		ud.Telegram.Authtoken = authtoken.String
-	}
+	ud.Telegram.ID = int64(tgid)
+	ud.Telegram.Verified = verified
+	ud.Telegram.Authtoken = authtoken
 	return nil
 }
 
 func (gid GoogleID) adOps(ud *AgentData) error {
-	var op AdOperation
-	var g GoogleID
-
-	row2, err := db.Query("SELECT o.ID, o.Name, o.Gid, o.Color, t.Name, p.teamID FROM operation=o, team=t, agentteams=x, opteams=p WHERE p.opID = o.ID AND x.gid = ? AND x.teamID = p.teamID AND x.teamID = t.teamID AND x.state = 'On' ORDER BY o.Name, t.Name", gid)
+	ops, err := store.AgentOps(gid)
 	if err != nil {
 		Log.Error(err)
 		return err
 	}
-	defer row2.Close()
-	for row2.Next() {
-		err := row2.Scan(&op.ID, &op.Name, &g, &op.Color, &op.TeamName, &op.TeamID)
-		if err != nil {
-			Log.Error(err)
-			return err
-		}
-		if gid == g {
-			op.IsOwner = true
-		} else {
-			op.IsOwner = false
-		}
-		ud.Ops = append(ud.Ops, op)
-	}
+	ud.Ops = append(ud.Ops, ops...)
 	return nil
 }
 
 func (gid GoogleID) adAssignments(ud *AgentData) error {
-	var a Assignment
-
-	a.Type = "Marker"
-	row, err := db.Query("SELECT DISTINCT o.Name, o.ID FROM marker=m, operation=o WHERE m.gid = ? AND m.opID = o.ID ORDER BY o.Name", gid)
+	// only ops where the agent still has something to do in the current or an upcoming phase
+	assignments, err := store.AgentAssignments(gid)
 	if err != nil {
 		Log.Error(err)
 		return err
 	}
-	defer row.Close()
-	for row.Next() {
-		err := row.Scan(&a.OperationName, &a.OpID)
-		if err != nil {
-			Log.Error(err)
-			return err
-		}
-		ud.Assignments = append(ud.Assignments, a)
-	}
-
-	a.Type = "Link"
-	row2, err := db.Query("SELECT DISTINCT o.Name, o.ID FROM link=l, operation=o WHERE l.gid = ? AND l.opID = o.ID ORDER BY o.Name", gid)
-	if err != nil {
-		Log.Error(err)
-		return err
-	}
-	defer row2.Close()
-	for row2.Next() {
-		err := row2.Scan(&a.OperationName, &a.OpID)
-		if err != nil {
-			Log.Error(err)
-			return err
-		}
-		ud.Assignments = append(ud.Assignments, a)
-	}
-
+	ud.Assignments = append(ud.Assignments, assignments...)
 	return nil
 }
 
@@ -408,29 +333,25 @@ func (gid GoogleID) AgentLocation(lat, lon string) error {
 		Log.Notice(err)
 		return err
 	}
-	point := fmt.Sprintf("POINT(%s %s)", strconv.FormatFloat(flon, 'f', 7, 64), strconv.FormatFloat(flat, 'f', 7, 64))
-	if _, err := db.Exec("UPDATE locations SET loc = PointFromText(?), upTime = NOW() WHERE gid = ?", point, gid); err != nil {
+	if err := store.SetAgentLocation(gid, flat, flon); err != nil {
 		Log.Notice(err)
 		return err
 	}
 
-	gid.firebaseAgentLocation()
+	publishEvent(Event{Type: EventAgentLocation, Gid: gid})
 	return nil
 }
 
 // IngressName returns an agent's name for a given GoogleID.
 // It returns err == sql.ErrNoRows if there is no such agent.
 func (gid GoogleID) IngressName() (string, error) {
-	var iname string
-	err := db.QueryRow("SELECT iname FROM agent WHERE gid = ?", gid).Scan(&iname)
-	return iname, err
+	return store.IngressName(gid)
 }
 
 // IngressNameTeam returns the display name for an agent on a particular team, or the IngressName if not set
 func (gid GoogleID) IngressNameTeam(teamID TeamID) (string, error) {
-	var displayname sql.NullString
-	err := db.QueryRow("SELECT displayname FROM agentteams WHERE teamID = ? AND gid = ?", teamID, gid).Scan(&displayname)
-	if (err != nil && err == sql.ErrNoRows) || !displayname.Valid {
+	displayname, err := store.TeamAgentDisplayName(teamID, gid)
+	if (err != nil && err == sql.ErrNoRows) || (err == nil && displayname == "") {
 		return gid.IngressName()
 	}
 	if err != nil {
@@ -438,7 +359,7 @@ func (gid GoogleID) IngressNameTeam(teamID TeamID) (string, error) {
 		return "", err
 	}
 
-	return displayname.String, nil
+	return displayname, nil
 }
 
 func (gid GoogleID) IngressNameOperation(o *Operation) (string, error) {
@@ -478,20 +399,13 @@ func RevalidateEveryone() error {
 	channel := make(chan error, 2)
 	defer close(channel)
 
-	rows, err := db.Query("SELECT gid FROM agent")
+	gids, err := store.AllAgents()
 	if err != nil {
 		Log.Error(err)
 		return err
 	}
-	defer rows.Close()
-
-	var gid GoogleID
-	for rows.Next() {
-		if err = rows.Scan(&gid); err != nil {
-			Log.Error(err)
-			continue
-		}
 
+	for _, gid := range gids {
 		var v Vresult
 		var r RocksAgent
 
@@ -530,8 +444,7 @@ func RevalidateEveryone() error {
 
 // SearchAgentName gets a GoogleID from an Agent's name
 func SearchAgentName(agent string) (GoogleID, error) {
-	var gid GoogleID
-	err := db.QueryRow("SELECT gid FROM agent WHERE LOWER(iname) LIKE LOWER(?)", agent).Scan(&gid)
+	gid, err := store.SearchAgentName(agent)
 	if err != nil && err != sql.ErrNoRows {
 		Log.Notice(err)
 		return "", err
@@ -542,19 +455,13 @@ func SearchAgentName(agent string) (GoogleID, error) {
 // Delete removes an agent and all associated data
 func (gid GoogleID) Delete() error {
 	// teams require special attention since they might be linked to .rocks communities
-	var teamID TeamID
-	rows, err := db.Query("SELECT teamID FROM team WHERE owner = ?", gid)
+	owned, err := store.AgentOwnedTeams(gid)
 	if err != nil {
 		Log.Error(err)
 		return err
 	}
-	defer rows.Close()
-	for rows.Next() {
-		err = rows.Scan(&teamID)
-		if err != nil {
-			Log.Error(err)
-			continue
-		}
+	for _, t := range owned {
+		teamID := TeamID(t.ID)
 		err = teamID.Delete()
 		if err != nil {
 			Log.Error(err)
@@ -562,34 +469,21 @@ func (gid GoogleID) Delete() error {
 		}
 	}
 
-	teamrows, err := db.Query("SELECT teamID FROM agentteams WHERE gid = ?", gid)
+	teams, err := store.AgentTeamIDs(gid)
 	if err != nil {
 		Log.Error(err)
 		return err
 	}
-	defer teamrows.Close()
-	for teamrows.Next() {
-		err := teamrows.Scan(&teamID)
-		if err != nil {
-			Log.Error(err)
-			continue
-		}
+	for _, teamID := range teams {
 		_ = teamID.RemoveAgent(gid)
 	}
 
 	// brute force delete everyhing else
-	_, err = db.Exec("DELETE FROM agent WHERE gid = ?", gid)
-	if err != nil {
+	if err = store.DeleteAgent(gid); err != nil {
 		Log.Notice(err)
 		return err
 	}
 
-	// the foreign key constraints should take care of these, but just in case...
-	_, _ = db.Exec("DELETE FROM locations WHERE gid = ?", gid)
-	_, _ = db.Exec("DELETE FROM telegram WHERE gid = ?", gid)
-	_, _ = db.Exec("DELETE FROM agentextras WHERE gid = ?", gid)
-	_, _ = db.Exec("DELETE FROM firebase WHERE gid = ?", gid)
-
 	return nil
 }
 
@@ -601,7 +495,7 @@ func (gid GoogleID) Lock(reason string) error {
 		return err
 	}
 
-	if _, err := db.Exec("UPDATE agent SET RISC = 1 WHERE gid = ?", gid); err != nil {
+	if err := store.SetAgentRISC(gid, true); err != nil {
 		Log.Error(err)
 		return err
 	}
@@ -616,7 +510,7 @@ func (gid GoogleID) Unlock(reason string) error {
 		return err
 	}
 
-	if _, err := db.Exec("UPDATE agent SET RISC = 0 WHERE gid = ?", gid); err != nil {
+	if err := store.SetAgentRISC(gid, false); err != nil {
 		Log.Error(err)
 		return err
 	}
@@ -651,9 +545,7 @@ func (gid GoogleID) CheckLogout() bool {
 
 // RISC checks to see if the user was marked as compromised by Google
 func (gid GoogleID) RISC() bool {
-	var RISC bool
-
-	err := db.QueryRow("SELECT RISC FROM agent WHERE gid = ?", gid).Scan(&RISC)
+	RISC, err := store.AgentRISC(gid)
 	if err != nil {
 		Log.Notice(err)
 	}
@@ -662,7 +554,7 @@ func (gid GoogleID) RISC() bool {
 
 // UpdatePicture sets/updates the agent's google picture URL
 func (gid GoogleID) UpdatePicture(picurl string) error {
-	if _, err := db.Exec("REPLACE INTO agentextras (gid, picurl) VALUES (?,?) ", gid, picurl); err != nil {
+	if err := store.SetAgentPicture(gid, picurl); err != nil {
 		Log.Error(err)
 		return err
 	}
@@ -671,9 +563,7 @@ func (gid GoogleID) UpdatePicture(picurl string) error {
 
 // GetPicture returns the agent's Google Picture URL
 func (gid GoogleID) GetPicture() string {
-	var url string
-
-	err := db.QueryRow("SELECT picurl FROM agentextras WHERE gid = ?", gid).Scan(&url)
+	url, err := store.AgentPicture(gid)
 	if err != nil {
 		// Log.Info(err)
 		wr, _ := GetWebroot()
@@ -9,25 +9,12 @@ func (o *Operation) PopulateTeams() error {
 	// start empty, trust only what is in the database
 	o.Teams = nil
 
-	rows, err := db.Query("SELECT teamID, permission FROM opteams WHERE opID = ?", o.ID)
-	if err != nil && err != sql.ErrNoRows {
+	teams, err := store.OperationTeams(o.ID)
+	if err != nil {
 		Log.Notice(err)
 		return err
 	}
-	defer rows.Close()
-
-	var tid, role string
-	for rows.Next() {
-		err := rows.Scan(&tid, &role)
-		if err != nil {
-			Log.Notice(err)
-			continue
-		}
-		o.Teams = append(o.Teams, ExtendedTeam{
-			TeamID: TeamID(tid),
-			Role:   etRole(role),
-		})
-	}
+	o.Teams = teams
 	return nil
 }
 
@@ -69,16 +56,12 @@ func (o *Operation) WriteAccess(gid GoogleID) bool {
 
 // IsOwner returns a bool value determining if the operation is owned by the specified googleID
 func (opID OperationID) IsOwner(gid GoogleID) bool {
-	var c int
-	err := db.QueryRow("SELECT COUNT(*) FROM operation WHERE ID = ? and gid = ?", opID, gid).Scan(&c)
-	if err != nil {
+	owner, err := store.OperationOwner(opID)
+	if err != nil && err != sql.ErrNoRows {
 		Log.Error(err)
 		return false
 	}
-	if c < 1 {
-		return false
-	}
-	return true
+	return err == nil && owner == gid
 }
 
 // Chown changes an operation's owner
@@ -101,11 +84,18 @@ func (opID OperationID) Chown(gid GoogleID, to string) error {
 		return err
 	}
 
-	_, err = db.Exec("UPDATE operation SET gid = ? WHERE ID = ?", togid, opID)
+	err = store.SetOperationOwner(opID, togid)
 	if err != nil {
 		Log.Error(err)
 		return err
 	}
+	opID.emit(Event{
+		Type:   EventOpMeta,
+		Gid:    gid,
+		Action: "chown",
+		Detail: togid.String(),
+		Data:   map[string]string{"owner": togid.String()},
+	})
 
 	return nil
 }
@@ -149,13 +139,22 @@ func (o *Operation) AddPerm(gid GoogleID, teamID TeamID, perm string) error {
 		Log.Error(err)
 		return err
 	}
-	_, err = db.Exec("INSERT INTO opteams VALUES (?,?,?)", teamID, o.ID, perm)
+	err = store.AddOperationTeam(o.ID, teamID, et)
 	if err != nil {
 		Log.Error(err)
 		return err
 	}
 
-	if err = o.Touch(); err != nil {
+	o.ID.emit(Event{
+		Type:     EventOpTeam,
+		TeamID:   teamID,
+		ObjectID: teamID.String(),
+		Gid:      gid,
+		Action:   "add",
+		Detail:   perm,
+		Data:     map[string]string{"role": perm},
+	})
+	if err = o.Touch(gid, "add team "+teamID.String()); err != nil {
 		Log.Error(err)
 		return err
 	}
@@ -169,12 +168,21 @@ func (o *Operation) DelPerm(gid GoogleID, teamID TeamID, perm string) error {
 		return err
 	}
 
-	_, err := db.Exec("DELETE FROM opteams WHERE teamID = ? AND opID = ? AND permission = ? LIMIT 1", teamID, o.ID, perm)
+	err := store.DeleteOperationTeam(o.ID, teamID, etRole(perm))
 	if err != nil {
 		Log.Error(err)
 		return err
 	}
-	if err = o.Touch(); err != nil {
+	o.ID.emit(Event{
+		Type:     EventOpTeam,
+		TeamID:   teamID,
+		ObjectID: teamID.String(),
+		Gid:      gid,
+		Action:   "remove",
+		Detail:   perm,
+		Data:     map[string]string{"role": ""},
+	})
+	if err = o.Touch(gid, "remove team "+teamID.String()); err != nil {
 		Log.Error(err)
 		return err
 	}
@@ -1,7 +1,7 @@
 package wasabee
 
 import (
-	"database/sql"
+	"time"
 )
 
 // Assignments is used to show assignments to users in various ways
@@ -9,70 +9,61 @@ type Assignments struct {
 	Links   []Link
 	Markers []Marker
 	Portals map[PortalID]Portal
+	Phases  map[PhaseID]Phase
 }
 
-// Assignments builds an Assignments struct for a user for an op
+// Assignments builds an Assignments struct for a user for an op; links and markers in phases which have ended are left out
 func (gid GoogleID) Assignments(opID OperationID, assignments *Assignments) error {
-	var tmpLink Link
-	var tmpMarker Marker
-	var tmpPortal Portal
-	var description, comment sql.NullString
-
-	rows, err := db.Query("SELECT ID, fromPortalID, toPortalID, description, throworder FROM link WHERE opID = ? AND gid = ? ORDER BY throworder", opID, gid)
+	links, markers, phases, err := gid.currentAssignments(opID)
 	if err != nil {
 		Log.Error(err)
 		return err
 	}
-	defer rows.Close()
-	for rows.Next() {
-		err := rows.Scan(&tmpLink.ID, &tmpLink.From, &tmpLink.To, &description, &tmpLink.ThrowOrder)
-		if err != nil {
-			Log.Error(err)
-			continue
-		}
-		if description.Valid {
-			tmpLink.Desc = description.String
-		} else {
-			tmpLink.Desc = ""
-		}
-		assignments.Links = append(assignments.Links, tmpLink)
-	}
+	assignments.Links = append(assignments.Links, links...)
+	assignments.Markers = append(assignments.Markers, markers...)
+	assignments.Phases = phases
 
-	rows2, err := db.Query("SELECT ID, PortalID, type, gid, comment, state FROM marker WHERE opID = ? AND gid = ?", opID, gid)
+	// XXX this gets way too much, but good enough for now
+	assignments.Portals = make(map[PortalID]Portal)
+	portals, err := store.Portals(opID)
 	if err != nil {
 		Log.Error(err)
 		return err
 	}
-	defer rows2.Close()
-	for rows2.Next() {
-		err := rows2.Scan(&tmpMarker.ID, &tmpMarker.PortalID, &tmpMarker.Type, &tmpMarker.AssignedTo, &comment, &tmpMarker.State)
-		if err != nil {
-			Log.Error(err)
-			continue
-		}
-		if comment.Valid {
-			tmpMarker.Comment = comment.String
-		} else {
-			tmpMarker.Comment = ""
+	for _, p := range portals {
+		assignments.Portals[p.ID] = p
+	}
+	return nil
+}
+
+// currentAssignments is the agent's links and markers in the op which are not in a phase which has ended
+func (gid GoogleID) currentAssignments(opID OperationID) ([]Link, []Marker, map[PhaseID]Phase, error) {
+	phases, err := opID.phaseMap()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	now := time.Now()
+
+	var links []Link
+	all, err := store.AgentLinks(opID, gid)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	for _, l := range all {
+		if phaseCurrent(phases, l.Phase, now) {
+			links = append(links, l)
 		}
-		assignments.Markers = append(assignments.Markers, tmpMarker)
 	}
 
-	// XXX this gets way too much, but good enough for now
-	assignments.Portals = make(map[PortalID]Portal)
-	rows3, err := db.Query("SELECT ID, name, Y(loc) AS lat, X(loc) AS lon FROM portal WHERE opID = ? ORDER BY name", opID)
+	var markers []Marker
+	allm, err := store.AgentMarkers(opID, gid)
 	if err != nil {
-		Log.Error(err)
-		return err
+		return nil, nil, nil, err
 	}
-	defer rows3.Close()
-	for rows3.Next() {
-		err := rows3.Scan(&tmpPortal.ID, &tmpPortal.Name, &tmpPortal.Lat, &tmpPortal.Lon)
-		if err != nil {
-			Log.Error(err)
-			continue
+	for _, m := range allm {
+		if phaseCurrent(phases, m.Phase, now) {
+			markers = append(markers, m)
 		}
-		assignments.Portals[tmpPortal.ID] = tmpPortal
 	}
-	return nil
+	return links, markers, phases, nil
 }
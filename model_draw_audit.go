@@ -0,0 +1,77 @@
+package wasabee
+
+import (
+	"fmt"
+)
+
+// the default and largest number of audit entries returned in one page
+const (
+	auditDefaultLimit = 50
+	auditMaxLimit     = 500
+)
+
+// AuditEntry records who changed what in an operation. The audit log is append-only and outlives the op itself.
+type AuditEntry struct {
+	ID       int         `json:"id"`
+	OpID     OperationID `json:"opID"`
+	Gid      GoogleID    `json:"gid"`
+	Name     string      `json:"name"`
+	Recorded string      `json:"recorded"`
+	Action   string      `json:"action"`
+	Type     string      `json:"type"` // operation, portal, link, wave, marker or team
+	ObjectID string      `json:"objectID"`
+	Detail   string      `json:"detail"`
+}
+
+// AuditFilter selects a page of the audit log; empty fields match everything
+type AuditFilter struct {
+	Gid      GoogleID
+	Type     string
+	ObjectID string
+	Offset   int
+	Limit    int
+}
+
+// auditEvent appends an op event to the audit log; failures are logged but never undo the change being recorded
+func auditEvent(e Event) {
+	if e.OpID == "" || e.Action == "" {
+		return
+	}
+	err := store.InsertAuditEntry(AuditEntry{
+		OpID:     e.OpID,
+		Gid:      e.Gid,
+		Action:   e.Action,
+		Type:     e.Type.object(),
+		ObjectID: e.ObjectID,
+		Detail:   e.Detail,
+	})
+	if err != nil {
+		Log.Error(err)
+	}
+}
+
+// Audit returns a page of the op's audit log, newest first. Only the owner may see it.
+func (o *Operation) Audit(gid GoogleID, filter AuditFilter) ([]AuditEntry, error) {
+	if !o.ID.IsOwner(gid) {
+		err := fmt.Errorf("only the owner can view the audit log")
+		Log.Error(err)
+		return nil, err
+	}
+
+	if filter.Offset < 0 {
+		filter.Offset = 0
+	}
+	if filter.Limit <= 0 {
+		filter.Limit = auditDefaultLimit
+	}
+	if filter.Limit > auditMaxLimit {
+		filter.Limit = auditMaxLimit
+	}
+
+	entries, err := store.AuditEntries(o.ID, filter)
+	if err != nil {
+		Log.Error(err)
+		return entries, err
+	}
+	return entries, nil
+}
@@ -0,0 +1,294 @@
+package wasabee
+
+import (
+	"fmt"
+)
+
+// Blocker is an enemy link in the way of an op, as reported by a planner or an agent in the field.
+// The ends are kept with it so it can be shown in ops which do not have those portals.
+type Blocker struct {
+	ID         LinkID      `json:"ID"`
+	OpID       OperationID `json:"opID"`
+	From       PortalID    `json:"fromPortalId"`
+	To         PortalID    `json:"toPortalId"`
+	FromName   string      `json:"fromName"`
+	FromLat    string      `json:"fromLat"`
+	FromLon    string      `json:"fromLng"`
+	ToName     string      `json:"toName"`
+	ToLat      string      `json:"toLat"`
+	ToLon      string      `json:"toLng"`
+	ReportedBy GoogleID    `json:"reportedBy,omitempty"`
+	Reported   string      `json:"reported"`
+	State      string      `json:"state"`
+	ClearedBy  GoogleID    `json:"clearedBy,omitempty"`
+	Cleared    string      `json:"cleared,omitempty"`
+}
+
+// BlockerActive and BlockerDestroyed are the states of a Blocker
+const (
+	BlockerActive    = "active"
+	BlockerDestroyed = "destroyed"
+)
+
+// link is the blocker as it appears in Operation.Blockers
+func (b Blocker) link() Link {
+	return Link{ID: b.ID, From: b.From, To: b.To}
+}
+
+// ends are the blocker's portals as they were when it was reported
+func (b Blocker) ends() []Portal {
+	return []Portal{
+		{ID: b.From, Name: b.FromName, Lat: b.FromLat, Lon: b.FromLon},
+		{ID: b.To, Name: b.ToName, Lat: b.ToLat, Lon: b.ToLon},
+	}
+}
+
+// same is true if both are the same enemy link, whichever way round and in whichever op
+func (b Blocker) same(c Blocker) bool {
+	return (b.From == c.From && b.To == c.To) || (b.From == c.To && b.To == c.From)
+}
+
+func newBlocker(opID OperationID, id LinkID, from, to Portal, gid GoogleID) Blocker {
+	return Blocker{
+		ID:         id,
+		OpID:       opID,
+		From:       from.ID,
+		To:         to.ID,
+		FromName:   from.Name,
+		FromLat:    from.Lat,
+		FromLon:    from.Lon,
+		ToName:     to.Name,
+		ToLat:      to.Lat,
+		ToLon:      to.Lon,
+		ReportedBy: gid,
+		State:      BlockerActive,
+	}
+}
+
+// insertBlockers saves any blockers in an upload the op does not already have; blockers are never removed by an upload
+func (o *Operation) insertBlockers(gid GoogleID) {
+	for _, b := range o.uploadedBlockers(gid) {
+		if err := store.InsertBlocker(b); err != nil {
+			Log.Error(err)
+		}
+	}
+}
+
+// uploadedBlockers are the blockers in an upload, skipping any to a portal not in the upload
+func (o *Operation) uploadedBlockers(gid GoogleID) []Blocker {
+	var blockers []Blocker
+	portals := make(map[PortalID]Portal)
+	for _, p := range o.OpPortals {
+		portals[p.ID] = p
+	}
+	for _, l := range o.Blockers {
+		from, ok := portals[l.From]
+		if !ok {
+			Log.Debugf("source portalID %s missing from portal list for blocker in op %s", l.From, o.ID)
+			continue
+		}
+		to, ok := portals[l.To]
+		if !ok {
+			Log.Debugf("destination portalID %s missing from portal list for blocker in op %s", l.To, o.ID)
+			continue
+		}
+		blockers = append(blockers, newBlocker(o.ID, l.ID, from, to, gid))
+	}
+	return blockers
+}
+
+// PopulateBlockers fills in the op's blockers which are still up. No authorization takes place.
+func (o *Operation) PopulateBlockers() error {
+	blockers, err := store.Blockers(o.ID)
+	if err != nil {
+		Log.Error(err)
+		return err
+	}
+	for _, b := range blockers {
+		if b.State == BlockerActive {
+			o.Blockers = append(o.Blockers, b.link())
+			o.blockerEnds = append(o.blockerEnds, b.ends()...)
+		}
+	}
+	return nil
+}
+
+// ListBlockers returns all the op's blockers, including those which have been destroyed
+func (o *Operation) ListBlockers(gid GoogleID) ([]Blocker, error) {
+	if !o.ReadAccess(gid) && !o.AssignedOnlyAccess(gid) {
+		err := fmt.Errorf("permission denied: %s listing blockers in op %s", gid, o.ID)
+		Log.Error(err)
+		return nil, err
+	}
+	blockers, err := store.Blockers(o.ID)
+	if err != nil {
+		Log.Error(err)
+		return nil, err
+	}
+	return blockers, nil
+}
+
+// ReportBlocker records a blocker between two portals; portals with no location are looked up in the op
+func (o *Operation) ReportBlocker(gid GoogleID, from, to Portal) (Blocker, error) {
+	if !o.ReadAccess(gid) && !o.AssignedOnlyAccess(gid) {
+		err := fmt.Errorf("permission denied: %s reporting a blocker in op %s", gid, o.ID)
+		Log.Error(err)
+		return Blocker{}, err
+	}
+	for _, p := range []*Portal{&from, &to} {
+		if p.Lat != "" && p.Lon != "" {
+			continue
+		}
+		known, err := store.Portal(o.ID, p.ID)
+		if err != nil {
+			err := fmt.Errorf("portal %s not in op %s and no location given", p.ID, o.ID)
+			Log.Notice(err)
+			return Blocker{}, err
+		}
+		*p = known
+	}
+	if from.ID == to.ID {
+		err := fmt.Errorf("a blocker needs two different portals")
+		Log.Notice(err)
+		return Blocker{}, err
+	}
+
+	b := newBlocker(o.ID, LinkID(GenerateName()), from, to, gid)
+	if err := store.InsertBlocker(b); err != nil {
+		Log.Error(err)
+		return b, err
+	}
+	o.ID.emit(Event{
+		Type:     EventBlockerReported,
+		ObjectID: string(b.ID),
+		Gid:      gid,
+		Action:   "report",
+		Detail:   fmt.Sprintf("blocker %s - %s", b.FromName, b.ToName),
+		Data:     b,
+	})
+	if err := o.Touch(gid, "report blocker "+string(b.ID)); err != nil {
+		Log.Error(err)
+	}
+	return store.Blocker(o.ID, b.ID)
+}
+
+// ClearBlocker marks a blocker destroyed, here and in every op sharing a team with this one,
+// and tells the agents assigned to links it crossed that they are clear to throw
+func (o *Operation) ClearBlocker(gid GoogleID, blockerID LinkID) (Blocker, error) {
+	if !o.ReadAccess(gid) && !o.AssignedOnlyAccess(gid) {
+		err := fmt.Errorf("permission denied: %s clearing a blocker in op %s", gid, o.ID)
+		Log.Error(err)
+		return Blocker{}, err
+	}
+	b, err := store.Blocker(o.ID, blockerID)
+	if err != nil {
+		Log.Notice(err)
+		return b, err
+	}
+
+	cleared := []Blocker{b}
+	if len(o.Teams) == 0 {
+		o.PopulateTeams()
+	}
+	seen := map[OperationID]bool{o.ID: true}
+	for _, t := range o.Teams {
+		shared, err := store.TeamBlockers(t.TeamID)
+		if err != nil {
+			Log.Error(err)
+			continue
+		}
+		for _, s := range shared {
+			if !seen[s.OpID] && s.State == BlockerActive && s.same(b) {
+				seen[s.OpID] = true
+				cleared = append(cleared, s)
+			}
+		}
+	}
+
+	for _, c := range cleared {
+		if err := store.SetBlockerCleared(c.OpID, c.ID, gid); err != nil {
+			Log.Error(err)
+			return b, err
+		}
+		op := Operation{ID: c.OpID}
+		op.ID.emit(Event{
+			Type:     EventBlockerCleared,
+			ObjectID: string(c.ID),
+			Gid:      gid,
+			Action:   "clear",
+			Detail:   fmt.Sprintf("blocker %s - %s", c.FromName, c.ToName),
+			Data:     c,
+		})
+		if err := op.Touch(gid, "clear blocker "+string(c.ID)); err != nil {
+			Log.Error(err)
+		}
+		op.notifyBlockerCleared(c, gid)
+	}
+	return store.Blocker(o.ID, blockerID)
+}
+
+// notifyBlockerCleared messages the assignees of the op's links which crossed the blocker
+func (o *Operation) notifyBlockerCleared(b Blocker, by GoogleID) {
+	portals, err := store.Portals(o.ID)
+	if err != nil {
+		Log.Error(err)
+		return
+	}
+	links, err := store.Links(o.ID)
+	if err != nil {
+		Log.Error(err)
+		return
+	}
+	g, _ := (&Operation{OpPortals: portals, blockerEnds: b.ends()}).geometry()
+
+	affected := make(map[GoogleID][]LinkID)
+	for _, l := range links {
+		if l.AssignedTo != "" && l.AssignedTo != by && g.linksCross(l, b.link()) {
+			affected[l.AssignedTo] = append(affected[l.AssignedTo], l.ID)
+		}
+	}
+	for agent, ls := range affected {
+		msg := fmt.Sprintf("the blocker %s - %s is down; %d of your links in op %s are clear", b.FromName, b.ToName, len(ls), o.ID)
+		if _, err := agent.SendMessage(msg); err != nil {
+			Log.Notice(err)
+		}
+	}
+}
+
+// DeleteBlocker removes a blocker reported by mistake
+func (o *Operation) DeleteBlocker(gid GoogleID, blockerID LinkID) error {
+	if !o.WriteAccess(gid) {
+		err := fmt.Errorf("write access required to delete blockers")
+		Log.Error(err)
+		return err
+	}
+	if err := store.DeleteBlocker(o.ID, blockerID); err != nil {
+		Log.Error(err)
+		return err
+	}
+	o.ID.emit(Event{
+		Type:     EventBlockerDeleted,
+		ObjectID: string(blockerID),
+		Gid:      gid,
+		Action:   "delete",
+	})
+	if err := o.Touch(gid, "delete blocker "+string(blockerID)); err != nil {
+		Log.Error(err)
+	}
+	return nil
+}
+
+// Blockers lists the blockers of all the team's ops, for an agent on the team
+func (teamID TeamID) Blockers(gid GoogleID) ([]Blocker, error) {
+	if inteam, _ := gid.AgentInTeam(teamID, true); !inteam {
+		err := fmt.Errorf("%s not on team %s", gid, teamID)
+		Log.Error(err)
+		return nil, err
+	}
+	blockers, err := store.TeamBlockers(teamID)
+	if err != nil {
+		Log.Error(err)
+		return nil, err
+	}
+	return blockers, nil
+}
@@ -0,0 +1,133 @@
+package wasabee_test
+
+import (
+	"encoding/json"
+	"github.com/wasabee-project/Wasabee-Server"
+	"testing"
+)
+
+func TestBlockers(t *testing.T) {
+	portals := []wasabee.Portal{
+		{ID: "A", Name: "A", Lat: "0", Lon: "0"},
+		{ID: "B", Name: "B", Lat: "0", Lon: "2"},
+		{ID: "Y", Name: "Y", Lat: "-1", Lon: "1"},
+		{ID: "Z", Name: "Z", Lat: "1", Lon: "1"},
+	}
+	one := wasabee.Operation{
+		ID:        "blockers1",
+		Name:      "blockers1",
+		OpPortals: portals,
+		Links:     []wasabee.Link{{ID: "AB", From: "A", To: "B", AssignedTo: gid}},
+		Blockers:  []wasabee.Link{{ID: "YZ", From: "Y", To: "Z"}, {ID: "YQ", From: "Y", To: "Q"}},
+	}
+	j, _ := json.Marshal(one)
+	if err := wasabee.DrawInsert(j, gid); err != nil {
+		t.Fatal(err.Error())
+	}
+	defer one.Delete(gid)
+
+	op := wasabee.Operation{ID: one.ID}
+	if err := op.Populate(gid); err != nil {
+		t.Fatal(err.Error())
+	}
+	// the blocker to a portal not in the op is dropped
+	if len(op.Blockers) != 1 || op.Blockers[0].ID != "YZ" {
+		t.Fatalf("blockers not stored: %v", op.Blockers)
+	}
+	if v := op.Validate(); v.Valid {
+		t.Error("link crossing a blocker is valid")
+	}
+
+	// an upload without blockers does not lose them
+	one.Blockers = nil
+	j, _ = json.Marshal(one)
+	if err := wasabee.DrawUpdate(one.ID, j, gid, wasabee.OpPrecondition{}); err != nil {
+		t.Fatal(err.Error())
+	}
+	blockers, err := op.ListBlockers(gid)
+	if err != nil || len(blockers) != 1 || blockers[0].ReportedBy != gid || blockers[0].State != wasabee.BlockerActive || blockers[0].FromLat != "-1" {
+		t.Errorf("unexpected blockers: %v %v", blockers, err)
+	}
+
+	// another op on the same team sees the same enemy link the other way round
+	two := wasabee.Operation{ID: "blockers2", Name: "blockers2", OpPortals: portals, Blockers: []wasabee.Link{{ID: "ZY", From: "Z", To: "Y"}}}
+	j, _ = json.Marshal(two)
+	if err := wasabee.DrawInsert(j, gid); err != nil {
+		t.Fatal(err.Error())
+	}
+	defer two.Delete(gid)
+	if len(op.Teams) == 0 {
+		t.Fatal("op has no team")
+	}
+	team := op.Teams[0].TeamID
+	if err := two.AddPerm(gid, team, "read"); err != nil {
+		t.Fatal(err.Error())
+	}
+	shared, err := team.Blockers(gid)
+	if err != nil || len(shared) != 2 {
+		t.Errorf("unexpected team blockers: %v %v", shared, err)
+	}
+
+	// reported from the field, to a portal the op does not have
+	if _, err := op.ReportBlocker(gid, wasabee.Portal{ID: "A"}, wasabee.Portal{ID: "X"}); err == nil {
+		t.Error("reported a blocker to an unknown portal")
+	}
+	if _, err := op.ReportBlocker(wasabee.GoogleID("nobody"), wasabee.Portal{ID: "A"}, wasabee.Portal{ID: "B"}); err == nil {
+		t.Error("outsider reported a blocker")
+	}
+	reported, err := op.ReportBlocker(gid, wasabee.Portal{ID: "A"}, wasabee.Portal{ID: "X", Name: "X", Lat: "5", Lon: "5"})
+	if err != nil || reported.ToName != "X" || reported.FromLat != "0" {
+		t.Errorf("unexpected report: %v %v", reported, err)
+	}
+
+	// a blocker between two portals the op does not have still crosses its links
+	outside, err := op.ReportBlocker(gid, wasabee.Portal{ID: "P", Lat: "-1", Lon: "1.5"}, wasabee.Portal{ID: "Q", Lat: "1", Lon: "1.5"})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	crossing := wasabee.Operation{ID: one.ID}
+	if err := crossing.Populate(gid); err != nil {
+		t.Fatal(err.Error())
+	}
+	found := false
+	for _, p := range crossing.Validate().Problems {
+		if p.Type == wasabee.OpProblemCrossesBlocker && p.Other == outside.ID {
+			found = true
+		}
+	}
+	if !found {
+		t.Error("link crossing a blocker outside the op passed")
+	}
+	if err := op.DeleteBlocker(gid, outside.ID); err != nil {
+		t.Error(err.Error())
+	}
+	// one with no location at all is reported, not skipped
+	unknown := wasabee.Operation{ID: "unplaced", OpPortals: portals, Links: one.Links, Blockers: []wasabee.Link{{ID: "YQ", From: "Y", To: "Q"}}}
+	if v := unknown.Validate(); v.Valid || v.Problems[0].Type != wasabee.OpProblemBadPortal || v.Problems[0].LinkID != "YQ" {
+		t.Errorf("unplaced blocker not reported: %v", v.Problems)
+	}
+
+	// cleared in one op, cleared in both
+	cleared, err := op.ClearBlocker(gid, "YZ")
+	if err != nil || cleared.State != wasabee.BlockerDestroyed || cleared.ClearedBy != gid {
+		t.Errorf("unexpected clear: %v %v", cleared, err)
+	}
+	other := wasabee.Operation{ID: two.ID}
+	if b, err := other.ListBlockers(gid); err != nil || len(b) != 1 || b[0].State != wasabee.BlockerDestroyed {
+		t.Errorf("shared blocker not cleared: %v %v", b, err)
+	}
+	check := wasabee.Operation{ID: one.ID}
+	if err := check.Populate(gid); err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(check.Blockers) != 1 || check.Blockers[0].ID != reported.ID {
+		t.Errorf("destroyed blocker still loaded: %v", check.Blockers)
+	}
+
+	if err := op.DeleteBlocker(wasabee.GoogleID("nobody"), reported.ID); err == nil {
+		t.Error("outsider deleted a blocker")
+	}
+	if err := op.DeleteBlocker(gid, reported.ID); err != nil {
+		t.Error(err.Error())
+	}
+}
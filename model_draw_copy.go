@@ -0,0 +1,191 @@
+package wasabee
+
+import (
+	"fmt"
+)
+
+// CopyOptions says what goes into a copy of an op
+type CopyOptions struct {
+	Name     string      // defaults to the op's name with COPY on the end
+	Complete bool        // keep assignments, progress, keys, blockers and team permissions; otherwise the copy is a fresh template
+	Colors   []string    // only links of these colors; all links if empty
+	Region   *CopyRegion // only what lies inside the box; everything if nil
+}
+
+// CopyRegion is a lat/lng box
+type CopyRegion struct {
+	South float64
+	West  float64
+	North float64
+	East  float64
+}
+
+func (r *CopyRegion) contains(p Portal) bool {
+	if r == nil {
+		return true
+	}
+	lat, lon, err := p.latLon()
+	if err != nil || lat < r.South || lat > r.North {
+		return false
+	}
+	if r.West <= r.East {
+		return lon >= r.West && lon <= r.East
+	}
+	// the box crosses the antimeridian
+	return lon >= r.West || lon <= r.East
+}
+
+// Copy makes a new op from this one and returns its ID. The op must be populated.
+// Links, markers and blockers get new IDs so nothing in the copy can be mistaken for the original; phases are kept as they are.
+// A complete copy is shared with the same teams as the original, so it takes write access; a template copy gets a new team of its own.
+func (o *Operation) Copy(gid GoogleID, opts CopyOptions) (OperationID, error) {
+	if !o.ReadAccess(gid) {
+		err := fmt.Errorf("permission denied: %s copying op %s", gid, o.ID)
+		Log.Error(err)
+		return "", err
+	}
+	if opts.Complete && !o.WriteAccess(gid) {
+		err := fmt.Errorf("permission denied: %s making a complete copy of op %s", gid, o.ID)
+		Log.Error(err)
+		return "", err
+	}
+
+	tmpid, err := GenerateSafeName()
+	if err != nil {
+		Log.Error(err)
+		return "", err
+	}
+	n := Operation{
+		ID:      OperationID(tmpid),
+		Name:    opts.Name,
+		Color:   o.Color,
+		Comment: o.Comment,
+		Phases:  o.Phases,
+	}
+	if n.Name == "" {
+		n.Name = fmt.Sprintf("%s %s", o.Name, "COPY")
+	}
+
+	colors := make(map[string]bool)
+	for _, c := range opts.Colors {
+		colors[OpValidColor(c)] = true
+	}
+
+	portals := make(map[PortalID]Portal)
+	for _, p := range o.OpPortals {
+		if opts.Region.contains(p) {
+			portals[p.ID] = p
+		}
+	}
+	used := make(map[PortalID]bool)
+
+	for _, l := range o.Links {
+		if _, ok := portals[l.From]; !ok {
+			continue
+		}
+		if _, ok := portals[l.To]; !ok {
+			continue
+		}
+		if len(colors) > 0 && !colors[OpValidColor(l.Color)] {
+			continue
+		}
+		l.ID = LinkID(GenerateName())
+		if !opts.Complete {
+			l.AssignedTo = ""
+			l.Iname = ""
+			l.Completed = false
+		}
+		n.Links = append(n.Links, l)
+		used[l.From] = true
+		used[l.To] = true
+	}
+
+	for _, m := range o.Markers {
+		if _, ok := portals[m.PortalID]; !ok {
+			continue
+		}
+		m.ID = MarkerID(GenerateName())
+		if !opts.Complete {
+			m.AssignedTo = ""
+			m.IngressName = ""
+			m.CompletedBy = ""
+			m.State = "pending"
+		}
+		n.Markers = append(n.Markers, m)
+		used[m.PortalID] = true
+	}
+
+	for _, a := range o.Anchors {
+		// with a color filter, only the anchors of the links being copied
+		if _, ok := portals[a]; ok && (len(colors) == 0 || used[a]) {
+			n.Anchors = append(n.Anchors, a)
+			used[a] = true
+		}
+	}
+
+	if opts.Complete {
+		for _, b := range o.Blockers {
+			_, from := portals[b.From]
+			_, to := portals[b.To]
+			if from && to {
+				b.ID = LinkID(GenerateName())
+				n.Blockers = append(n.Blockers, b)
+				used[b.From] = true
+				used[b.To] = true
+			}
+		}
+	}
+
+	// a color filter drops the portals left with nothing on them
+	for _, p := range o.OpPortals {
+		if _, ok := portals[p.ID]; ok && (len(colors) == 0 || used[p.ID]) {
+			n.OpPortals = append(n.OpPortals, p)
+		}
+	}
+
+	if opts.Complete {
+		for _, k := range o.Keys {
+			if _, ok := portals[k.ID]; ok && (len(colors) == 0 || used[k.ID]) {
+				n.Keys = append(n.Keys, k)
+			}
+		}
+	}
+
+	var teamID TeamID
+	if opts.Complete {
+		for _, t := range o.Teams {
+			if t.Role == etRoleRead {
+				teamID = t.TeamID
+				break
+			}
+		}
+	}
+	if teamID == "" {
+		teamID, err = gid.NewTeam(n.Name)
+		if err != nil {
+			Log.Error(err)
+			return "", err
+		}
+	}
+	if err = drawOpInsertWorker(n, gid, teamID); err != nil {
+		Log.Error(err)
+		return "", err
+	}
+
+	if opts.Complete {
+		for _, t := range o.Teams {
+			if t.TeamID == teamID && t.Role == etRoleRead {
+				continue
+			}
+			// teams the copier is not on cannot be added; that is not fatal
+			if err := n.AddPerm(gid, t.TeamID, string(t.Role)); err != nil {
+				Log.Notice(err)
+			}
+		}
+	}
+
+	if err := n.recordRevision(gid, "copy"); err != nil {
+		Log.Error(err)
+	}
+	return n.ID, nil
+}
@@ -0,0 +1,126 @@
+package wasabee_test
+
+import (
+	"encoding/json"
+	"github.com/wasabee-project/Wasabee-Server"
+	"testing"
+)
+
+func TestCopy(t *testing.T) {
+	orig := wasabee.Operation{
+		ID:   "copysource",
+		Name: "copy source",
+		OpPortals: []wasabee.Portal{
+			{ID: "A", Name: "A", Lat: "0", Lon: "0"},
+			{ID: "B", Name: "B", Lat: "0", Lon: "1"},
+			{ID: "C", Name: "C", Lat: "1", Lon: "0"},
+			{ID: "D", Name: "D", Lat: "5", Lon: "5"},
+		},
+		Links: []wasabee.Link{
+			{ID: "AB", From: "A", To: "B", AssignedTo: gid, ThrowOrder: 1},
+			{ID: "BC", From: "B", To: "C", Color: "groupa", ThrowOrder: 2},
+			{ID: "CD", From: "C", To: "D", ThrowOrder: 3},
+		},
+		Markers: []wasabee.Marker{{ID: "MA", PortalID: "A", Type: "DestroyPortalAlert", AssignedTo: gid}},
+		Anchors: []wasabee.PortalID{"A", "D"},
+	}
+	j, _ := json.Marshal(orig)
+	if err := wasabee.DrawInsert(j, gid); err != nil {
+		t.Fatal(err.Error())
+	}
+	defer orig.Delete(gid)
+
+	src := wasabee.Operation{ID: orig.ID}
+	if err := src.Populate(gid); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	load := func(opts wasabee.CopyOptions) wasabee.Operation {
+		id, err := src.Copy(gid, opts)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		o := wasabee.Operation{ID: id}
+		if err := o.Populate(gid); err != nil {
+			t.Fatal(err.Error())
+		}
+		return o
+	}
+
+	template := load(wasabee.CopyOptions{Name: "template"})
+	defer template.Delete(gid)
+	if template.Name != "template" || len(template.OpPortals) != 4 || len(template.Links) != 3 || len(template.Markers) != 1 || len(template.Anchors) != 2 {
+		t.Errorf("template copy incomplete: %v", template)
+	}
+	for _, l := range template.Links {
+		if l.AssignedTo != "" {
+			t.Errorf("template kept assignment on %s", l.ID)
+		}
+		if l.ID == "AB" || l.ID == "BC" || l.ID == "CD" {
+			t.Errorf("link ID %s not remapped", l.ID)
+		}
+	}
+	if m := template.Markers[0]; m.AssignedTo != "" || m.ID == "MA" || m.State != "pending" {
+		t.Errorf("template marker not reset: %v", m)
+	}
+	if len(template.Teams) != 1 || template.Teams[0].TeamID == src.Teams[0].TeamID {
+		t.Errorf("template should have a team of its own: %v", template.Teams)
+	}
+
+	full := load(wasabee.CopyOptions{Complete: true})
+	defer full.Delete(gid)
+	if full.Name != "copy source COPY" {
+		t.Errorf("unexpected name %s", full.Name)
+	}
+	assigned := 0
+	for _, l := range full.Links {
+		if l.AssignedTo == gid {
+			assigned++
+		}
+	}
+	if assigned != 1 || full.Markers[0].AssignedTo != gid {
+		t.Error("complete copy lost assignments")
+	}
+	if len(full.Teams) != len(src.Teams) || full.Teams[0].TeamID != src.Teams[0].TeamID {
+		t.Errorf("complete copy teams %v, want %v", full.Teams, src.Teams)
+	}
+
+	groupa := load(wasabee.CopyOptions{Colors: []string{"groupa"}})
+	defer groupa.Delete(gid)
+	if len(groupa.Links) != 1 || groupa.Links[0].From != "B" || len(groupa.OpPortals) != 3 || len(groupa.Anchors) != 1 {
+		t.Errorf("color copy: links %v portals %v anchors %v", groupa.Links, groupa.OpPortals, groupa.Anchors)
+	}
+
+	region := load(wasabee.CopyOptions{Region: &wasabee.CopyRegion{South: -1, West: -1, North: 2, East: 2}})
+	defer region.Delete(gid)
+	if len(region.Links) != 2 || len(region.OpPortals) != 3 || len(region.Anchors) != 1 {
+		t.Errorf("region copy: links %v portals %v anchors %v", region.Links, region.OpPortals, region.Anchors)
+	}
+
+	if _, err := src.Copy(wasabee.GoogleID("nobody"), wasabee.CopyOptions{}); err == nil {
+		t.Error("outsider copied the op")
+	}
+
+	// a reader may take a template, but not the op with its assignments and teams
+	rgid := wasabee.GoogleID("104743827901423568957")
+	if _, err := rgid.InitAgent(); err != nil {
+		t.Fatal(err.Error())
+	}
+	defer rgid.Delete()
+	if err := src.Teams[0].TeamID.AddAgent(rgid); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := rgid.SetTeamState(src.Teams[0].TeamID, "On"); err != nil {
+		t.Fatal(err.Error())
+	}
+	id, err := src.Copy(rgid, wasabee.CopyOptions{})
+	if err != nil {
+		t.Error(err.Error())
+	} else {
+		mine := wasabee.Operation{ID: id}
+		defer mine.Delete(rgid)
+	}
+	if _, err := src.Copy(rgid, wasabee.CopyOptions{Complete: true}); err == nil {
+		t.Error("reader made a complete copy")
+	}
+}
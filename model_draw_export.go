@@ -0,0 +1,396 @@
+package wasabee
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+)
+
+// export formats
+const (
+	ExportKML     = "kml"
+	ExportGPX     = "gpx"
+	ExportGeoJSON = "geojson"
+)
+
+// Export writes the op in one of the Export formats: portals as points, links as lines and markers as waypoints.
+// The op must be populated first; an agent with assigned-only access gets only their own links and markers.
+func (o *Operation) Export(w io.Writer, format string) error {
+	switch format {
+	case ExportKML:
+		return o.KML(w)
+	case ExportGPX:
+		return o.GPX(w)
+	case ExportGeoJSON:
+		return o.GeoJSON(w)
+	}
+	err := fmt.Errorf("unknown export format: %s", format)
+	Log.Notice(err)
+	return err
+}
+
+// exportPortals maps the op's portals, skipping any without a usable location
+func (o *Operation) exportPortals() map[PortalID]Portal {
+	portals := make(map[PortalID]Portal)
+	for _, p := range o.OpPortals {
+		if _, _, err := p.latLon(); err == nil {
+			portals[p.ID] = p
+		}
+	}
+	return portals
+}
+
+// linkHex is the link's color as rrggbb
+func (l Link) linkHex() string {
+	return OpColorMap()[OpValidColor(l.Color)].Hex
+}
+
+func (l Link) assignee() string {
+	if l.Iname != "" {
+		return l.Iname
+	}
+	return string(l.AssignedTo)
+}
+
+type geoJSONFeature struct {
+	Type       string                 `json:"type"`
+	Geometry   geoJSONGeometry        `json:"geometry"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+type geoJSONGeometry struct {
+	Type        string      `json:"type"`
+	Coordinates interface{} `json:"coordinates"`
+}
+
+type geoJSONCollection struct {
+	Type       string                 `json:"type"`
+	Properties map[string]interface{} `json:"properties"`
+	Features   []geoJSONFeature       `json:"features"`
+}
+
+// GeoJSON writes the op as a FeatureCollection; links are styled with simplestyle stroke properties
+func (o *Operation) GeoJSON(w io.Writer) error {
+	fc := geoJSONCollection{
+		Type:       "FeatureCollection",
+		Properties: map[string]interface{}{"id": o.ID, "name": o.Name, "comment": o.Comment},
+		Features:   []geoJSONFeature{},
+	}
+
+	portals := o.exportPortals()
+	for _, p := range o.OpPortals {
+		lat, lon, err := p.latLon()
+		if err != nil {
+			continue
+		}
+		fc.Features = append(fc.Features, geoJSONFeature{
+			Type:     "Feature",
+			Geometry: geoJSONGeometry{Type: "Point", Coordinates: []float64{lon, lat}},
+			Properties: map[string]interface{}{
+				"type":     "portal",
+				"id":       p.ID,
+				"name":     p.Name,
+				"comment":  p.Comment,
+				"hardness": p.Hardness,
+			},
+		})
+	}
+
+	for _, l := range o.thrownLinks() {
+		from, ok := portals[l.From]
+		if !ok {
+			continue
+		}
+		to, ok := portals[l.To]
+		if !ok {
+			continue
+		}
+		flat, flon, _ := from.latLon()
+		tlat, tlon, _ := to.latLon()
+		fc.Features = append(fc.Features, geoJSONFeature{
+			Type:     "Feature",
+			Geometry: geoJSONGeometry{Type: "LineString", Coordinates: [][]float64{{flon, flat}, {tlon, tlat}}},
+			Properties: map[string]interface{}{
+				"type":             "link",
+				"id":               l.ID,
+				"fromPortalId":     l.From,
+				"toPortalId":       l.To,
+				"description":      l.Desc,
+				"throwOrderPos":    l.ThrowOrder,
+				"color":            OpValidColor(l.Color),
+				"stroke":           "#" + l.linkHex(),
+				"assignedTo":       l.AssignedTo,
+				"assignedNickname": l.Iname,
+				"completed":        l.Completed,
+			},
+		})
+	}
+
+	for _, m := range o.Markers {
+		p, ok := portals[m.PortalID]
+		if !ok {
+			continue
+		}
+		lat, lon, _ := p.latLon()
+		fc.Features = append(fc.Features, geoJSONFeature{
+			Type:     "Feature",
+			Geometry: geoJSONGeometry{Type: "Point", Coordinates: []float64{lon, lat}},
+			Properties: map[string]interface{}{
+				"type":             "marker",
+				"id":               m.ID,
+				"markerType":       m.Type,
+				"portalId":         m.PortalID,
+				"name":             p.Name,
+				"comment":          m.Comment,
+				"state":            m.State,
+				"order":            m.Order,
+				"assignedTo":       m.AssignedTo,
+				"assignedNickname": m.IngressName,
+			},
+		})
+	}
+
+	e := json.NewEncoder(w)
+	e.SetIndent("", "  ")
+	return e.Encode(fc)
+}
+
+type kmlData struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value"`
+}
+
+type kmlPlacemark struct {
+	Name         string         `xml:"name"`
+	Description  string         `xml:"description,omitempty"`
+	StyleURL     string         `xml:"styleUrl,omitempty"`
+	ExtendedData []kmlData      `xml:"ExtendedData>Data,omitempty"`
+	Point        *kmlPoint      `xml:"Point,omitempty"`
+	LineString   *kmlLineString `xml:"LineString,omitempty"`
+}
+
+type kmlPoint struct {
+	Coordinates string `xml:"coordinates"`
+}
+
+type kmlLineString struct {
+	Tessellate  int    `xml:"tessellate"`
+	Coordinates string `xml:"coordinates"`
+}
+
+type kmlStyle struct {
+	ID        string `xml:"id,attr"`
+	LineStyle struct {
+		Color string `xml:"color"`
+		Width int    `xml:"width"`
+	} `xml:"LineStyle"`
+}
+
+type kmlFolder struct {
+	Name       string         `xml:"name"`
+	Placemarks []kmlPlacemark `xml:"Placemark"`
+}
+
+type kmlDoc struct {
+	XMLName  xml.Name `xml:"kml"`
+	XMLNS    string   `xml:"xmlns,attr"`
+	Document struct {
+		Name        string      `xml:"name"`
+		Description string      `xml:"description,omitempty"`
+		Styles      []kmlStyle  `xml:"Style"`
+		Folders     []kmlFolder `xml:"Folder"`
+	} `xml:"Document"`
+}
+
+// KML writes the op as a KML document with a folder each for portals, links and markers; links get a line style per color
+func (o *Operation) KML(w io.Writer) error {
+	var doc kmlDoc
+	doc.XMLNS = "http://www.opengis.net/kml/2.2"
+	doc.Document.Name = o.Name
+	doc.Document.Description = o.Comment
+
+	colors := make([]string, 0)
+	for c := range OpColorMap() {
+		colors = append(colors, c)
+	}
+	sort.Strings(colors)
+	for _, c := range colors {
+		hex := OpColorMap()[c].Hex
+		s := kmlStyle{ID: c}
+		// KML colors are aabbggrr
+		s.LineStyle.Color = "ff" + hex[4:6] + hex[2:4] + hex[0:2]
+		s.LineStyle.Width = 3
+		doc.Document.Styles = append(doc.Document.Styles, s)
+	}
+
+	portals := o.exportPortals()
+	pf := kmlFolder{Name: "Portals"}
+	for _, p := range o.OpPortals {
+		if _, ok := portals[p.ID]; !ok {
+			continue
+		}
+		pf.Placemarks = append(pf.Placemarks, kmlPlacemark{
+			Name:        p.Name,
+			Description: p.Comment,
+			ExtendedData: []kmlData{
+				{Name: "id", Value: string(p.ID)},
+				{Name: "comment", Value: p.Comment},
+				{Name: "hardness", Value: p.Hardness},
+			},
+			Point: &kmlPoint{Coordinates: p.Lon + "," + p.Lat},
+		})
+	}
+
+	lf := kmlFolder{Name: "Links"}
+	for _, l := range o.thrownLinks() {
+		from, ok := portals[l.From]
+		if !ok {
+			continue
+		}
+		to, ok := portals[l.To]
+		if !ok {
+			continue
+		}
+		pm := kmlPlacemark{
+			Name:        fmt.Sprintf("%d: %s - %s", l.ThrowOrder, from.Name, to.Name),
+			Description: l.Desc,
+			StyleURL:    "#" + OpValidColor(l.Color),
+			ExtendedData: []kmlData{
+				{Name: "id", Value: string(l.ID)},
+				{Name: "throwOrderPos", Value: strconv.Itoa(int(l.ThrowOrder))},
+				{Name: "assignedTo", Value: l.assignee()},
+			},
+			LineString: &kmlLineString{Tessellate: 1, Coordinates: from.Lon + "," + from.Lat + " " + to.Lon + "," + to.Lat},
+		}
+		lf.Placemarks = append(lf.Placemarks, pm)
+	}
+
+	mf := kmlFolder{Name: "Markers"}
+	for _, m := range o.Markers {
+		p, ok := portals[m.PortalID]
+		if !ok {
+			continue
+		}
+		assignee := m.IngressName
+		if assignee == "" {
+			assignee = string(m.AssignedTo)
+		}
+		mf.Placemarks = append(mf.Placemarks, kmlPlacemark{
+			Name:        fmt.Sprintf("%s: %s", m.Type, p.Name),
+			Description: m.Comment,
+			ExtendedData: []kmlData{
+				{Name: "id", Value: string(m.ID)},
+				{Name: "type", Value: string(m.Type)},
+				{Name: "state", Value: m.State},
+				{Name: "assignedTo", Value: assignee},
+			},
+			Point: &kmlPoint{Coordinates: p.Lon + "," + p.Lat},
+		})
+	}
+	doc.Document.Folders = []kmlFolder{pf, lf, mf}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	e := xml.NewEncoder(w)
+	e.Indent("", "  ")
+	return e.Encode(doc)
+}
+
+type gpxWpt struct {
+	Lat  string `xml:"lat,attr"`
+	Lon  string `xml:"lon,attr"`
+	Name string `xml:"name"`
+	Desc string `xml:"desc,omitempty"`
+	Type string `xml:"type,omitempty"`
+}
+
+type gpxRte struct {
+	Name string   `xml:"name"`
+	Desc string   `xml:"desc,omitempty"`
+	Type string   `xml:"type"`
+	Line *gpxLine `xml:"extensions>line,omitempty"`
+	Pts  []gpxWpt `xml:"rtept"`
+}
+
+// gpxLine is the gpx_style extension OsmAnd and others use to color a route
+type gpxLine struct {
+	XMLNS string `xml:"xmlns,attr"`
+	Color string `xml:"color"`
+}
+
+type gpxExport struct {
+	XMLName xml.Name `xml:"gpx"`
+	XMLNS   string   `xml:"xmlns,attr"`
+	Version string   `xml:"version,attr"`
+	Creator string   `xml:"creator,attr"`
+	Name    string   `xml:"metadata>name"`
+	Wpts    []gpxWpt `xml:"wpt"`
+	Rtes    []gpxRte `xml:"rte"`
+}
+
+// GPX writes the op as GPX 1.1: portals and markers as waypoints typed "portal" or by marker type, links as two point routes
+func (o *Operation) GPX(w io.Writer) error {
+	doc := gpxExport{XMLNS: "http://www.topografix.com/GPX/1/1", Version: "1.1", Creator: "Wasabee", Name: o.Name}
+
+	portals := o.exportPortals()
+	for _, p := range o.OpPortals {
+		if _, ok := portals[p.ID]; !ok {
+			continue
+		}
+		desc := p.Comment
+		if p.Hardness != "" {
+			desc = fmt.Sprintf("%s (hardness: %s)", desc, p.Hardness)
+		}
+		doc.Wpts = append(doc.Wpts, gpxWpt{Lat: p.Lat, Lon: p.Lon, Name: p.Name, Desc: desc, Type: "portal"})
+	}
+	for _, m := range o.Markers {
+		p, ok := portals[m.PortalID]
+		if !ok {
+			continue
+		}
+		desc := m.Comment
+		if m.AssignedTo != "" {
+			assignee := m.IngressName
+			if assignee == "" {
+				assignee = string(m.AssignedTo)
+			}
+			desc = fmt.Sprintf("%s (assigned to %s)", desc, assignee)
+		}
+		doc.Wpts = append(doc.Wpts, gpxWpt{Lat: p.Lat, Lon: p.Lon, Name: fmt.Sprintf("%s: %s", m.Type, p.Name), Desc: desc, Type: string(m.Type)})
+	}
+	for _, l := range o.thrownLinks() {
+		from, ok := portals[l.From]
+		if !ok {
+			continue
+		}
+		to, ok := portals[l.To]
+		if !ok {
+			continue
+		}
+		r := gpxRte{
+			Name: fmt.Sprintf("%d: %s - %s", l.ThrowOrder, from.Name, to.Name),
+			Desc: l.Desc,
+			Type: "link",
+			Line: &gpxLine{XMLNS: "http://www.topografix.com/GPX/gpx_style/0/2", Color: l.linkHex()},
+			Pts: []gpxWpt{
+				{Lat: from.Lat, Lon: from.Lon, Name: from.Name},
+				{Lat: to.Lat, Lon: to.Lon, Name: to.Name},
+			},
+		}
+		if a := l.assignee(); a != "" {
+			r.Desc = fmt.Sprintf("%s (assigned to %s)", r.Desc, a)
+		}
+		doc.Rtes = append(doc.Rtes, r)
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	e := xml.NewEncoder(w)
+	e.Indent("", "  ")
+	return e.Encode(doc)
+}
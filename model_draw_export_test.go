@@ -0,0 +1,148 @@
+package wasabee_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"github.com/wasabee-project/Wasabee-Server"
+	"strings"
+	"testing"
+)
+
+func TestExport(t *testing.T) {
+	op := wasabee.Operation{
+		ID:   "export",
+		Name: "export",
+		OpPortals: []wasabee.Portal{
+			{ID: "A", Name: "Alpha", Lat: "1", Lon: "2", Comment: "L8", Hardness: "2xSBUL"},
+			{ID: "B", Name: "Beta", Lat: "3", Lon: "4"},
+			{ID: "X", Name: "Nowhere", Lat: "", Lon: ""},
+		},
+		Links: []wasabee.Link{
+			{ID: "AB", From: "A", To: "B", Color: "groupa", AssignedTo: "agent1", ThrowOrder: 2},
+			{ID: "BX", From: "B", To: "X", ThrowOrder: 1},
+		},
+		Markers: []wasabee.Marker{
+			{ID: "M", PortalID: "B", Type: "DestroyPortalAlert", AssignedTo: "agent1"},
+		},
+	}
+
+	var b bytes.Buffer
+	if err := op.Export(&b, wasabee.ExportGeoJSON); err != nil {
+		t.Fatal(err.Error())
+	}
+	var fc struct {
+		Features []struct {
+			Geometry struct {
+				Type        string
+				Coordinates interface{}
+			}
+			Properties map[string]interface{}
+		}
+	}
+	if err := json.Unmarshal(b.Bytes(), &fc); err != nil {
+		t.Fatal(err.Error())
+	}
+	// two portals, one link and one marker; the portal with no location and its link are left out
+	if len(fc.Features) != 4 {
+		t.Fatalf("unexpected features: %s", b.String())
+	}
+	if c, ok := fc.Features[0].Geometry.Coordinates.([]interface{}); !ok || c[0] != 2.0 || c[1] != 1.0 || fc.Features[0].Properties["hardness"] != "2xSBUL" {
+		t.Errorf("unexpected portal: %v", fc.Features[0])
+	}
+	if fc.Features[2].Geometry.Type != "LineString" || fc.Features[2].Properties["stroke"] != "#ff6600" || fc.Features[2].Properties["assignedTo"] != "agent1" {
+		t.Errorf("unexpected link: %v", fc.Features[2])
+	}
+	if fc.Features[3].Properties["markerType"] != "DestroyPortalAlert" {
+		t.Errorf("unexpected marker: %v", fc.Features[3])
+	}
+
+	for _, f := range []string{wasabee.ExportKML, wasabee.ExportGPX} {
+		b.Reset()
+		if err := op.Export(&b, f); err != nil {
+			t.Fatal(err.Error())
+		}
+		var v interface{}
+		if err := xml.Unmarshal(b.Bytes(), &v); err != nil {
+			t.Errorf("%s is not xml: %s", f, err.Error())
+		}
+		if !strings.Contains(b.String(), "Alpha") || !strings.Contains(b.String(), "DestroyPortalAlert") {
+			t.Errorf("unexpected %s: %s", f, b.String())
+		}
+	}
+	if !strings.Contains(b.String(), "<color>ff6600</color>") {
+		t.Errorf("gpx link not colored: %s", b.String())
+	}
+	b.Reset()
+	op.KML(&b)
+	if !strings.Contains(b.String(), "<color>ff0066ff</color>") || !strings.Contains(b.String(), "<styleUrl>#groupa</styleUrl>") {
+		t.Errorf("kml link not styled: %s", b.String())
+	}
+
+	if err := op.Export(&b, "shp"); err == nil {
+		t.Error("exported an unknown format")
+	}
+}
+
+func TestPopulateAssignedOnly(t *testing.T) {
+	op := wasabee.Operation{
+		ID:   "assignedonly",
+		Name: "assignedonly",
+		OpPortals: []wasabee.Portal{
+			{ID: "A", Name: "Alpha", Lat: "1", Lon: "2"},
+			{ID: "B", Name: "Beta", Lat: "3", Lon: "4"},
+			{ID: "C", Name: "Gamma", Lat: "5", Lon: "6"},
+		},
+		Links: []wasabee.Link{
+			{ID: "AB", From: "A", To: "B"},
+			{ID: "BC", From: "B", To: "C"},
+		},
+		Markers: []wasabee.Marker{
+			{ID: "M", PortalID: "C", Type: "DestroyPortalAlert"},
+		},
+	}
+	j, _ := json.Marshal(op)
+	if err := wasabee.DrawInsert(j, gid); err != nil {
+		t.Fatal(err.Error())
+	}
+	defer op.Delete(gid)
+
+	ngid := wasabee.GoogleID("104743827901423568950")
+	if _, err := ngid.InitAgent(); err != nil {
+		t.Fatal(err.Error())
+	}
+	defer ngid.Delete()
+	teamID, err := gid.NewTeam("assigned only")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer teamID.Delete()
+	if err := teamID.AddAgent(ngid); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := ngid.SetTeamState(teamID, "On"); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := op.AddPerm(gid, teamID, "assignedonly"); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := op.AssignLink("AB", ngid, gid); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	mine := wasabee.Operation{ID: op.ID}
+	if err := mine.Populate(ngid); err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(mine.Links) != 1 || mine.Links[0].ID != "AB" || len(mine.Markers) != 0 || len(mine.OpPortals) != 2 {
+		t.Errorf("assigned-only agent sees too much: %v %v %v", mine.Links, mine.Markers, mine.OpPortals)
+	}
+
+	var b bytes.Buffer
+	if err := mine.GeoJSON(&b); err != nil {
+		t.Error(err.Error())
+	}
+	if strings.Contains(b.String(), "Gamma") {
+		t.Errorf("export has portals the agent was not given: %s", b.String())
+	}
+}
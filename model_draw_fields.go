@@ -0,0 +1,214 @@
+package wasabee
+
+import (
+	"math"
+	"sort"
+	"sync"
+)
+
+// earthRadius in meters, the mean radius
+const earthRadius = 6371008.8
+
+// area is the area of the triangle a, b, c in square meters
+func area(a, b, c geoPoint) float64 {
+	// the spherical excess, from Oosterom & Strackee
+	excess := 2 * math.Atan2(math.Abs(a.dot(b.cross(c))), 1+a.dot(b)+b.dot(c)+c.dot(a))
+	return excess * earthRadius * earthRadius
+}
+
+// OpField is a field closed by one of the op's links
+type OpField struct {
+	Step       int        `json:"step"` // the position of the closing link in the throw order, from 1
+	ThrowOrder int32      `json:"throwOrderPos"`
+	LinkID     LinkID     `json:"linkID"`
+	Portals    []PortalID `json:"portals"`
+	Area       float64    `json:"area"` // square kilometers
+	MU         int        `json:"mu"`
+	Agent      GoogleID   `json:"agent,omitempty"` // who is assigned the closing link
+}
+
+// AgentFields is what one agent's links are expected to field
+type AgentFields struct {
+	Gid    GoogleID `json:"gid"`
+	Fields int      `json:"fields"`
+	Area   float64  `json:"area"`
+	MU     int      `json:"mu"`
+}
+
+// OpFields lists the fields an op makes and totals them
+type OpFields struct {
+	ID     OperationID   `json:"ID"`
+	Fields []OpField     `json:"fields"`
+	Area   float64       `json:"area"`
+	MU     int           `json:"mu"`
+	Agents []AgentFields `json:"agents"`
+}
+
+// MUModel estimates the MU for a field
+type MUModel interface {
+	MU(f OpField, portals [3]Portal) float64
+}
+
+// FlatMUDensity is an MUModel with the same number of MU per square kilometer everywhere
+type FlatMUDensity float64
+
+// MU is the density times the area
+func (d FlatMUDensity) MU(f OpField, portals [3]Portal) float64 {
+	return f.Area * float64(d)
+}
+
+// DefaultMUDensity is a rough suburban MU per square kilometer; cities are far more, open country far less
+const DefaultMUDensity = 1000
+
+var mu = struct {
+	sync.RWMutex
+	model MUModel
+}{
+	model: FlatMUDensity(DefaultMUDensity),
+}
+
+// SetMUModel replaces the model used to estimate the MU of fields
+func SetMUModel(m MUModel) {
+	mu.Lock()
+	mu.model = m
+	mu.Unlock()
+
+	fieldStats.Lock()
+	fieldStats.ops = make(map[OperationID]fieldStat)
+	fieldStats.Unlock()
+}
+
+// fieldStats is each op's field count and MU for the version they were worked out for, so polling the stat stays cheap
+var fieldStats = struct {
+	sync.Mutex
+	ops map[OperationID]fieldStat
+}{ops: make(map[OperationID]fieldStat)}
+
+type fieldStat struct {
+	version int64
+	fields  int
+	mu      int
+}
+
+func forgetFieldStat(opID OperationID) {
+	fieldStats.Lock()
+	delete(fieldStats.ops, opID)
+	fieldStats.Unlock()
+}
+
+// AddFields fills in the stat's field count and MU; they are only worked out again once the op has changed
+func (s *OpStat) AddFields() error {
+	fieldStats.Lock()
+	c, ok := fieldStats.ops[s.ID]
+	fieldStats.Unlock()
+	if ok && c.version == s.Version {
+		s.Fields, s.MU = c.fields, c.mu
+		return nil
+	}
+
+	o := Operation{ID: s.ID}
+	var err error
+	if o.OpPortals, err = store.Portals(s.ID); err != nil {
+		Log.Error(err)
+		return err
+	}
+	if o.Links, err = store.Links(s.ID); err != nil {
+		Log.Error(err)
+		return err
+	}
+	f := o.Fields()
+	s.Fields, s.MU = len(f.Fields), f.MU
+
+	fieldStats.Lock()
+	fieldStats.ops[s.ID] = fieldStat{version: s.Version, fields: s.Fields, mu: s.MU}
+	fieldStats.Unlock()
+	return nil
+}
+
+func muModel() MUModel {
+	mu.RLock()
+	defer mu.RUnlock()
+	return mu.model
+}
+
+// Fields works through the links in throw order and finds the fields each one closes.
+// A link makes at most one field on each side of it, the largest it completes.
+func (o *Operation) Fields() OpFields {
+	f := OpFields{ID: o.ID, Fields: []OpField{}, Agents: []AgentFields{}}
+
+	g, _ := o.geometry()
+	portals := make(map[PortalID]Portal)
+	for _, p := range o.OpPortals {
+		portals[p.ID] = p
+	}
+	model := muModel()
+	agents := make(map[GoogleID]*AgentFields)
+
+	lg := make(linkGraph)
+	for i, l := range o.thrownLinks() {
+		a, b, ok := g.arc(l)
+		if !ok || l.From == l.To || lg[l.From][l.To] {
+			continue
+		}
+
+		// the largest on each side
+		var best [2]OpField
+		for _, c := range lg.closes(l.From, l.To) {
+			cp, ok := g.points[c]
+			if !ok {
+				continue
+			}
+			s := side(a, b, cp)
+			if s == 0 {
+				continue
+			}
+			ar := area(a, b, cp) / 1e6
+			k := (s + 1) / 2
+			if ar > best[k].Area {
+				best[k] = OpField{
+					Step:       i + 1,
+					ThrowOrder: l.ThrowOrder,
+					LinkID:     l.ID,
+					Portals:    []PortalID{l.From, l.To, c},
+					Area:       ar,
+					Agent:      l.AssignedTo,
+				}
+			}
+		}
+		lg.add(l.From, l.To)
+
+		for _, field := range best {
+			if field.LinkID == "" {
+				continue
+			}
+			// every field is worth at least 1 MU
+			field.MU = int(math.Max(1, math.Round(model.MU(field, [3]Portal{portals[field.Portals[0]], portals[field.Portals[1]], portals[field.Portals[2]]}))))
+			f.Fields = append(f.Fields, field)
+			f.Area += field.Area
+			f.MU += field.MU
+
+			if field.Agent == "" {
+				continue
+			}
+			af, ok := agents[field.Agent]
+			if !ok {
+				af = &AgentFields{Gid: field.Agent}
+				agents[field.Agent] = af
+			}
+			af.Fields++
+			af.Area += field.Area
+			af.MU += field.MU
+		}
+	}
+
+	for _, af := range agents {
+		f.Agents = append(f.Agents, *af)
+	}
+	sort.Slice(f.Agents, func(i, j int) bool {
+		if f.Agents[i].MU != f.Agents[j].MU {
+			return f.Agents[i].MU > f.Agents[j].MU
+		}
+		return f.Agents[i].Gid < f.Agents[j].Gid
+	})
+	return f
+}
@@ -0,0 +1,104 @@
+package wasabee_test
+
+import (
+	"encoding/json"
+	"github.com/wasabee-project/Wasabee-Server"
+	"math"
+	"testing"
+)
+
+func TestFields(t *testing.T) {
+	op := wasabee.Operation{
+		ID: "fields",
+		OpPortals: []wasabee.Portal{
+			{ID: "A", Lat: "0", Lon: "0"},
+			{ID: "B", Lat: "0", Lon: "0.01"},
+			{ID: "C", Lat: "0.01", Lon: "0.005"},
+			{ID: "D", Lat: "-0.01", Lon: "0.005"},
+			{ID: "F", Lat: "0.02", Lon: "0.005"},
+		},
+		Links: []wasabee.Link{
+			{ID: "AB", From: "A", To: "B", ThrowOrder: 7, AssignedTo: "agent2"},
+			{ID: "AC", From: "A", To: "C", ThrowOrder: 1},
+			{ID: "BC", From: "B", To: "C", ThrowOrder: 2},
+			{ID: "AF", From: "A", To: "F", ThrowOrder: 3},
+			{ID: "BF", From: "B", To: "F", ThrowOrder: 4},
+			{ID: "AD", From: "A", To: "D", ThrowOrder: 5},
+			{ID: "DB", From: "D", To: "B", ThrowOrder: 6, AssignedTo: "agent1"},
+		},
+	}
+
+	// AB closes ABC and ABF on one side, only the larger counts, and ABD on the other
+	f := op.Fields()
+	if len(f.Fields) != 2 {
+		t.Fatalf("unexpected fields: %v", f.Fields)
+	}
+	for _, field := range f.Fields {
+		if field.LinkID != "AB" || field.Step != 7 || field.Agent != "agent2" {
+			t.Errorf("unexpected field: %v", field)
+		}
+	}
+	if third := f.Fields[0].Portals[2]; third != "D" && third != "F" {
+		t.Errorf("wrong field kept: %v", f.Fields)
+	}
+	// 0.01 degrees at the equator is about 1112m
+	if math.Abs(f.Area-1.5*1.11195*1.11195) > 0.01 {
+		t.Errorf("unexpected area: %f", f.Area)
+	}
+	if f.MU != f.Fields[0].MU+f.Fields[1].MU || len(f.Agents) != 1 || f.Agents[0].Gid != "agent2" || f.Agents[0].MU != f.MU {
+		t.Errorf("unexpected totals: %v", f)
+	}
+
+	wasabee.SetMUModel(wasabee.FlatMUDensity(0))
+	defer wasabee.SetMUModel(wasabee.FlatMUDensity(wasabee.DefaultMUDensity))
+	if f := op.Fields(); f.MU != 2 {
+		t.Errorf("fields should be worth at least 1 MU: %v", f)
+	}
+}
+
+func TestFieldStat(t *testing.T) {
+	op := wasabee.Operation{
+		ID:   "fieldstat",
+		Name: "fieldstat",
+		OpPortals: []wasabee.Portal{
+			{ID: "A", Lat: "0", Lon: "0"},
+			{ID: "B", Lat: "0", Lon: "0.01"},
+			{ID: "C", Lat: "0.01", Lon: "0.005"},
+		},
+		Links: []wasabee.Link{
+			{ID: "AC", From: "A", To: "C", ThrowOrder: 1},
+			{ID: "BC", From: "B", To: "C", ThrowOrder: 2},
+			{ID: "AB", From: "A", To: "B", ThrowOrder: 3},
+		},
+	}
+	j, _ := json.Marshal(op)
+	if err := wasabee.DrawInsert(j, gid); err != nil {
+		t.Fatal(err.Error())
+	}
+	defer op.Delete(gid)
+
+	s, err := op.ID.Stat()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if s.Fields != 0 {
+		t.Error("Stat worked out the fields")
+	}
+	if err := s.AddFields(); err != nil || s.Fields != 1 || s.MU == 0 {
+		t.Errorf("unexpected stat: %v %v", s, err)
+	}
+
+	// a new version is worked out again
+	op.Links = op.Links[:2]
+	j, _ = json.Marshal(op)
+	if err := wasabee.DrawUpdate(op.ID, j, gid, wasabee.OpPrecondition{}); err != nil {
+		t.Fatal(err.Error())
+	}
+	after, err := op.ID.Stat()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := after.AddFields(); err != nil || after.Version <= s.Version || after.Fields != 0 {
+		t.Errorf("unexpected stat after update: %v %v", after, err)
+	}
+}
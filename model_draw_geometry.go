@@ -0,0 +1,323 @@
+package wasabee
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+)
+
+// geoPoint is a position on the unit sphere; links are great circle arcs between them
+type geoPoint [3]float64
+
+// geoEpsilon absorbs rounding so portals on a line are neither in nor out
+const geoEpsilon = 1e-12
+
+func newGeoPoint(lat, lon float64) geoPoint {
+	la := lat * math.Pi / 180.0
+	lo := lon * math.Pi / 180.0
+	return geoPoint{math.Cos(la) * math.Cos(lo), math.Cos(la) * math.Sin(lo), math.Sin(la)}
+}
+
+// latLon is where the portal is, in degrees
+func (p Portal) latLon() (float64, float64, error) {
+	lat, err := strconv.ParseFloat(p.Lat, 64)
+	if err != nil {
+		return 0, 0, err
+	}
+	lon, err := strconv.ParseFloat(p.Lon, 64)
+	if err != nil {
+		return 0, 0, err
+	}
+	if lat < -90 || lat > 90 || lon < -180 || lon > 180 {
+		err := fmt.Errorf("portal %s out of range: %s,%s", p.ID, p.Lat, p.Lon)
+		return 0, 0, err
+	}
+	return lat, lon, nil
+}
+
+// point is where the portal is
+func (p Portal) point() (geoPoint, error) {
+	lat, lon, err := p.latLon()
+	if err != nil {
+		return geoPoint{}, err
+	}
+	return newGeoPoint(lat, lon), nil
+}
+
+func (a geoPoint) cross(b geoPoint) geoPoint {
+	return geoPoint{a[1]*b[2] - a[2]*b[1], a[2]*b[0] - a[0]*b[2], a[0]*b[1] - a[1]*b[0]}
+}
+
+func (a geoPoint) dot(b geoPoint) float64 {
+	return a[0]*b[0] + a[1]*b[1] + a[2]*b[2]
+}
+
+func (a geoPoint) add(b geoPoint) geoPoint {
+	return geoPoint{a[0] + b[0], a[1] + b[1], a[2] + b[2]}
+}
+
+// side is 1 or -1 for the side of the great circle through a and b that p is on, 0 if it is on it
+func side(a, b, p geoPoint) int {
+	d := a.cross(b).dot(p)
+	switch {
+	case d > geoEpsilon:
+		return 1
+	case d < -geoEpsilon:
+		return -1
+	}
+	return 0
+}
+
+// arcsCross is true if the arcs a-b and c-d cross; touching at an end does not count
+func arcsCross(a, b, c, d geoPoint) bool {
+	if side(a, b, c)*side(a, b, d) >= 0 || side(c, d, a)*side(c, d, b) >= 0 {
+		return false
+	}
+	// the great circles meet at two opposite points, the arcs must both be near the same one
+	x := a.cross(b).cross(c.cross(d))
+	if a.add(b).dot(x) < 0 {
+		x = geoPoint{-x[0], -x[1], -x[2]}
+	}
+	return c.add(d).dot(x) > 0
+}
+
+// inTriangle is true if p is strictly inside the triangle a, b, c
+func inTriangle(p, a, b, c geoPoint) bool {
+	s := side(a, b, p)
+	if s == 0 || side(b, c, p) != s || side(c, a, p) != s {
+		return false
+	}
+	return a.add(b).add(c).dot(p) > 0
+}
+
+// opGeometry has the position of each portal in an op
+type opGeometry struct {
+	points  map[PortalID]geoPoint
+	portals map[PortalID]Portal // those with a point
+}
+
+func (o *Operation) geometry() (opGeometry, []PortalID) {
+	g := opGeometry{points: make(map[PortalID]geoPoint), portals: make(map[PortalID]Portal)}
+	var bad []PortalID
+	for _, p := range o.OpPortals {
+		pt, err := p.point()
+		if err != nil {
+			Log.Debug(err)
+			bad = append(bad, p.ID)
+			continue
+		}
+		g.points[p.ID] = pt
+		g.portals[p.ID] = p
+	}
+	// blockers often end at portals the op does not have; the op's own location wins
+	for _, p := range o.blockerEnds {
+		if _, ok := g.points[p.ID]; ok {
+			continue
+		}
+		if pt, err := p.point(); err == nil {
+			g.points[p.ID] = pt
+			g.portals[p.ID] = p
+		}
+	}
+	return g, bad
+}
+
+// arc returns the ends of a link, false if either portal is not known
+func (g opGeometry) arc(l Link) (geoPoint, geoPoint, bool) {
+	from, ok := g.points[l.From]
+	if !ok {
+		return from, from, false
+	}
+	to, ok := g.points[l.To]
+	return from, to, ok
+}
+
+// linksCross is true if the two links cross somewhere other than a shared portal
+func (g opGeometry) linksCross(l, m Link) bool {
+	if l.From == m.From || l.From == m.To || l.To == m.From || l.To == m.To {
+		return false
+	}
+	a, b, ok := g.arc(l)
+	if !ok {
+		return false
+	}
+	c, d, ok := g.arc(m)
+	if !ok {
+		return false
+	}
+	return arcsCross(a, b, c, d)
+}
+
+// inField is true if the portal is strictly inside the field
+func (g opGeometry) inField(p PortalID, f [3]PortalID) bool {
+	if p == f[0] || p == f[1] || p == f[2] {
+		return false
+	}
+	pt, ok := g.points[p]
+	if !ok {
+		return false
+	}
+	a, aok := g.points[f[0]]
+	b, bok := g.points[f[1]]
+	c, cok := g.points[f[2]]
+	if !aok || !bok || !cok {
+		return false
+	}
+	return inTriangle(pt, a, b, c)
+}
+
+// fieldAround finds a field the portal is inside
+func (g opGeometry) fieldAround(p PortalID, fields [][3]PortalID) ([3]PortalID, bool) {
+	for _, f := range fields {
+		if g.inField(p, f) {
+			return f, true
+		}
+	}
+	return [3]PortalID{}, false
+}
+
+// thrownLinks is the op's links in the order they are to be thrown; links with the same order keep their order in the op
+func (o *Operation) thrownLinks() []Link {
+	links := make([]Link, len(o.Links))
+	copy(links, o.Links)
+	sort.SliceStable(links, func(i, j int) bool {
+		return links[i].ThrowOrder < links[j].ThrowOrder
+	})
+	return links
+}
+
+// linkGraph tracks which portals are linked as links are thrown, to find the triangles each new link closes
+type linkGraph map[PortalID]map[PortalID]bool
+
+func (lg linkGraph) add(a, b PortalID) {
+	if lg[a] == nil {
+		lg[a] = make(map[PortalID]bool)
+	}
+	if lg[b] == nil {
+		lg[b] = make(map[PortalID]bool)
+	}
+	lg[a][b] = true
+	lg[b][a] = true
+}
+
+// closes lists the third portal of each triangle a link between a and b would complete
+func (lg linkGraph) closes(a, b PortalID) []PortalID {
+	var thirds []PortalID
+	for c := range lg[a] {
+		if c != b && lg[b][c] {
+			thirds = append(thirds, c)
+		}
+	}
+	sort.Slice(thirds, func(i, j int) bool { return thirds[i] < thirds[j] })
+	return thirds
+}
+
+// OpProblem is one thing wrong with the op's plan
+type OpProblem struct {
+	Type    string     `json:"type"`
+	LinkID  LinkID     `json:"linkID,omitempty"`
+	Other   LinkID     `json:"other,omitempty"`  // the link or blocker it crosses
+	Portal  PortalID   `json:"portal,omitempty"` // the portal with bad coordinates
+	Field   []PortalID `json:"field,omitempty"`
+	Message string     `json:"message"`
+}
+
+// OpProblemCrossesLink et al. are the kinds of OpProblem
+const (
+	OpProblemCrossesLink    = "crossesLink"
+	OpProblemCrossesBlocker = "crossesBlocker"
+	OpProblemInsideField    = "insideField"
+	OpProblemBadPortal      = "badPortal"
+)
+
+// OpValidation is the result of checking an op's geometry
+type OpValidation struct {
+	ID       OperationID `json:"ID"`
+	Valid    bool        `json:"valid"`
+	Problems []OpProblem `json:"problems"`
+}
+
+// Validate checks the op's links against each other, against the blockers and against the fields thrown before them.
+// The op must be populated, or parsed from a client, first.
+func (o *Operation) Validate() OpValidation {
+	v := OpValidation{ID: o.ID, Problems: []OpProblem{}}
+
+	g, bad := o.geometry()
+	for _, p := range bad {
+		v.Problems = append(v.Problems, OpProblem{
+			Type:    OpProblemBadPortal,
+			Portal:  p,
+			Message: fmt.Sprintf("portal %s has no usable location", p),
+		})
+	}
+
+	// a blocker which cannot be placed cannot be checked; say so rather than pass the links it may cross
+	var blockers []Link
+	for _, b := range o.Blockers {
+		if _, _, ok := g.arc(b); !ok {
+			v.Problems = append(v.Problems, OpProblem{
+				Type:    OpProblemBadPortal,
+				LinkID:  b.ID,
+				Message: fmt.Sprintf("blocker %s is between portals with no usable location", b.ID),
+			})
+			continue
+		}
+		blockers = append(blockers, b)
+	}
+
+	for i, l := range o.Links {
+		if _, _, ok := g.arc(l); !ok {
+			v.Problems = append(v.Problems, OpProblem{
+				Type:    OpProblemBadPortal,
+				LinkID:  l.ID,
+				Message: fmt.Sprintf("link %s is between unknown portals", l.ID),
+			})
+			continue
+		}
+		for _, m := range o.Links[i+1:] {
+			if g.linksCross(l, m) {
+				v.Problems = append(v.Problems, OpProblem{
+					Type:    OpProblemCrossesLink,
+					LinkID:  l.ID,
+					Other:   m.ID,
+					Message: fmt.Sprintf("link %s crosses link %s", l.ID, m.ID),
+				})
+			}
+		}
+		for _, b := range blockers {
+			if g.linksCross(l, b) {
+				v.Problems = append(v.Problems, OpProblem{
+					Type:    OpProblemCrossesBlocker,
+					LinkID:  l.ID,
+					Other:   b.ID,
+					Message: fmt.Sprintf("link %s crosses blocker %s", l.ID, b.ID),
+				})
+			}
+		}
+	}
+
+	// a link cannot be thrown from inside a field which is already up
+	lg := make(linkGraph)
+	var fields [][3]PortalID
+	for _, l := range o.thrownLinks() {
+		if l.From == l.To {
+			continue
+		}
+		if f, ok := g.fieldAround(l.From, fields); ok {
+			v.Problems = append(v.Problems, OpProblem{
+				Type:    OpProblemInsideField,
+				LinkID:  l.ID,
+				Field:   []PortalID{f[0], f[1], f[2]},
+				Message: fmt.Sprintf("link %s starts inside the field %s, %s, %s thrown before it", l.ID, f[0], f[1], f[2]),
+			})
+		}
+		for _, c := range lg.closes(l.From, l.To) {
+			fields = append(fields, [3]PortalID{l.From, l.To, c})
+		}
+		lg.add(l.From, l.To)
+	}
+
+	v.Valid = len(v.Problems) == 0
+	return v
+}
@@ -0,0 +1,56 @@
+package wasabee_test
+
+import (
+	"github.com/wasabee-project/Wasabee-Server"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	op := wasabee.Operation{
+		ID: "validate",
+		OpPortals: []wasabee.Portal{
+			{ID: "A", Lat: "0", Lon: "0"},
+			{ID: "B", Lat: "0", Lon: "2"},
+			{ID: "C", Lat: "2", Lon: "1"},
+			{ID: "D", Lat: "0.5", Lon: "1"},
+			{ID: "E", Lat: "-1", Lon: "1"},
+			{ID: "Y", Lat: "1", Lon: "-1"},
+			{ID: "Z", Lat: "1", Lon: "3"},
+		},
+		Links: []wasabee.Link{
+			{ID: "AB", From: "A", To: "B", ThrowOrder: 1},
+			{ID: "BC", From: "B", To: "C", ThrowOrder: 2},
+			{ID: "CA", From: "C", To: "A", ThrowOrder: 3},
+			{ID: "DE", From: "D", To: "E", ThrowOrder: 4},
+		},
+		Blockers: []wasabee.Link{
+			{ID: "YZ", From: "Y", To: "Z"},
+		},
+	}
+
+	v := op.Validate()
+	found := make(map[string]int)
+	for _, p := range v.Problems {
+		found[p.Type+":"+string(p.LinkID)+":"+string(p.Other)]++
+	}
+	for _, want := range []string{"crossesLink:AB:DE", "crossesBlocker:BC:YZ", "crossesBlocker:CA:YZ", "insideField:DE:"} {
+		if found[want] != 1 {
+			t.Errorf("missing %s in %v", want, v.Problems)
+		}
+	}
+	if v.Valid || len(v.Problems) != 4 {
+		t.Errorf("unexpected problems: %v", v.Problems)
+	}
+
+	// thrown before the field closes it is fine
+	op.Blockers = nil
+	op.Links[3] = wasabee.Link{ID: "DC", From: "D", To: "C", ThrowOrder: 0}
+	if v := op.Validate(); !v.Valid {
+		t.Errorf("unexpected problems: %v", v.Problems)
+	}
+
+	op.OpPortals[0].Lat = "north"
+	if v := op.Validate(); v.Valid {
+		t.Error("bad portal location not reported")
+	}
+}
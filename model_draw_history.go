@@ -0,0 +1,141 @@
+package wasabee
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+)
+
+// opRevisionsKept is how many revisions are kept for each op, older ones are pruned as new ones are recorded
+const opRevisionsKept = 100
+
+// OpRevision is a snapshot of an operation taken after each change
+type OpRevision struct {
+	ID          int             `json:"revision"`
+	Gid         GoogleID        `json:"gid"`
+	Description string          `json:"description"`
+	Recorded    string          `json:"recorded"`
+	Version     int64           `json:"version"` // the op's version when it was recorded
+	Op          json.RawMessage `json:"op,omitempty"`
+}
+
+// opSnapshot is what a revision records: the op as its owner sees it, and who completed each marker,
+// since the op itself only carries their names
+type opSnapshot struct {
+	Operation
+	CompletedBy map[MarkerID]GoogleID `json:"completedByGid,omitempty"`
+}
+
+// recordRevision saves the op as it is now; gid is the agent who made the change
+func (o *Operation) recordRevision(gid GoogleID, description string) error {
+	owner, err := store.OperationOwner(o.ID)
+	if err != nil {
+		Log.Error(err)
+		return err
+	}
+
+	// the owner can see everything
+	snap := opSnapshot{Operation: Operation{ID: o.ID}}
+	if err := snap.Populate(owner); err != nil {
+		Log.Error(err)
+		return err
+	}
+	snap.Fetched = ""
+	if snap.CompletedBy, err = store.MarkerCompleters(o.ID); err != nil {
+		Log.Error(err)
+		return err
+	}
+
+	data, err := json.Marshal(snap)
+	if err != nil {
+		Log.Error(err)
+		return err
+	}
+
+	if err := store.InsertOpRevision(o.ID, gid, description, snap.Version, data); err != nil {
+		Log.Error(err)
+		return err
+	}
+	if err := store.PruneOpRevisions(o.ID, opRevisionsKept); err != nil {
+		Log.Error(err)
+		return err
+	}
+	return nil
+}
+
+// History lists the op's revisions, newest first, without the snapshots
+func (o *Operation) History(gid GoogleID) ([]OpRevision, error) {
+	if !o.WriteAccess(gid) {
+		err := fmt.Errorf("write access required to view history")
+		Log.Error(err)
+		return nil, err
+	}
+
+	revisions, err := store.OpRevisions(o.ID)
+	if err != nil {
+		Log.Error(err)
+		return revisions, err
+	}
+	return revisions, nil
+}
+
+// Revision returns a single revision of the op, including the snapshot
+func (o *Operation) Revision(gid GoogleID, revision int) (OpRevision, error) {
+	if !o.WriteAccess(gid) {
+		err := fmt.Errorf("write access required to view history")
+		Log.Error(err)
+		return OpRevision{}, err
+	}
+
+	r, err := store.OpRevision(o.ID, revision)
+	if err == sql.ErrNoRows {
+		err = fmt.Errorf("no such revision: %d", revision)
+		Log.Notice(err)
+		return r, err
+	}
+	if err != nil {
+		Log.Error(err)
+		return r, err
+	}
+	return r, nil
+}
+
+// Restore puts the op's portals, links, markers and anchors back the way they were at a revision,
+// including assignments and state. Team permissions and keys on hand are left untouched.
+// The restore itself is recorded as a new revision, so it can be undone.
+func (o *Operation) Restore(gid GoogleID, revision int) error {
+	r, err := o.Revision(gid, revision)
+	if err != nil {
+		return err
+	}
+
+	var snap opSnapshot
+	if err := json.Unmarshal(r.Op, &snap); err != nil {
+		Log.Error(err)
+		return err
+	}
+	snap.ID = o.ID
+
+	// the markers carry the names of the agents who completed them, the store wants the GoogleIDs
+	for i := range snap.Markers {
+		snap.Markers[i].CompletedBy = string(snap.CompletedBy[snap.Markers[i].ID])
+	}
+
+	if rejected := snap.normalizeUpdate(); len(rejected) > 0 {
+		err := &OpUpdateError{OpID: o.ID, Rejected: rejected}
+		Log.Error(err)
+		return err
+	}
+
+	if err := store.RestoreOperation(&snap.Operation); err != nil {
+		Log.Error(err)
+		return err
+	}
+
+	// the store moved the version along with the restore
+	if err := o.recordRevision(gid, fmt.Sprintf("restore revision %d", revision)); err != nil {
+		Log.Error(err)
+	}
+	o.changeEvent(gid, "restore", fmt.Sprintf("revision %d", revision))
+	return nil
+}
@@ -0,0 +1,517 @@
+package wasabee
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ImportDrawTools is the IITC DrawTools export format; GeoJSON and KML use the Export names
+const ImportDrawTools = "drawtools"
+
+// importMatchMeters is how close a point has to be to a known portal to be taken as that portal
+const importMatchMeters = 15.0
+
+var portalGUIDRe = regexp.MustCompile(`^[0-9a-f]{32}\.[0-9a-f]{2}$`)
+
+// ImportProblem is something in an import which could not be made part of the op
+type ImportProblem struct {
+	Feature int    `json:"feature"` // position in the file, from 0
+	Name    string `json:"name,omitempty"`
+	Lat     string `json:"lat,omitempty"`
+	Lon     string `json:"lng,omitempty"`
+	Reason  string `json:"reason"`
+}
+
+// ImportReport says what DrawImport made of a file
+type ImportReport struct {
+	ID        OperationID     `json:"ID"`
+	Portals   int             `json:"portals"`
+	Links     int             `json:"links"`
+	Markers   int             `json:"markers"`
+	Unmatched []ImportProblem `json:"unmatched"`
+}
+
+// importFeature is a point, line or polygon from any of the formats
+type importFeature struct {
+	name       string
+	guid       PortalID
+	color      string
+	markerType MarkerType
+	ring       bool         // a polygon; the last point joins the first
+	points     [][2]float64 // lat, lon
+}
+
+// DrawImport creates an op from a GeoJSON, KML or DrawTools file. Points become portals when they carry a portal GUID
+// or are within a few meters of a portal in the file or in one of the agent's ops; lines become links between
+// consecutive points and polygons a link along each side. Anything which cannot be matched to a portal is reported.
+func DrawImport(data []byte, format string, name string, gid GoogleID) (ImportReport, error) {
+	r := ImportReport{Unmatched: []ImportProblem{}}
+
+	var features []importFeature
+	var err error
+	switch format {
+	case ExportGeoJSON:
+		features, err = importGeoJSON(data)
+	case ExportKML:
+		features, err = importKML(data)
+	case ImportDrawTools:
+		features, err = importDrawTools(data)
+	default:
+		err = fmt.Errorf("unknown import format: %s", format)
+	}
+	if err != nil {
+		Log.Notice(err)
+		return r, err
+	}
+
+	known, err := gid.importKnownPortals(features)
+	if err != nil {
+		Log.Error(err)
+		return r, err
+	}
+
+	tmpid, err := GenerateSafeName()
+	if err != nil {
+		Log.Error(err)
+		return r, err
+	}
+	o := Operation{ID: OperationID(tmpid), Name: name, Color: "main"}
+	if o.Name == "" {
+		o.Name = "import " + tmpid
+	}
+	r.ID = o.ID
+
+	portals := make(map[PortalID]bool)
+	addPortal := func(p Portal) {
+		if !portals[p.ID] {
+			portals[p.ID] = true
+			o.OpPortals = append(o.OpPortals, p)
+		}
+	}
+	links := make(map[[2]PortalID]bool)
+
+	for i, f := range features {
+		matched := make([]*Portal, len(f.points))
+		for j, pt := range f.points {
+			if p, ok := known.match(f, pt); ok {
+				matched[j] = &p
+				addPortal(p)
+				continue
+			}
+			r.Unmatched = append(r.Unmatched, ImportProblem{
+				Feature: i,
+				Name:    f.name,
+				Lat:     strconv.FormatFloat(pt[0], 'f', -1, 64),
+				Lon:     strconv.FormatFloat(pt[1], 'f', -1, 64),
+				Reason:  "no portal here",
+			})
+		}
+
+		if len(f.points) == 1 {
+			if matched[0] != nil && f.markerType != "" {
+				o.Markers = append(o.Markers, Marker{ID: MarkerID(GenerateName()), PortalID: matched[0].ID, Type: f.markerType, Comment: f.name})
+			}
+			continue
+		}
+
+		edges := len(f.points) - 1
+		if f.ring {
+			edges = len(f.points)
+		}
+		for j := 0; j < edges; j++ {
+			from, to := matched[j], matched[(j+1)%len(f.points)]
+			if from == nil || to == nil || from.ID == to.ID {
+				continue
+			}
+			key := [2]PortalID{from.ID, to.ID}
+			if to.ID < from.ID {
+				key = [2]PortalID{to.ID, from.ID}
+			}
+			if links[key] {
+				continue
+			}
+			links[key] = true
+			o.Links = append(o.Links, Link{
+				ID:         LinkID(GenerateName()),
+				From:       from.ID,
+				To:         to.ID,
+				Color:      OpValidColor(f.color),
+				ThrowOrder: int32(len(o.Links) + 1),
+			})
+		}
+	}
+
+	if len(o.OpPortals) == 0 {
+		err := fmt.Errorf("nothing in the import matched a portal")
+		Log.Notice(err)
+		return r, err
+	}
+
+	teamID, err := gid.NewTeam(o.Name)
+	if err != nil {
+		Log.Error(err)
+	}
+	if err = drawOpInsertWorker(o, gid, teamID); err != nil {
+		Log.Error(err)
+		return r, err
+	}
+	if err = o.recordRevision(gid, "import"); err != nil {
+		Log.Error(err)
+	}
+
+	r.Portals, r.Links, r.Markers = len(o.OpPortals), len(o.Links), len(o.Markers)
+	return r, nil
+}
+
+type importPortals []Portal
+
+// importKnownPortals is every portal the import can be matched to: the ones named in the file, then those in the agent's ops
+func (gid GoogleID) importKnownPortals(features []importFeature) (importPortals, error) {
+	var known importPortals
+	seen := make(map[PortalID]bool)
+
+	for _, f := range features {
+		if f.guid == "" || len(f.points) != 1 || seen[f.guid] {
+			continue
+		}
+		seen[f.guid] = true
+		known = append(known, Portal{
+			ID:   f.guid,
+			Name: f.name,
+			Lat:  strconv.FormatFloat(f.points[0][0], 'f', -1, 64),
+			Lon:  strconv.FormatFloat(f.points[0][1], 'f', -1, 64),
+		})
+	}
+
+	ops, err := store.AgentOps(gid)
+	if err != nil {
+		return known, err
+	}
+	for _, op := range ops {
+		portals, err := store.Portals(OperationID(op.ID))
+		if err != nil {
+			Log.Notice(err)
+			continue
+		}
+		for _, p := range portals {
+			if !seen[p.ID] {
+				seen[p.ID] = true
+				known = append(known, p)
+			}
+		}
+	}
+	return known, nil
+}
+
+// match finds the portal a point refers to, by its GUID or the nearest known portal
+func (known importPortals) match(f importFeature, pt [2]float64) (Portal, bool) {
+	lat := strconv.FormatFloat(pt[0], 'f', -1, 64)
+	lon := strconv.FormatFloat(pt[1], 'f', -1, 64)
+	if f.guid != "" && len(f.points) == 1 {
+		for _, p := range known {
+			if p.ID == f.guid {
+				return p, true
+			}
+		}
+	}
+
+	best, bestDist := -1, importMatchMeters
+	for i, p := range known {
+		if d := Distance(lat, lon, p.Lat, p.Lon); d <= bestDist {
+			best, bestDist = i, d
+		}
+	}
+	if best == -1 {
+		return Portal{}, false
+	}
+	return known[best], true
+}
+
+func importGUID(props map[string]interface{}) PortalID {
+	for _, k := range []string{"guid", "portalId", "portalID", "id"} {
+		if s, ok := props[k].(string); ok && portalGUIDRe.MatchString(s) {
+			return PortalID(s)
+		}
+	}
+	return ""
+}
+
+func importString(props map[string]interface{}, keys ...string) string {
+	for _, k := range keys {
+		if s, ok := props[k].(string); ok && s != "" {
+			return s
+		}
+	}
+	return ""
+}
+
+type geoJSONImport struct {
+	Type        string                 `json:"type"`
+	Features    []geoJSONImport        `json:"features"`
+	Geometry    *geoJSONImport         `json:"geometry"`
+	Geometries  []geoJSONImport        `json:"geometries"`
+	Properties  map[string]interface{} `json:"properties"`
+	Coordinates json.RawMessage        `json:"coordinates"`
+}
+
+func importGeoJSON(data []byte) ([]importFeature, error) {
+	var g geoJSONImport
+	if err := json.Unmarshal(data, &g); err != nil {
+		return nil, err
+	}
+	var features []importFeature
+	if err := g.features(nil, &features); err != nil {
+		return nil, err
+	}
+	return features, nil
+}
+
+func (g geoJSONImport) features(props map[string]interface{}, out *[]importFeature) error {
+	switch g.Type {
+	case "FeatureCollection":
+		for _, f := range g.Features {
+			if err := f.features(nil, out); err != nil {
+				return err
+			}
+		}
+		return nil
+	case "Feature":
+		if g.Geometry == nil {
+			return nil
+		}
+		return g.Geometry.features(g.Properties, out)
+	case "GeometryCollection":
+		for _, c := range g.Geometries {
+			if err := c.features(props, out); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	f := importFeature{
+		name:  importString(props, "name", "title"),
+		guid:  importGUID(props),
+		color: importString(props, "color"),
+	}
+	if importString(props, "type") == "marker" {
+		f.markerType = MarkerType(importString(props, "markerType"))
+	}
+	emit := func(pts [][]float64, ring bool) {
+		nf := f
+		nf.ring = ring
+		for _, c := range pts {
+			if len(c) >= 2 {
+				nf.points = append(nf.points, [2]float64{c[1], c[0]})
+			}
+		}
+		if ring && len(nf.points) > 1 && nf.points[0] == nf.points[len(nf.points)-1] {
+			nf.points = nf.points[:len(nf.points)-1]
+		}
+		if len(nf.points) > 0 {
+			*out = append(*out, nf)
+		}
+	}
+
+	var err error
+	switch g.Type {
+	case "Point":
+		var c []float64
+		if err = json.Unmarshal(g.Coordinates, &c); err == nil {
+			emit([][]float64{c}, false)
+		}
+	case "MultiPoint":
+		var cs [][]float64
+		if err = json.Unmarshal(g.Coordinates, &cs); err == nil {
+			for _, c := range cs {
+				emit([][]float64{c}, false)
+			}
+		}
+	case "LineString":
+		var cs [][]float64
+		if err = json.Unmarshal(g.Coordinates, &cs); err == nil {
+			emit(cs, false)
+		}
+	case "MultiLineString":
+		var ls [][][]float64
+		if err = json.Unmarshal(g.Coordinates, &ls); err == nil {
+			for _, l := range ls {
+				emit(l, false)
+			}
+		}
+	case "Polygon":
+		var rings [][][]float64
+		if err = json.Unmarshal(g.Coordinates, &rings); err == nil && len(rings) > 0 {
+			emit(rings[0], true)
+		}
+	case "MultiPolygon":
+		var polys [][][][]float64
+		if err = json.Unmarshal(g.Coordinates, &polys); err == nil {
+			for _, rings := range polys {
+				if len(rings) > 0 {
+					emit(rings[0], true)
+				}
+			}
+		}
+	default:
+		err = fmt.Errorf("unknown GeoJSON type: %s", g.Type)
+	}
+	return err
+}
+
+type kmlImportGeometry struct {
+	Points      []kmlPoint      `xml:"Point"`
+	LineStrings []kmlLineString `xml:"LineString"`
+	Polygons    []struct {
+		Outer string `xml:"outerBoundaryIs>LinearRing>coordinates"`
+	} `xml:"Polygon"`
+	Multi []kmlImportGeometry `xml:"MultiGeometry"`
+}
+
+type kmlImportPlacemark struct {
+	Name     string    `xml:"name"`
+	StyleURL string    `xml:"styleUrl"`
+	Data     []kmlData `xml:"ExtendedData>Data"`
+	kmlImportGeometry
+}
+
+// importKML reads every Placemark in the file, however deep in folders
+func importKML(data []byte) ([]importFeature, error) {
+	var features []importFeature
+	d := xml.NewDecoder(bytes.NewReader(data))
+	for {
+		t, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		se, ok := t.(xml.StartElement)
+		if !ok || se.Name.Local != "Placemark" {
+			continue
+		}
+		var pm kmlImportPlacemark
+		if err := d.DecodeElement(&pm, &se); err != nil {
+			return nil, err
+		}
+
+		props := make(map[string]interface{})
+		for _, d := range pm.Data {
+			props[d.Name] = strings.TrimSpace(d.Value)
+		}
+		f := importFeature{
+			name:       strings.TrimSpace(pm.Name),
+			guid:       importGUID(props),
+			color:      strings.TrimPrefix(pm.StyleURL, "#"),
+			markerType: MarkerType(importString(props, "type")),
+		}
+		pm.kmlImportGeometry.features(f, &features)
+	}
+	return features, nil
+}
+
+func (g kmlImportGeometry) features(f importFeature, out *[]importFeature) {
+	emit := func(coords string, ring bool) {
+		nf := f
+		nf.ring = ring
+		nf.points = kmlCoordinates(coords)
+		if ring && len(nf.points) > 1 && nf.points[0] == nf.points[len(nf.points)-1] {
+			nf.points = nf.points[:len(nf.points)-1]
+		}
+		if len(nf.points) > 0 {
+			*out = append(*out, nf)
+		}
+	}
+	for _, p := range g.Points {
+		emit(p.Coordinates, false)
+	}
+	for _, l := range g.LineStrings {
+		emit(l.Coordinates, false)
+	}
+	for _, p := range g.Polygons {
+		emit(p.Outer, true)
+	}
+	for _, m := range g.Multi {
+		m.features(f, out)
+	}
+}
+
+// kmlCoordinates parses "lon,lat[,alt] lon,lat[,alt] ..."
+func kmlCoordinates(s string) [][2]float64 {
+	var pts [][2]float64
+	for _, c := range strings.Fields(s) {
+		parts := strings.Split(c, ",")
+		if len(parts) < 2 {
+			continue
+		}
+		lon, err := strconv.ParseFloat(parts[0], 64)
+		if err != nil {
+			continue
+		}
+		lat, err := strconv.ParseFloat(parts[1], 64)
+		if err != nil {
+			continue
+		}
+		pts = append(pts, [2]float64{lat, lon})
+	}
+	return pts
+}
+
+type drawToolsLatLng struct {
+	Lat float64 `json:"lat"`
+	Lng float64 `json:"lng"`
+}
+
+type drawToolsItem struct {
+	Type    string           `json:"type"`
+	Color   string           `json:"color"`
+	LatLng  *drawToolsLatLng `json:"latLng"`
+	LatLngs json.RawMessage  `json:"latLngs"`
+}
+
+// importDrawTools reads IITC DrawTools' copy/paste format; it has no GUIDs, so everything is matched by location
+func importDrawTools(data []byte) ([]importFeature, error) {
+	var items []drawToolsItem
+	if err := json.Unmarshal(data, &items); err != nil {
+		return nil, err
+	}
+
+	var features []importFeature
+	for _, it := range items {
+		f := importFeature{color: it.Color}
+		switch it.Type {
+		case "marker":
+			if it.LatLng != nil {
+				f.points = [][2]float64{{it.LatLng.Lat, it.LatLng.Lng}}
+				features = append(features, f)
+			}
+		case "polyline", "polygon":
+			f.ring = it.Type == "polygon"
+			// newer versions nest polygons' rings
+			var rings [][]drawToolsLatLng
+			var flat []drawToolsLatLng
+			if err := json.Unmarshal(it.LatLngs, &flat); err == nil {
+				rings = [][]drawToolsLatLng{flat}
+			} else if err := json.Unmarshal(it.LatLngs, &rings); err != nil {
+				return nil, err
+			}
+			for _, ring := range rings {
+				nf := f
+				for _, ll := range ring {
+					nf.points = append(nf.points, [2]float64{ll.Lat, ll.Lng})
+				}
+				if len(nf.points) > 0 {
+					features = append(features, nf)
+				}
+			}
+		}
+	}
+	return features, nil
+}
@@ -0,0 +1,79 @@
+package wasabee_test
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/wasabee-project/Wasabee-Server"
+	"testing"
+)
+
+const (
+	importA = "0123456789abcdef0123456789abcdef.16"
+	importB = "1123456789abcdef0123456789abcdef.16"
+	importC = "2123456789abcdef0123456789abcdef.16"
+)
+
+func TestDrawImport(t *testing.T) {
+	geojson := fmt.Sprintf(`{"type": "FeatureCollection", "features": [
+		{"type": "Feature", "geometry": {"type": "Point", "coordinates": [0, 0]}, "properties": {"guid": "%s", "name": "A"}},
+		{"type": "Feature", "geometry": {"type": "Point", "coordinates": [0.01, 0]}, "properties": {"guid": "%s", "name": "B"}},
+		{"type": "Feature", "geometry": {"type": "Point", "coordinates": [0, 0.01]}, "properties": {"guid": "%s", "name": "C"}},
+		{"type": "Feature", "geometry": {"type": "Polygon", "coordinates": [[[0, 0], [0.01, 0], [0, 0.01], [0, 0]]]}, "properties": {"color": "groupb"}},
+		{"type": "Feature", "geometry": {"type": "LineString", "coordinates": [[0.00001, 0.00001], [0.01, 0], [5, 5]]}},
+		{"type": "Feature", "geometry": {"type": "Point", "coordinates": [0.01, 0]}, "properties": {"type": "marker", "markerType": "DestroyPortalAlert"}}
+	]}`, importA, importB, importC)
+
+	r, err := wasabee.DrawImport([]byte(geojson), wasabee.ExportGeoJSON, "import test", gid)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	op := wasabee.Operation{ID: r.ID}
+	defer op.Delete(gid)
+
+	// the triangle's three links; the line's first link repeats AB, its second has nowhere to go
+	if r.Portals != 3 || r.Links != 3 || r.Markers != 1 || len(r.Unmatched) != 1 || r.Unmatched[0].Feature != 4 {
+		t.Errorf("unexpected import: %v", r)
+	}
+	if err := op.Populate(gid); err != nil {
+		t.Fatal(err.Error())
+	}
+	if op.Name != "import test" || len(op.Links) != 3 || op.Links[0].Color != "groupb" {
+		t.Errorf("unexpected op: %v", op)
+	}
+
+	// DrawTools has no GUIDs; the portals are found in the op just imported
+	drawtools := `[{"type": "polyline", "latLngs": [{"lat": 0, "lng": 0}, {"lat": 0.00002, "lng": 0.01}], "color": "#a24ac3"},
+		{"type": "polygon", "latLngs": [[{"lat": 0, "lng": 0}, {"lat": 0.01, "lng": 0}, {"lat": 2, "lng": 2}]]},
+		{"type": "marker", "latLng": {"lat": 0.01, "lng": 0}}]`
+	dr, err := wasabee.DrawImport([]byte(drawtools), wasabee.ImportDrawTools, "", gid)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	dop := wasabee.Operation{ID: dr.ID}
+	defer dop.Delete(gid)
+	if dr.Portals != 3 || dr.Links != 2 || len(dr.Unmatched) != 1 {
+		t.Errorf("unexpected drawtools import: %v", dr)
+	}
+
+	// what we export we can import
+	var b bytes.Buffer
+	if err := op.KML(&b); err != nil {
+		t.Fatal(err.Error())
+	}
+	kr, err := wasabee.DrawImport(b.Bytes(), wasabee.ExportKML, "kml", gid)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	kop := wasabee.Operation{ID: kr.ID}
+	defer kop.Delete(gid)
+	if kr.Portals != 3 || kr.Links != 3 || kr.Markers != 1 || len(kr.Unmatched) != 0 {
+		t.Errorf("unexpected kml import: %v", kr)
+	}
+
+	if _, err := wasabee.DrawImport([]byte(`[{"type": "marker", "latLng": {"lat": 50, "lng": 50}}]`), wasabee.ImportDrawTools, "", gid); err == nil {
+		t.Error("imported an op with no portals")
+	}
+	if _, err := wasabee.DrawImport([]byte(geojson), "shp", "", gid); err == nil {
+		t.Error("imported an unknown format")
+	}
+}
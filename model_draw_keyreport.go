@@ -0,0 +1,225 @@
+package wasabee
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// KeyHolder is one agent's keys for a portal
+type KeyHolder struct {
+	Gid       GoogleID `json:"gid"`
+	OnHand    int32    `json:"onhand"`
+	Defensive int32    `json:"defensive,omitempty"`
+	Capsule   string   `json:"capsule,omitempty"`
+}
+
+// PortalKeys is how many keys a portal needs and who has them
+type PortalKeys struct {
+	PortalID  PortalID    `json:"portalId"`
+	Name      string      `json:"name"`
+	Required  int32       `json:"required"` // one per link to the portal
+	OnHand    int32       `json:"onhand"`
+	Defensive int32       `json:"defensive"`
+	Shortfall int32       `json:"shortfall"`
+	Holders   []KeyHolder `json:"holders"`
+}
+
+// AgentPortalKeys is what an agent needs for one portal to throw their assigned links
+type AgentPortalKeys struct {
+	PortalID  PortalID `json:"portalId"`
+	Name      string   `json:"name"`
+	Required  int32    `json:"required"`
+	Held      int32    `json:"held"`
+	Shortfall int32    `json:"shortfall"`
+	Capsule   string   `json:"capsule,omitempty"`
+}
+
+// AgentKeys is what an agent needs to throw their assigned links
+type AgentKeys struct {
+	Gid       GoogleID          `json:"gid"`
+	Required  int32             `json:"required"`
+	Held      int32             `json:"held"`
+	Shortfall int32             `json:"shortfall"`
+	Portals   []AgentPortalKeys `json:"portals"`
+}
+
+// KeyReport is the keys an op needs, by portal and by assigned agent
+type KeyReport struct {
+	ID         OperationID  `json:"ID"`
+	Required   int32        `json:"required"`
+	Shortfall  int32        `json:"shortfall"`
+	Portals    []PortalKeys `json:"portals"`
+	Agents     []AgentKeys  `json:"agents"`
+	Unassigned int32        `json:"unassigned"` // keys needed for links nobody has been assigned
+}
+
+// KeyReport works out the keys the op's links need and compares them to the keys agents have said they hold.
+// With defensive set, defensive keys count too; only agents On in a team with the caller share theirs.
+// The op must be populated first.
+func (o *Operation) KeyReport(gid GoogleID, defensive bool) (KeyReport, error) {
+	r := KeyReport{ID: o.ID, Portals: []PortalKeys{}, Agents: []AgentKeys{}}
+
+	names := make(map[PortalID]string)
+	for _, p := range o.OpPortals {
+		names[p.ID] = p.Name
+	}
+
+	required := make(map[PortalID]int32)
+	agentRequired := make(map[GoogleID]map[PortalID]int32)
+	for _, l := range o.Links {
+		required[l.To]++
+		r.Required++
+		if l.AssignedTo == "" {
+			r.Unassigned++
+			continue
+		}
+		if agentRequired[l.AssignedTo] == nil {
+			agentRequired[l.AssignedTo] = make(map[PortalID]int32)
+		}
+		agentRequired[l.AssignedTo][l.To]++
+	}
+
+	holders := make(map[PortalID]map[GoogleID]*KeyHolder)
+	holder := func(p PortalID, g GoogleID) *KeyHolder {
+		if holders[p] == nil {
+			holders[p] = make(map[GoogleID]*KeyHolder)
+		}
+		h, ok := holders[p][g]
+		if !ok {
+			h = &KeyHolder{Gid: g}
+			holders[p][g] = h
+		}
+		return h
+	}
+	capsule := func(h *KeyHolder, c string) {
+		if c == "" {
+			return
+		}
+		if h.Capsule == "" {
+			h.Capsule = c
+			return
+		}
+		for _, have := range strings.Split(h.Capsule, ",") {
+			if have == c {
+				return
+			}
+		}
+		h.Capsule += "," + c
+	}
+
+	for _, k := range o.Keys {
+		if _, ok := required[k.ID]; !ok || k.Onhand <= 0 {
+			continue
+		}
+		h := holder(k.ID, k.Gid)
+		h.OnHand += k.Onhand
+		capsule(h, k.Capsule)
+	}
+	if defensive {
+		dks, err := store.DefensiveKeys(gid)
+		if err != nil {
+			Log.Error(err)
+			return r, err
+		}
+		for _, dk := range dks {
+			if _, ok := required[dk.PortalID]; !ok || dk.Count <= 0 {
+				continue
+			}
+			h := holder(dk.PortalID, dk.GID)
+			h.Defensive += dk.Count
+			capsule(h, dk.CapID)
+		}
+	}
+
+	for p, req := range required {
+		pk := PortalKeys{PortalID: p, Name: names[p], Required: req, Holders: []KeyHolder{}}
+		for _, h := range holders[p] {
+			pk.OnHand += h.OnHand
+			pk.Defensive += h.Defensive
+			pk.Holders = append(pk.Holders, *h)
+		}
+		sort.Slice(pk.Holders, func(i, j int) bool { return pk.Holders[i].Gid < pk.Holders[j].Gid })
+		if short := req - pk.OnHand - pk.Defensive; short > 0 {
+			pk.Shortfall = short
+			r.Shortfall += short
+		}
+		r.Portals = append(r.Portals, pk)
+	}
+	sort.Slice(r.Portals, func(i, j int) bool {
+		if r.Portals[i].Shortfall != r.Portals[j].Shortfall {
+			return r.Portals[i].Shortfall > r.Portals[j].Shortfall
+		}
+		return r.Portals[i].PortalID < r.Portals[j].PortalID
+	})
+
+	// each agent needs their own keys in hand to throw their links
+	for g, portals := range agentRequired {
+		ak := AgentKeys{Gid: g, Portals: []AgentPortalKeys{}}
+		for p, req := range portals {
+			apk := AgentPortalKeys{PortalID: p, Name: names[p], Required: req}
+			if h, ok := holders[p][g]; ok {
+				apk.Held = h.OnHand + h.Defensive
+				apk.Capsule = h.Capsule
+			}
+			if apk.Held < req {
+				apk.Shortfall = req - apk.Held
+			}
+			ak.Required += req
+			ak.Held += apk.Held
+			ak.Shortfall += apk.Shortfall
+			ak.Portals = append(ak.Portals, apk)
+		}
+		sort.Slice(ak.Portals, func(i, j int) bool { return ak.Portals[i].PortalID < ak.Portals[j].PortalID })
+		r.Agents = append(r.Agents, ak)
+	}
+	sort.Slice(r.Agents, func(i, j int) bool { return r.Agents[i].Gid < r.Agents[j].Gid })
+	return r, nil
+}
+
+// PortalCSV writes the report one row per portal
+func (r KeyReport) PortalCSV(w io.Writer) error {
+	c := csv.NewWriter(w)
+	if err := c.Write([]string{"portalId", "name", "required", "onhand", "defensive", "shortfall", "holders"}); err != nil {
+		return err
+	}
+	for _, p := range r.Portals {
+		var hs []string
+		for _, h := range p.Holders {
+			s := fmt.Sprintf("%s:%d", h.Gid, h.OnHand+h.Defensive)
+			if h.Capsule != "" {
+				s += "@" + h.Capsule
+			}
+			hs = append(hs, s)
+		}
+		if err := c.Write([]string{string(p.PortalID), p.Name, itoa(p.Required), itoa(p.OnHand), itoa(p.Defensive), itoa(p.Shortfall), strings.Join(hs, ";")}); err != nil {
+			return err
+		}
+	}
+	c.Flush()
+	return c.Error()
+}
+
+// AgentCSV writes the report one row per agent and portal
+func (r KeyReport) AgentCSV(w io.Writer) error {
+	c := csv.NewWriter(w)
+	if err := c.Write([]string{"gid", "portalId", "name", "required", "held", "shortfall", "capsule"}); err != nil {
+		return err
+	}
+	for _, a := range r.Agents {
+		for _, p := range a.Portals {
+			if err := c.Write([]string{string(a.Gid), string(p.PortalID), p.Name, itoa(p.Required), itoa(p.Held), itoa(p.Shortfall), p.Capsule}); err != nil {
+				return err
+			}
+		}
+	}
+	c.Flush()
+	return c.Error()
+}
+
+func itoa(i int32) string {
+	return strconv.FormatInt(int64(i), 10)
+}
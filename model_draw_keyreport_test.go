@@ -0,0 +1,92 @@
+package wasabee_test
+
+import (
+	"bytes"
+	"github.com/wasabee-project/Wasabee-Server"
+	"strings"
+	"testing"
+)
+
+func TestKeyReport(t *testing.T) {
+	op := wasabee.Operation{
+		ID: "keyreport",
+		OpPortals: []wasabee.Portal{
+			{ID: "A", Name: "Anchor A", Lat: "0", Lon: "0"},
+			{ID: "B", Name: "Anchor B", Lat: "0", Lon: "1"},
+			{ID: "C", Name: "Origin", Lat: "1", Lon: "0"},
+		},
+		Links: []wasabee.Link{
+			{ID: "CA", From: "C", To: "A", AssignedTo: "agent1"},
+			{ID: "CB", From: "C", To: "B", AssignedTo: "agent1"},
+			{ID: "BA", From: "B", To: "A", AssignedTo: "agent2"},
+			{ID: "XA", From: "C", To: "A"},
+		},
+		Keys: []wasabee.KeyOnHand{
+			{ID: "A", Gid: "agent1", Onhand: 1, Capsule: "cap1"},
+			{ID: "A", Gid: "agent3", Onhand: 1},
+			{ID: "C", Gid: "agent2", Onhand: 5},
+		},
+	}
+
+	r, err := op.KeyReport(gid, false)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if r.Required != 4 || r.Unassigned != 1 || r.Shortfall != 2 || len(r.Portals) != 2 {
+		t.Errorf("unexpected report: %v", r)
+	}
+	for _, p := range r.Portals {
+		switch p.PortalID {
+		case "A":
+			if p.Required != 3 || p.OnHand != 2 || p.Shortfall != 1 || len(p.Holders) != 2 || p.Holders[0].Capsule != "cap1" {
+				t.Errorf("unexpected portal: %v", p)
+			}
+		case "B":
+			if p.Required != 1 || p.Shortfall != 1 {
+				t.Errorf("unexpected portal: %v", p)
+			}
+		default:
+			t.Errorf("portal %s needs no keys", p.PortalID)
+		}
+	}
+	// agent3's key does not help agent2
+	if len(r.Agents) != 2 || r.Agents[0].Gid != "agent1" || r.Agents[0].Shortfall != 1 || r.Agents[1].Shortfall != 1 {
+		t.Errorf("unexpected agents: %v", r.Agents)
+	}
+
+	var b bytes.Buffer
+	if err := r.PortalCSV(&b); err != nil {
+		t.Error(err.Error())
+	}
+	if lines := strings.Split(strings.TrimSpace(b.String()), "\n"); len(lines) != 3 || !strings.Contains(lines[1], "agent1:1@cap1;agent3:1") {
+		t.Errorf("unexpected csv: %s", b.String())
+	}
+	b.Reset()
+	if err := r.AgentCSV(&b); err != nil {
+		t.Error(err.Error())
+	}
+	if lines := strings.Split(strings.TrimSpace(b.String()), "\n"); len(lines) != 4 {
+		t.Errorf("unexpected csv: %s", b.String())
+	}
+
+	// defensive keys count when asked for
+	teamID, err := gid.NewTeam("key report")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := gid.InsertDefensiveKey("B", "dcap", 2); err != nil {
+		t.Error(err.Error())
+	}
+	if r, err = op.KeyReport(gid, true); err != nil {
+		t.Error(err.Error())
+	}
+	if r.Shortfall != 1 {
+		t.Errorf("defensive keys not counted: %v", r.Portals)
+	}
+	if err := gid.InsertDefensiveKey("B", "", 0); err != nil {
+		t.Error(err.Error())
+	}
+	if err := teamID.Delete(); err != nil {
+		t.Error(err.Error())
+	}
+}
@@ -1,7 +1,7 @@
 package wasabee
 
 import (
-	"database/sql"
+	"fmt"
 )
 
 // KeyOnHand describes the already in possession for the op
@@ -12,42 +12,32 @@ type KeyOnHand struct {
 	Capsule string   `json:"capsule"`
 }
 
-// insertKey adds a user keycount to the database
+// insertKey adds a user keycount to the database, the caller touches the op
 func (o *Operation) insertKey(k KeyOnHand) error {
-	_, err := db.Exec("INSERT INTO opkeys (opID, portalID, gid, onhand, capsule) VALUES (?, ?, ?, ?, ?) ON DUPLICATE KEY UPDATE onhand = ?, capsule = ?",
-		o.ID, k.ID, k.Gid, k.Onhand, MakeNullString(k.Capsule), k.Onhand, MakeNullString(k.Capsule))
+	err := store.InsertKey(o.ID, k)
 	if err != nil {
 		Log.Error(err)
 		return err
 	}
-	if err = o.Touch(); err != nil {
-		Log.Error(err)
-	}
+	o.ID.emit(Event{
+		Type:     EventPortalKeys,
+		ObjectID: k.ID.String(),
+		Gid:      k.Gid,
+		Action:   "keys",
+		Detail:   fmt.Sprintf("%d on hand", k.Onhand),
+		Data:     k,
+	})
 	return nil
 }
 
 // PopulateKeys fills in the Keys on hand list for the Operation. No authorization takes place.
 func (o *Operation) PopulateKeys() error {
-	var k KeyOnHand
-	rows, err := db.Query("SELECT portalID, gid, onhand, capsule FROM opkeys WHERE opID = ?", o.ID)
+	keys, err := store.Keys(o.ID)
 	if err != nil {
 		Log.Error(err)
 		return err
 	}
-	defer rows.Close()
-
-	var cap sql.NullString
-	for rows.Next() {
-		err := rows.Scan(&k.ID, &k.Gid, &k.Onhand, &cap)
-		if err != nil {
-			Log.Error(err)
-			continue
-		}
-		if cap.Valid {
-			k.Capsule = cap.String
-		}
-		o.Keys = append(o.Keys, k)
-	}
+	o.Keys = append(o.Keys, keys...)
 	return nil
 }
 
@@ -63,5 +53,9 @@ func (o *Operation) KeyOnHand(gid GoogleID, portalID PortalID, count int32, caps
 		Log.Error(err)
 		return err
 	}
+	// keys are not restored, so they are kept out of the revisions
+	if err := o.touch(); err != nil {
+		Log.Error(err)
+	}
 	return nil
 }
@@ -0,0 +1,260 @@
+package wasabee
+
+import (
+	"errors"
+	"fmt"
+)
+
+// KeyTransfer is one agent asking another for keys to a portal in an op
+type KeyTransfer struct {
+	ID            string      `json:"ID"`
+	OpID          OperationID `json:"opID"`
+	OperationName string      `json:"opName,omitempty"`
+	PortalID      PortalID    `json:"portalId"`
+	From          GoogleID    `json:"from,omitempty"` // the holder, empty until someone accepts an open request
+	To            GoogleID    `json:"to"`             // the agent asking
+	Count         int32       `json:"count"`
+	State         string      `json:"state"`
+	Created       string      `json:"created"`
+	Updated       string      `json:"updated"`
+}
+
+// KeyTransferRequested et al. are the states a KeyTransfer goes through
+const (
+	KeyTransferRequested = "requested"
+	KeyTransferAccepted  = "accepted"
+	KeyTransferCompleted = "completed"
+	KeyTransferCancelled = "cancelled"
+)
+
+// ErrKeyTransferState is returned when a transfer has moved on, perhaps by another agent at the same moment
+var ErrKeyTransferState = errors.New("key transfer is not in a state which allows that")
+var errKeysShort = errors.New("not enough keys on hand")
+
+// opAgent is true if the agent can see the op at all
+func (o *Operation) opAgent(gid GoogleID) bool {
+	return o.ReadAccess(gid) || o.AssignedOnlyAccess(gid)
+}
+
+// RequestKeys asks for keys to a portal, from a particular holder or, if from is empty, from anyone in the op who has enough
+func (o *Operation) RequestKeys(gid GoogleID, portalID PortalID, count int32, from GoogleID) (KeyTransfer, error) {
+	if !o.opAgent(gid) {
+		err := fmt.Errorf("permission denied: %s requesting keys in op %s", gid, o.ID)
+		Log.Error(err)
+		return KeyTransfer{}, err
+	}
+	if count < 1 {
+		err := fmt.Errorf("key count must be at least 1")
+		Log.Notice(err)
+		return KeyTransfer{}, err
+	}
+	portal, err := store.Portal(o.ID, portalID)
+	if err != nil {
+		err := fmt.Errorf("portal %s not in op %s", portalID, o.ID)
+		Log.Notice(err)
+		return KeyTransfer{}, err
+	}
+	if from == gid || (from != "" && !o.opAgent(from)) {
+		err := fmt.Errorf("%s cannot be asked for keys in op %s", from, o.ID)
+		Log.Notice(err)
+		return KeyTransfer{}, err
+	}
+
+	t := KeyTransfer{
+		ID:       GenerateName(),
+		OpID:     o.ID,
+		PortalID: portalID,
+		From:     from,
+		To:       gid,
+		Count:    count,
+		State:    KeyTransferRequested,
+	}
+	if err := store.InsertKeyTransfer(t); err != nil {
+		Log.Error(err)
+		return KeyTransfer{}, err
+	}
+
+	iname, _ := gid.IngressNameOperation(o)
+	msg := fmt.Sprintf("%s needs %d key(s) for %s in op %s (transfer %s)", iname, count, portal.Name, o.ID, t.ID)
+	if from != "" {
+		notifyKeyTransfer(from, msg)
+	} else {
+		keys, err := store.Keys(o.ID)
+		if err != nil {
+			Log.Error(err)
+		}
+		for _, k := range keys {
+			if k.ID == portalID && k.Gid != gid && k.Onhand >= count {
+				notifyKeyTransfer(k.Gid, msg)
+			}
+		}
+	}
+
+	return store.KeyTransfer(t.ID)
+}
+
+// logKeyTransferError keeps losing a race with another agent out of the error log
+func logKeyTransferError(err error) {
+	if err == ErrKeyTransferState {
+		Log.Notice(err)
+		return
+	}
+	Log.Error(err)
+}
+
+// notifyKeyTransfer tells an agent about a transfer; failing to do so does not stop the transfer
+func notifyKeyTransfer(gid GoogleID, msg string) {
+	if _, err := gid.SendMessage(msg); err != nil {
+		Log.Notice(err)
+	}
+}
+
+// KeyTransfers lists the op's transfers
+func (o *Operation) KeyTransfers(gid GoogleID) ([]KeyTransfer, error) {
+	if !o.opAgent(gid) {
+		err := fmt.Errorf("permission denied: %s listing key transfers in op %s", gid, o.ID)
+		Log.Error(err)
+		return nil, err
+	}
+	transfers, err := store.OpKeyTransfers(o.ID)
+	if err != nil {
+		Log.Error(err)
+		return nil, err
+	}
+	return transfers, nil
+}
+
+// AcceptKeyTransfer agrees to hand over the keys; the agent must have enough on hand
+func (gid GoogleID) AcceptKeyTransfer(transferID string) (KeyTransfer, error) {
+	t, err := store.KeyTransfer(transferID)
+	if err != nil {
+		Log.Notice(err)
+		return t, err
+	}
+	o := Operation{ID: t.OpID}
+	if t.State != KeyTransferRequested || t.To == gid || (t.From != "" && t.From != gid) || !o.opAgent(gid) {
+		err := fmt.Errorf("%s cannot accept key transfer %s", gid, transferID)
+		Log.Notice(err)
+		return t, err
+	}
+
+	keys, err := store.Keys(t.OpID)
+	if err != nil {
+		Log.Error(err)
+		return t, err
+	}
+	var onhand int32
+	for _, k := range keys {
+		if k.ID == t.PortalID && k.Gid == gid {
+			onhand = k.Onhand
+		}
+	}
+	if onhand < t.Count {
+		Log.Notice(errKeysShort)
+		return t, errKeysShort
+	}
+
+	if err := store.SetKeyTransferState(transferID, KeyTransferRequested, gid, KeyTransferAccepted); err != nil {
+		logKeyTransferError(err)
+		return t, err
+	}
+
+	iname, _ := gid.IngressNameOperation(&o)
+	notifyKeyTransfer(t.To, fmt.Sprintf("%s will give you %d key(s) for op %s (transfer %s)", iname, t.Count, t.OpID, t.ID))
+	return store.KeyTransfer(transferID)
+}
+
+// CompleteKeyTransfer records that the keys have changed hands, moving them from the holder's keys on hand to the requester's
+func (gid GoogleID) CompleteKeyTransfer(transferID string) (KeyTransfer, error) {
+	t, err := store.KeyTransfer(transferID)
+	if err != nil {
+		Log.Notice(err)
+		return t, err
+	}
+	if gid != t.From && gid != t.To {
+		err := fmt.Errorf("%s is not part of key transfer %s", gid, transferID)
+		Log.Notice(err)
+		return t, err
+	}
+	if err := store.CompleteKeyTransfer(transferID); err != nil {
+		Log.Notice(err)
+		return t, err
+	}
+
+	o := Operation{ID: t.OpID}
+	for _, g := range []GoogleID{t.From, t.To} {
+		o.ID.emit(Event{
+			Type:     EventPortalKeys,
+			ObjectID: t.PortalID.String(),
+			Gid:      g,
+			Action:   "keys",
+			Detail:   fmt.Sprintf("transfer %s: %d from %s to %s", t.ID, t.Count, t.From, t.To),
+			Data:     t,
+		})
+	}
+	if err := o.touch(); err != nil {
+		Log.Error(err)
+	}
+
+	other := t.From
+	if gid == t.From {
+		other = t.To
+	}
+	iname, _ := gid.IngressNameOperation(&o)
+	notifyKeyTransfer(other, fmt.Sprintf("%s marked the transfer of %d key(s) for op %s done (transfer %s)", iname, t.Count, t.OpID, t.ID))
+	return store.KeyTransfer(transferID)
+}
+
+// CancelKeyTransfer withdraws a request, or backs out of one the agent accepted
+func (gid GoogleID) CancelKeyTransfer(transferID string) (KeyTransfer, error) {
+	t, err := store.KeyTransfer(transferID)
+	if err != nil {
+		Log.Notice(err)
+		return t, err
+	}
+	if t.State != KeyTransferRequested && t.State != KeyTransferAccepted {
+		Log.Notice(ErrKeyTransferState)
+		return t, ErrKeyTransferState
+	}
+
+	switch gid {
+	case t.To:
+		if err := store.SetKeyTransferState(transferID, t.State, t.From, KeyTransferCancelled); err != nil {
+			logKeyTransferError(err)
+			return t, err
+		}
+		if t.From != "" {
+			notifyKeyTransfer(t.From, fmt.Sprintf("key transfer %s for op %s was cancelled", t.ID, t.OpID))
+		}
+	case t.From:
+		// the request goes back to being open
+		if err := store.SetKeyTransferState(transferID, t.State, "", KeyTransferRequested); err != nil {
+			logKeyTransferError(err)
+			return t, err
+		}
+		notifyKeyTransfer(t.To, fmt.Sprintf("%s cannot give you the keys after all (transfer %s, op %s)", gid, t.ID, t.OpID))
+	default:
+		err := fmt.Errorf("%s is not part of key transfer %s", gid, transferID)
+		Log.Notice(err)
+		return t, err
+	}
+	return store.KeyTransfer(transferID)
+}
+
+func (gid GoogleID) adKeyTransfers(ud *AgentData) error {
+	transfers, err := store.AgentKeyTransfers(gid)
+	if err != nil {
+		Log.Error(err)
+		return err
+	}
+	ud.KeyTransfers = append(ud.KeyTransfers, transfers...)
+
+	seen := make(map[OperationID]bool)
+	for _, t := range transfers {
+		if !seen[t.OpID] {
+			seen[t.OpID] = true
+			ud.Assignments = append(ud.Assignments, Assignment{OpID: t.OpID, OperationName: t.OperationName, Type: "KeyTransfer"})
+		}
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+package wasabee_test
+
+import (
+	"encoding/json"
+	"github.com/wasabee-project/Wasabee-Server"
+	"io/ioutil"
+	"testing"
+)
+
+func TestKeyTransfer(t *testing.T) {
+	content, err := ioutil.ReadFile("testdata/test2.json")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := wasabee.DrawInsert(json.RawMessage(content), gid); err != nil {
+		t.Fatal(err.Error())
+	}
+	var in wasabee.Operation
+	if err := json.Unmarshal(content, &in); err != nil {
+		t.Fatal(err.Error())
+	}
+	op := wasabee.Operation{ID: in.ID}
+	defer op.Delete(gid)
+
+	// a second agent who can read the op
+	ngid := wasabee.GoogleID("104743827901423568949")
+	if _, err := ngid.InitAgent(); err != nil {
+		t.Fatal(err.Error())
+	}
+	defer ngid.Delete()
+	teamID, err := gid.NewTeam("key transfer")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer teamID.Delete()
+	if err := teamID.AddAgent(ngid); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := ngid.SetTeamState(teamID, "On"); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := op.AddPerm(gid, teamID, "read"); err != nil {
+		t.Fatal(err.Error())
+	}
+	op = wasabee.Operation{ID: in.ID}
+
+	portal := in.OpPortals[0].ID
+	if err := op.KeyOnHand(gid, portal, 3, ""); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if _, err := op.RequestKeys(ngid, portal, 0, gid); err == nil {
+		t.Error("requested no keys")
+	}
+	if _, err := op.RequestKeys(gid, portal, 1, gid); err == nil {
+		t.Error("requested keys from self")
+	}
+	if _, err := op.RequestKeys(wasabee.GoogleID("nobody"), portal, 1, gid); err == nil {
+		t.Error("outsider requested keys")
+	}
+
+	// more than the holder has
+	big, err := op.RequestKeys(ngid, portal, 5, "")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if _, err := gid.AcceptKeyTransfer(big.ID); err == nil {
+		t.Error("accepted a transfer without the keys")
+	}
+	if c, err := ngid.CancelKeyTransfer(big.ID); err != nil || c.State != wasabee.KeyTransferCancelled {
+		t.Errorf("cancel failed: %v %v", c, err)
+	}
+
+	tr, err := op.RequestKeys(ngid, portal, 2, "")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if _, err := ngid.CompleteKeyTransfer(tr.ID); err == nil {
+		t.Error("completed a transfer nobody accepted")
+	}
+
+	var ad wasabee.AgentData
+	if err := ngid.GetAgentData(&ad); err != nil {
+		t.Error(err.Error())
+	}
+	found := false
+	for _, k := range ad.KeyTransfers {
+		found = found || k.ID == tr.ID
+	}
+	if !found {
+		t.Errorf("pending transfer not in /me: %v", ad.KeyTransfers)
+	}
+
+	if a, err := gid.AcceptKeyTransfer(tr.ID); err != nil || a.From != gid || a.State != wasabee.KeyTransferAccepted {
+		t.Errorf("accept failed: %v %v", a, err)
+	}
+	if c, err := ngid.CompleteKeyTransfer(tr.ID); err != nil || c.State != wasabee.KeyTransferCompleted {
+		t.Errorf("complete failed: %v %v", c, err)
+	}
+	if _, err := ngid.CancelKeyTransfer(tr.ID); err == nil {
+		t.Error("cancelled a completed transfer")
+	}
+
+	// accepted twice at the same moment: only one of them wins
+	race, err := op.RequestKeys(ngid, portal, 1, "")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	errs := make(chan error, 2)
+	go func() {
+		_, err := gid.AcceptKeyTransfer(race.ID)
+		errs <- err
+	}()
+	go func() {
+		_, err := gid.AcceptKeyTransfer(race.ID)
+		errs <- err
+	}()
+	failed := 0
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err != nil {
+			failed++
+		}
+	}
+	if failed != 1 {
+		t.Errorf("%d of two accepts failed", failed)
+	}
+	if _, err := ngid.CancelKeyTransfer(race.ID); err != nil {
+		t.Error(err.Error())
+	}
+
+	check := wasabee.Operation{ID: in.ID}
+	if err := check.Populate(gid); err != nil {
+		t.Fatal(err.Error())
+	}
+	onhand := make(map[wasabee.GoogleID]int32)
+	for _, k := range check.Keys {
+		if k.ID == portal {
+			onhand[k.Gid] = k.Onhand
+		}
+	}
+	if onhand[gid] != 1 || onhand[ngid] != 2 {
+		t.Errorf("unexpected keys on hand after transfer: %v", onhand)
+	}
+
+	transfers, err := op.KeyTransfers(gid)
+	if err != nil || len(transfers) != 3 {
+		t.Errorf("unexpected transfers: %v %v", transfers, err)
+	}
+}
@@ -1,7 +1,6 @@
 package wasabee
 
 import (
-	"database/sql"
 	"math"
 	"strconv"
 	"strings"
@@ -21,6 +20,7 @@ type Link struct {
 	ThrowOrder int32    `json:"throwOrderPos"`
 	Completed  bool     `json:"completed"`
 	Color      string   `json:"color"`
+	Phase      PhaseID  `json:"phase,omitempty"`
 }
 
 // insertLink adds a link to the database
@@ -32,35 +32,7 @@ func (opID OperationID) insertLink(l Link) error {
 
 	l.Color = OpValidColor(l.Color)
 
-	_, err := db.Exec("INSERT INTO link (ID, fromPortalID, toPortalID, opID, description, gid, throworder, completed, color) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)",
-		l.ID, l.From, l.To, opID, MakeNullString(l.Desc), MakeNullString(l.AssignedTo), l.ThrowOrder, l.Completed, l.Color)
-	if err != nil {
-		Log.Error(err)
-		return err
-	}
-	return nil
-}
-
-func (opID OperationID) deleteLink(lid LinkID) error {
-	_, err := db.Exec("DELETE FROM link WHERE OpID = ? and ID = ?", opID, lid)
-	if err != nil {
-		Log.Error(err)
-		return err
-	}
-	return nil
-}
-
-func (opID OperationID) updateLink(l Link) error {
-	if l.To == l.From {
-		Log.Debug("source and destination the same, ignoring link")
-		return nil
-	}
-
-	l.Color = OpValidColor(l.Color)
-
-	_, err := db.Exec("INSERT INTO link (ID, fromPortalID, toPortalID, opID, description, gid, throworder, completed, color) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?) ON DUPLICATE KEY UPDATE fromPortalID = ?, toPortalID = ?, description = ?, color=?",
-		l.ID, l.From, l.To, opID, MakeNullString(l.Desc), MakeNullString(l.AssignedTo), l.ThrowOrder, l.Completed, l.Color,
-		l.From, l.To, MakeNullString(l.Desc), l.Color)
+	err := store.InsertLink(opID, l)
 	if err != nil {
 		Log.Error(err)
 		return err
@@ -70,38 +42,12 @@ func (opID OperationID) updateLink(l Link) error {
 
 // PopulateLinks fills in the Links list for the Operation. No authorization takes place.
 func (o *Operation) PopulateLinks() error {
-	var tmpLink Link
-	var description, gid, iname sql.NullString
-
-	rows, err := db.Query("SELECT l.ID, l.fromPortalID, l.toPortalID, l.description, l.gid, l.throworder, l.completed, a.iname, l.color FROM link=l LEFT JOIN agent=a ON l.gid=a.gid WHERE l.opID = ? ORDER BY l.throworder", o.ID)
+	links, err := store.Links(o.ID)
 	if err != nil {
 		Log.Error(err)
 		return err
 	}
-	defer rows.Close()
-	for rows.Next() {
-		err := rows.Scan(&tmpLink.ID, &tmpLink.From, &tmpLink.To, &description, &gid, &tmpLink.ThrowOrder, &tmpLink.Completed, &iname, &tmpLink.Color)
-		if err != nil {
-			Log.Error(err)
-			continue
-		}
-		if description.Valid {
-			tmpLink.Desc = description.String
-		} else {
-			tmpLink.Desc = ""
-		}
-		if gid.Valid {
-			tmpLink.AssignedTo = GoogleID(gid.String)
-		} else {
-			tmpLink.AssignedTo = ""
-		}
-		if iname.Valid {
-			tmpLink.Iname = iname.String
-		} else {
-			tmpLink.Iname = ""
-		}
-		o.Links = append(o.Links, tmpLink)
-	}
+	o.Links = append(o.Links, links...)
 	return nil
 }
 
@@ -111,12 +57,24 @@ func (l LinkID) String() string {
 }
 
 // AssignLink assigns a link to an agent, sending them a message that they have an assignment
-func (o *Operation) AssignLink(linkID LinkID, gid GoogleID) error {
+// by is the agent making the assignment
+func (o *Operation) AssignLink(linkID LinkID, gid GoogleID, by GoogleID) error {
+	if err := o.assignLink(linkID, gid, by); err != nil {
+		return err
+	}
+	if err := o.Touch(by, "assign link "+linkID.String()); err != nil {
+		Log.Error(err)
+	}
+	return nil
+}
+
+// assignLink sets the assignment without touching the op, for callers making many changes at once
+func (o *Operation) assignLink(linkID LinkID, gid GoogleID, by GoogleID) error {
 	if gid == "0" {
 		gid = ""
 	}
 
-	_, err := db.Exec("UPDATE link SET gid = ? WHERE ID = ? AND opID = ?", MakeNullString(gid), linkID, o.ID)
+	err := store.SetLinkAssignment(o.ID, linkID, gid)
 	if err != nil {
 		Log.Error(err)
 		return err
@@ -146,118 +104,150 @@ func (o *Operation) AssignLink(linkID LinkID, gid GoogleID) error {
 		}
 	*/
 
-	if gid.String() != "" {
-		o.ID.firebaseAssignLink(gid, linkID)
-	}
-	if err = o.Touch(); err != nil {
-		Log.Error(err)
-	}
-
+	o.linkAssigned(linkID, gid, by)
 	return nil
 }
 
+// linkAssigned announces a link's new assignment
+func (o *Operation) linkAssigned(linkID LinkID, gid GoogleID, by GoogleID) {
+	o.ID.emit(Event{
+		Type:     EventLinkAssignment,
+		ObjectID: linkID.String(),
+		Gid:      by,
+		Agent:    gid,
+		Action:   "assign",
+		Detail:   gid.String(),
+		Data:     map[string]string{"assignedTo": gid.String()},
+	})
+}
+
 // LinkDescription updates the description for a link
-func (o *Operation) LinkDescription(linkID LinkID, desc string) error {
-	_, err := db.Exec("UPDATE link SET description = ? WHERE ID = ? AND opID = ?", MakeNullString(desc), linkID, o.ID)
+func (o *Operation) LinkDescription(linkID LinkID, desc string, gid GoogleID) error {
+	err := store.SetLinkDescription(o.ID, linkID, desc)
 	if err != nil {
 		Log.Error(err)
 		return err
 	}
-	if err = o.Touch(); err != nil {
+	o.ID.emit(Event{
+		Type:     EventLinkDescription,
+		ObjectID: linkID.String(),
+		Gid:      gid,
+		Action:   "description",
+		Detail:   desc,
+		Data:     map[string]string{"description": desc},
+	})
+	if err = o.Touch(gid, "link description "+linkID.String()); err != nil {
 		Log.Error(err)
 	}
 	return nil
 }
 
 // LinkCompleted updates the completed flag for a link
-func (o *Operation) LinkCompleted(linkID LinkID, completed bool) error {
-	_, err := db.Exec("UPDATE link SET completed = ? WHERE ID = ? AND opID = ?", completed, linkID, o.ID)
+func (o *Operation) LinkCompleted(linkID LinkID, completed bool, gid GoogleID) error {
+	err := store.SetLinkCompleted(o.ID, linkID, completed)
 	if err != nil {
 		Log.Error(err)
 		return err
 	}
-	if err = o.Touch(); err != nil {
+	action := "incomplete"
+	if completed {
+		action = "complete"
+	}
+	o.ID.emit(Event{
+		Type:     EventLinkStatus,
+		ObjectID: linkID.String(),
+		Gid:      gid,
+		Action:   action,
+		Data:     map[string]bool{"completed": completed},
+	})
+	// for the wave report
+	if err := store.SetWaveLinkCompleted(o.ID, linkID, completed); err != nil {
+		Log.Error(err)
+	}
+	if err = o.Touch(gid, "link completed "+linkID.String()); err != nil {
 		Log.Error(err)
 	}
 
-	o.firebaseLinkStatus(linkID, completed)
 	return nil
 }
 
 // AssignedTo checks to see if a link is assigned to a particular agent
 func (opID OperationID) AssignedTo(link LinkID, gid GoogleID) bool {
-	var x int
-
-	err := db.QueryRow("SELECT COUNT(*) FROM link WHERE opID = ? AND ID = ? AND gid = ?", opID, link, gid).Scan(&x)
+	assigned, err := store.LinkAssignedTo(opID, link, gid)
 	if err != nil {
 		Log.Error(err)
 		return false
 	}
-	if x != 1 {
-		return false
-	}
-	return true
+	return assigned
 }
 
 // LinkOrder changes the order of the throws for an operation
 func (o *Operation) LinkOrder(order string, gid GoogleID) error {
 	// check isowner (already done in http/pdraw.go, but there may be other callers in the future
 
-	stmt, err := db.Prepare("UPDATE link SET throworder = ? WHERE opID = ? AND ID = ?")
-	if err != nil {
-		Log.Error(err)
-		return err
-	}
-
-	pos := 1
+	var err error
+	var pos int32 = 1
 	links := strings.Split(order, ",")
 	for i := range links {
 		if links[i] == "000" { // the header, could be anyplace in the order if the user was being silly
 			continue
 		}
-		if _, err := stmt.Exec(pos, o.ID, links[i]); err != nil {
+		if err := store.SetLinkThrowOrder(o.ID, LinkID(links[i]), pos); err != nil {
 			Log.Error(err)
 			continue
 		}
 		pos++
 	}
-	if err = o.Touch(); err != nil {
+	o.ID.emit(Event{
+		Type:   EventLinkOrder,
+		Gid:    gid,
+		Action: "order",
+		Detail: order,
+		Data:   map[string]string{"order": order},
+	})
+	if err = o.Touch(gid, "link order"); err != nil {
 		Log.Error(err)
 	}
 	return nil
 }
 
 // LinkColor changes the color of a link in an operation
-func (o *Operation) LinkColor(link LinkID, color string) error {
+func (o *Operation) LinkColor(link LinkID, color string, gid GoogleID) error {
 	checked := OpValidColor(color)
 
-	_, err := db.Exec("UPDATE link SET color = ? WHERE ID = ? and opID = ?", checked, link, o.ID)
+	err := store.SetLinkColor(o.ID, link, checked)
 	if err != nil {
 		Log.Error(err)
 		return err
 	}
-	if err = o.Touch(); err != nil {
+	o.ID.emit(Event{
+		Type:     EventLinkColor,
+		ObjectID: link.String(),
+		Gid:      gid,
+		Action:   "color",
+		Detail:   checked,
+		Data:     map[string]string{"color": checked},
+	})
+	if err = o.Touch(gid, "link color "+link.String()); err != nil {
 		Log.Error(err)
 	}
 	return nil
 }
 
 // LinkSwap changes the direction of a link in an operation
-func (o *Operation) LinkSwap(link LinkID) error {
-	var tmpLink Link
-
-	err := db.QueryRow("SELECT fromPortalID, toPortalID FROM link WHERE opID = ? AND ID = ?", o.ID, link).Scan(&tmpLink.From, &tmpLink.To)
-	if err != nil {
-		Log.Error(err)
-		return err
-	}
-
-	_, err = db.Exec("UPDATE link SET fromPortalID = ?, toPortalID = ? WHERE ID = ? and opID = ?", tmpLink.To, tmpLink.From, link, o.ID)
+func (o *Operation) LinkSwap(link LinkID, gid GoogleID) error {
+	err := store.SwapLink(o.ID, link)
 	if err != nil {
 		Log.Error(err)
 		return err
 	}
-	if err = o.Touch(); err != nil {
+	o.ID.emit(Event{
+		Type:     EventLinkSwap,
+		ObjectID: link.String(),
+		Gid:      gid,
+		Action:   "swap",
+	})
+	if err = o.Touch(gid, "swap link "+link.String()); err != nil {
 		Log.Error(err)
 	}
 	return nil
@@ -4,6 +4,7 @@ import (
 	"database/sql"
 	"fmt"
 	"strings"
+	"time"
 )
 
 // MarkerID wrapper to ensure type safety
@@ -23,6 +24,7 @@ type Marker struct {
 	CompletedBy string     `json:"completedBy"`
 	State       string     `json:"state"`
 	Order       int        `json:"order"`
+	Phase       PhaseID    `json:"phase,omitempty"`
 }
 
 // insertMarkers adds a marker to the database
@@ -31,31 +33,7 @@ func (opID OperationID) insertMarker(m Marker) error {
 		m.State = "pending"
 	}
 
-	_, err := db.Exec("INSERT INTO marker (ID, opID, PortalID, type, gid, comment, state, oporder) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
-		m.ID, opID, m.PortalID, m.Type, MakeNullString(m.AssignedTo), MakeNullString(m.Comment), m.State, m.Order)
-	if err != nil {
-		Log.Error(err)
-		return err
-	}
-	return nil
-}
-
-func (opID OperationID) updateMarker(m Marker) error {
-	if m.State == "" {
-		m.State = "pending"
-	}
-
-	_, err := db.Exec("INSERT INTO marker (ID, opID, PortalID, type, gid, comment, state, oporder) VALUES (?, ?, ?, ?, ?, ?, ?, ?) ON DUPLICATE KEY UPDATE type = ?, PortalID = ?, comment = ?",
-		m.ID, opID, m.PortalID, m.Type, MakeNullString(m.AssignedTo), MakeNullString(m.Comment), m.State, m.Order, m.Type, m.PortalID, MakeNullString(m.Comment))
-	if err != nil {
-		Log.Error(err)
-		return err
-	}
-	return nil
-}
-
-func (opID OperationID) deleteMarker(mid MarkerID) error {
-	_, err := db.Exec("DELETE FROM marker WHERE opID = ? and ID = ?", opID, mid)
+	err := store.InsertMarker(opID, m)
 	if err != nil {
 		Log.Error(err)
 		return err
@@ -65,46 +43,16 @@ func (opID OperationID) deleteMarker(mid MarkerID) error {
 
 // PopulateMarkers fills in the Markers list for the Operation. No authorization takes place.
 func (o *Operation) PopulateMarkers() error {
-	var tmpMarker Marker
-	var assignedGid, comment, assignedNick, completedBy sql.NullString
-
-	// XXX join with portals table, get name and order by name, don't expose it in this json -- will make the friendly in the https module easier
-	rows, err := db.Query("SELECT m.ID, m.PortalID, m.type, m.gid, m.comment, m.state, a.iname AS assignedTo, b.iname AS completedBy, m.oporder FROM marker=m LEFT JOIN agent=a ON m.gid = a.gid LEFT JOIN agent=b on m.completedby = b.gid WHERE m.opID = ? ORDER BY m.oporder, m.type", o.ID)
+	markers, err := store.Markers(o.ID)
 	if err != nil {
 		Log.Error(err)
 		return err
 	}
-	defer rows.Close()
-	for rows.Next() {
-		err := rows.Scan(&tmpMarker.ID, &tmpMarker.PortalID, &tmpMarker.Type, &assignedGid, &comment, &tmpMarker.State, &assignedNick, &completedBy, &tmpMarker.Order)
-		if err != nil {
-			Log.Error(err)
-			continue
-		}
-		if tmpMarker.State == "" { // enums in sql default to "" if invalid, WTF?
-			tmpMarker.State = "pending"
-		}
-		if assignedGid.Valid {
-			tmpMarker.AssignedTo = GoogleID(assignedGid.String)
-		} else {
-			tmpMarker.AssignedTo = ""
+	for _, m := range markers {
+		if m.State == "" { // enums in sql default to "" if invalid, WTF?
+			m.State = "pending"
 		}
-		if assignedNick.Valid {
-			tmpMarker.IngressName = assignedNick.String
-		} else {
-			tmpMarker.IngressName = ""
-		}
-		if comment.Valid {
-			tmpMarker.Comment = comment.String
-		} else {
-			tmpMarker.Comment = ""
-		}
-		if completedBy.Valid {
-			tmpMarker.CompletedBy = completedBy.String
-		} else {
-			tmpMarker.CompletedBy = ""
-		}
-		o.Markers = append(o.Markers, tmpMarker)
+		o.Markers = append(o.Markers, m)
 	}
 	return nil
 }
@@ -120,51 +68,58 @@ func (m MarkerID) String() string {
 }
 
 // AssignMarker assigns a marker to an agent, sending them a message
-func (o *Operation) AssignMarker(markerID MarkerID, gid GoogleID) error {
-	_, err := db.Exec("UPDATE marker SET gid = ?, state = ? WHERE ID = ? AND opID = ?", MakeNullString(gid), "assigned", markerID, o.ID)
-	if err != nil {
-		Log.Error(err)
+// by is the agent making the assignment
+func (o *Operation) AssignMarker(markerID MarkerID, gid GoogleID, by GoogleID) error {
+	if err := o.assignMarker(markerID, gid, by); err != nil {
 		return err
 	}
-
-	if gid.String() != "" {
-		o.ID.firebaseAssignMarker(gid, markerID)
-
-		marker := struct {
-			OpID     OperationID
-			MarkerID MarkerID
-		}{
-			OpID:     o.ID,
-			MarkerID: markerID,
-		}
-
-		msg, err := gid.ExecuteTemplate("assignMarker", marker)
-		if err != nil {
-			Log.Error(err)
-			msg = fmt.Sprintf("assigned a marker for op %s", o.ID)
-			// do not report send errors up the chain, just log
-		}
-		_, err = gid.SendMessage(msg)
-		if err != nil {
-			Log.Errorf("%s %s %s", gid, err, msg)
-			// do not report send errors up the chain, just log
-		}
+	if err := o.Touch(by, "assign marker "+markerID.String()); err != nil {
+		Log.Error(err)
 	}
+	return nil
+}
 
-	if err = o.Touch(); err != nil {
+// assignMarker sets the assignment without touching the op, for callers making many changes at once
+func (o *Operation) assignMarker(markerID MarkerID, gid GoogleID, by GoogleID) error {
+	err := store.SetMarkerAssignment(o.ID, markerID, gid, "assigned")
+	if err != nil {
 		Log.Error(err)
+		return err
 	}
+
+	o.markerAssigned(markerID, gid, by)
 	return nil
 }
 
+// markerAssigned announces a marker's new assignment
+func (o *Operation) markerAssigned(markerID MarkerID, gid GoogleID, by GoogleID) {
+	o.ID.emit(Event{
+		Type:     EventMarkerAssignment,
+		ObjectID: markerID.String(),
+		Gid:      by,
+		Agent:    gid,
+		Action:   "assign",
+		Detail:   gid.String(),
+		Data:     map[string]string{"assignedTo": gid.String(), "state": "assigned"},
+	})
+}
+
 // MarkerComment updates the comment on a marker
-func (o *Operation) MarkerComment(markerID MarkerID, comment string) error {
-	_, err := db.Exec("UPDATE marker SET comment = ? WHERE ID = ? AND opID = ?", MakeNullString(comment), markerID, o.ID)
+func (o *Operation) MarkerComment(markerID MarkerID, comment string, gid GoogleID) error {
+	err := store.SetMarkerComment(o.ID, markerID, comment)
 	if err != nil {
 		Log.Error(err)
 		return err
 	}
-	if err = o.Touch(); err != nil {
+	o.ID.emit(Event{
+		Type:     EventMarkerComment,
+		ObjectID: markerID.String(),
+		Gid:      gid,
+		Action:   "comment",
+		Detail:   comment,
+		Data:     map[string]string{"comment": comment},
+	})
+	if err = o.Touch(gid, "marker comment "+markerID.String()); err != nil {
 		Log.Error(err)
 	}
 	return nil
@@ -173,8 +128,7 @@ func (o *Operation) MarkerComment(markerID MarkerID, comment string) error {
 // Acknowledge that a marker has been assigned
 // gid must be the assigned agent.
 func (m MarkerID) Acknowledge(o *Operation, gid GoogleID) error {
-	var ns sql.NullString
-	err := db.QueryRow("SELECT gid FROM marker WHERE ID = ? and opID = ?", m, o.ID).Scan(&ns)
+	markerGid, err := store.MarkerAssignee(o.ID, m)
 	if err != nil && err != sql.ErrNoRows {
 		Log.Notice(err)
 		return err
@@ -184,27 +138,32 @@ func (m MarkerID) Acknowledge(o *Operation, gid GoogleID) error {
 		Log.Error(err)
 		return err
 	}
-	if !ns.Valid {
+	if markerGid == "" {
 		err = fmt.Errorf("marker not assigned")
 		Log.Error(err)
 		return err
 	}
-	markerGid := GoogleID(ns.String)
 	if gid != markerGid {
 		err = fmt.Errorf("marker assigned to someone else")
 		Log.Error(err)
 		return err
 	}
-	_, err = db.Exec("UPDATE marker SET state = ? WHERE ID = ? AND opID = ?", "acknowledged", m, o.ID)
+	err = store.SetMarkerState(o.ID, m, "acknowledged")
 	if err != nil {
 		Log.Error(err)
 		return err
 	}
-	if err = o.Touch(); err != nil {
+	o.ID.emit(Event{
+		Type:     EventMarkerStatus,
+		ObjectID: m.String(),
+		Gid:      gid,
+		Action:   "acknowledge",
+		Data:     map[string]string{"state": "acknowledged"},
+	})
+	if err = o.Touch(gid, "acknowledge marker "+m.String()); err != nil {
 		Log.Error(err)
 	}
 
-	o.firebaseMarkerStatus(m, "acknowledged")
 	return nil
 }
 
@@ -215,16 +174,22 @@ func (m MarkerID) Complete(o Operation, gid GoogleID) error {
 		Log.Error(err)
 		return err
 	}
-	_, err := db.Exec("UPDATE marker SET state = ?, completedby = ? WHERE ID = ? AND opID = ?", "completed", gid, m, o.ID)
+	err := store.SetMarkerCompleted(o.ID, m, gid)
 	if err != nil {
 		Log.Error(err)
 		return err
 	}
-	if err = o.Touch(); err != nil {
+	o.ID.emit(Event{
+		Type:     EventMarkerStatus,
+		ObjectID: m.String(),
+		Gid:      gid,
+		Action:   "complete",
+		Data:     map[string]string{"state": "completed", "completedBy": gid.String()},
+	})
+	if err = o.Touch(gid, "complete marker "+m.String()); err != nil {
 		Log.Error(err)
 	}
 
-	o.firebaseMarkerStatus(m, "completed")
 	return nil
 }
 
@@ -235,24 +200,29 @@ func (m MarkerID) Incomplete(o Operation, gid GoogleID) error {
 		Log.Error(err)
 		return err
 	}
-	_, err := db.Exec("UPDATE marker SET state = ?, completedby = NULL WHERE ID = ? AND opID = ?", "assigned", m, o.ID)
+	err := store.SetMarkerIncomplete(o.ID, m)
 	if err != nil {
 		Log.Error(err)
 		return err
 	}
-	if err = o.Touch(); err != nil {
+	o.ID.emit(Event{
+		Type:     EventMarkerStatus,
+		ObjectID: m.String(),
+		Gid:      gid,
+		Action:   "incomplete",
+		Data:     map[string]string{"state": "assigned"},
+	})
+	if err = o.Touch(gid, "incomplete marker "+m.String()); err != nil {
 		Log.Error(err)
 	}
 
-	o.firebaseMarkerStatus(m, "assigned")
 	return nil
 }
 
 // Reject allows an agent to refuse to take a target
 // gid must be the assigned agent.
 func (m MarkerID) Reject(o *Operation, gid GoogleID) error {
-	var ns sql.NullString
-	err := db.QueryRow("SELECT gid FROM marker WHERE ID = ? and opID = ?", m, o.ID).Scan(&ns)
+	markerGid, err := store.MarkerAssignee(o.ID, m)
 	if err != nil && err != sql.ErrNoRows {
 		Log.Notice(err)
 		return err
@@ -262,57 +232,108 @@ func (m MarkerID) Reject(o *Operation, gid GoogleID) error {
 		Log.Error(err)
 		return err
 	}
-	if !ns.Valid {
+	if markerGid == "" {
 		err = fmt.Errorf("marker not assigned")
 		Log.Error(err)
 		return err
 	}
-	markerGid := GoogleID(ns.String)
 	if gid != markerGid {
 		err = fmt.Errorf("marker assigned to someone else")
 		Log.Error(err)
 		return err
 	}
-	_, err = db.Exec("UPDATE marker SET state = 'pending', gid = NULL WHERE ID = ? AND opID = ?", m, o.ID)
+	err = store.SetMarkerAssignment(o.ID, m, "", "pending")
 	if err != nil {
 		Log.Error(err)
 		return err
 	}
-	if err = o.Touch(); err != nil {
+	o.ID.emit(Event{
+		Type:     EventMarkerStatus,
+		ObjectID: m.String(),
+		Gid:      gid,
+		Action:   "reject",
+		Data:     map[string]string{"assignedTo": "", "state": "pending"},
+	})
+	if err = o.Touch(gid, "reject marker "+m.String()); err != nil {
 		Log.Error(err)
 	}
 
-	o.firebaseMarkerStatus(m, "pending")
 	return nil
 }
 
+// PopulateAssignedOnly fills in only the agent's own links and markers and the portals they need
 func (o *Operation) PopulateAssignedOnly(gid GoogleID) error {
-	// get all marker assignments
+	needed := make(map[PortalID]bool)
 
-	return nil
-}
+	links, err := store.Links(o.ID)
+	if err != nil {
+		Log.Error(err)
+		return err
+	}
+	for _, l := range links {
+		if l.AssignedTo == gid {
+			o.Links = append(o.Links, l)
+			needed[l.From] = true
+			needed[l.To] = true
+		}
+	}
 
-// MarkerOrder changes the order of the throws for an operation
-func (o *Operation) MarkerOrder(order string, gid GoogleID) error {
-	stmt, err := db.Prepare("UPDATE marker SET oporder = ? WHERE opID = ? AND ID = ?")
+	markers, err := store.Markers(o.ID)
 	if err != nil {
 		Log.Error(err)
 		return err
 	}
+	for _, m := range markers {
+		if m.AssignedTo == gid {
+			if m.State == "" {
+				m.State = "pending"
+			}
+			o.Markers = append(o.Markers, m)
+			needed[m.PortalID] = true
+		}
+	}
 
+	portals, err := store.Portals(o.ID)
+	if err != nil {
+		Log.Error(err)
+		return err
+	}
+	for _, p := range portals {
+		if needed[p.ID] {
+			o.OpPortals = append(o.OpPortals, p)
+		}
+	}
+	if err = o.PopulatePhases(); err != nil {
+		Log.Error(err)
+		return err
+	}
+	o.Fetched = fmt.Sprint(time.Now().UTC().Format(time.RFC1123))
+	return nil
+}
+
+// MarkerOrder changes the order of the throws for an operation
+func (o *Operation) MarkerOrder(order string, gid GoogleID) error {
+	var err error
 	pos := 1
 	markers := strings.Split(order, ",")
 	for i := range markers {
 		if markers[i] == "000" { // the header, could be anyplace in the order if the user was being silly
 			continue
 		}
-		if _, err := stmt.Exec(pos, o.ID, markers[i]); err != nil {
+		if err := store.SetMarkerOrder(o.ID, MarkerID(markers[i]), pos); err != nil {
 			Log.Error(err)
 			continue
 		}
 		pos++
 	}
-	if err = o.Touch(); err != nil {
+	o.ID.emit(Event{
+		Type:   EventMarkerOrder,
+		Gid:    gid,
+		Action: "order",
+		Detail: order,
+		Data:   map[string]string{"order": order},
+	})
+	if err = o.Touch(gid, "marker order"); err != nil {
 		Log.Error(err)
 	}
 	return nil
@@ -0,0 +1,233 @@
+package wasabee
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// OpMergeError is returned by DrawUpdate when the op changed after the client fetched it and the two sets of edits overlap; nothing was changed
+type OpMergeError struct {
+	OpID      OperationID
+	Conflicts []RejectedObject
+}
+
+func (e *OpMergeError) Error() string {
+	return fmt.Sprintf("update of op %s conflicts with changes made since it was fetched: %d conflicts", e.OpID, len(e.Conflicts))
+}
+
+// mergeItem is one portal, link, marker or anchor reduced to what the merge compares
+type mergeItem struct {
+	id      string
+	content string // what DrawUpdate writes
+	status  string // assignments and progress, which only change through the per-object calls
+	obj     interface{}
+}
+
+// rebase merges the incoming op with the current one, using the revision which was current when the client fetched its copy as the base:
+// the revision of the version in pre if there is one, otherwise the one recorded before the op's fetched time.
+// It returns the merged op and the precondition for the version of the current op it was merged with.
+func (o *Operation) rebase(pre OpPrecondition) (Operation, OpPrecondition, error) {
+	var merged Operation
+
+	var r OpRevision
+	var err error
+	if pre.Version != 0 {
+		r, err = store.OpRevisionVersion(o.ID, pre.Version)
+	} else {
+		fetched, perr := time.Parse(time.RFC1123, o.Fetched)
+		if perr != nil {
+			Log.Notice(perr)
+			return merged, OpPrecondition{}, perr
+		}
+		r, err = store.OpRevisionAt(o.ID, fetched)
+	}
+	if err != nil {
+		if err == sql.ErrNoRows {
+			err = fmt.Errorf("no revision of op %s from %s", o.ID, o.Fetched)
+		}
+		Log.Notice(err)
+		return merged, OpPrecondition{}, err
+	}
+	var base Operation
+	if err := json.Unmarshal(r.Op, &base); err != nil {
+		Log.Error(err)
+		return merged, OpPrecondition{}, err
+	}
+
+	owner, err := store.OperationOwner(o.ID)
+	if err != nil {
+		Log.Error(err)
+		return merged, OpPrecondition{}, err
+	}
+	theirs := Operation{ID: o.ID}
+	if err := theirs.Populate(owner); err != nil {
+		Log.Error(err)
+		return merged, OpPrecondition{}, err
+	}
+	// the merge is only good against the version it was made from
+	current := OpPrecondition{Version: theirs.Version}
+
+	merged, conflicts := mergeOps(&base, &theirs, o)
+	if len(conflicts) > 0 {
+		return merged, current, &OpMergeError{OpID: o.ID, Conflicts: conflicts}
+	}
+	return merged, current, nil
+}
+
+// mergeOps does a three-way merge of mine and theirs, object by object.
+// Anything changed on only one side takes that side's version; anything changed differently on both sides is a conflict.
+// Assignments and status are never written by DrawUpdate, so on my side only the drawn content counts as a change,
+// but on their side a new assignment is a change, and deleting something they just assigned is a conflict.
+func mergeOps(base, theirs, mine *Operation) (Operation, []RejectedObject) {
+	var conflicts []RejectedObject
+
+	merged := *mine
+	merged.Name = mergeField("name", base.Name, theirs.Name, mine.Name, &conflicts)
+	merged.Color = mergeField("color", base.Color, theirs.Color, mine.Color, &conflicts)
+	merged.Comment = mergeField("comment", base.Comment, theirs.Comment, mine.Comment, &conflicts)
+
+	merged.OpPortals = nil
+	for _, i := range merge3("portal", portalItems(base), portalItems(theirs), portalItems(mine), &conflicts) {
+		merged.OpPortals = append(merged.OpPortals, i.(Portal))
+	}
+	merged.Links = nil
+	for _, i := range merge3("link", linkItems(base), linkItems(theirs), linkItems(mine), &conflicts) {
+		merged.Links = append(merged.Links, i.(Link))
+	}
+	merged.Markers = nil
+	for _, i := range merge3("marker", markerItems(base), markerItems(theirs), markerItems(mine), &conflicts) {
+		merged.Markers = append(merged.Markers, i.(Marker))
+	}
+	merged.Anchors = nil
+	for _, i := range merge3("anchor", anchorItems(base), anchorItems(theirs), anchorItems(mine), &conflicts) {
+		merged.Anchors = append(merged.Anchors, i.(PortalID))
+	}
+	return merged, conflicts
+}
+
+func mergeField(field, base, theirs, mine string, conflicts *[]RejectedObject) string {
+	if mine == base || mine == theirs {
+		return theirs
+	}
+	if theirs != base {
+		*conflicts = append(*conflicts, RejectedObject{Type: "operation", ID: field, Reason: "edited on both sides"})
+	}
+	return mine
+}
+
+// merge3 merges one kind of object; the result is in my order followed by anything only they added
+func merge3(kind string, base, theirs, mine []mergeItem, conflicts *[]RejectedObject) []interface{} {
+	b := mergeIndex(base)
+	t := mergeIndex(theirs)
+	m := mergeIndex(mine)
+
+	var merged []interface{}
+	conflict := func(id, reason string) {
+		*conflicts = append(*conflicts, RejectedObject{Type: kind, ID: id, Reason: reason})
+	}
+
+	for _, x := range mine {
+		bi, inBase := b[x.id]
+		ti, inTheirs := t[x.id]
+		switch {
+		case inBase && inTheirs:
+			mineChanged := x.content != bi.content
+			theirsChanged := ti.content != bi.content
+			if mineChanged && theirsChanged && x.content != ti.content {
+				conflict(x.id, "edited on both sides")
+				continue
+			}
+			if mineChanged {
+				merged = append(merged, x.obj)
+			} else {
+				merged = append(merged, ti.obj)
+			}
+		case inBase && !inTheirs:
+			if x.content != bi.content {
+				conflict(x.id, "edited here, deleted by another agent")
+			}
+			// otherwise stays deleted
+		case !inBase && inTheirs:
+			if x.content != ti.content {
+				conflict(x.id, "added on both sides")
+				continue
+			}
+			merged = append(merged, x.obj)
+		default:
+			merged = append(merged, x.obj)
+		}
+	}
+
+	for _, x := range theirs {
+		if _, ok := m[x.id]; ok {
+			continue
+		}
+		bi, inBase := b[x.id]
+		if !inBase {
+			// they added it
+			merged = append(merged, x.obj)
+			continue
+		}
+		if x.content != bi.content || x.status != bi.status {
+			conflict(x.id, "deleted here, changed by another agent")
+		}
+		// otherwise I deleted it
+	}
+	return merged
+}
+
+func mergeIndex(items []mergeItem) map[string]mergeItem {
+	index := make(map[string]mergeItem, len(items))
+	for _, i := range items {
+		index[i.id] = i
+	}
+	return index
+}
+
+func portalItems(o *Operation) []mergeItem {
+	items := make([]mergeItem, 0, len(o.OpPortals))
+	for _, p := range o.OpPortals {
+		items = append(items, mergeItem{
+			id:      string(p.ID),
+			content: fmt.Sprintf("%s|%s|%s|%s|%s", p.Name, p.Lat, p.Lon, p.Comment, p.Hardness),
+			obj:     p,
+		})
+	}
+	return items
+}
+
+func linkItems(o *Operation) []mergeItem {
+	items := make([]mergeItem, 0, len(o.Links))
+	for _, l := range o.Links {
+		items = append(items, mergeItem{
+			id:      string(l.ID),
+			content: fmt.Sprintf("%s|%s|%s|%s", l.From, l.To, l.Desc, OpValidColor(l.Color)),
+			status:  fmt.Sprintf("%s|%d|%t", l.AssignedTo, l.ThrowOrder, l.Completed),
+			obj:     l,
+		})
+	}
+	return items
+}
+
+func markerItems(o *Operation) []mergeItem {
+	items := make([]mergeItem, 0, len(o.Markers))
+	for _, m := range o.Markers {
+		items = append(items, mergeItem{
+			id:      string(m.ID),
+			content: fmt.Sprintf("%s|%s|%s", m.PortalID, m.Type, m.Comment),
+			status:  fmt.Sprintf("%s|%s|%d|%s", m.AssignedTo, m.State, m.Order, m.CompletedBy),
+			obj:     m,
+		})
+	}
+	return items
+}
+
+func anchorItems(o *Operation) []mergeItem {
+	items := make([]mergeItem, 0, len(o.Anchors))
+	for _, a := range o.Anchors {
+		items = append(items, mergeItem{id: string(a), obj: a})
+	}
+	return items
+}
@@ -6,6 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"html/template"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -24,13 +25,19 @@ type Operation struct {
 	Anchors   []PortalID     `json:"anchors"`
 	Links     []Link         `json:"links"`
 	Blockers  []Link         `json:"blockers"`
+	Phases    []Phase        `json:"phases"`
 	Markers   []Marker       `json:"markers"`
 	TeamIDdep TeamID         `json:"teamid"`
 	Teams     []ExtendedTeam `json:"teamlist"`
 	Modified  string         `json:"modified"`
+	Version   int64          `json:"version"` // goes up by one with every change, for the ETag
 	Comment   string         `json:"comment"`
 	Keys      []KeyOnHand    `json:"keysonhand"`
 	Fetched   string         `json:"fetched"`
+	// the ends of the stored blockers, which need not be in OpPortals
+	blockerEnds []Portal
+	// the blockers in an update, ready for UpdateOperation to add
+	newBlockers []Blocker
 }
 
 // OpStat is a minimal struct to determine if the op has been updated
@@ -39,6 +46,9 @@ type OpStat struct {
 	Name     string      `json:"name"`
 	Gid      GoogleID    `json:"creator"`
 	Modified string      `json:"modified"`
+	Version  int64       `json:"version"`
+	Fields   int         `json:"fields"` // set by AddFields
+	MU       int         `json:"mu"`
 }
 
 type ExtendedTeam struct {
@@ -72,13 +82,12 @@ func DrawInsert(op json.RawMessage, gid GoogleID) error {
 	}
 
 	// check to see if this opID is already in use
-	var count int
-	err := db.QueryRow("SELECT COUNT(*) FROM operation WHERE ID = ?", o.ID).Scan(&count)
+	exists, err := store.OperationExists(o.ID)
 	if err != nil {
 		Log.Error(err)
 		return err
 	}
-	if count != 0 {
+	if exists {
 		err := fmt.Errorf("attempt to POST to an existing opID; use PUT to update an existing op")
 		Log.Error(err)
 		return err
@@ -94,12 +103,16 @@ func DrawInsert(op json.RawMessage, gid GoogleID) error {
 		Log.Error(err)
 		return err
 	}
+
+	if err = o.recordRevision(gid, "upload"); err != nil {
+		Log.Error(err)
+	}
 	return nil
 }
 
 func drawOpInsertWorker(o Operation, gid GoogleID, teamID TeamID) error {
 	// start the insert process
-	_, err := db.Exec("INSERT INTO operation (ID, name, gid, color, teamID, modified, comment) VALUES (?, ?, ?, ?, ?, NOW(), ?)", o.ID, o.Name, gid, o.Color, teamID.String(), MakeNullString(o.Comment))
+	err := store.InsertOperation(&o, gid, teamID)
 	if err != nil {
 		Log.Error(err)
 		return err
@@ -160,6 +173,8 @@ func drawOpInsertWorker(o Operation, gid GoogleID, teamID TeamID) error {
 			continue
 		}
 	}
+	o.insertBlockers(gid)
+	o.insertPhases()
 
 	err = o.AddPerm(gid, teamID, "read")
 	if err != nil {
@@ -176,7 +191,12 @@ func drawOpInsertWorker(o Operation, gid GoogleID, teamID TeamID) error {
 // Markers are added/removed as necessary -- assignments and status are not overwritten (deleting the marker removes the assignment/status)
 // Anchors can simply be deleted and rebuilt
 // Key count data is left untouched (unless the portal is no longer listed in the portals list).
-func DrawUpdate(opID OperationID, op json.RawMessage, gid GoogleID) error {
+// Blockers are added but never removed. Phases are replaced by those in the upload, unless it has none at all.
+// The update is applied atomically: if anything is rejected an *OpUpdateError is returned and nothing is changed.
+// If pre is set and the op has changed since the client's copy, the update is merged with the changes made since the
+// client fetched its copy. Overlapping changes give an *OpMergeError; if no merge is possible an *OpModifiedError is returned.
+// In either case nothing is changed.
+func DrawUpdate(opID OperationID, op json.RawMessage, gid GoogleID, pre OpPrecondition) error {
 	var o Operation
 	if err := json.Unmarshal(op, &o); err != nil {
 		Log.Error(err)
@@ -197,162 +217,207 @@ func DrawUpdate(opID OperationID, op json.RawMessage, gid GoogleID) error {
 		return err
 	}
 
-	if err := drawOpUpdateWorker(o); err != nil {
+	// kept for the audit log, so deleted links and markers can be recorded along with who they were assigned to
+	beforeLinks, err := store.Links(o.ID)
+	if err != nil {
 		Log.Error(err)
 		return err
 	}
-
-	if err := o.Touch(); err != nil {
+	beforeMarkers, err := store.Markers(o.ID)
+	if err != nil {
 		Log.Error(err)
 		return err
 	}
-	return nil
-}
 
-func drawOpUpdateWorker(o Operation) error {
-	_, err := db.Exec("UPDATE operation SET name = ?, color = ?, comment = ? WHERE ID = ?",
-		o.Name, o.Color, MakeNullString(o.Comment), o.ID)
+	incoming := o
+	what := "update"
+	err = drawOpUpdateWorker(o, gid, pre)
+	if _, ok := err.(*OpModifiedError); ok {
+		// someone else changed the op after this copy was fetched, try to merge the two
+		merged, current, rerr := o.rebase(pre)
+		if _, ok := rerr.(*OpMergeError); ok {
+			Log.Notice(rerr)
+			return rerr
+		}
+		if rerr == nil {
+			// a merge does not carry blockers or phases, so take them from the upload
+			merged.Blockers = incoming.Blockers
+			merged.Phases = incoming.Phases
+			// if it changes yet again, give up
+			err = drawOpUpdateWorker(merged, gid, current)
+			what = "merged update"
+			o = merged
+		}
+	}
 	if err != nil {
 		Log.Error(err)
 		return err
 	}
 
-	// get the current portal list and stash in map
-	curPortals := make(map[PortalID]PortalID)
-	portalRows, err := db.Query("SELECT ID FROM portal WHERE OpID = ?", o.ID)
-	if err != nil {
+	// the store moved the version along with the update, so only the revision is left to record
+	if err := o.recordRevision(gid, what); err != nil {
 		Log.Error(err)
-		return err
 	}
-	defer portalRows.Close()
-	var pid PortalID
-	for portalRows.Next() {
-		err := portalRows.Scan(&pid)
-		if err != nil {
-			Log.Error(err)
-			continue
-		}
-		curPortals[pid] = pid
+	o.updateEvents(gid, what, beforeLinks, beforeMarkers)
+	return nil
+}
+
+// updateEvents announces a DrawUpdate: one event for each link or marker it deleted, one for each it added already
+// completed, so the progress history has them, and one for the update itself
+func (o *Operation) updateEvents(gid GoogleID, what string, beforeLinks []Link, beforeMarkers []Marker) {
+	existed := make(map[LinkID]bool, len(beforeLinks))
+	for _, l := range beforeLinks {
+		existed[l.ID] = true
 	}
-	// update/add portals
-	portalMap := make(map[PortalID]Portal)
-	for _, p := range o.OpPortals {
-		portalMap[p.ID] = p
-		if err = o.ID.updatePortal(p); err != nil {
-			Log.Error(err)
-			continue
+	links := make(map[LinkID]bool, len(o.Links))
+	for _, l := range o.Links {
+		links[l.ID] = true
+		if l.Completed && !existed[l.ID] {
+			o.ID.emit(Event{Type: EventLinkStatus, ObjectID: l.ID.String(), Gid: gid, Action: "complete", Detail: "uploaded", Data: map[string]bool{"completed": true}})
 		}
-		delete(curPortals, p.ID)
 	}
-	// clear portals that are no longer used
-	for k := range curPortals {
-		err := o.ID.deletePortal(k)
-		if err != nil {
-			Log.Error(err)
+	for _, l := range beforeLinks {
+		if links[l.ID] {
 			continue
 		}
+		var detail string
+		if l.AssignedTo != "" {
+			detail = "was assigned to " + l.AssignedTo.String()
+		}
+		o.ID.emit(Event{Type: EventLinkDeleted, ObjectID: l.ID.String(), Gid: gid, Agent: l.AssignedTo, Action: "delete", Detail: detail})
 	}
 
-	curMarkers := make(map[MarkerID]MarkerID)
-	markerRows, err := db.Query("SELECT ID FROM marker WHERE OpID = ?", o.ID)
-	if err != nil {
-		Log.Error(err)
-		return err
+	existedMarker := make(map[MarkerID]bool, len(beforeMarkers))
+	for _, m := range beforeMarkers {
+		existedMarker[m.ID] = true
 	}
-	defer markerRows.Close()
-	var mid MarkerID
-	for markerRows.Next() {
-		err := markerRows.Scan(&mid)
-		if err != nil {
-			Log.Error(err)
-			continue
+	markers := make(map[MarkerID]bool, len(o.Markers))
+	for _, m := range o.Markers {
+		markers[m.ID] = true
+		if m.State == "completed" && !existedMarker[m.ID] {
+			o.ID.emit(Event{Type: EventMarkerStatus, ObjectID: m.ID.String(), Gid: gid, Action: "complete", Detail: "uploaded", Data: map[string]string{"state": "completed"}})
 		}
-		curMarkers[mid] = mid
 	}
-	for _, m := range o.Markers {
-		_, ok := portalMap[m.PortalID]
-		if !ok {
-			Log.Debugf("portalID %s missing from portal list for op %s", m.PortalID, o.ID)
+	for _, m := range beforeMarkers {
+		if markers[m.ID] {
 			continue
 		}
-		if err = o.ID.updateMarker(m); err != nil {
-			Log.Error(err)
-			continue
+		var detail string
+		if m.AssignedTo != "" {
+			detail = "was assigned to " + m.AssignedTo.String()
 		}
-		delete(curMarkers, m.ID)
+		o.ID.emit(Event{Type: EventMarkerDeleted, ObjectID: m.ID.String(), Gid: gid, Agent: m.AssignedTo, Action: "delete", Detail: detail})
 	}
-	for k := range curMarkers {
-		err = o.ID.deleteMarker(k)
-		// _, err = db.Exec("DELETE FROM marker WHERE OpID = ? AND ID = ?", o.ID, k)
+
+	o.changeEvent(gid, what, fmt.Sprintf("%d portals, %d links, %d markers", len(o.OpPortals), len(o.Links), len(o.Markers)))
+}
+
+// RejectedObject is a portal, link, marker or anchor which DrawUpdate could not apply
+type RejectedObject struct {
+	Type   string `json:"type"`
+	ID     string `json:"id"`
+	Reason string `json:"reason"`
+}
+
+// OpUpdateError is returned by DrawUpdate when part of the update was rejected; in that case nothing was changed
+type OpUpdateError struct {
+	OpID     OperationID
+	Rejected []RejectedObject
+}
+
+func (e *OpUpdateError) Error() string {
+	return fmt.Sprintf("update of op %s rejected: %d invalid objects", e.OpID, len(e.Rejected))
+}
+
+func drawOpUpdateWorker(o Operation, gid GoogleID, pre OpPrecondition) error {
+	rejected := o.normalizeUpdate()
+	if len(rejected) == 0 {
+		o.newBlockers = o.uploadedBlockers(gid)
+		var err error
+		rejected, err = store.UpdateOperation(&o, pre)
+		if err == errOpModified {
+			current, err := o.ID.Stat()
+			if err != nil {
+				Log.Error(err)
+				return err
+			}
+			return &OpModifiedError{Current: current}
+		}
 		if err != nil {
 			Log.Error(err)
-			continue
+			return err
 		}
 	}
-
-	curLinks := make(map[LinkID]LinkID)
-	linkRows, err := db.Query("SELECT ID FROM link WHERE OpID = ?", o.ID)
-	if err != nil {
-		Log.Error(err)
+	if len(rejected) > 0 {
+		err := &OpUpdateError{OpID: o.ID, Rejected: rejected}
+		Log.Notice(err)
 		return err
 	}
-	defer linkRows.Close()
-	var lid LinkID
-	for linkRows.Next() {
-		err := linkRows.Scan(&lid)
-		if err != nil {
-			Log.Error(err)
+
+	// XXX TBD remove unused opkey portals?
+
+	return nil
+}
+
+// normalizeUpdate cleans up an incoming update the same way drawOpInsertWorker does
+// and returns anything which references a portal that is not in the portal list
+func (o *Operation) normalizeUpdate() []RejectedObject {
+	var rejected []RejectedObject
+
+	portalMap := make(map[PortalID]bool)
+	for _, p := range o.OpPortals {
+		portalMap[p.ID] = true
+	}
+
+	var markers []Marker
+	for _, m := range o.Markers {
+		if !portalMap[m.PortalID] {
+			rejected = append(rejected, RejectedObject{Type: "marker", ID: string(m.ID), Reason: fmt.Sprintf("portal %s missing from portal list", m.PortalID)})
 			continue
 		}
-		curLinks[lid] = lid
+		if m.State == "" {
+			m.State = "pending"
+		}
+		markers = append(markers, m)
 	}
+	o.Markers = markers
+
+	var links []Link
 	for _, l := range o.Links {
-		_, ok := portalMap[l.From]
-		if !ok {
-			Log.Debugf("source portalID %s missing from portal list for op %s", l.From, o.ID)
+		if !portalMap[l.From] {
+			rejected = append(rejected, RejectedObject{Type: "link", ID: string(l.ID), Reason: fmt.Sprintf("source portal %s missing from portal list", l.From)})
 			continue
 		}
-		_, ok = portalMap[l.To]
-		if !ok {
-			Log.Debugf("destination portalID %s missing from portal list for op %s", l.To, o.ID)
+		if !portalMap[l.To] {
+			rejected = append(rejected, RejectedObject{Type: "link", ID: string(l.ID), Reason: fmt.Sprintf("destination portal %s missing from portal list", l.To)})
 			continue
 		}
-		if err = o.ID.updateLink(l); err != nil {
-			Log.Error(err)
+		if l.To == l.From {
+			Log.Debug("source and destination the same, ignoring link")
 			continue
 		}
-		delete(curLinks, l.ID)
+		l.Color = OpValidColor(l.Color)
+		links = append(links, l)
 	}
-	for k := range curLinks {
-		err = o.ID.deleteLink(k)
-		// _, err = db.Exec("DELETE FROM link WHERE OpID = ? AND ID = ?", o.ID, k)
-		if err != nil {
-			Log.Error(err)
-			continue
+	o.Links = links
+
+	for _, a := range o.Anchors {
+		if !portalMap[a] {
+			rejected = append(rejected, RejectedObject{Type: "anchor", ID: string(a), Reason: "portal missing from portal list"})
 		}
 	}
 
-	// anchors are easy, just delete and re-add them all.
-	_, err = db.Exec("DELETE FROM anchor WHERE OpID = ?", o.ID)
-	if err != nil {
-		Log.Error(err)
-		return err
-	}
-	for _, a := range o.Anchors {
-		_, ok := portalMap[a]
-		if !ok {
-			Log.Debugf("anchor portalID %s missing from portal list for op %s", a, o.ID)
+	// nil is an upload without phases, which leaves them alone
+	for i := range o.Phases {
+		if err := o.Phases[i].normalize(); err != nil {
+			rejected = append(rejected, RejectedObject{Type: "phase", ID: string(o.Phases[i].ID), Reason: err.Error()})
 			continue
 		}
-		if err = o.ID.insertAnchor(a); err != nil {
-			Log.Error(err)
-			continue
+		if o.Phases[i].ID == "" {
+			o.Phases[i].ID = PhaseID(GenerateName())
 		}
 	}
-
-	// XXX TBD remove unused opkey portals?
-
-	return nil
+	return rejected
 }
 
 // Delete removes an operation and all associated data
@@ -368,18 +433,17 @@ func (o *Operation) Delete(gid GoogleID) error {
 		o.PopulateTeams()
 	}
 
-	_, err := db.Exec("DELETE FROM operation WHERE ID = ?", o.ID)
+	err := store.DeleteOperation(o.ID)
 	if err != nil {
 		Log.Error(err)
 		return err
 	}
-	// the foreign key constraints should take care of these, but just in case...
-	_, _ = db.Exec("DELETE FROM marker WHERE opID = ?", o.ID)
-	_, _ = db.Exec("DELETE FROM link WHERE opID = ?", o.ID)
-	_, _ = db.Exec("DELETE FROM portal WHERE opID = ?", o.ID)
-	_, _ = db.Exec("DELETE FROM anchor WHERE opID = ?", o.ID)
-	_, _ = db.Exec("DELETE FROM opkeys WHERE opID = ?", o.ID)
-	_, _ = db.Exec("DELETE FROM opteams WHERE opID = ?", o.ID)
+	forgetFieldStat(o.ID)
+	o.ID.emit(Event{
+		Type:   EventOpDeleted,
+		Gid:    gid,
+		Action: "delete",
+	})
 
 	for _, t := range o.Teams {
 		owns, err := gid.OwnsTeam(t.TeamID)
@@ -391,8 +455,7 @@ func (o *Operation) Delete(gid GoogleID) error {
 			return nil
 		}
 
-		var teamOps int
-		err = db.QueryRow("SELECT COUNT(*) FROM operation WHERE teamID = ?", t.TeamID).Scan(&teamOps)
+		teamOps, err := store.TeamOperationCount(t.TeamID)
 		if err != nil {
 			Log.Error(err)
 			teamOps = 0
@@ -412,10 +475,8 @@ func (o *Operation) Delete(gid GoogleID) error {
 // Populate takes a pointer to an Operation and fills it in; o.ID must be set
 // checks to see that either the gid created the operation or the gid is on the team assigned to the operation
 func (o *Operation) Populate(gid GoogleID) error {
-	var comment sql.NullString
 	// permission check and populate Operation top level
-	r := db.QueryRow("SELECT name, gid, color, teamID, modified, comment FROM operation WHERE ID = ?", o.ID)
-	err := r.Scan(&o.Name, &o.Gid, &o.Color, &o.TeamIDdep, &o.Modified, &comment)
+	err := store.Operation(o)
 
 	if err != nil && err == sql.ErrNoRows {
 		err = fmt.Errorf("operation not found")
@@ -435,10 +496,6 @@ func (o *Operation) Populate(gid GoogleID) error {
 		return fmt.Errorf("unauthorized: you are not on a team authorized to see this operation (%s: %s)", gid, o.ID)
 	}
 
-	if comment.Valid {
-		o.Comment = comment.String
-	}
-
 	if err = o.PopulatePortals(); err != nil {
 		Log.Notice(err)
 		return err
@@ -463,7 +520,18 @@ func (o *Operation) Populate(gid GoogleID) error {
 		Log.Notice(err)
 		return err
 	}
-	t := time.Now()
+
+	if err = o.PopulateBlockers(); err != nil {
+		Log.Notice(err)
+		return err
+	}
+
+	if err = o.PopulatePhases(); err != nil {
+		Log.Notice(err)
+		return err
+	}
+	// UTC so rebase can parse it back
+	t := time.Now().UTC()
 	o.Fetched = fmt.Sprint(t.Format(time.RFC1123))
 
 	return nil
@@ -475,28 +543,21 @@ type objectID interface {
 
 // OpUserMenu is used in html templates to draw the menus to assign targets/links
 func OpUserMenu(currentGid GoogleID, opID OperationID, objID objectID, function string) (template.HTML, error) {
-	rows, err := db.Query("SELECT DISTINCT a.iname, a.gid, x.displayname FROM agentteams=x, agent=a, opteams=p WHERE x.teamID = p.teamID AND p.opID =  ? AND x.gid = a.gid ORDER BY a.iname", opID)
+	agents, err := store.OperationAgents(opID)
 	if err != nil {
 		Log.Error(err)
 		return "", err
 	}
 
-	defer rows.Close()
-
 	var b strings.Builder
-	var iname, gid string
-	var dn sql.NullString
 
 	_, _ = b.WriteString(`<select name="agent" onchange="` + function + `('` + objID.String() + `', this);">`)
 	_, _ = b.WriteString(`<option value="">-- unassigned--</option>`)
-	for rows.Next() {
-		err := rows.Scan(&iname, &gid, &dn)
-		if err != nil {
-			Log.Error(err)
-			continue
-		}
-		if dn.Valid {
-			iname = dn.String
+	for _, a := range agents {
+		gid := string(a.Gid)
+		iname := a.Name
+		if a.DisplayName != "" {
+			iname = a.DisplayName
 		}
 
 		if gid == string(currentGid) {
@@ -513,34 +574,70 @@ func OpUserMenu(currentGid GoogleID, opID OperationID, objID objectID, function
 // SetInfo changes the description of an operation
 func (o *Operation) SetInfo(info string, gid GoogleID) error {
 	// check isowner (already done in http/pdraw.go, but there may be other callers in the future
-	_, err := db.Exec("UPDATE operation SET comment = ? WHERE ID = ?", info, o.ID)
+	err := store.SetOperationComment(o.ID, info)
 	if err != nil {
 		Log.Error(err)
 		return err
 	}
-	if err = o.Touch(); err != nil {
+	o.ID.emit(Event{
+		Type:   EventOpMeta,
+		Gid:    gid,
+		Action: "info",
+		Detail: info,
+		Data:   map[string]string{"comment": info},
+	})
+	if err = o.Touch(gid, "info"); err != nil {
 		Log.Error(err)
 	}
 	return nil
 }
 
-// Touch updates the modified timestamp on an operation
-func (o *Operation) Touch() error {
-	_, err := db.Exec("UPDATE operation SET modified = NOW() WHERE ID = ?", o.ID)
-	if err != nil {
-		Log.Error(err)
+// Touch updates the modified timestamp on an operation and records a revision
+// gid is the agent who made the change, what is a short description of it
+func (o *Operation) Touch(gid GoogleID, what string) error {
+	if err := o.touch(); err != nil {
 		return err
 	}
 
-	o.firebaseMapChange()
+	// a missing revision should not undo the change, just log it
+	if err := o.recordRevision(gid, what); err != nil {
+		Log.Error(err)
+	}
+
+	return nil
+}
+
+// touch marks the op as changed without recording a revision, for things a restore leaves alone such as keys on hand
+func (o *Operation) touch() error {
+	if err := store.TouchOperation(o.ID); err != nil {
+		Log.Error(err)
+		return err
+	}
 	return nil
 }
 
-// Stat returns useful info on an operation
+// changeEvent announces that the op was changed wholesale (an upload, merge or restore), with the new ETag to fetch
+func (o *Operation) changeEvent(gid GoogleID, action, detail string) {
+	stat, err := o.ID.Stat()
+	if err != nil {
+		Log.Error(err)
+		return
+	}
+	o.ID.emit(Event{
+		Type:   EventOpChange,
+		Gid:    gid,
+		Action: action,
+		Detail: detail,
+		Data:   map[string]string{"what": action, "etag": stat.ETag()},
+	})
+}
+
+// Stat returns useful info on an operation; it is a single lookup, the field count and MU are added by AddFields
 func (opID OperationID) Stat() (OpStat, error) {
 	var s OpStat
 	s.ID = opID
-	err := db.QueryRow("SELECT name, gid, modified FROM operation WHERE ID = ?", opID).Scan(&s.Name, &s.Gid, &s.Modified)
+	o := Operation{ID: opID}
+	err := store.Operation(&o)
 	if err != nil && err != sql.ErrNoRows {
 		Log.Notice(err)
 		return s, err
@@ -550,9 +647,73 @@ func (opID OperationID) Stat() (OpStat, error) {
 		Log.Error(err)
 		return s, err
 	}
+	s.Name = o.Name
+	s.Gid = o.Gid
+	s.Modified = o.Modified
+	s.Version = o.Version
 	return s, nil
 }
 
+// opTimeFormat is how the database reports an op's modified time, always in UTC
+const opTimeFormat = "2006-01-02 15:04:05"
+
+// errOpModified is returned by the store when a conditional update finds the op has changed
+var errOpModified = errors.New("operation modified")
+
+// OpModifiedError is returned by DrawUpdate when the op changed after the client fetched it and could not be merged; nothing was changed
+type OpModifiedError struct {
+	Current OpStat
+}
+
+func (e *OpModifiedError) Error() string {
+	return fmt.Sprintf("op %s was modified at %s: refusing update", e.Current.ID, e.Current.Modified)
+}
+
+// OpPrecondition describes the client's copy of an op for a conditional update; the zero value updates unconditionally
+type OpPrecondition struct {
+	Version         int64     // from If-Match
+	UnmodifiedSince time.Time // from If-Unmodified-Since, which only has one-second resolution
+}
+
+func (p OpPrecondition) unconditional() bool {
+	return p.Version == 0 && p.UnmodifiedSince.IsZero()
+}
+
+// changed is true if the op, now at version and last modified at modified, is not the client's copy
+func (p OpPrecondition) changed(version int64, modified time.Time) bool {
+	if p.Version != 0 {
+		return version != p.Version
+	}
+	return modified.After(p.UnmodifiedSince)
+}
+
+// ETag returns the HTTP entity tag for this version of the op
+func (o *Operation) ETag() string {
+	return opETag(o.Version)
+}
+
+// ETag returns the HTTP entity tag for this version of the op
+func (s OpStat) ETag() string {
+	return opETag(s.Version)
+}
+
+// the tag is the op's version, so two changes in the same second still get different tags
+func opETag(version int64) string {
+	return fmt.Sprintf("\"%d\"", version)
+}
+
+// ParseOpETag returns the precondition for an If-Match of an ETag generated by ETag
+func ParseOpETag(etag string) (OpPrecondition, error) {
+	tag := strings.Trim(strings.TrimPrefix(strings.TrimSpace(etag), "W/"), "\"")
+	n, err := strconv.ParseInt(tag, 10, 64)
+	if err != nil || n <= 0 {
+		err := fmt.Errorf("invalid ETag: %s", etag)
+		Log.Notice(err)
+		return OpPrecondition{}, err
+	}
+	return OpPrecondition{Version: n}, nil
+}
+
 // Rename changes an op's name
 func (opID OperationID) Rename(gid GoogleID, name string) error {
 	if !opID.IsOwner(gid) {
@@ -567,64 +728,21 @@ func (opID OperationID) Rename(gid GoogleID, name string) error {
 		return err
 	}
 
-	_, err := db.Exec("UPDATE operation SET name = ? WHERE ID = ?", name, opID)
+	err := store.SetOperationName(opID, name)
 	if err != nil {
 		Log.Error(err)
 		return err
 	}
+	opID.emit(Event{
+		Type:   EventOpMeta,
+		Gid:    gid,
+		Action: "rename",
+		Detail: name,
+		Data:   map[string]string{"name": name},
+	})
 	return nil
 }
 
-// Copy duplicates an operation and returns the new op's ID
-func (o *Operation) Copy(gid GoogleID, complete bool) (OperationID, error) {
-	var new Operation
-
-	if o.Name == "" { // not populated
-		err := o.Populate(gid)
-		if err != nil {
-			Log.Error(err)
-			return "", err
-		}
-	}
-
-	tmpid, err := GenerateSafeName()
-	if err != nil {
-		Log.Error(err)
-		return "", err
-	}
-	new.ID = OperationID(tmpid)
-	new.Name = fmt.Sprintf("%s %s", o.Name, "COPY")
-	new.Color = o.Color
-	copy(new.OpPortals, o.OpPortals)
-	copy(new.Anchors, o.Anchors)
-	copy(new.Links, o.Links)
-	copy(new.Markers, o.Markers)
-	// should this be in the complete section?
-	copy(new.Teams, o.Teams)
-
-	if !complete {
-		for _, l := range new.Links {
-			l.AssignedTo = ""
-		}
-		for _, m := range new.Markers {
-			m.AssignedTo = ""
-		}
-
-		teamID, err := gid.NewTeam(new.Name)
-		if err != nil {
-			Log.Error(err)
-		}
-		if err = drawOpInsertWorker(new, gid, teamID); err != nil {
-			Log.Error(err)
-			return "", err
-		}
-	} else { // complete
-		// XXX copy Teams?
-	}
-
-	return new.ID, nil
-}
-
 type opColor struct {
 	Name string
 	Hex  string
@@ -0,0 +1,183 @@
+package wasabee
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// AgentWalk is how far an agent walks between the portals they throw from, in meters
+type AgentWalk struct {
+	Gid    GoogleID `json:"gid"` // empty for unassigned links
+	Before float64  `json:"before"`
+	After  float64  `json:"after"`
+}
+
+// ProposedOrder is a throw order suggested by OptimizeThrowOrder
+type ProposedOrder struct {
+	ID    OperationID     `json:"ID"`
+	Order string          `json:"order"` // as sent to LinkOrder
+	Links []LinkID        `json:"links"`
+	Walk  []AgentWalk     `json:"walk"`
+	Check ThrowOrderCheck `json:"check"`
+}
+
+// walks adds up how far each agent walks between link origins, taking the links in order
+func (g opGeometry) walks(links []Link) map[GoogleID]float64 {
+	walk := make(map[GoogleID]float64)
+	at := make(map[GoogleID]PortalID)
+	for _, l := range links {
+		if _, ok := walk[l.AssignedTo]; !ok {
+			walk[l.AssignedTo] = 0
+		}
+		if prev, ok := at[l.AssignedTo]; ok && prev != l.From {
+			walk[l.AssignedTo] += g.distance(prev, l.From)
+		}
+		at[l.AssignedTo] = l.From
+	}
+	return walk
+}
+
+// distance between two portals in meters, 0 if either is unknown
+func (g opGeometry) distance(a, b PortalID) float64 {
+	pa, ok := g.portals[a]
+	if !ok {
+		return 0
+	}
+	pb, ok := g.portals[b]
+	if !ok {
+		return 0
+	}
+	return Distance(pa.Lat, pa.Lon, pb.Lat, pb.Lon)
+}
+
+// OptimizeThrowOrder proposes a throw order which never throws a link from inside a field already up and keeps each agent's walk short.
+// Each step takes, of the links which would not trap another link's origin inside a new field, the one nearest its agent.
+// Outbound limits do not depend on the order; any still exceeded are reported in the check. The op must be populated first.
+func (o *Operation) OptimizeThrowOrder() ProposedOrder {
+	g, _ := o.geometry()
+	current := o.thrownLinks()
+	before := g.walks(current)
+
+	remaining := make([]Link, len(current))
+	copy(remaining, current)
+	unthrown := make(map[PortalID]int)
+	for _, l := range remaining {
+		unthrown[l.From]++
+	}
+
+	// the portals inside each triangle, worked out as needed
+	inside := make(map[[3]PortalID][]PortalID)
+	portalsInside := func(f [3]PortalID) []PortalID {
+		if ps, ok := inside[f]; ok {
+			return ps
+		}
+		var ps []PortalID
+		for p := range unthrown {
+			if g.inField(p, f) {
+				ps = append(ps, p)
+			}
+		}
+		inside[f] = ps
+		return ps
+	}
+
+	lg := make(linkGraph)
+	var fields [][3]PortalID
+	at := make(map[GoogleID]PortalID)
+	var order []Link
+	for len(remaining) > 0 {
+		best := -1
+		var bestCost float64
+		bestSafe := false
+		for i, l := range remaining {
+			if _, ok := g.fieldAround(l.From, fields); ok {
+				// already trapped, nothing to be done for it; throw it last
+				continue
+			}
+
+			safe := true
+			if l.From != l.To {
+			closes:
+				for _, c := range lg.closes(l.From, l.To) {
+					for _, p := range portalsInside(sortedField(l.From, l.To, c)) {
+						if unthrown[p] > 0 {
+							safe = false
+							break closes
+						}
+					}
+				}
+			}
+
+			cost := 0.0
+			if prev, ok := at[l.AssignedTo]; ok {
+				cost = g.distance(prev, l.From)
+			}
+			// safe beats unsafe, then the shortest walk, then the current order
+			if best == -1 || (safe && !bestSafe) || (safe == bestSafe && cost < bestCost) {
+				best, bestCost, bestSafe = i, cost, safe
+			}
+		}
+		if best == -1 {
+			// everything left is trapped
+			best = 0
+		}
+
+		l := remaining[best]
+		remaining = append(remaining[:best], remaining[best+1:]...)
+		unthrown[l.From]--
+		at[l.AssignedTo] = l.From
+		if l.From != l.To {
+			for _, c := range lg.closes(l.From, l.To) {
+				fields = append(fields, [3]PortalID{l.From, l.To, c})
+			}
+			lg.add(l.From, l.To)
+		}
+		order = append(order, l)
+	}
+
+	p := ProposedOrder{ID: o.ID, Links: make([]LinkID, 0, len(order)), Walk: []AgentWalk{}}
+	for _, l := range order {
+		p.Links = append(p.Links, l.ID)
+	}
+	after := g.walks(order)
+	for gid, b := range before {
+		p.Walk = append(p.Walk, AgentWalk{Gid: gid, Before: b, After: after[gid]})
+	}
+	sort.Slice(p.Walk, func(i, j int) bool { return p.Walk[i].Gid < p.Walk[j].Gid })
+
+	ids := make([]string, len(p.Links))
+	for i, id := range p.Links {
+		ids[i] = string(id)
+	}
+	p.Order = strings.Join(ids, ",")
+
+	proposed := *o
+	proposed.Links = make([]Link, len(o.Links))
+	copy(proposed.Links, o.Links)
+	p.Check = proposed.CheckThrowOrder(p.Order)
+	return p
+}
+
+// sortedField names a triangle the same way whichever link closes it
+func sortedField(a, b, c PortalID) [3]PortalID {
+	f := []PortalID{a, b, c}
+	sort.Slice(f, func(i, j int) bool { return f[i] < f[j] })
+	return [3]PortalID{f[0], f[1], f[2]}
+}
+
+// ApplyThrowOrder optimizes the throw order and saves it
+func (o *Operation) ApplyThrowOrder(gid GoogleID) (ProposedOrder, error) {
+	if !o.WriteAccess(gid) {
+		err := fmt.Errorf("write access required to set operation order")
+		Log.Error(err)
+		return ProposedOrder{}, err
+	}
+
+	p := o.OptimizeThrowOrder()
+	if err := o.LinkOrder(p.Order, gid); err != nil {
+		Log.Error(err)
+		return p, err
+	}
+	return p, nil
+}
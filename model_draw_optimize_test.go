@@ -0,0 +1,82 @@
+package wasabee_test
+
+import (
+	"encoding/json"
+	"github.com/wasabee-project/Wasabee-Server"
+	"io/ioutil"
+	"testing"
+)
+
+func TestOptimizeThrowOrder(t *testing.T) {
+	op := wasabee.Operation{
+		ID: "optimize",
+		OpPortals: []wasabee.Portal{
+			{ID: "H", Lat: "0", Lon: "0"},
+			{ID: "T0", Lat: "1", Lon: "-5"},
+			{ID: "T1", Lat: "1", Lon: "-4"},
+			{ID: "X", Lat: "0.667", Lon: "-3"},
+			{ID: "F", Lat: "-1", Lon: "5"},
+			{ID: "G", Lat: "-1", Lon: "6"},
+		},
+		Links: []wasabee.Link{
+			{ID: "HT0", From: "H", To: "T0", ThrowOrder: 1, AssignedTo: "a"},
+			{ID: "FG", From: "F", To: "G", ThrowOrder: 2, AssignedTo: "a"},
+			{ID: "HT1", From: "H", To: "T1", ThrowOrder: 3, AssignedTo: "a"},
+			{ID: "T0T1", From: "T0", To: "T1", ThrowOrder: 4, AssignedTo: "b"},
+			{ID: "XT0", From: "X", To: "T0", ThrowOrder: 5, AssignedTo: "b"},
+		},
+	}
+	if c := op.CheckThrowOrder(""); c.Valid {
+		t.Fatal("test op should start with a bad order")
+	}
+
+	p := op.OptimizeThrowOrder()
+	if !p.Check.Valid {
+		t.Errorf("proposed order is not valid: %v", p.Check)
+	}
+	pos := make(map[wasabee.LinkID]int)
+	for i, id := range p.Links {
+		pos[id] = i
+	}
+	if len(pos) != 5 || pos["XT0"] > pos["T0T1"] {
+		t.Errorf("unexpected order: %s", p.Order)
+	}
+	for _, w := range p.Walk {
+		if w.Gid == "a" && w.After >= w.Before {
+			t.Errorf("agent a still walks back and forth: %s %v", p.Order, w)
+		}
+	}
+
+	// applying it saves it
+	content, err := ioutil.ReadFile("testdata/test2.json")
+	if err != nil {
+		t.Error(err.Error())
+	}
+	if err := wasabee.DrawInsert(json.RawMessage(content), gid); err != nil {
+		t.Error(err.Error())
+	}
+	var in wasabee.Operation
+	if err := json.Unmarshal(content, &in); err != nil {
+		t.Error(err.Error())
+	}
+	stored := wasabee.Operation{ID: in.ID}
+	if err := stored.Populate(gid); err != nil {
+		t.Error(err.Error())
+	}
+	applied, err := stored.ApplyThrowOrder(gid)
+	if err != nil {
+		t.Error(err.Error())
+	}
+	after := wasabee.Operation{ID: in.ID}
+	if err := after.Populate(gid); err != nil {
+		t.Error(err.Error())
+	}
+	for _, l := range after.Links {
+		if applied.Links[l.ThrowOrder-1] != l.ID {
+			t.Errorf("link %s saved at %d, proposed %s", l.ID, l.ThrowOrder, applied.Order)
+		}
+	}
+	if err := after.Delete(gid); err != nil {
+		t.Error(err.Error())
+	}
+}
@@ -0,0 +1,295 @@
+package wasabee
+
+import (
+	"fmt"
+	"time"
+)
+
+// PhaseID identifies a phase within an op
+type PhaseID string
+
+// Phase is a stretch of an op with a start and end, such as key farming, cleaning, or the linking wave.
+// Links and markers can be put in a phase; once it has ended they drop out of the agents' assignments.
+type Phase struct {
+	ID    PhaseID     `json:"ID"`
+	OpID  OperationID `json:"opID"`
+	Name  string      `json:"name"`
+	Start string      `json:"start"` // UTC, as phaseTimeFormat
+	End   string      `json:"end"`
+}
+
+// phaseTimeFormat is how phase and wave times are stored and sent; RFC3339 is also accepted
+const phaseTimeFormat = "2006-01-02 15:04:05"
+
+// phaseReminderLead is how long before a phase starts its agents are reminded
+const phaseReminderLead = 30 * time.Minute
+
+func parsePhaseTime(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t.UTC(), nil
+	}
+	t, err := time.Parse(phaseTimeFormat, s)
+	if err != nil {
+		return t, fmt.Errorf("unable to parse time %q", s)
+	}
+	return t, nil
+}
+
+// normalize checks the phase and puts its times in phaseTimeFormat
+func (p *Phase) normalize() error {
+	if p.Name == "" {
+		return fmt.Errorf("phase needs a name")
+	}
+	start, err := parsePhaseTime(p.Start)
+	if err != nil {
+		return err
+	}
+	end, err := parsePhaseTime(p.End)
+	if err != nil {
+		return err
+	}
+	if !end.After(start) {
+		return fmt.Errorf("phase %s ends before it starts", p.Name)
+	}
+	p.Start = start.Format(phaseTimeFormat)
+	p.End = end.Format(phaseTimeFormat)
+	return nil
+}
+
+// ended is true once the phase's end has passed; an unparsable end never ends
+func (p Phase) ended(now time.Time) bool {
+	end, err := parsePhaseTime(p.End)
+	return err == nil && now.After(end)
+}
+
+// insertPhases saves the phases in a new op; invalid ones are skipped and those without an ID get one
+func (o *Operation) insertPhases() {
+	for _, p := range o.Phases {
+		if p.ID == "" {
+			p.ID = PhaseID(GenerateName())
+		}
+		if err := p.normalize(); err != nil {
+			Log.Notice(err)
+			continue
+		}
+		if err := store.SetPhase(o.ID, p); err != nil {
+			Log.Error(err)
+		}
+	}
+}
+
+// PopulatePhases fills in the op's phases. No authorization takes place.
+func (o *Operation) PopulatePhases() error {
+	phases, err := store.Phases(o.ID)
+	if err != nil {
+		Log.Error(err)
+		return err
+	}
+	o.Phases = append(o.Phases, phases...)
+	return nil
+}
+
+// ListPhases returns the op's phases in order
+func (o *Operation) ListPhases(gid GoogleID) ([]Phase, error) {
+	if !o.opAgent(gid) {
+		err := fmt.Errorf("permission denied: %s listing phases in op %s", gid, o.ID)
+		Log.Error(err)
+		return nil, err
+	}
+	phases, err := store.Phases(o.ID)
+	if err != nil {
+		Log.Error(err)
+		return nil, err
+	}
+	return phases, nil
+}
+
+// SetPhase adds a phase to the op, or changes it if the ID is already in use; a phase with no ID gets one
+func (o *Operation) SetPhase(gid GoogleID, p Phase) (Phase, error) {
+	if !o.WriteAccess(gid) {
+		err := fmt.Errorf("write access required to change phases")
+		Log.Error(err)
+		return p, err
+	}
+	if err := p.normalize(); err != nil {
+		Log.Notice(err)
+		return p, err
+	}
+	if p.ID == "" {
+		p.ID = PhaseID(GenerateName())
+	}
+	p.OpID = o.ID
+	if err := store.SetPhase(o.ID, p); err != nil {
+		Log.Error(err)
+		return p, err
+	}
+	o.ID.emit(Event{
+		Type:     EventOpPhase,
+		ObjectID: string(p.ID),
+		Gid:      gid,
+		Action:   "phase",
+		Detail:   fmt.Sprintf("%s %s - %s", p.Name, p.Start, p.End),
+		Data:     p,
+	})
+	if err := o.Touch(gid, "phase "+string(p.ID)); err != nil {
+		Log.Error(err)
+	}
+	return store.Phase(o.ID, p.ID)
+}
+
+// DeletePhase removes a phase; its links and markers are no longer in any phase
+func (o *Operation) DeletePhase(gid GoogleID, phaseID PhaseID) error {
+	if !o.WriteAccess(gid) {
+		err := fmt.Errorf("write access required to change phases")
+		Log.Error(err)
+		return err
+	}
+	if err := store.DeletePhase(o.ID, phaseID); err != nil {
+		Log.Error(err)
+		return err
+	}
+	o.ID.emit(Event{
+		Type:     EventOpPhase,
+		ObjectID: string(phaseID),
+		Gid:      gid,
+		Action:   "delete",
+	})
+	if err := o.Touch(gid, "delete phase "+string(phaseID)); err != nil {
+		Log.Error(err)
+	}
+	return nil
+}
+
+// checkPhase makes sure the phase exists; the empty phase always does
+func (o *Operation) checkPhase(phaseID PhaseID) error {
+	if phaseID == "" {
+		return nil
+	}
+	if _, err := store.Phase(o.ID, phaseID); err != nil {
+		err := fmt.Errorf("phase %s not in op %s", phaseID, o.ID)
+		Log.Notice(err)
+		return err
+	}
+	return nil
+}
+
+// LinkPhase puts a link in a phase, or takes it out of any phase if phaseID is empty
+func (o *Operation) LinkPhase(linkID LinkID, phaseID PhaseID, gid GoogleID) error {
+	if err := o.checkPhase(phaseID); err != nil {
+		return err
+	}
+	if err := store.SetLinkPhase(o.ID, linkID, phaseID); err != nil {
+		Log.Error(err)
+		return err
+	}
+	o.ID.emit(Event{
+		Type:     EventLinkPhase,
+		ObjectID: linkID.String(),
+		Gid:      gid,
+		Action:   "phase",
+		Detail:   string(phaseID),
+		Data:     map[string]string{"phase": string(phaseID)},
+	})
+	if err := o.Touch(gid, "link phase "+linkID.String()); err != nil {
+		Log.Error(err)
+	}
+	return nil
+}
+
+// MarkerPhase puts a marker in a phase, or takes it out of any phase if phaseID is empty
+func (o *Operation) MarkerPhase(markerID MarkerID, phaseID PhaseID, gid GoogleID) error {
+	if err := o.checkPhase(phaseID); err != nil {
+		return err
+	}
+	if err := store.SetMarkerPhase(o.ID, markerID, phaseID); err != nil {
+		Log.Error(err)
+		return err
+	}
+	o.ID.emit(Event{
+		Type:     EventMarkerPhase,
+		ObjectID: markerID.String(),
+		Gid:      gid,
+		Action:   "phase",
+		Detail:   string(phaseID),
+		Data:     map[string]string{"phase": string(phaseID)},
+	})
+	if err := o.Touch(gid, "marker phase "+markerID.String()); err != nil {
+		Log.Error(err)
+	}
+	return nil
+}
+
+// phaseMap is the op's phases by ID
+func (opID OperationID) phaseMap() (map[PhaseID]Phase, error) {
+	phases, err := store.Phases(opID)
+	if err != nil {
+		return nil, err
+	}
+	m := make(map[PhaseID]Phase, len(phases))
+	for _, p := range phases {
+		m[p.ID] = p
+	}
+	return m, nil
+}
+
+// phaseCurrent is true if the phase has not ended; no phase, or a phase which has been deleted, is always current
+func phaseCurrent(phases map[PhaseID]Phase, phaseID PhaseID, now time.Time) bool {
+	p, ok := phases[phaseID]
+	return !ok || !p.ended(now)
+}
+
+// phaseReminders tells agents about phases starting soon in which they have links or markers
+func phaseReminders() {
+	phases, err := store.PhasesToRemind(time.Now().Add(phaseReminderLead))
+	if err != nil {
+		Log.Error(err)
+		return
+	}
+	for _, p := range phases {
+		// marked first, so a failure to send does not repeat the reminder every minute
+		if err := store.SetPhaseReminded(p.OpID, p.ID); err != nil {
+			Log.Error(err)
+			continue
+		}
+		p.remind()
+	}
+}
+
+func (p Phase) remind() {
+	links, err := store.Links(p.OpID)
+	if err != nil {
+		Log.Error(err)
+		return
+	}
+	markers, err := store.Markers(p.OpID)
+	if err != nil {
+		Log.Error(err)
+		return
+	}
+
+	type todo struct{ links, markers int }
+	agents := make(map[GoogleID]*todo)
+	get := func(gid GoogleID) *todo {
+		if agents[gid] == nil {
+			agents[gid] = &todo{}
+		}
+		return agents[gid]
+	}
+	for _, l := range links {
+		if l.Phase == p.ID && l.AssignedTo != "" && !l.Completed {
+			get(l.AssignedTo).links++
+		}
+	}
+	for _, m := range markers {
+		if m.Phase == p.ID && m.AssignedTo != "" && m.State != "completed" {
+			get(m.AssignedTo).markers++
+		}
+	}
+
+	for gid, t := range agents {
+		msg := fmt.Sprintf("phase %s of op %s starts at %s UTC: you have %d link(s) and %d marker(s)", p.Name, p.OpID, p.Start, t.links, t.markers)
+		if _, err := gid.SendMessage(msg); err != nil {
+			Log.Notice(err)
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package wasabee_test
+
+import (
+	"encoding/json"
+	"github.com/wasabee-project/Wasabee-Server"
+	"testing"
+	"time"
+)
+
+func TestPhases(t *testing.T) {
+	pgid := wasabee.GoogleID("104743827901423568951")
+	if _, err := pgid.InitAgent(); err != nil {
+		t.Fatal(err.Error())
+	}
+	defer pgid.Delete()
+
+	now := time.Now().UTC()
+	orig := wasabee.Operation{
+		ID:   "phases",
+		Name: "phases",
+		OpPortals: []wasabee.Portal{
+			{ID: "A", Name: "A", Lat: "0", Lon: "0"},
+			{ID: "B", Name: "B", Lat: "0", Lon: "1"},
+			{ID: "C", Name: "C", Lat: "1", Lon: "0"},
+		},
+		Phases: []wasabee.Phase{
+			{ID: "wave", Name: "linking wave", Start: now.Add(10 * time.Minute).Format(time.RFC3339), End: now.Add(time.Hour).Format(time.RFC3339)},
+			{ID: "farm", Name: "key farm", Start: now.Add(-3 * time.Hour).Format(time.RFC3339), End: now.Add(-2 * time.Hour).Format(time.RFC3339)},
+			{ID: "broken", Name: "ends first", Start: now.Format(time.RFC3339), End: now.Add(-time.Hour).Format(time.RFC3339)},
+		},
+		Links: []wasabee.Link{
+			{ID: "AB", From: "A", To: "B", AssignedTo: pgid, Phase: "farm"},
+			{ID: "BC", From: "B", To: "C", AssignedTo: pgid, Phase: "wave"},
+			{ID: "CA", From: "C", To: "A", AssignedTo: pgid},
+		},
+		Markers: []wasabee.Marker{{ID: "MA", PortalID: "A", Type: "GetKeyPortalMarker", AssignedTo: pgid, Phase: "farm"}},
+	}
+	j, _ := json.Marshal(orig)
+	if err := wasabee.DrawInsert(j, gid); err != nil {
+		t.Fatal(err.Error())
+	}
+	defer orig.Delete(gid)
+
+	op := wasabee.Operation{ID: orig.ID}
+	if err := op.Populate(gid); err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(op.Phases) != 2 || op.Phases[0].ID != "farm" || op.Phases[1].ID != "wave" {
+		t.Fatalf("phases not stored in order: %v", op.Phases)
+	}
+	if want := now.Add(10 * time.Minute).Format("2006-01-02 15:04:05"); op.Phases[1].Start != want {
+		t.Errorf("start %s, expected %s", op.Phases[1].Start, want)
+	}
+
+	// the key farm is over, so only the wave and the link in no phase are left
+	var a wasabee.Assignments
+	if err := pgid.Assignments(op.ID, &a); err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(a.Links) != 2 || len(a.Markers) != 0 || a.Phases["wave"].Name != "linking wave" {
+		t.Errorf("unexpected assignments: %v %v", a.Links, a.Markers)
+	}
+	for _, l := range a.Links {
+		if l.ID == "AB" {
+			t.Error("link in a phase which has ended is still assigned")
+		}
+	}
+	var ad wasabee.AgentData
+	if err := pgid.GetAgentData(&ad); err != nil {
+		t.Fatal(err.Error())
+	}
+	for _, x := range ad.Assignments {
+		if x.OpID == op.ID && x.Type == "Marker" {
+			t.Error("op listed for a marker in a phase which has ended")
+		}
+	}
+
+	if _, err := op.SetPhase(gid, wasabee.Phase{Name: "clean", Start: "soon", End: "later"}); err == nil {
+		t.Error("phase with unparsable times accepted")
+	}
+	if _, err := op.SetPhase(pgid, wasabee.Phase{Name: "clean", Start: "2030-01-01 10:00:00", End: "2030-01-01 11:00:00"}); err == nil {
+		t.Error("phase set without write access")
+	}
+	clean, err := op.SetPhase(gid, wasabee.Phase{Name: "clean", Start: "2030-01-01T10:00:00+02:00", End: "2030-01-01 11:00:00"})
+	if err != nil || clean.ID == "" || clean.Start != "2030-01-01 08:00:00" {
+		t.Errorf("unexpected phase: %v %v", clean, err)
+	}
+
+	if err := op.LinkPhase("AB", "nosuchphase", gid); err == nil {
+		t.Error("link put in a phase which does not exist")
+	}
+	if err := op.LinkPhase("AB", clean.ID, gid); err != nil {
+		t.Error(err.Error())
+	}
+	if err := op.MarkerPhase("MA", clean.ID, gid); err != nil {
+		t.Error(err.Error())
+	}
+	a = wasabee.Assignments{}
+	if err := pgid.Assignments(op.ID, &a); err != nil || len(a.Links) != 3 || len(a.Markers) != 1 {
+		t.Errorf("moved assignments not current: %v %v %v", a.Links, a.Markers, err)
+	}
+
+	// a copy keeps the phases and what is in them
+	src := wasabee.Operation{ID: op.ID}
+	if err := src.Populate(gid); err != nil {
+		t.Fatal(err.Error())
+	}
+	id, err := src.Copy(gid, wasabee.CopyOptions{Complete: true})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	cp := wasabee.Operation{ID: id}
+	defer cp.Delete(gid)
+	if err := cp.Populate(gid); err != nil {
+		t.Fatal(err.Error())
+	}
+	inClean := 0
+	for _, l := range cp.Links {
+		if l.Phase == clean.ID {
+			inClean++
+		}
+	}
+	if len(cp.Phases) != 3 || inClean != 1 {
+		t.Errorf("copy phases %v, %d links in the clean phase", cp.Phases, inClean)
+	}
+
+	// deleting the phase leaves its links in no phase
+	if err := op.DeletePhase(gid, clean.ID); err != nil {
+		t.Error(err.Error())
+	}
+	check := wasabee.Operation{ID: op.ID}
+	if err := check.Populate(gid); err != nil {
+		t.Fatal(err.Error())
+	}
+	for _, l := range check.Links {
+		if l.ID == "AB" && l.Phase != "" {
+			t.Errorf("link still in deleted phase %s", l.Phase)
+		}
+	}
+	if len(check.Phases) != 2 {
+		t.Errorf("phase not deleted: %v", check.Phases)
+	}
+
+	// an upload without phases leaves them alone
+	up := check
+	up.Phases = nil
+	j, _ = json.Marshal(up)
+	if err := wasabee.DrawUpdate(op.ID, j, gid, wasabee.OpPrecondition{}); err != nil {
+		t.Fatal(err.Error())
+	}
+	if phases, _ := op.ListPhases(gid); len(phases) != 2 {
+		t.Errorf("upload without phases changed them: %v", phases)
+	}
+
+	// one with phases replaces them: farm is dropped and the new one gets an ID
+	up.Phases = []wasabee.Phase{
+		{ID: "wave", Name: "linking wave", Start: now.Add(10 * time.Minute).Format(time.RFC3339), End: now.Add(time.Hour).Format(time.RFC3339)},
+		{Name: "clean up", Start: now.Add(time.Hour).Format(time.RFC3339), End: now.Add(2 * time.Hour).Format(time.RFC3339)},
+	}
+	j, _ = json.Marshal(up)
+	if err := wasabee.DrawUpdate(op.ID, j, gid, wasabee.OpPrecondition{}); err != nil {
+		t.Fatal(err.Error())
+	}
+	phases, err := op.ListPhases(gid)
+	if err != nil || len(phases) != 2 || phases[0].ID != "wave" || phases[1].ID == "" {
+		t.Errorf("upload did not replace phases: %v %v", phases, err)
+	}
+	check = wasabee.Operation{ID: op.ID}
+	if err := check.Populate(gid); err != nil {
+		t.Fatal(err.Error())
+	}
+	for _, mk := range check.Markers {
+		if mk.Phase == "farm" {
+			t.Errorf("marker %s still in deleted phase", mk.ID)
+		}
+	}
+
+	// an invalid phase rejects the whole upload
+	up.Phases = append(up.Phases, wasabee.Phase{ID: "broken", Name: "ends first", Start: now.Format(time.RFC3339), End: now.Add(-time.Hour).Format(time.RFC3339)})
+	j, _ = json.Marshal(up)
+	if err := wasabee.DrawUpdate(op.ID, j, gid, wasabee.OpPrecondition{}); err == nil {
+		t.Error("upload with an invalid phase accepted")
+	}
+}
@@ -0,0 +1,337 @@
+package wasabee
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// AssignmentRequest says who to plan assignments for and how
+type AssignmentRequest struct {
+	Team        TeamID     // the team's active agents, or
+	Agents      []GoogleID // these agents
+	MaxPerAgent int        // 0 to share evenly
+	Links       bool
+	Markers     bool
+	Reassign    bool // plan everything, not just what is unassigned
+}
+
+// PlannedAssignment is one link or marker given to an agent
+type PlannedAssignment struct {
+	Type     string   `json:"type"` // link or marker
+	ID       string   `json:"ID"`
+	PortalID PortalID `json:"portalId"` // where the agent has to be: the link's origin or the marker's portal
+	Agent    GoogleID `json:"agent"`
+	Previous GoogleID `json:"previous,omitempty"`
+	HasKey   bool     `json:"hasKey,omitempty"` // the agent has a key for the link's destination
+}
+
+// PlanAgent is what one agent is given
+type PlanAgent struct {
+	Gid     GoogleID `json:"gid"`
+	Links   int      `json:"links"`
+	Markers int      `json:"markers"`
+	Keys    int      `json:"keys"`    // links given to them because they hold the key
+	Located bool     `json:"located"` // their last known location was used
+}
+
+// AssignmentPlan is the result of PlanAssignments, to be reviewed and then committed
+type AssignmentPlan struct {
+	ID          OperationID         `json:"ID"`
+	Assignments []PlannedAssignment `json:"assignments"`
+	Agents      []PlanAgent         `json:"agents"`
+	Unassigned  []string            `json:"unassigned"` // items left over when everyone is at the maximum
+	Skipped     []GoogleID          `json:"skipped"`    // agents who cannot see the op
+}
+
+// planAgent is the planner's working state for one agent
+type planAgent struct {
+	PlanAgent
+	at    geoPoint
+	count int
+}
+
+// PlanAssignments shares out the op's links and markers among the agents, keeping each agent's work close together
+// and near where they were last seen, and giving links to agents holding keys for the destination.
+// The op must be populated by someone with write access.
+func (o *Operation) PlanAssignments(gid GoogleID, r AssignmentRequest) (AssignmentPlan, error) {
+	plan := AssignmentPlan{ID: o.ID, Assignments: []PlannedAssignment{}, Agents: []PlanAgent{}, Unassigned: []string{}, Skipped: []GoogleID{}}
+
+	if !o.WriteAccess(gid) {
+		err := fmt.Errorf("write access required to plan assignments")
+		Log.Error(err)
+		return plan, err
+	}
+
+	candidates := r.Agents
+	if r.Team != "" {
+		if err := o.planTeam(gid, r.Team); err != nil {
+			Log.Notice(err)
+			return plan, err
+		}
+		members, err := store.TeamMembers(r.Team, true)
+		if err != nil {
+			Log.Error(err)
+			return plan, err
+		}
+		candidates = append(candidates, members...)
+	}
+
+	g, _ := o.geometry()
+	var agents []*planAgent
+	seen := make(map[GoogleID]bool)
+	for _, a := range candidates {
+		if seen[a] {
+			continue
+		}
+		seen[a] = true
+		if !o.ReadAccess(a) && !o.AssignedOnlyAccess(a) {
+			plan.Skipped = append(plan.Skipped, a)
+			continue
+		}
+		pa := &planAgent{PlanAgent: PlanAgent{Gid: a}}
+		if lat, lon, err := store.AgentLastLocation(a); err == nil {
+			pa.at = newGeoPoint(lat, lon)
+			pa.Located = true
+		}
+		agents = append(agents, pa)
+	}
+	if len(agents) == 0 {
+		err := fmt.Errorf("no agents to assign to")
+		Log.Notice(err)
+		return plan, err
+	}
+
+	var items []PlannedAssignment
+	if r.Links {
+		for _, l := range o.thrownLinks() {
+			if l.AssignedTo == "" || r.Reassign {
+				items = append(items, PlannedAssignment{Type: "link", ID: string(l.ID), PortalID: l.From, Previous: l.AssignedTo})
+			}
+		}
+	}
+	if r.Markers {
+		for _, m := range o.Markers {
+			if m.State == "completed" {
+				continue
+			}
+			if m.AssignedTo == "" || r.Reassign {
+				items = append(items, PlannedAssignment{Type: "marker", ID: string(m.ID), PortalID: m.PortalID, Previous: m.AssignedTo})
+			}
+		}
+	}
+
+	max := r.MaxPerAgent
+	if max <= 0 {
+		max = (len(items) + len(agents) - 1) / len(agents)
+	}
+
+	// agents nobody has seen lately start at the work furthest from everyone else, so the op is spread out
+	for _, a := range agents {
+		if a.Located {
+			continue
+		}
+		far, farthest := -1.0, geoPoint{}
+		for _, it := range items {
+			p, ok := g.points[it.PortalID]
+			if !ok {
+				continue
+			}
+			near := math.Inf(1)
+			for _, b := range agents {
+				if b.Located || b.at != (geoPoint{}) {
+					near = math.Min(near, angle(b.at, p))
+				}
+			}
+			if near > far {
+				far, farthest = near, p
+			}
+		}
+		a.at = farthest
+	}
+
+	// who has which keys
+	keys := make(map[PortalID]map[GoogleID]int32)
+	for _, k := range o.Keys {
+		if k.Onhand <= 0 {
+			continue
+		}
+		if keys[k.ID] == nil {
+			keys[k.ID] = make(map[GoogleID]int32)
+		}
+		keys[k.ID][k.Gid] += k.Onhand
+	}
+	to := make(map[string]PortalID)
+	for _, l := range o.Links {
+		to[string(l.ID)] = l.To
+	}
+
+	// links someone has the key for go first, to the nearest key holder
+	sort.SliceStable(items, func(i, j int) bool {
+		return items[i].Type == "link" && len(keys[to[items[i].ID]]) > 0 && !(items[j].Type == "link" && len(keys[to[items[j].ID]]) > 0)
+	})
+
+	for _, it := range items {
+		p, located := g.points[it.PortalID]
+		var best *planAgent
+		bestKey := false
+		bestDist := math.Inf(1)
+		for _, a := range agents {
+			if a.count >= max {
+				continue
+			}
+			hasKey := it.Type == "link" && keys[to[it.ID]][a.Gid] > 0
+			d := 0.0
+			if located {
+				d = angle(a.at, p)
+			}
+			// a key beats distance, then the nearest, then whoever has least so far
+			if best == nil || (hasKey && !bestKey) || (hasKey == bestKey && (d < bestDist || (d == bestDist && a.count < best.count))) {
+				best, bestKey, bestDist = a, hasKey, d
+			}
+		}
+		if best == nil {
+			plan.Unassigned = append(plan.Unassigned, it.ID)
+			continue
+		}
+
+		it.Agent = best.Gid
+		it.HasKey = bestKey
+		best.count++
+		if it.Type == "link" {
+			best.Links++
+		} else {
+			best.Markers++
+		}
+		if bestKey {
+			best.Keys++
+			keys[to[it.ID]][best.Gid]--
+		}
+		// drift toward the work so the next nearby item follows
+		if located {
+			best.at = best.at.toward(p, 1/float64(best.count+1))
+		}
+		plan.Assignments = append(plan.Assignments, it)
+	}
+
+	for _, a := range agents {
+		plan.Agents = append(plan.Agents, a.PlanAgent)
+	}
+	return plan, nil
+}
+
+// planTeam checks the team is one of the op's and the planner owns the op or is on the team; the op must be populated
+func (o *Operation) planTeam(gid GoogleID, teamID TeamID) error {
+	found := false
+	for _, t := range o.Teams {
+		if t.TeamID == teamID {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return fmt.Errorf("team %s is not on op %s", teamID, o.ID)
+	}
+	if o.ID.IsOwner(gid) {
+		return nil
+	}
+	in, err := gid.AgentInTeam(teamID, true)
+	if err != nil {
+		return err
+	}
+	if !in {
+		return fmt.Errorf("%s is not on team %s", gid, teamID)
+	}
+	return nil
+}
+
+// toward moves a fraction f of the way to b
+func (a geoPoint) toward(b geoPoint, f float64) geoPoint {
+	return geoPoint{a[0] + (b[0]-a[0])*f, a[1] + (b[1]-a[1])*f, a[2] + (b[2]-a[2])*f}
+}
+
+// angle between two points, in radians; it does not need the points to be unit length
+func angle(a, b geoPoint) float64 {
+	return math.Atan2(math.Sqrt(a.cross(b).dot(a.cross(b))), a.dot(b))
+}
+
+// PlanChangedError is returned by CommitAssignmentPlan when the op no longer matches the plan; nothing was assigned
+type PlanChangedError struct {
+	OpID    OperationID
+	Changed []string // the links and markers which have changed
+}
+
+func (e *PlanChangedError) Error() string {
+	return fmt.Sprintf("op %s changed since the assignment plan was made: %d items differ", e.OpID, len(e.Changed))
+}
+
+// CommitAssignmentPlan makes the planned assignments all at once, each one sending its usual notifications, and touches the op once.
+// The op must be populated. If anything in the plan has been deleted or assigned to someone else since it was made,
+// or an agent in it can no longer see the op, nothing is assigned.
+func (o *Operation) CommitAssignmentPlan(gid GoogleID, plan AssignmentPlan) error {
+	if !o.WriteAccess(gid) {
+		err := fmt.Errorf("write access required to assign")
+		Log.Error(err)
+		return err
+	}
+	if plan.ID != o.ID {
+		err := fmt.Errorf("assignment plan is for op %s, not %s", plan.ID, o.ID)
+		Log.Notice(err)
+		return err
+	}
+
+	current := make(map[string]GoogleID)
+	for _, l := range o.Links {
+		current["link:"+string(l.ID)] = l.AssignedTo
+	}
+	for _, m := range o.Markers {
+		current["marker:"+string(m.ID)] = m.AssignedTo
+	}
+	access := make(map[GoogleID]bool)
+	changed := []string{}
+	for _, a := range plan.Assignments {
+		assigned, ok := current[a.Type+":"+a.ID]
+		if !ok || assigned != a.Previous {
+			changed = append(changed, a.ID)
+			continue
+		}
+		if _, checked := access[a.Agent]; !checked {
+			access[a.Agent] = o.ReadAccess(a.Agent) || o.AssignedOnlyAccess(a.Agent)
+		}
+		if !access[a.Agent] {
+			changed = append(changed, a.ID)
+		}
+	}
+	if len(changed) > 0 {
+		err := &PlanChangedError{OpID: o.ID, Changed: changed}
+		Log.Notice(err)
+		return err
+	}
+
+	// the store checks again as it assigns, for anything changed since the op was populated
+	changed, err := store.SetAssignments(o.ID, plan.Assignments)
+	if err != nil {
+		Log.Error(err)
+		return err
+	}
+	if len(changed) > 0 {
+		err := &PlanChangedError{OpID: o.ID, Changed: changed}
+		Log.Notice(err)
+		return err
+	}
+
+	for _, a := range plan.Assignments {
+		if a.Agent == a.Previous {
+			continue
+		}
+		if a.Type == "link" {
+			o.linkAssigned(LinkID(a.ID), a.Agent, gid)
+		} else {
+			o.markerAssigned(MarkerID(a.ID), a.Agent, gid)
+		}
+	}
+	if err := o.Touch(gid, fmt.Sprintf("assignment plan: %d assignments", len(plan.Assignments))); err != nil {
+		Log.Error(err)
+	}
+	return nil
+}
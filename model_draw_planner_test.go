@@ -0,0 +1,133 @@
+package wasabee_test
+
+import (
+	"encoding/json"
+	"github.com/wasabee-project/Wasabee-Server"
+	"io/ioutil"
+	"testing"
+)
+
+func TestPlanAssignments(t *testing.T) {
+	content, err := ioutil.ReadFile("testdata/test2.json")
+	if err != nil {
+		t.Error(err.Error())
+	}
+	if err := wasabee.DrawInsert(json.RawMessage(content), gid); err != nil {
+		t.Error(err.Error())
+	}
+	var in wasabee.Operation
+	if err := json.Unmarshal(content, &in); err != nil {
+		t.Error(err.Error())
+	}
+	op := wasabee.Operation{ID: in.ID}
+	if err := op.Populate(gid); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	r := wasabee.AssignmentRequest{
+		Agents:      []wasabee.GoogleID{gid, "nobody"},
+		MaxPerAgent: 1,
+		Links:       true,
+		Reassign:    true,
+	}
+	plan, err := op.PlanAssignments(gid, r)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(plan.Skipped) != 1 || plan.Skipped[0] != "nobody" {
+		t.Errorf("stranger not skipped: %v", plan.Skipped)
+	}
+	if len(plan.Assignments) != 1 || len(plan.Unassigned) != len(op.Links)-1 {
+		t.Errorf("unexpected plan: %v", plan)
+	}
+
+	r.MaxPerAgent = 0
+	if plan, err = op.PlanAssignments(gid, r); err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(plan.Assignments) != len(op.Links) || plan.Agents[0].Links != len(op.Links) {
+		t.Errorf("unexpected plan: %v", plan)
+	}
+	if err := op.CommitAssignmentPlan(gid, plan); err != nil {
+		t.Error(err.Error())
+	}
+	// the same plan again is stale: every link has been assigned since it was made
+	again := wasabee.Operation{ID: in.ID}
+	if err := again.Populate(gid); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := again.CommitAssignmentPlan(gid, plan); err == nil {
+		t.Error("stale plan committed")
+	} else if pe, ok := err.(*wasabee.PlanChangedError); !ok || len(pe.Changed) != len(op.Links) {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	after := wasabee.Operation{ID: in.ID}
+	if err := after.Populate(gid); err != nil {
+		t.Error(err.Error())
+	}
+	for _, l := range after.Links {
+		if l.AssignedTo != gid {
+			t.Errorf("link %s not assigned", l.ID)
+		}
+	}
+
+	// all or nothing: a link reassigned since the op was populated stops the whole plan
+	pgid := wasabee.GoogleID("104743827901423568956")
+	if _, err := pgid.InitAgent(); err != nil {
+		t.Fatal(err.Error())
+	}
+	defer pgid.Delete()
+	if len(after.Teams) == 0 {
+		t.Fatal("op has no team")
+	}
+	if err := after.Teams[0].TeamID.AddAgent(pgid); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := pgid.SetTeamState(after.Teams[0].TeamID, "On"); err != nil {
+		t.Fatal(err.Error())
+	}
+	move := wasabee.AssignmentPlan{ID: in.ID}
+	for _, l := range after.Links {
+		move.Assignments = append(move.Assignments, wasabee.PlannedAssignment{Type: "link", ID: string(l.ID), Agent: pgid, Previous: gid})
+	}
+	last := after.Links[len(after.Links)-1].ID
+	if err := after.AssignLink(last, "", gid); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := after.CommitAssignmentPlan(gid, move); err == nil {
+		t.Error("plan committed over a changed link")
+	} else if pe, ok := err.(*wasabee.PlanChangedError); !ok || len(pe.Changed) != 1 || pe.Changed[0] != string(last) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	moved := wasabee.Operation{ID: in.ID}
+	if err := moved.Populate(gid); err != nil {
+		t.Error(err.Error())
+	}
+	for _, l := range moved.Links {
+		if l.AssignedTo == pgid {
+			t.Errorf("link %s assigned by a plan that failed", l.ID)
+		}
+	}
+
+	// only the op's own teams
+	r.Agents = nil
+	r.Team = "notonop"
+	if _, err := op.PlanAssignments(gid, r); err == nil {
+		t.Error("planned for a team not on the op")
+	}
+	if len(op.Teams) == 0 {
+		t.Fatal("op has no team")
+	}
+	r.Team = op.Teams[0].TeamID
+	if _, err := op.PlanAssignments(gid, r); err != nil {
+		t.Error(err.Error())
+	}
+
+	if _, err := op.PlanAssignments(wasabee.GoogleID("nobody"), r); err == nil {
+		t.Error("planned without write access")
+	}
+	if err := after.Delete(gid); err != nil {
+		t.Error(err.Error())
+	}
+}
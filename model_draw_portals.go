@@ -1,7 +1,6 @@
 package wasabee
 
 import (
-	"database/sql"
 	"fmt"
 )
 
@@ -20,8 +19,7 @@ type Portal struct {
 
 // insertPortal adds a portal to the database
 func (opID OperationID) insertPortal(p Portal) error {
-	_, err := db.Exec("INSERT IGNORE INTO portal (ID, opID, name, loc, comment, hardness) VALUES (?, ?, ?, POINT(?, ?), ?, ?)",
-		p.ID, opID, p.Name, p.Lon, p.Lat, MakeNullString(p.Comment), MakeNullString(p.Hardness))
+	err := store.InsertPortal(opID, p)
 	if err != nil {
 		Log.Error(err)
 		return err
@@ -31,26 +29,7 @@ func (opID OperationID) insertPortal(p Portal) error {
 
 // insertAnchor adds an anchor to the database
 func (opID OperationID) insertAnchor(p PortalID) error {
-	_, err := db.Exec("INSERT IGNORE INTO anchor (opID, portalID) VALUES (?, ?)", opID, p)
-	if err != nil {
-		Log.Error(err)
-		return err
-	}
-	return nil
-}
-
-func (opID OperationID) updatePortal(p Portal) error {
-	_, err := db.Exec("REPLACE INTO portal (ID, opID, name, loc, comment, hardness) VALUES (?, ?, ?, POINT(?, ?), ?, ?)",
-		p.ID, opID, p.Name, p.Lon, p.Lat, MakeNullString(p.Comment), MakeNullString(p.Hardness))
-	if err != nil {
-		Log.Error(err)
-		return err
-	}
-	return nil
-}
-
-func (opID OperationID) deletePortal(p PortalID) error {
-	_, err := db.Exec("DELETE FROM portal WHERE ID = ? AND opID = ?", p, opID)
+	err := store.InsertAnchor(opID, p)
 	if err != nil {
 		Log.Error(err)
 		return err
@@ -60,55 +39,23 @@ func (opID OperationID) deletePortal(p PortalID) error {
 
 // PopulatePortals fills in the OpPortals list for the Operation. No authorization takes place.
 func (o *Operation) PopulatePortals() error {
-	var tmpPortal Portal
-
-	var comment, hardness sql.NullString
-
-	rows, err := db.Query("SELECT ID, name, Y(loc) AS lat, X(loc) AS lon, comment, hardness FROM portal WHERE opID = ? ORDER BY name", o.ID)
+	portals, err := store.Portals(o.ID)
 	if err != nil {
 		Log.Error(err)
 		return err
 	}
-	defer rows.Close()
-	for rows.Next() {
-		err := rows.Scan(&tmpPortal.ID, &tmpPortal.Name, &tmpPortal.Lat, &tmpPortal.Lon, &comment, &hardness)
-		if err != nil {
-			Log.Error(err)
-			continue
-		}
-		if comment.Valid {
-			tmpPortal.Comment = comment.String
-		} else {
-			tmpPortal.Comment = ""
-		}
-		if hardness.Valid {
-			tmpPortal.Hardness = hardness.String
-		} else {
-			tmpPortal.Hardness = ""
-		}
-
-		o.OpPortals = append(o.OpPortals, tmpPortal)
-	}
+	o.OpPortals = append(o.OpPortals, portals...)
 	return nil
 }
 
 // PopulateAnchors fills in the Anchors list for the Operation. No authorization takes place.
 func (o *Operation) PopulateAnchors() error {
-	var anchor PortalID
-	rows, err := db.Query("SELECT portalID FROM anchor WHERE opID = ?", o.ID)
+	anchors, err := store.Anchors(o.ID)
 	if err != nil {
 		Log.Error(err)
 		return err
 	}
-	defer rows.Close()
-	for rows.Next() {
-		err := rows.Scan(&anchor)
-		if err != nil {
-			Log.Error(err)
-			continue
-		}
-		o.Anchors = append(o.Anchors, anchor)
-	}
+	o.Anchors = append(o.Anchors, anchors...)
 	return nil
 }
 
@@ -118,26 +65,42 @@ func (p PortalID) String() string {
 }
 
 // PortalHardness updates the comment on a portal
-func (o *Operation) PortalHardness(portalID PortalID, hardness string) error {
-	_, err := db.Exec("UPDATE portal SET hardness = ? WHERE ID = ? AND opID = ?", MakeNullString(hardness), portalID, o.ID)
+func (o *Operation) PortalHardness(portalID PortalID, hardness string, gid GoogleID) error {
+	err := store.SetPortalHardness(o.ID, portalID, hardness)
 	if err != nil {
 		Log.Error(err)
 		return err
 	}
-	if err = o.Touch(); err != nil {
+	o.ID.emit(Event{
+		Type:     EventPortalHardness,
+		ObjectID: portalID.String(),
+		Gid:      gid,
+		Action:   "hardness",
+		Detail:   hardness,
+		Data:     map[string]string{"hardness": hardness},
+	})
+	if err = o.Touch(gid, "portal hardness "+portalID.String()); err != nil {
 		Log.Error(err)
 	}
 	return nil
 }
 
 // PortalComment updates the comment on a portal
-func (o *Operation) PortalComment(portalID PortalID, comment string) error {
-	_, err := db.Exec("UPDATE portal SET comment = ? WHERE ID = ? AND opID = ?", MakeNullString(comment), portalID, o.ID)
+func (o *Operation) PortalComment(portalID PortalID, comment string, gid GoogleID) error {
+	err := store.SetPortalComment(o.ID, portalID, comment)
 	if err != nil {
 		Log.Error(err)
 		return err
 	}
-	if err = o.Touch(); err != nil {
+	o.ID.emit(Event{
+		Type:     EventPortalComment,
+		ObjectID: portalID.String(),
+		Gid:      gid,
+		Action:   "comment",
+		Detail:   comment,
+		Data:     map[string]string{"comment": comment},
+	})
+	if err = o.Touch(gid, "portal comment "+portalID.String()); err != nil {
 		Log.Error(err)
 	}
 	return nil
@@ -147,15 +110,15 @@ func (o *Operation) PortalComment(portalID PortalID, comment string) error {
 func (o *Operation) PortalDetails(portalID PortalID, gid GoogleID) (Portal, error) {
 	var p Portal
 	p.ID = portalID
-	var teamID TeamID
 
-	err := db.QueryRow("SELECT teamID FROM operation WHERE ID = ?", o.ID).Scan(&teamID)
+	op := Operation{ID: o.ID}
+	err := store.Operation(&op)
 	if err != nil {
 		Log.Error(err)
 		return p, err
 	}
 	var inteam bool
-	inteam, err = gid.AgentInTeam(teamID, false)
+	inteam, err = gid.AgentInTeam(op.TeamIDdep, false)
 	if err != nil {
 		Log.Error(err)
 		return p, err
@@ -166,17 +129,10 @@ func (o *Operation) PortalDetails(portalID PortalID, gid GoogleID) (Portal, erro
 		return p, err
 	}
 
-	var comment, hardness sql.NullString
-	err = db.QueryRow("SELECT name, Y(loc) AS lat, X(loc) AS lon, comment, hardness FROM portal WHERE opID = ? AND ID = ?", o.ID, portalID).Scan(&p.Name, &p.Lat, &p.Lon, &comment, &hardness)
+	p, err = store.Portal(o.ID, portalID)
 	if err != nil {
 		Log.Error(err)
 		return p, err
 	}
-	if comment.Valid {
-		p.Comment = comment.String
-	}
-	if hardness.Valid {
-		p.Hardness = hardness.String
-	}
 	return p, nil
 }
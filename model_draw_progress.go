@@ -0,0 +1,303 @@
+package wasabee
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// OpProgress is how an op is going, worked out from its links and markers as they are now and from the audit log
+type OpProgress struct {
+	ID         OperationID         `json:"ID"`
+	Generated  string              `json:"generated"`
+	Links      LinkProgress        `json:"links"`
+	Markers    map[string]int      `json:"markers"` // by state: pending, assigned, acknowledged, completed
+	Agents     []AgentProgress     `json:"agents"`
+	Rejections []ProgressRejection `json:"rejections"`
+	Idle       []IdleAssignee      `json:"idle"`
+	History    []ProgressPoint     `json:"history"` // the most recent progressHistoryEntries changes of each kind
+}
+
+// LinkProgress counts the op's links
+type LinkProgress struct {
+	Total     int `json:"total"`
+	Assigned  int `json:"assigned"`
+	Completed int `json:"completed"`
+}
+
+// AgentProgress is how far one agent has got with their assignments
+type AgentProgress struct {
+	Gid              GoogleID `json:"gid"`
+	Name             string   `json:"name"`
+	Links            int      `json:"links"`
+	LinksCompleted   int      `json:"linksCompleted"`
+	Markers          int      `json:"markers"`
+	MarkersCompleted int      `json:"markersCompleted"`
+	Rate             float64  `json:"rate"` // completed / assigned, 0 to 1
+}
+
+// ProgressRejection is a marker an agent turned down which nobody has taken since
+type ProgressRejection struct {
+	MarkerID MarkerID `json:"markerId"`
+	PortalID PortalID `json:"portalId"`
+	Type     string   `json:"type"`
+	Gid      GoogleID `json:"gid"`
+	Name     string   `json:"name"`
+	Rejected string   `json:"rejected"`
+}
+
+// IdleAssignee is an agent with markers they have not acknowledged
+type IdleAssignee struct {
+	Gid     GoogleID   `json:"gid"`
+	Name    string     `json:"name"`
+	Markers []MarkerID `json:"markers"`
+	Since   string     `json:"since,omitempty"` // when the oldest was assigned, if known
+}
+
+// ProgressPoint is the number of links and markers done at a time the count changed
+type ProgressPoint struct {
+	Time             string `json:"time"`
+	LinksCompleted   int    `json:"linksCompleted"`
+	MarkersCompleted int    `json:"markersCompleted"`
+}
+
+// progressHistoryEntries is how many of the latest link and marker audit entries the report reads,
+// so a long-running op does not have its whole log read on every request
+const progressHistoryEntries = auditMaxLimit
+
+// Progress reports on the op; it needs write access, as it shows how each agent is doing
+func (o *Operation) Progress(gid GoogleID) (OpProgress, error) {
+	p := OpProgress{
+		ID:         o.ID,
+		Generated:  time.Now().UTC().Format(time.RFC3339),
+		Markers:    map[string]int{"pending": 0, "assigned": 0, "acknowledged": 0, "completed": 0},
+		Agents:     []AgentProgress{},
+		Rejections: []ProgressRejection{},
+		Idle:       []IdleAssignee{},
+		History:    []ProgressPoint{},
+	}
+	if !o.WriteAccess(gid) {
+		err := fmt.Errorf("permission denied: %s viewing progress of op %s", gid, o.ID)
+		Log.Error(err)
+		return p, err
+	}
+
+	links, err := store.Links(o.ID)
+	if err != nil {
+		Log.Error(err)
+		return p, err
+	}
+	markers, err := store.Markers(o.ID)
+	if err != nil {
+		Log.Error(err)
+		return p, err
+	}
+	history, err := o.ID.auditHistory()
+	if err != nil {
+		Log.Error(err)
+		return p, err
+	}
+
+	agents := make(map[GoogleID]*AgentProgress)
+	agent := func(g GoogleID, name string) *AgentProgress {
+		if agents[g] == nil {
+			agents[g] = &AgentProgress{Gid: g}
+		}
+		if name != "" {
+			agents[g].Name = name
+		}
+		return agents[g]
+	}
+
+	for _, l := range links {
+		p.Links.Total++
+		if l.Completed {
+			p.Links.Completed++
+		}
+		if l.AssignedTo == "" {
+			continue
+		}
+		p.Links.Assigned++
+		a := agent(l.AssignedTo, l.Iname)
+		a.Links++
+		if l.Completed {
+			a.LinksCompleted++
+		}
+	}
+
+	// when each marker was last assigned or rejected, and by whom
+	lastAssigned := make(map[string]string)
+	lastRejected := make(map[string]AuditEntry)
+	for _, e := range history {
+		if e.Type != "marker" {
+			continue
+		}
+		switch e.Action {
+		case "assign":
+			lastAssigned[e.ObjectID] = e.Recorded
+			delete(lastRejected, e.ObjectID)
+		case "reject":
+			lastRejected[e.ObjectID] = e
+		}
+	}
+
+	idle := make(map[GoogleID]*IdleAssignee)
+	for _, m := range markers {
+		state := m.State
+		if state == "" {
+			state = "pending"
+		}
+		p.Markers[state]++
+
+		if m.AssignedTo == "" {
+			if r, ok := lastRejected[string(m.ID)]; ok {
+				p.Rejections = append(p.Rejections, ProgressRejection{
+					MarkerID: m.ID,
+					PortalID: m.PortalID,
+					Type:     string(m.Type),
+					Gid:      r.Gid,
+					Name:     r.Name,
+					Rejected: r.Recorded,
+				})
+			}
+			continue
+		}
+		a := agent(m.AssignedTo, m.IngressName)
+		a.Markers++
+		if state == "completed" {
+			a.MarkersCompleted++
+		}
+		if state == "assigned" {
+			if idle[m.AssignedTo] == nil {
+				idle[m.AssignedTo] = &IdleAssignee{Gid: m.AssignedTo, Name: m.IngressName}
+			}
+			i := idle[m.AssignedTo]
+			i.Markers = append(i.Markers, m.ID)
+			if at, ok := lastAssigned[string(m.ID)]; ok && (i.Since == "" || at < i.Since) {
+				i.Since = at
+			}
+		}
+	}
+
+	for _, a := range agents {
+		if total := a.Links + a.Markers; total > 0 {
+			a.Rate = float64(a.LinksCompleted+a.MarkersCompleted) / float64(total)
+		}
+		p.Agents = append(p.Agents, *a)
+	}
+	sort.Slice(p.Agents, func(i, j int) bool { return p.Agents[i].Gid < p.Agents[j].Gid })
+	for _, i := range idle {
+		p.Idle = append(p.Idle, *i)
+	}
+	// longest idle first; unknown last
+	sort.Slice(p.Idle, func(i, j int) bool {
+		if (p.Idle[i].Since == "") != (p.Idle[j].Since == "") {
+			return p.Idle[j].Since == ""
+		}
+		if p.Idle[i].Since != p.Idle[j].Since {
+			return p.Idle[i].Since < p.Idle[j].Since
+		}
+		return p.Idle[i].Gid < p.Idle[j].Gid
+	})
+
+	p.History = progressHistory(history, links, markers)
+	return p, nil
+}
+
+// auditHistory is the op's latest link and marker audit entries, oldest first
+func (opID OperationID) auditHistory() ([]AuditEntry, error) {
+	var history []AuditEntry
+	for _, t := range []string{"link", "marker"} {
+		page, err := store.AuditEntries(opID, AuditFilter{Type: t, Limit: progressHistoryEntries})
+		if err != nil {
+			return nil, err
+		}
+		history = append(history, page...)
+	}
+	sort.SliceStable(history, func(i, j int) bool {
+		if history[i].Recorded != history[j].Recorded {
+			return history[i].Recorded < history[j].Recorded
+		}
+		return history[i].ID < history[j].ID
+	})
+	return history, nil
+}
+
+// progressHistory replays completions from the audit log. Only links and markers still in the op are counted,
+// since the log outlives deleted objects. Changes recorded in the same second make one point.
+// The log is read from part way through, so each object starts as it was before its first entry,
+// or as it is now if it has none.
+func progressHistory(history []AuditEntry, links []Link, markers []Marker) []ProgressPoint {
+	done := make(map[string]bool)
+	for _, l := range links {
+		done["link:"+string(l.ID)] = l.Completed
+	}
+	for _, m := range markers {
+		done["marker:"+string(m.ID)] = m.State == "completed"
+	}
+	seen := make(map[string]bool)
+	for _, e := range history {
+		key := e.Type + ":" + e.ObjectID
+		if _, ok := done[key]; !ok || seen[key] {
+			continue
+		}
+		switch e.Action {
+		case "complete", "reject":
+			seen[key] = true
+			done[key] = false
+		case "incomplete":
+			seen[key] = true
+			done[key] = true
+		}
+	}
+
+	var l, m int
+	for key, d := range done {
+		if !d {
+			continue
+		}
+		if strings.HasPrefix(key, "link:") {
+			l++
+		} else {
+			m++
+		}
+	}
+
+	points := []ProgressPoint{}
+	for _, e := range history {
+		key := e.Type + ":" + e.ObjectID
+		if _, ok := done[key]; !ok {
+			continue
+		}
+		var now bool
+		switch e.Action {
+		case "complete":
+			now = true
+		case "incomplete", "reject":
+			now = false
+		default:
+			continue
+		}
+		if done[key] == now {
+			continue
+		}
+		done[key] = now
+		d := -1
+		if now {
+			d = 1
+		}
+		if e.Type == "link" {
+			l += d
+		} else {
+			m += d
+		}
+
+		if n := len(points); n > 0 && points[n-1].Time == e.Recorded {
+			points[n-1].LinksCompleted, points[n-1].MarkersCompleted = l, m
+			continue
+		}
+		points = append(points, ProgressPoint{Time: e.Recorded, LinksCompleted: l, MarkersCompleted: m})
+	}
+	return points
+}
@@ -0,0 +1,110 @@
+package wasabee_test
+
+import (
+	"encoding/json"
+	"github.com/wasabee-project/Wasabee-Server"
+	"testing"
+)
+
+func TestProgress(t *testing.T) {
+	pgid := wasabee.GoogleID("104743827901423568952")
+	if _, err := pgid.InitAgent(); err != nil {
+		t.Fatal(err.Error())
+	}
+	defer pgid.Delete()
+
+	orig := wasabee.Operation{
+		ID:   "progress",
+		Name: "progress",
+		OpPortals: []wasabee.Portal{
+			{ID: "A", Name: "A", Lat: "0", Lon: "0"},
+			{ID: "B", Name: "B", Lat: "0", Lon: "1"},
+			{ID: "C", Name: "C", Lat: "1", Lon: "0"},
+		},
+		Links: []wasabee.Link{
+			{ID: "AB", From: "A", To: "B", AssignedTo: pgid},
+			{ID: "BC", From: "B", To: "C", AssignedTo: gid},
+			{ID: "CA", From: "C", To: "A"},
+		},
+		Markers: []wasabee.Marker{
+			{ID: "MA", PortalID: "A", Type: "DestroyPortalAlert"},
+			{ID: "MB", PortalID: "B", Type: "DestroyPortalAlert"},
+			{ID: "MC", PortalID: "C", Type: "CaptureAlert"},
+		},
+	}
+	j, _ := json.Marshal(orig)
+	if err := wasabee.DrawInsert(j, gid); err != nil {
+		t.Fatal(err.Error())
+	}
+	defer orig.Delete(gid)
+	op := wasabee.Operation{ID: orig.ID}
+
+	for _, m := range []wasabee.MarkerID{"MA", "MB"} {
+		if err := op.AssignMarker(m, pgid, gid); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+	if err := op.AssignMarker("MC", gid, gid); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := op.LinkCompleted("AB", true, pgid); err != nil {
+		t.Error(err.Error())
+	}
+	if err := wasabee.MarkerID("MB").Acknowledge(&op, pgid); err != nil {
+		t.Error(err.Error())
+	}
+	if err := wasabee.MarkerID("MB").Complete(op, gid); err != nil {
+		t.Error(err.Error())
+	}
+	if err := wasabee.MarkerID("MC").Reject(&op, gid); err != nil {
+		t.Error(err.Error())
+	}
+
+	if _, err := op.Progress(pgid); err == nil {
+		t.Error("agent without write access saw progress")
+	}
+
+	// the history comes from the audit log, which is written as events are delivered
+	var p wasabee.OpProgress
+	waitFor(t, func() bool {
+		var err error
+		p, err = op.Progress(gid)
+		return err == nil && len(p.Rejections) == 1 && len(p.History) > 0 && p.History[len(p.History)-1].MarkersCompleted == 1
+	})
+
+	if p.Links.Total != 3 || p.Links.Assigned != 2 || p.Links.Completed != 1 {
+		t.Errorf("links %v", p.Links)
+	}
+	if p.Markers["pending"] != 1 || p.Markers["assigned"] != 1 || p.Markers["completed"] != 1 || p.Markers["acknowledged"] != 0 {
+		t.Errorf("markers %v", p.Markers)
+	}
+	for _, a := range p.Agents {
+		if a.Gid == pgid && (a.Links != 1 || a.LinksCompleted != 1 || a.Markers != 2 || a.MarkersCompleted != 1 || a.Rate < 0.66 || a.Rate > 0.67) {
+			t.Errorf("agent %v", a)
+		}
+	}
+	if r := p.Rejections[0]; r.MarkerID != "MC" || r.Gid != gid || r.Rejected == "" {
+		t.Errorf("rejection %v", r)
+	}
+	if len(p.Idle) != 1 || p.Idle[0].Gid != pgid || len(p.Idle[0].Markers) != 1 || p.Idle[0].Markers[0] != "MA" || p.Idle[0].Since == "" {
+		t.Errorf("idle %v", p.Idle)
+	}
+	if last := p.History[len(p.History)-1]; last.LinksCompleted != 1 {
+		t.Errorf("history %v", p.History)
+	}
+
+	// a link uploaded already done counts too
+	up := orig
+	up.Links = append(up.Links, wasabee.Link{ID: "BA", From: "B", To: "A", Completed: true})
+	j, _ = json.Marshal(up)
+	if err := wasabee.DrawUpdate(op.ID, j, gid, wasabee.OpPrecondition{}); err != nil {
+		t.Fatal(err.Error())
+	}
+	waitFor(t, func() bool {
+		entries, err := op.Audit(gid, wasabee.AuditFilter{Type: "link", ObjectID: "BA"})
+		return err == nil && len(entries) == 1 && entries[0].Action == "complete"
+	})
+	if p, err := op.Progress(gid); err != nil || p.History[len(p.History)-1].LinksCompleted != 2 {
+		t.Errorf("history %v %v", p.History, err)
+	}
+}
@@ -0,0 +1,368 @@
+package wasabee
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// mapsWaypoints is how many waypoints Google Maps takes between the origin and destination
+const mapsWaypoints = 9
+
+// RouteStop is one portal on an agent's route and what to do there
+type RouteStop struct {
+	Step     int        `json:"step"`
+	PortalID PortalID   `json:"portalId"`
+	Name     string     `json:"name"`
+	Lat      string     `json:"lat"`
+	Lon      string     `json:"lng"`
+	Links    []LinkID   `json:"links"`    // to throw here, in order
+	Markers  []MarkerID `json:"markers"`  // to do here
+	Distance float64    `json:"distance"` // meters from the previous stop, or from the start
+}
+
+// Route is the order an agent should visit the portals for their assignments in an op
+type Route struct {
+	ID       OperationID `json:"ID"`
+	Gid      GoogleID    `json:"gid"`
+	StartLat string      `json:"startLat,omitempty"`
+	StartLon string      `json:"startLng,omitempty"`
+	Distance float64     `json:"distance"` // meters
+	Stops    []RouteStop `json:"stops"`
+	MapURLs  []string    `json:"mapURLs"` // one per leg of at most mapsWaypoints waypoints
+}
+
+// routeItem is a link or marker to be visited
+type routeItem struct {
+	portal PortalID
+	link   LinkID
+	to     PortalID
+	order  int32
+	marker MarkerID
+}
+
+// Route works out a walking order for all of the agent's assigned links and markers in an op, starting from the given location,
+// or the agent's last known location if none is given. A link which closes a field with the agent's earlier links in the throw order
+// is never visited before them, nor before the links thrown from inside that field; everything else is free to move. The order is found nearest-first and then improved with 2-opt.
+func (gid GoogleID) Route(opID OperationID, startLat, startLon string) (Route, error) {
+	r := Route{ID: opID, Gid: gid, Stops: []RouteStop{}, MapURLs: []string{}}
+
+	var a Assignments
+	if err := gid.Assignments(opID, &a); err != nil {
+		Log.Error(err)
+		return r, err
+	}
+
+	if startLat == "" || startLon == "" {
+		if lat, lon, err := store.AgentLastLocation(gid); err == nil {
+			startLat, startLon = fmt.Sprint(lat), fmt.Sprint(lon)
+		}
+	}
+	r.StartLat, r.StartLon = startLat, startLon
+
+	links := a.Links
+	sort.SliceStable(links, func(i, j int) bool { return links[i].ThrowOrder < links[j].ThrowOrder })
+
+	var items []routeItem
+	for _, l := range links {
+		if _, ok := a.Portals[l.From]; ok && !l.Completed {
+			items = append(items, routeItem{portal: l.From, link: l.ID, to: l.To, order: l.ThrowOrder})
+		}
+	}
+	for _, m := range a.Markers {
+		if _, ok := a.Portals[m.PortalID]; ok && m.State != "completed" {
+			items = append(items, routeItem{portal: m.PortalID, marker: m.ID})
+		}
+	}
+	if len(items) == 0 {
+		return r, nil
+	}
+
+	// after[i][j]: item j must come after item i
+	n := len(items)
+	after := make([][]bool, n)
+	for i := range after {
+		after[i] = make([]bool, n)
+	}
+	// a link which closes a field with two earlier links must be thrown after both
+	earlier := func(i, j int) bool {
+		return items[i].link != "" && items[j].link != "" && items[i].order < items[j].order
+	}
+	joins := func(i int, a, b PortalID) bool {
+		return (items[i].portal == a && items[i].to == b) || (items[i].portal == b && items[i].to == a)
+	}
+	// and a link thrown from inside that field must be thrown before it, as it cannot be once the field is up
+	portals := make([]Portal, 0, len(a.Portals))
+	for _, p := range a.Portals {
+		portals = append(portals, p)
+	}
+	g, _ := (&Operation{OpPortals: portals}).geometry()
+	for j := 0; j < n; j++ {
+		var fields [][3]PortalID
+		for i := 0; i < n; i++ {
+			if !earlier(i, j) {
+				continue
+			}
+			var s, x, y PortalID // the end i and j share, and the ends which are not shared
+			switch {
+			case items[i].portal == items[j].portal:
+				s, x, y = items[i].portal, items[i].to, items[j].to
+			case items[i].portal == items[j].to:
+				s, x, y = items[i].portal, items[i].to, items[j].portal
+			case items[i].to == items[j].portal:
+				s, x, y = items[i].to, items[i].portal, items[j].to
+			case items[i].to == items[j].to:
+				s, x, y = items[i].to, items[i].portal, items[j].portal
+			default:
+				continue
+			}
+			for k := 0; k < n; k++ {
+				if k != i && earlier(k, j) && joins(k, x, y) {
+					after[i][j] = true
+					after[k][j] = true
+					fields = append(fields, [3]PortalID{s, x, y})
+				}
+			}
+		}
+		for m := 0; m < n; m++ {
+			if m == j || items[m].link == "" {
+				continue
+			}
+			if _, inside := g.fieldAround(items[m].portal, fields); inside {
+				after[m][j] = true
+			}
+		}
+	}
+
+	// distances between items; the start is index n
+	dist := make([][]float64, n+1)
+	for i := range dist {
+		dist[i] = make([]float64, n+1)
+	}
+	located := startLat != "" && startLon != ""
+	for i := 0; i < n; i++ {
+		pi := a.Portals[items[i].portal]
+		for j := i + 1; j < n; j++ {
+			if items[i].portal == items[j].portal {
+				continue
+			}
+			pj := a.Portals[items[j].portal]
+			dist[i][j] = Distance(pi.Lat, pi.Lon, pj.Lat, pj.Lon)
+			dist[j][i] = dist[i][j]
+		}
+		if located {
+			dist[n][i] = Distance(startLat, startLon, pi.Lat, pi.Lon)
+			dist[i][n] = dist[n][i]
+		}
+	}
+
+	order := routeNearest(n, dist, after)
+	routeTwoOpt(order, dist, after, n)
+
+	prev := n
+	for _, i := range order {
+		it := items[i]
+		d := 0.0
+		if prev != n || located {
+			d = dist[prev][i]
+		}
+		r.Distance += d
+		prev = i
+
+		if len(r.Stops) == 0 || r.Stops[len(r.Stops)-1].PortalID != it.portal {
+			p := a.Portals[it.portal]
+			r.Stops = append(r.Stops, RouteStop{
+				Step:     len(r.Stops) + 1,
+				PortalID: p.ID,
+				Name:     p.Name,
+				Lat:      p.Lat,
+				Lon:      p.Lon,
+				Links:    []LinkID{},
+				Markers:  []MarkerID{},
+				Distance: d,
+			})
+		}
+		s := &r.Stops[len(r.Stops)-1]
+		if it.link != "" {
+			s.Links = append(s.Links, it.link)
+		} else {
+			s.Markers = append(s.Markers, it.marker)
+		}
+	}
+	r.MapURLs = r.mapURLs()
+	return r, nil
+}
+
+// routeNearest builds an order by always walking to the nearest item whose prerequisites are done
+func routeNearest(n int, dist [][]float64, after [][]bool) []int {
+	done := make([]bool, n)
+	order := make([]int, 0, n)
+	at := n
+	for len(order) < n {
+		best := -1
+		for j := 0; j < n; j++ {
+			if done[j] || !routeReady(j, done, after) {
+				continue
+			}
+			if best == -1 || dist[at][j] < dist[at][best] {
+				best = j
+			}
+		}
+		if best == -1 {
+			// only a plan which throws from inside its own fields gets here; take the nearest and carry on
+			for j := 0; j < n; j++ {
+				if !done[j] && (best == -1 || dist[at][j] < dist[at][best]) {
+					best = j
+				}
+			}
+		}
+		done[best] = true
+		order = append(order, best)
+		at = best
+	}
+	return order
+}
+
+func routeReady(j int, done []bool, after [][]bool) bool {
+	for i := range after {
+		if after[i][j] && !done[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// routeTwoOpt reverses stretches of the order while that shortens the walk and keeps every item after its prerequisites
+func routeTwoOpt(order []int, dist [][]float64, after [][]bool, start int) {
+	n := len(order)
+	// prereqs[j]: the items j must come after
+	prereqs := make([][]int, len(after))
+	for i := range after {
+		for j := range after[i] {
+			if after[i][j] {
+				prereqs[j] = append(prereqs[j], i)
+			}
+		}
+	}
+	at := func(k int) int {
+		if k < 0 {
+			return start
+		}
+		return order[k]
+	}
+
+	pos := make([]int, len(after))
+	// latest[k]: the position of the last prerequisite of the item at k which is before it, or -1
+	latest := make([]int, n)
+	for improved := true; improved; {
+		improved = false
+		for k, it := range order {
+			pos[it] = k
+		}
+		for k, it := range order {
+			latest[k] = -1
+			for _, p := range prereqs[it] {
+				if pos[p] < k && pos[p] > latest[k] {
+					latest[k] = pos[p]
+				}
+			}
+		}
+
+	pass:
+		for i := 0; i < n-1; i++ {
+			for j := i + 1; j < n; j++ {
+				// reversing puts the item at j ahead of everything from i on; if one of those is a prerequisite, so it is for any longer stretch
+				if latest[j] >= i {
+					break
+				}
+				// the walk is open ended, so reversing up to the last item only changes one edge
+				before := dist[at(i-1)][order[i]]
+				changed := dist[at(i-1)][order[j]]
+				if j+1 < n {
+					before += dist[order[j]][order[j+1]]
+					changed += dist[order[i]][order[j+1]]
+				}
+				if changed >= before-1e-6 {
+					continue
+				}
+				for x, y := i, j; x < y; x, y = x+1, y-1 {
+					order[x], order[y] = order[y], order[x]
+				}
+				// the positions have moved, start a new pass
+				improved = true
+				break pass
+			}
+		}
+	}
+}
+
+// mapURLs splits the route into Google Maps directions, each leg starting where the last ended
+func (r Route) mapURLs() []string {
+	var points []string
+	if r.StartLat != "" && r.StartLon != "" {
+		points = append(points, r.StartLat+","+r.StartLon)
+	}
+	for _, s := range r.Stops {
+		points = append(points, s.Lat+","+s.Lon)
+	}
+
+	urls := []string{}
+	if len(points) < 2 {
+		if len(points) == 1 {
+			urls = append(urls, "https://maps.google.com/maps/dir/?api=1&travelmode=walking&destination="+url.QueryEscape(points[0]))
+		}
+		return urls
+	}
+	for i := 0; i < len(points)-1; i += mapsWaypoints + 1 {
+		end := i + mapsWaypoints + 1
+		if end > len(points)-1 {
+			end = len(points) - 1
+		}
+		u := fmt.Sprintf("https://maps.google.com/maps/dir/?api=1&travelmode=walking&origin=%s&destination=%s", url.QueryEscape(points[i]), url.QueryEscape(points[end]))
+		if end > i+1 {
+			u += "&waypoints=" + url.QueryEscape(strings.Join(points[i+1:end], "|"))
+		}
+		urls = append(urls, u)
+	}
+	return urls
+}
+
+type gpxDoc struct {
+	XMLName xml.Name `xml:"gpx"`
+	XMLNS   string   `xml:"xmlns,attr"`
+	Version string   `xml:"version,attr"`
+	Creator string   `xml:"creator,attr"`
+	Wpts    []gpxWpt `xml:"wpt"`
+	Rte     struct {
+		Name string   `xml:"name"`
+		Pts  []gpxWpt `xml:"rtept"`
+	} `xml:"rte"`
+}
+
+// GPX writes the route as a GPX 1.1 route, with a waypoint for each stop
+func (r Route) GPX(w io.Writer) error {
+	doc := gpxDoc{XMLNS: "http://www.topografix.com/GPX/1/1", Version: "1.1", Creator: "Wasabee"}
+	doc.Rte.Name = string(r.ID)
+	for _, s := range r.Stops {
+		var do []string
+		for _, l := range s.Links {
+			do = append(do, "link "+string(l))
+		}
+		for _, m := range s.Markers {
+			do = append(do, "marker "+string(m))
+		}
+		p := gpxWpt{Lat: s.Lat, Lon: s.Lon, Name: fmt.Sprintf("%d: %s", s.Step, s.Name), Desc: strings.Join(do, ", ")}
+		doc.Wpts = append(doc.Wpts, p)
+		doc.Rte.Pts = append(doc.Rte.Pts, p)
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	e := xml.NewEncoder(w)
+	e.Indent("", "  ")
+	return e.Encode(doc)
+}
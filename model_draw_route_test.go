@@ -0,0 +1,166 @@
+package wasabee_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/wasabee-project/Wasabee-Server"
+	"strings"
+	"testing"
+)
+
+func TestRoute(t *testing.T) {
+	// a row of portals each linking to an anchor, thrown in a scattered order, then a link across the bottom closing a field
+	op := wasabee.Operation{ID: "route", Name: "route", Color: "main"}
+	op.OpPortals = append(op.OpPortals, wasabee.Portal{ID: "A", Name: "anchor", Lat: "0.01", Lon: "0.005"})
+	for i := 0; i <= 10; i++ {
+		p := wasabee.PortalID(fmt.Sprintf("P%d", i))
+		op.OpPortals = append(op.OpPortals, wasabee.Portal{ID: p, Name: string(p), Lat: "0", Lon: fmt.Sprintf("%.3f", 0.001*float64(i))})
+		op.Links = append(op.Links, wasabee.Link{ID: wasabee.LinkID(fmt.Sprintf("L%d", i)), From: p, To: "A", ThrowOrder: int32(i*7%11 + 1)})
+	}
+	op.Links = append(op.Links, wasabee.Link{ID: "C", From: "P0", To: "P10", ThrowOrder: 12})
+	op.Markers = append(op.Markers, wasabee.Marker{ID: "M", PortalID: "P5", Type: "DestroyPortalAlert"})
+
+	j, _ := json.Marshal(op)
+	if err := wasabee.DrawInsert(j, gid); err != nil {
+		t.Fatal(err.Error())
+	}
+	defer op.Delete(gid)
+	for _, l := range op.Links {
+		if err := op.AssignLink(l.ID, gid, gid); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+	if err := op.AssignMarker("M", gid, gid); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	r, err := gid.Route(op.ID, "0", "-0.001")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	// where each link and marker comes in the walk
+	step := make(map[string]int)
+	var walked float64
+	for _, st := range r.Stops {
+		for _, l := range st.Links {
+			if _, ok := step[string(l)]; ok {
+				t.Errorf("%s visited twice", l)
+			}
+			step[string(l)] = len(step)
+		}
+		for _, m := range st.Markers {
+			step[string(m)] = len(step)
+		}
+		walked += st.Distance
+	}
+	if len(step) != 13 {
+		t.Errorf("unexpected stops: %v", r.Stops)
+	}
+	if step["C"] <= step["L0"] || step["C"] <= step["L10"] {
+		t.Errorf("field closed too early: %v", r.Stops)
+	}
+	for _, st := range r.Stops {
+		if len(st.Markers) == 1 && st.PortalID != "P5" {
+			t.Errorf("marker not on its portal's stop: %v", st)
+		}
+	}
+	if walked != r.Distance {
+		t.Errorf("stop distances add up to %f, not %f", walked, r.Distance)
+	}
+
+	// walking in throw order zig-zags along the row
+	var naive float64
+	lat, lon := "0", "-0.001"
+	for o := int32(1); o <= 12; o++ {
+		for _, l := range op.Links {
+			if l.ThrowOrder != o {
+				continue
+			}
+			for _, p := range op.OpPortals {
+				if p.ID == l.From {
+					naive += wasabee.Distance(lat, lon, p.Lat, p.Lon)
+					lat, lon = p.Lat, p.Lon
+				}
+			}
+		}
+	}
+	if r.Distance >= naive || r.Distance > 2400 {
+		t.Errorf("route of %fm is no better than throw order's %fm", r.Distance, naive)
+	}
+
+	// the start and at least 11 stops make two legs
+	if len(r.MapURLs) != 2 || !strings.Contains(r.MapURLs[0], "origin=0%2C-0.001") || strings.Count(r.MapURLs[0], "%7C") != 8 {
+		t.Errorf("unexpected map urls: %v", r.MapURLs)
+	}
+
+	var b bytes.Buffer
+	if err := r.GPX(&b); err != nil {
+		t.Error(err.Error())
+	}
+	if strings.Count(b.String(), "<rtept") != len(r.Stops) {
+		t.Errorf("unexpected gpx: %s", b.String())
+	}
+
+	// thrown links are left off, as done markers are
+	if err := op.LinkCompleted("L3", true, gid); err != nil {
+		t.Fatal(err.Error())
+	}
+	if r, err = gid.Route(op.ID, "0", "-0.001"); err != nil {
+		t.Fatal(err.Error())
+	}
+	for _, st := range r.Stops {
+		for _, l := range st.Links {
+			if l == "L3" {
+				t.Errorf("completed link routed: %v", st)
+			}
+		}
+	}
+}
+
+func TestRouteInnerPortal(t *testing.T) {
+	// the field A, B, C closes at C, where the walk already is, and I by A is the farthest stop; its link must still come first
+	op := wasabee.Operation{ID: "routeinner", Name: "routeinner", Color: "main"}
+	op.OpPortals = []wasabee.Portal{
+		{ID: "A", Name: "A", Lat: "0.01", Lon: "0.005"},
+		{ID: "B", Name: "B", Lat: "0", Lon: "0"},
+		{ID: "C", Name: "C", Lat: "0", Lon: "0.01"},
+		{ID: "I", Name: "I", Lat: "0.0095", Lon: "0.005"},
+	}
+	op.Links = []wasabee.Link{
+		{ID: "IA", From: "I", To: "A", ThrowOrder: 1},
+		{ID: "BA", From: "B", To: "A", ThrowOrder: 2},
+		{ID: "CA", From: "C", To: "A", ThrowOrder: 3},
+		{ID: "CB", From: "C", To: "B", ThrowOrder: 4},
+	}
+	j, _ := json.Marshal(op)
+	if err := wasabee.DrawInsert(j, gid); err != nil {
+		t.Fatal(err.Error())
+	}
+	defer op.Delete(gid)
+	for _, l := range op.Links {
+		if err := op.AssignLink(l.ID, gid, gid); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+
+	r, err := gid.Route(op.ID, "0", "-0.001")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	step := make(map[wasabee.LinkID]int)
+	for _, st := range r.Stops {
+		for _, l := range st.Links {
+			step[l] = len(step)
+		}
+	}
+	if len(step) != 4 {
+		t.Fatalf("unexpected stops: %v", r.Stops)
+	}
+	if step["IA"] > step["CB"] {
+		t.Errorf("link from inside the field thrown after it closed: %v", r.Stops)
+	}
+	if step["CB"] < step["BA"] || step["CB"] < step["CA"] {
+		t.Errorf("field closed too early: %v", r.Stops)
+	}
+}
@@ -0,0 +1,200 @@
+package wasabee
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// opEventsKept is how many recent events each streamed op keeps for clients reconnecting with Last-Event-ID
+	opEventsKept = 100
+	// opStreamBuffer is how many events a subscriber may fall behind before it is dropped
+	opStreamBuffer = 32
+	// opStreamIdle is how long the recent events of an op nobody is watching are kept
+	opStreamIdle = 5 * time.Minute
+)
+
+// OpEvent is a single change to an operation, pushed to agents watching the op
+type OpEvent struct {
+	ID       int64       `json:"id"`
+	Type     string      `json:"type"`
+	OpID     OperationID `json:"opID"`
+	ObjectID string      `json:"objectID,omitempty"`
+	Gid      GoogleID    `json:"gid,omitempty"`
+	Data     interface{} `json:"data,omitempty"`
+}
+
+// OpEventResync is sent in place of events which could not be replayed; the client should fetch the whole op again
+const OpEventResync = "resync"
+
+type opStream struct {
+	recent  []OpEvent
+	since   int64 // events up to here were published before anyone watched the op
+	dropped int64 // events up to here have fallen out of recent
+	subs    map[chan OpEvent]int64
+	idle    time.Time
+}
+
+var streams = struct {
+	mu  sync.Mutex
+	ops map[OperationID]*opStream
+}{
+	ops: make(map[OperationID]*opStream),
+}
+
+func init() {
+	go streamEvents(SubscribeEvents("stream", 256))
+}
+
+// streamEvents passes op events on to everyone watching the op
+func streamEvents(sub *EventSubscription) {
+	var dropped uint64
+	for e := range sub.Events() {
+		// the bus dropped events on their way here, there is no telling which ops they were for
+		if d := sub.Dropped(); d != dropped {
+			dropped = d
+			resyncStreams(e.ID - 1)
+		}
+		if e.OpID == "" {
+			continue
+		}
+		e.OpID.publish(OpEvent{
+			ID:       e.ID,
+			Type:     string(e.Type),
+			OpID:     e.OpID,
+			ObjectID: e.ObjectID,
+			Gid:      e.Gid,
+			Data:     e.Data,
+		})
+	}
+}
+
+// resyncStreams tells everyone watching an op to fetch it again, since events up to upTo may have been lost;
+// anyone reconnecting from before then is sent a resync rather than a replay with gaps
+func resyncStreams(upTo int64) {
+	streams.mu.Lock()
+	defer streams.mu.Unlock()
+
+	now := time.Now()
+	for opID, s := range streams.ops {
+		if s.dropped < upTo {
+			s.dropped = upTo
+		}
+		subscribed := len(s.subs)
+		for c, from := range s.subs {
+			if upTo <= from {
+				continue
+			}
+			select {
+			case c <- OpEvent{ID: upTo, Type: OpEventResync, OpID: opID}:
+				s.subs[c] = upTo
+			default:
+				Log.Noticef("dropping slow subscriber to op %s", opID)
+				delete(s.subs, c)
+				close(c)
+			}
+		}
+		if subscribed > 0 && len(s.subs) == 0 {
+			s.idle = now
+		}
+	}
+}
+
+// Subscribe starts watching an op for changes. Any events after lastID which are still known are returned for replay;
+// if some are no longer known a resync event is returned instead. The channel is closed if the subscriber falls too far behind.
+// cancel must be called when done.
+func (opID OperationID) Subscribe(lastID int64) (<-chan OpEvent, []OpEvent, func()) {
+	streams.mu.Lock()
+	defer streams.mu.Unlock()
+
+	// events are handed over asynchronously, anything published before now which is still on its way is not for this subscriber
+	from := lastEventID()
+	s, ok := streams.ops[opID]
+	if !ok {
+		s = &opStream{since: from, subs: make(map[chan OpEvent]int64)}
+		streams.ops[opID] = s
+	}
+
+	var replay []OpEvent
+	if lastID > 0 {
+		if lastID < s.since || lastID < s.dropped {
+			replay = append(replay, OpEvent{ID: from, Type: OpEventResync, OpID: opID})
+		} else {
+			for _, e := range s.recent {
+				if e.ID > lastID {
+					replay = append(replay, e)
+				}
+			}
+			// whatever is still on its way will be sent as it arrives
+			from = lastID
+			if len(replay) > 0 {
+				from = replay[len(replay)-1].ID
+			}
+		}
+	}
+
+	c := make(chan OpEvent, opStreamBuffer)
+	s.subs[c] = from
+
+	cancel := func() {
+		streams.mu.Lock()
+		defer streams.mu.Unlock()
+
+		if _, ok := s.subs[c]; ok {
+			delete(s.subs, c)
+			close(c)
+		}
+		if len(s.subs) == 0 {
+			s.idle = time.Now()
+		}
+	}
+	return c, replay, cancel
+}
+
+// publish sends an event to everyone watching the op; ops nobody has watched recently are skipped
+func (opID OperationID) publish(e OpEvent) {
+	streams.mu.Lock()
+	defer streams.mu.Unlock()
+
+	now := time.Now()
+	for id, s := range streams.ops {
+		if len(s.subs) == 0 && now.Sub(s.idle) > opStreamIdle {
+			delete(streams.ops, id)
+		}
+	}
+
+	s, ok := streams.ops[opID]
+	if !ok {
+		return
+	}
+
+	s.recent = append(s.recent, e)
+	if len(s.recent) > opEventsKept {
+		s.dropped = s.recent[len(s.recent)-opEventsKept-1].ID
+		s.recent = s.recent[len(s.recent)-opEventsKept:]
+	}
+
+	subscribed := len(s.subs)
+	for c, from := range s.subs {
+		if e.ID <= from {
+			continue
+		}
+		select {
+		case c <- e:
+		default:
+			// too far behind, close it so the client reconnects and replays from the last event it saw
+			Log.Noticef("dropping slow subscriber to op %s", opID)
+			delete(s.subs, c)
+			close(c)
+			continue
+		}
+		if e.Type == string(EventOpDeleted) {
+			// nothing more to watch
+			delete(s.subs, c)
+			close(c)
+		}
+	}
+	if subscribed > 0 && len(s.subs) == 0 {
+		s.idle = now
+	}
+}
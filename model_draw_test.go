@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"testing"
+	"time"
 
 	"github.com/wasabee-project/Wasabee-Server"
 )
@@ -40,11 +41,11 @@ func TestOperation(t *testing.T) {
 	fmt.Print(string(newj))
 
 	// make some changes
-	opp.KeyOnHand(gid, "83c4d2bee503409cbfc76db98af4d749.16", 7)
-	opp.KeyOnHand(gid, "2aa9e865ab8a4bb9896fb371281dcb7b.16", 99)
-	opp.PortalHardness("2aa9e865ab8a4bb9896fb371281dcb7b.16", "booster required")
-	opp.PortalHardness("83c4d2bee503409cbfc76db98af4d749.16", "BGAN only")
-	opp.PortalComment("83c4d2bee503409cbfc76db98af4d749.16", "testing a comment")
+	opp.KeyOnHand(gid, "83c4d2bee503409cbfc76db98af4d749.16", 7, "")
+	opp.KeyOnHand(gid, "2aa9e865ab8a4bb9896fb371281dcb7b.16", 99, "")
+	opp.PortalHardness("2aa9e865ab8a4bb9896fb371281dcb7b.16", "booster required", gid)
+	opp.PortalHardness("83c4d2bee503409cbfc76db98af4d749.16", "BGAN only", gid)
+	opp.PortalComment("83c4d2bee503409cbfc76db98af4d749.16", "testing a comment", gid)
 	// pull again
 	opp = &opx
 	if err := opp.Populate(gid); err != nil {
@@ -56,8 +57,23 @@ func TestOperation(t *testing.T) {
 	}
 	fmt.Print(string(newj))
 
+	// an update based on a stale copy with no fetched time cannot be merged and must be refused
+	stale := *opp
+	stale.Fetched = ""
+	stalej, _ := json.Marshal(stale)
+	err = wasabee.DrawUpdate("test1", stalej, gid, wasabee.OpPrecondition{UnmodifiedSince: time.Now().Add(-1 * time.Hour)})
+	if me, ok := err.(*wasabee.OpModifiedError); !ok {
+		t.Errorf("expected *OpModifiedError, got %v", err)
+	} else if me.Current.ID != "test1" {
+		t.Errorf("wrong op in OpModifiedError: %s", me.Current.ID)
+	}
+
 	// run an update
-	if err := wasabee.DrawUpdate("test1", newj, gid); err != nil {
+	since, err := wasabee.ParseOpETag(opp.ETag())
+	if err != nil {
+		t.Error(err.Error())
+	}
+	if err := wasabee.DrawUpdate("test1", newj, gid, since); err != nil {
 		t.Error(err.Error())
 	}
 
@@ -117,7 +133,7 @@ func TestDamagedOperation(t *testing.T) {
 
 	opp := &in
 	// does not print error for invalid portals
-	opp.KeyOnHand(gid, "83c4d2bee503409cbfc76db98af4d749.xx", 7)
+	opp.KeyOnHand(gid, "83c4d2bee503409cbfc76db98af4d749.xx", 7, "")
 
 	content, err = ioutil.ReadFile("testdata/test3-update.json")
 	if err != nil {
@@ -126,11 +142,25 @@ func TestDamagedOperation(t *testing.T) {
 
 	j = json.RawMessage(content)
 
-	if err := wasabee.DrawUpdate(opp.ID, j, gid); err != nil {
+	// test3-update has two anchors which are not in the portal list; the whole update must be refused
+	err = wasabee.DrawUpdate(opp.ID, j, gid, wasabee.OpPrecondition{})
+	ue, ok := err.(*wasabee.OpUpdateError)
+	if !ok {
+		t.Errorf("expected *OpUpdateError, got %v", err)
+	} else if len(ue.Rejected) != 2 {
+		t.Errorf("expected 2 rejected anchors, got %v", ue.Rejected)
+	}
+
+	var after wasabee.Operation
+	after.ID = opp.ID
+	if err := after.Populate(gid); err != nil {
 		t.Error(err.Error())
 	}
+	if after.Name != in.Name {
+		t.Errorf("rejected update was partially applied: name %s", after.Name)
+	}
 
-	if err := wasabee.DrawUpdate("random", j, gid); err != nil {
+	if err := wasabee.DrawUpdate("random", j, gid, wasabee.OpPrecondition{}); err != nil {
 		wasabee.Log.Debug("properly ignored update to 'random'")
 		// t.Error(err.Error())
 	}
@@ -139,3 +169,350 @@ func TestDamagedOperation(t *testing.T) {
 		t.Error(err.Error())
 	}
 }
+
+func TestOperationHistory(t *testing.T) {
+	content, err := ioutil.ReadFile("testdata/test2.json")
+	if err != nil {
+		t.Error(err.Error())
+	}
+	j := json.RawMessage(content)
+	if err := wasabee.DrawInsert(j, gid); err != nil {
+		t.Error(err.Error())
+	}
+
+	var in wasabee.Operation
+	if err := json.Unmarshal(j, &in); err != nil {
+		t.Error(err.Error())
+	}
+
+	// a bad upload that drops all the links
+	bad := in
+	bad.Links = nil
+	badj, _ := json.Marshal(bad)
+	uploaded, _ := in.ID.Stat()
+	if err := wasabee.DrawUpdate(in.ID, badj, gid, wasabee.OpPrecondition{}); err != nil {
+		t.Error(err.Error())
+	}
+	updated, _ := in.ID.Stat()
+	if updated.Version != uploaded.Version+1 {
+		t.Errorf("update moved the version from %d to %d", uploaded.Version, updated.Version)
+	}
+
+	op := wasabee.Operation{ID: in.ID}
+	history, err := op.History(gid)
+	if err != nil {
+		t.Error(err.Error())
+	}
+	if len(history) < 2 || history[0].Description != "update" {
+		t.Errorf("unexpected history: %v", history)
+	}
+	var upload int
+	for _, r := range history {
+		if r.Description == "upload" {
+			upload = r.ID
+		}
+	}
+
+	if err := op.Restore(gid, upload); err != nil {
+		t.Error(err.Error())
+	}
+	if err := op.Populate(gid); err != nil {
+		t.Error(err.Error())
+	}
+	if op.Version != updated.Version+1 {
+		t.Errorf("restore moved the version from %d to %d", updated.Version, op.Version)
+	}
+	if len(op.Links) != len(in.Links) {
+		t.Errorf("restore gave %d links, expected %d", len(op.Links), len(in.Links))
+	}
+
+	history, err = op.History(gid)
+	if err != nil {
+		t.Error(err.Error())
+	}
+	if len(history) == 0 || history[0].Description != fmt.Sprintf("restore revision %d", upload) {
+		t.Errorf("restore was not recorded: %v", history)
+	}
+
+	// keys on hand move the ETag but are not revisions
+	before, _ := op.ID.Stat()
+	if err := op.KeyOnHand(gid, in.OpPortals[0].ID, 3, ""); err != nil {
+		t.Error(err.Error())
+	}
+	after, _ := op.ID.Stat()
+	if after.Version != before.Version+1 {
+		t.Errorf("keys on hand moved the version from %d to %d", before.Version, after.Version)
+	}
+	keyed, err := op.History(gid)
+	if err != nil {
+		t.Error(err.Error())
+	}
+	if len(keyed) != len(history) {
+		t.Errorf("keys on hand recorded a revision: %v", keyed)
+	}
+
+	if err := op.Delete(gid); err != nil {
+		t.Error(err.Error())
+	}
+}
+
+func TestRestoreCompletedBy(t *testing.T) {
+	orig := wasabee.Operation{
+		ID:        "restorecompleted",
+		Name:      "restorecompleted",
+		OpPortals: []wasabee.Portal{{ID: "A", Name: "A", Lat: "0", Lon: "0"}},
+		Markers:   []wasabee.Marker{{ID: "MA", PortalID: "A", Type: "CaptureAlert", AssignedTo: gid}},
+	}
+	j, _ := json.Marshal(orig)
+	if err := wasabee.DrawInsert(j, gid); err != nil {
+		t.Fatal(err.Error())
+	}
+	defer orig.Delete(gid)
+	op := wasabee.Operation{ID: orig.ID}
+
+	// completed by an agent on the op's team
+	cgid := wasabee.GoogleID("104743827901423568954")
+	if _, err := cgid.InitAgent(); err != nil {
+		t.Fatal(err.Error())
+	}
+	defer cgid.Delete()
+	if err := op.Populate(gid); err != nil || len(op.Teams) == 0 {
+		t.Fatalf("op has no team: %v", err)
+	}
+	if err := op.Teams[0].TeamID.AddAgent(cgid); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := cgid.SetTeamState(op.Teams[0].TeamID, "On"); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if err := wasabee.MarkerID("MA").Complete(op, cgid); err != nil {
+		t.Fatal(err.Error())
+	}
+	history, err := op.History(gid)
+	if err != nil || len(history) == 0 {
+		t.Fatalf("no history: %v", err)
+	}
+	completed := history[0].ID
+	if err := wasabee.MarkerID("MA").Incomplete(op, cgid); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	// who completed it comes back with the restore, whatever their name
+	if err := op.Restore(gid, completed); err != nil {
+		t.Fatal(err.Error())
+	}
+	history, err = op.History(gid)
+	if err != nil || len(history) == 0 {
+		t.Fatalf("no history: %v", err)
+	}
+	r, err := op.Revision(gid, history[0].ID)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	var snap struct {
+		CompletedBy map[wasabee.MarkerID]wasabee.GoogleID `json:"completedByGid"`
+	}
+	if err := json.Unmarshal(r.Op, &snap); err != nil {
+		t.Fatal(err.Error())
+	}
+	if snap.CompletedBy["MA"] != cgid {
+		t.Errorf("completion not restored: %v", snap.CompletedBy)
+	}
+}
+
+func TestOperationMerge(t *testing.T) {
+	content, err := ioutil.ReadFile("testdata/test2.json")
+	if err != nil {
+		t.Error(err.Error())
+	}
+	if err := wasabee.DrawInsert(json.RawMessage(content), gid); err != nil {
+		t.Error(err.Error())
+	}
+
+	var in wasabee.Operation
+	if err := json.Unmarshal(content, &in); err != nil {
+		t.Error(err.Error())
+	}
+
+	// two planners fetch the same copy
+	mine := wasabee.Operation{ID: in.ID}
+	if err := mine.Populate(gid); err != nil {
+		t.Error(err.Error())
+	}
+	since, err := wasabee.ParseOpETag(mine.ETag())
+	if err != nil {
+		t.Error(err.Error())
+	}
+	if len(mine.Links) < 2 {
+		t.Fatal("test2.json needs at least two links")
+	}
+
+	// no waiting: their change lands in the same second as the fetch, and is still caught
+	theirs := wasabee.Operation{ID: mine.ID}
+	if err := theirs.LinkDescription(mine.Links[0].ID, "theirs", gid); err != nil {
+		t.Error(err.Error())
+	}
+
+	// a different link merges cleanly
+	mine.Links[1].Desc = "mine"
+	j, _ := json.Marshal(mine)
+	if err := wasabee.DrawUpdate(mine.ID, j, gid, since); err != nil {
+		t.Errorf("expected a clean merge, got %v", err)
+	}
+	check := wasabee.Operation{ID: mine.ID}
+	if err := check.Populate(gid); err != nil {
+		t.Error(err.Error())
+	}
+	for _, l := range check.Links {
+		if l.ID == mine.Links[0].ID && l.Desc != "theirs" {
+			t.Errorf("their edit was lost: %s", l.Desc)
+		}
+		if l.ID == mine.Links[1].ID && l.Desc != "mine" {
+			t.Errorf("my edit was lost: %s", l.Desc)
+		}
+	}
+
+	// the same link is a conflict
+	mine.Links[0].Desc = "also mine"
+	j, _ = json.Marshal(mine)
+	err = wasabee.DrawUpdate(mine.ID, j, gid, since)
+	if me, ok := err.(*wasabee.OpMergeError); !ok {
+		t.Errorf("expected *OpMergeError, got %v", err)
+	} else if len(me.Conflicts) != 1 || me.Conflicts[0].ID != string(mine.Links[0].ID) {
+		t.Errorf("unexpected conflicts: %v", me.Conflicts)
+	}
+
+	if err := check.Delete(gid); err != nil {
+		t.Error(err.Error())
+	}
+}
+
+func TestOperationAudit(t *testing.T) {
+	content, err := ioutil.ReadFile("testdata/test2.json")
+	if err != nil {
+		t.Error(err.Error())
+	}
+	if err := wasabee.DrawInsert(json.RawMessage(content), gid); err != nil {
+		t.Error(err.Error())
+	}
+
+	var in wasabee.Operation
+	if err := json.Unmarshal(content, &in); err != nil {
+		t.Error(err.Error())
+	}
+	op := wasabee.Operation{ID: in.ID}
+	if len(in.Links) < 2 {
+		t.Fatal("test2.json needs at least two links")
+	}
+
+	if err := op.LinkColor(in.Links[0].ID, "blue", gid); err != nil {
+		t.Error(err.Error())
+	}
+	if err := op.LinkSwap(in.Links[1].ID, gid); err != nil {
+		t.Error(err.Error())
+	}
+
+	// the log outlives the op, so earlier tests using test2.json left entries too; only the newest are checked
+	// entries are written as the events are delivered, so wait for the last one
+	var entries []wasabee.AuditEntry
+	waitFor(t, func() bool {
+		entries, err = op.Audit(gid, wasabee.AuditFilter{Type: "link"})
+		return err == nil && len(entries) > 0 && entries[0].Action == "swap"
+	})
+	if len(entries) < 2 || entries[0].Action != "swap" || entries[1].Action != "color" || entries[1].ObjectID != string(in.Links[0].ID) {
+		t.Errorf("unexpected audit entries: %v", entries)
+	}
+
+	entries, err = op.Audit(gid, wasabee.AuditFilter{ObjectID: string(in.Links[0].ID)})
+	if err != nil {
+		t.Error(err.Error())
+	}
+	if len(entries) == 0 || entries[0].Action != "color" || entries[0].Gid != gid {
+		t.Errorf("unexpected audit entries: %v", entries)
+	}
+
+	entries, err = op.Audit(gid, wasabee.AuditFilter{Type: "link", Limit: 1, Offset: 1})
+	if err != nil {
+		t.Error(err.Error())
+	}
+	if len(entries) != 1 || entries[0].Action != "color" {
+		t.Errorf("unexpected audit page: %v", entries)
+	}
+
+	if err := op.Delete(gid); err != nil {
+		t.Error(err.Error())
+	}
+}
+
+func TestOperationStream(t *testing.T) {
+	content, err := ioutil.ReadFile("testdata/test2.json")
+	if err != nil {
+		t.Error(err.Error())
+	}
+	if err := wasabee.DrawInsert(json.RawMessage(content), gid); err != nil {
+		t.Error(err.Error())
+	}
+	var in wasabee.Operation
+	if err := json.Unmarshal(content, &in); err != nil {
+		t.Error(err.Error())
+	}
+	op := wasabee.Operation{ID: in.ID}
+	if len(in.Links) < 1 {
+		t.Fatal("test2.json needs at least one link")
+	}
+
+	events, _, cancel := op.ID.Subscribe(0)
+	defer cancel()
+	if err := op.LinkColor(in.Links[0].ID, "blue", gid); err != nil {
+		t.Error(err.Error())
+	}
+	first := nextEvent(t, events, "linkColor")
+	if first.ObjectID != string(in.Links[0].ID) {
+		t.Errorf("unexpected event: %v", first)
+	}
+
+	// a reconnecting client is sent what it missed
+	if err := op.LinkSwap(in.Links[0].ID, gid); err != nil {
+		t.Error(err.Error())
+	}
+	nextEvent(t, events, "linkSwap")
+	_, replay, cancel2 := op.ID.Subscribe(first.ID)
+	if len(replay) == 0 || replay[len(replay)-1].Type != "linkSwap" || replay[0].ID <= first.ID {
+		t.Errorf("unexpected replay: %v", replay)
+	}
+	cancel2()
+
+	if err := op.Delete(gid); err != nil {
+		t.Error(err.Error())
+	}
+}
+
+// waitFor polls until done returns true; events are delivered asynchronously
+func waitFor(t *testing.T, done func() bool) {
+	for i := 0; i < 100; i++ {
+		if done() {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Error("timed out waiting for events to be delivered")
+}
+
+// nextEvent skips events until one of the given type arrives
+func nextEvent(t *testing.T, events <-chan wasabee.OpEvent, eventType string) wasabee.OpEvent {
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				t.Fatal("stream closed")
+			}
+			if e.Type == eventType {
+				return e
+			}
+		case <-timeout:
+			t.Fatalf("no %s event received", eventType)
+		}
+	}
+}
@@ -0,0 +1,203 @@
+package wasabee
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// outboundLinks is how many links a portal can throw without softbank ultra links
+	outboundLinks = 8
+	// maxPortalMods is how many mods fit on a portal
+	maxPortalMods = 4
+)
+
+// linkAmp multipliers, strongest first; the first mod counts in full, the rest less
+var linkAmps = []struct {
+	re   *regexp.Regexp
+	mult float64
+}{
+	{regexp.MustCompile(`(?i)\b(?:([1-4])\s*x?\s*)?vrlas?\b`), 7.0},
+	{regexp.MustCompile(`(?i)\b(?:([1-4])\s*x?\s*)?sbuls?\b`), 5.0},
+	{regexp.MustCompile(`(?i)\b(?:([1-4])\s*x?\s*)?las?\b`), 2.0},
+}
+
+var linkAmpStacking = [maxPortalMods]float64{1.0, 0.25, 0.125, 0.125}
+
+var portalLevelRe = regexp.MustCompile(`(?i)\b(?:l|level\s*)([1-8])\b`)
+
+// portalMods is what the planner has noted about a portal in its hardness or comment, e.g. "L7 2xSBUL"
+type portalMods struct {
+	level int // 0 if not noted
+	amps  []float64
+	sbuls int
+}
+
+func (p Portal) mods() portalMods {
+	var m portalMods
+	notes := p.Hardness + " " + p.Comment
+
+	if s := portalLevelRe.FindStringSubmatch(notes); s != nil {
+		m.level, _ = strconv.Atoi(s[1])
+	}
+	for i, la := range linkAmps {
+		for _, s := range la.re.FindAllStringSubmatch(notes, -1) {
+			n := 1
+			if s[1] != "" {
+				n, _ = strconv.Atoi(s[1])
+			}
+			for ; n > 0 && len(m.amps) < maxPortalMods; n-- {
+				m.amps = append(m.amps, la.mult)
+				if i == 1 {
+					m.sbuls++
+				}
+			}
+		}
+	}
+	sort.Sort(sort.Reverse(sort.Float64Slice(m.amps)))
+	return m
+}
+
+// linkRange is how far the portal can link, in meters; an unknown level is taken to be 8
+func (m portalMods) linkRange() float64 {
+	level := m.level
+	if level == 0 {
+		level = 8
+	}
+	r := 160.0 * math.Pow(float64(level), 4)
+	if len(m.amps) == 0 {
+		return r
+	}
+	mult := 0.0
+	for i, a := range m.amps {
+		mult += a * linkAmpStacking[i]
+	}
+	return r * mult
+}
+
+// outboundLimit is how many links the portal can throw
+func (m portalMods) outboundLimit() int {
+	return outboundLinks * (1 + m.sbuls)
+}
+
+// LinkProblemOutbound et al. are the problems CheckThrowOrder finds with a link
+const (
+	LinkProblemOutbound    = "outboundLimit"
+	LinkProblemInsideField = OpProblemInsideField
+	LinkProblemRange       = "outOfRange"
+	LinkProblemBadPortal   = OpProblemBadPortal
+)
+
+// LinkCheck is how one link fares when the links are thrown in order
+type LinkCheck struct {
+	LinkID        LinkID   `json:"linkID"`
+	Step          int      `json:"step"`
+	ThrowOrder    int32    `json:"throwOrderPos"`
+	From          PortalID `json:"fromPortalId"`
+	To            PortalID `json:"toPortalId"`
+	Length        float64  `json:"length"` // meters
+	Range         float64  `json:"range"`  // meters, of the origin portal as noted
+	Outbound      int      `json:"outbound"`
+	OutboundLimit int      `json:"outboundLimit"`
+	Problems      []string `json:"problems"`
+	Messages      []string `json:"messages"`
+}
+
+func (lc *LinkCheck) problem(p, msg string, args ...interface{}) {
+	lc.Problems = append(lc.Problems, p)
+	lc.Messages = append(lc.Messages, fmt.Sprintf(msg, args...))
+}
+
+// ThrowOrderCheck is the result of walking an op's links in throw order
+type ThrowOrderCheck struct {
+	ID    OperationID `json:"ID"`
+	Valid bool        `json:"valid"`
+	Links []LinkCheck `json:"links"`
+}
+
+// reorderLinks sets the throw order as LinkOrder would, without saving it
+func (o *Operation) reorderLinks(order string) {
+	pos := make(map[LinkID]int32)
+	var p int32 = 1
+	for _, id := range strings.Split(order, ",") {
+		if id == "000" {
+			continue
+		}
+		pos[LinkID(id)] = p
+		p++
+	}
+	for i := range o.Links {
+		if n, ok := pos[o.Links[i].ID]; ok {
+			o.Links[i].ThrowOrder = n
+		}
+	}
+}
+
+// CheckThrowOrder walks the op's links in throw order, or in the order given (as sent to LinkOrder), and reports for each link
+// whether its portal has run out of outbound links, whether it starts inside a field already up and whether it is out of range.
+// The op must be populated first.
+func (o *Operation) CheckThrowOrder(order string) ThrowOrderCheck {
+	if order != "" {
+		o.reorderLinks(order)
+	}
+
+	check := ThrowOrderCheck{ID: o.ID, Valid: true, Links: []LinkCheck{}}
+	g, _ := o.geometry()
+	mods := make(map[PortalID]portalMods)
+	for _, p := range o.OpPortals {
+		mods[p.ID] = p.mods()
+	}
+
+	outbound := make(map[PortalID]int)
+	lg := make(linkGraph)
+	var fields [][3]PortalID
+	for i, l := range o.thrownLinks() {
+		lc := LinkCheck{
+			LinkID:     l.ID,
+			Step:       i + 1,
+			ThrowOrder: l.ThrowOrder,
+			From:       l.From,
+			To:         l.To,
+			Problems:   []string{},
+			Messages:   []string{},
+		}
+
+		m := mods[l.From]
+		outbound[l.From]++
+		lc.Outbound = outbound[l.From]
+		lc.OutboundLimit = m.outboundLimit()
+		if lc.Outbound > lc.OutboundLimit {
+			lc.problem(LinkProblemOutbound, "%s would be link %d from %s, which can only throw %d (note SBULs on the portal)", l.ID, lc.Outbound, l.From, lc.OutboundLimit)
+		}
+
+		if a, b, ok := g.arc(l); !ok {
+			lc.problem(LinkProblemBadPortal, "%s is between portals with no usable location", l.ID)
+		} else {
+			lc.Length = math.Acos(math.Max(-1, math.Min(1, a.dot(b)))) * earthRadius
+			lc.Range = m.linkRange()
+			if lc.Length > lc.Range {
+				lc.problem(LinkProblemRange, "%s is %.0fm long, %s can only reach %.0fm", l.ID, lc.Length, l.From, lc.Range)
+			}
+		}
+
+		if f, ok := g.fieldAround(l.From, fields); ok {
+			lc.problem(LinkProblemInsideField, "%s starts inside the field %s, %s, %s thrown before it", l.ID, f[0], f[1], f[2])
+		}
+		if l.From != l.To {
+			for _, c := range lg.closes(l.From, l.To) {
+				fields = append(fields, [3]PortalID{l.From, l.To, c})
+			}
+			lg.add(l.From, l.To)
+		}
+
+		if len(lc.Problems) > 0 {
+			check.Valid = false
+		}
+		check.Links = append(check.Links, lc)
+	}
+	return check
+}
@@ -0,0 +1,59 @@
+package wasabee_test
+
+import (
+	"fmt"
+	"github.com/wasabee-project/Wasabee-Server"
+	"testing"
+)
+
+func TestCheckThrowOrder(t *testing.T) {
+	op := wasabee.Operation{ID: "throworder"}
+	op.OpPortals = append(op.OpPortals,
+		wasabee.Portal{ID: "H", Lat: "0", Lon: "0"},
+		wasabee.Portal{ID: "R", Lat: "0", Lon: "-0.01", Hardness: "L1"},
+	)
+	for i := 0; i < 10; i++ {
+		p := wasabee.PortalID(fmt.Sprintf("T%d", i))
+		op.OpPortals = append(op.OpPortals, wasabee.Portal{ID: p, Lat: "1", Lon: fmt.Sprintf("%d", i-5)})
+		op.Links = append(op.Links, wasabee.Link{ID: wasabee.LinkID(fmt.Sprintf("H%d", i)), From: "H", To: p, ThrowOrder: int32(i + 1)})
+	}
+	// about 1100m, too far for an L1
+	op.Links = append(op.Links, wasabee.Link{ID: "RH", From: "R", To: "H", ThrowOrder: 11})
+
+	problems := func(c wasabee.ThrowOrderCheck) map[wasabee.LinkID][]string {
+		p := make(map[wasabee.LinkID][]string)
+		for _, lc := range c.Links {
+			if len(lc.Problems) > 0 {
+				p[lc.LinkID] = lc.Problems
+			}
+		}
+		return p
+	}
+
+	c := op.CheckThrowOrder("")
+	p := problems(c)
+	if c.Valid || len(p) != 3 || p["H8"][0] != wasabee.LinkProblemOutbound || p["H9"][0] != wasabee.LinkProblemOutbound || p["RH"][0] != wasabee.LinkProblemRange {
+		t.Errorf("unexpected problems: %v", p)
+	}
+
+	op.OpPortals[0].Comment = "sbul"
+	op.OpPortals[1].Hardness = "L1 vrla"
+	if c := op.CheckThrowOrder(""); !c.Valid {
+		t.Errorf("unexpected problems: %v", problems(c))
+	}
+
+	// T0T1 closes H-T0-T1 around X
+	op.OpPortals = append(op.OpPortals, wasabee.Portal{ID: "X", Lat: "0.667", Lon: "-3"})
+	op.Links = append(op.Links,
+		wasabee.Link{ID: "T0T1", From: "T0", To: "T1", ThrowOrder: 12},
+		wasabee.Link{ID: "XT0", From: "X", To: "T0", ThrowOrder: 13},
+	)
+	p = problems(op.CheckThrowOrder(""))
+	if len(p) != 1 || p["XT0"][0] != wasabee.LinkProblemInsideField {
+		t.Errorf("unexpected problems: %v", p)
+	}
+	// thrown first it is fine
+	if c := op.CheckThrowOrder("000,XT0"); !c.Valid {
+		t.Errorf("unexpected problems: %v", problems(c))
+	}
+}
@@ -0,0 +1,381 @@
+package wasabee
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// WaveID identifies a wave within an op
+type WaveID string
+
+// Wave is a set of links to be thrown together at a set time. Agents with links in it get a countdown.
+type Wave struct {
+	ID    WaveID      `json:"ID"`
+	OpID  OperationID `json:"opID"`
+	Name  string      `json:"name"`
+	At    string      `json:"at"`    // UTC, as phaseTimeFormat
+	Links []LinkID    `json:"links"` // in throw order
+	// the last countdown mark sent, in seconds before At
+	notified sql.NullInt64
+}
+
+// WaveLink is a link in a wave, and when it was reported done
+type WaveLink struct {
+	ID        LinkID `json:"ID"`
+	Wave      WaveID `json:"wave"`
+	Completed string `json:"completed,omitempty"` // UTC, as phaseTimeFormat
+}
+
+// WaveCountdown is sent to the agents with links in a wave as it approaches
+type WaveCountdown struct {
+	Wave    WaveID     `json:"wave"`
+	Name    string     `json:"name"`
+	At      string     `json:"at"`
+	Seconds int        `json:"seconds"` // until the wave; 0 is throw now
+	Agents  []GoogleID `json:"agents"`
+}
+
+// WaveReport is how each link in a wave landed against the wave's time
+type WaveReport struct {
+	Wave  Wave             `json:"wave"`
+	Links []WaveLinkReport `json:"links"`
+}
+
+// WaveLinkReport is one link in a WaveReport
+type WaveLinkReport struct {
+	ID         LinkID   `json:"ID"`
+	ThrowOrder int32    `json:"throwOrderPos"`
+	AssignedTo GoogleID `json:"assignedTo,omitempty"`
+	Completed  string   `json:"completed,omitempty"`
+	Offset     int64    `json:"offset"` // seconds after the wave's time, negative if early
+	Status     string   `json:"status"` // pending, early, ontime or late
+}
+
+// waveMarks are the countdown points, in seconds before the wave
+var waveMarks = []int{300, 60, 0}
+
+const (
+	waveEarly = 30 * time.Second // completions reported this long before the wave still count as on time
+	waveLate  = 2 * time.Minute  // and this long after
+	waveGrace = 30 * time.Second // a mark missed by less than this, e.g. across a restart, is still sent
+	// how far ahead waveSchedule sets timers; more than the gap between runs
+	waveLookahead = 2 * time.Minute
+)
+
+var waveTimers = struct {
+	sync.Mutex
+	t map[string]*time.Timer
+}{t: make(map[string]*time.Timer)}
+
+// normalize checks the wave and puts its time in phaseTimeFormat
+func (w *Wave) normalize() error {
+	if w.Name == "" {
+		return fmt.Errorf("wave needs a name")
+	}
+	at, err := parsePhaseTime(w.At)
+	if err != nil {
+		return err
+	}
+	w.At = at.Format(phaseTimeFormat)
+	return nil
+}
+
+// ListWaves returns the op's waves in order
+func (o *Operation) ListWaves(gid GoogleID) ([]Wave, error) {
+	if !o.opAgent(gid) {
+		err := fmt.Errorf("permission denied: %s listing waves in op %s", gid, o.ID)
+		Log.Error(err)
+		return nil, err
+	}
+	waves, err := store.Waves(o.ID)
+	if err != nil {
+		Log.Error(err)
+		return nil, err
+	}
+	return waves, nil
+}
+
+// LinksByThrowOrder is the op's links with a throw order from first to last inclusive, in order
+func (o *Operation) LinksByThrowOrder(first, last int32) ([]LinkID, error) {
+	links, err := store.Links(o.ID)
+	if err != nil {
+		Log.Error(err)
+		return nil, err
+	}
+	ids := []LinkID{}
+	for _, l := range links {
+		if l.ThrowOrder >= first && l.ThrowOrder <= last {
+			ids = append(ids, l.ID)
+		}
+	}
+	return ids, nil
+}
+
+// SetWave adds a wave to the op, or changes it if the ID is already in use; a wave with no ID gets one.
+// Links are taken out of any other wave they were in.
+func (o *Operation) SetWave(gid GoogleID, w Wave) (Wave, error) {
+	if !o.WriteAccess(gid) {
+		err := fmt.Errorf("write access required to change waves")
+		Log.Error(err)
+		return w, err
+	}
+	if err := w.normalize(); err != nil {
+		Log.Notice(err)
+		return w, err
+	}
+
+	links, err := store.Links(o.ID)
+	if err != nil {
+		Log.Error(err)
+		return w, err
+	}
+	inOp := make(map[LinkID]bool, len(links))
+	for _, l := range links {
+		inOp[l.ID] = true
+	}
+	for _, l := range w.Links {
+		if !inOp[l] {
+			err := fmt.Errorf("link %s not in op %s", l, o.ID)
+			Log.Notice(err)
+			return w, err
+		}
+	}
+
+	if w.ID == "" {
+		w.ID = WaveID(GenerateName())
+	}
+	w.OpID = o.ID
+	if err := store.SetWave(o.ID, w); err != nil {
+		Log.Error(err)
+		return w, err
+	}
+	o.ID.emit(Event{
+		Type:     EventWaveChanged,
+		ObjectID: string(w.ID),
+		Gid:      gid,
+		Action:   "wave",
+		Detail:   fmt.Sprintf("%s at %s, %d link(s)", w.Name, w.At, len(w.Links)),
+		Data:     w,
+	})
+	if err := o.Touch(gid, "wave "+string(w.ID)); err != nil {
+		Log.Error(err)
+	}
+	// a wave set close to its time would otherwise wait for the next run
+	waveSchedule()
+	return store.Wave(o.ID, w.ID)
+}
+
+// DeleteWave removes a wave; its countdown is not sent
+func (o *Operation) DeleteWave(gid GoogleID, waveID WaveID) error {
+	if !o.WriteAccess(gid) {
+		err := fmt.Errorf("write access required to change waves")
+		Log.Error(err)
+		return err
+	}
+	if err := store.DeleteWave(o.ID, waveID); err != nil {
+		Log.Error(err)
+		return err
+	}
+	o.ID.emit(Event{
+		Type:     EventWaveChanged,
+		ObjectID: string(waveID),
+		Gid:      gid,
+		Action:   "delete",
+	})
+	if err := o.Touch(gid, "delete wave "+string(waveID)); err != nil {
+		Log.Error(err)
+	}
+	return nil
+}
+
+// WaveReport shows the owner which links in a wave landed on time
+func (o *Operation) WaveReport(gid GoogleID, waveID WaveID) (WaveReport, error) {
+	r := WaveReport{Links: []WaveLinkReport{}}
+	if !o.WriteAccess(gid) {
+		err := fmt.Errorf("permission denied: %s viewing wave report in op %s", gid, o.ID)
+		Log.Error(err)
+		return r, err
+	}
+
+	w, err := store.Wave(o.ID, waveID)
+	if err != nil {
+		Log.Error(err)
+		return r, err
+	}
+	r.Wave = w
+	at, err := parsePhaseTime(w.At)
+	if err != nil {
+		Log.Error(err)
+		return r, err
+	}
+
+	wavelinks, err := store.WaveLinks(o.ID, waveID)
+	if err != nil {
+		Log.Error(err)
+		return r, err
+	}
+	links, err := store.Links(o.ID)
+	if err != nil {
+		Log.Error(err)
+		return r, err
+	}
+	byID := make(map[LinkID]Link, len(links))
+	for _, l := range links {
+		byID[l.ID] = l
+	}
+
+	for _, wl := range wavelinks {
+		l, ok := byID[wl.ID]
+		if !ok {
+			// deleted from the op since the wave was set
+			continue
+		}
+		lr := WaveLinkReport{
+			ID:         l.ID,
+			ThrowOrder: l.ThrowOrder,
+			AssignedTo: l.AssignedTo,
+			Status:     "pending",
+		}
+		if done, err := parsePhaseTime(wl.Completed); err == nil && l.Completed {
+			lr.Completed = wl.Completed
+			d := done.Sub(at)
+			lr.Offset = int64(d / time.Second)
+			switch {
+			case d < -waveEarly:
+				lr.Status = "early"
+			case d > waveLate:
+				lr.Status = "late"
+			default:
+				lr.Status = "ontime"
+			}
+		}
+		r.Links = append(r.Links, lr)
+	}
+	return r, nil
+}
+
+// waveSchedule sets timers for the countdown marks coming up soon; it is run every minute and whenever a wave is set
+func waveSchedule() {
+	now := time.Now()
+	// the earliest mark is waveMarks[0] before the wave
+	waves, err := store.WavesBetween(now.Add(-waveGrace), now.Add(waveLookahead+time.Duration(waveMarks[0])*time.Second))
+	if err != nil {
+		Log.Error(err)
+		return
+	}
+
+	waveTimers.Lock()
+	defer waveTimers.Unlock()
+	for _, w := range waves {
+		at, err := parsePhaseTime(w.At)
+		if err != nil {
+			continue
+		}
+		for _, mark := range waveMarks {
+			if w.notified.Valid && w.notified.Int64 <= int64(mark) {
+				continue
+			}
+			due := at.Add(-time.Duration(mark) * time.Second)
+			if due.Before(now.Add(-waveGrace)) || due.After(now.Add(waveLookahead)) {
+				continue
+			}
+			key := fmt.Sprintf("%s/%s/%s/%d", w.OpID, w.ID, w.At, mark)
+			if _, ok := waveTimers.t[key]; ok {
+				continue
+			}
+			opID, waveID, waveAt, m := w.OpID, w.ID, w.At, mark
+			waveTimers.t[key] = time.AfterFunc(due.Sub(now), func() {
+				waveCountdown(opID, waveID, waveAt, m)
+				waveTimers.Lock()
+				delete(waveTimers.t, key)
+				waveTimers.Unlock()
+			})
+		}
+	}
+}
+
+// waveCountdown tells the agents with links in the wave how long they have.
+// The wave is read again in case it has been moved or deleted since the timer was set.
+func waveCountdown(opID OperationID, waveID WaveID, at string, mark int) {
+	w, err := store.Wave(opID, waveID)
+	if err != nil || w.At != at {
+		return
+	}
+	if w.notified.Valid && w.notified.Int64 <= int64(mark) {
+		return
+	}
+	// marked first, so a failure to send does not repeat the mark
+	if err := store.SetWaveNotified(opID, waveID, mark); err != nil {
+		Log.Error(err)
+		return
+	}
+
+	links, err := store.Links(opID)
+	if err != nil {
+		Log.Error(err)
+		return
+	}
+	portals, err := store.Portals(opID)
+	if err != nil {
+		Log.Error(err)
+		return
+	}
+	names := make(map[PortalID]string, len(portals))
+	for _, p := range portals {
+		names[p.ID] = p.Name
+	}
+	byID := make(map[LinkID]Link, len(links))
+	for _, l := range links {
+		byID[l.ID] = l
+	}
+
+	// w.Links is in throw order
+	todo := make(map[GoogleID][]string)
+	var agents []GoogleID
+	for _, id := range w.Links {
+		l, ok := byID[id]
+		if !ok || l.AssignedTo == "" || l.Completed {
+			continue
+		}
+		if _, ok := todo[l.AssignedTo]; !ok {
+			agents = append(agents, l.AssignedTo)
+		}
+		todo[l.AssignedTo] = append(todo[l.AssignedTo], fmt.Sprintf("%d: %s - %s", l.ThrowOrder, names[l.From], names[l.To]))
+	}
+	if len(agents) == 0 {
+		return
+	}
+
+	// announced before the messages are sent, which can be slow
+	// no action: countdowns are not kept in the audit log
+	opID.emit(Event{
+		Type:     EventWaveCountdown,
+		ObjectID: string(waveID),
+		Detail:   fmt.Sprintf("T-%d", mark),
+		Data: WaveCountdown{
+			Wave:    waveID,
+			Name:    w.Name,
+			At:      w.At,
+			Seconds: mark,
+			Agents:  agents,
+		},
+	})
+
+	for _, gid := range agents {
+		var msg string
+		if mark == 0 {
+			msg = fmt.Sprintf("THROW NOW: wave %s of op %s", w.Name, opID)
+		} else {
+			msg = fmt.Sprintf("wave %s of op %s in %d minute(s), at %s UTC", w.Name, opID, mark/60, w.At)
+		}
+		msg = msg + "\n" + strings.Join(todo[gid], "\n")
+		go func(gid GoogleID, msg string) {
+			if _, err := gid.SendMessage(msg); err != nil {
+				Log.Notice(err)
+			}
+		}(gid, msg)
+	}
+}
@@ -0,0 +1,149 @@
+package wasabee_test
+
+import (
+	"encoding/json"
+	"github.com/wasabee-project/Wasabee-Server"
+	"testing"
+	"time"
+)
+
+func TestWaves(t *testing.T) {
+	wgid := wasabee.GoogleID("104743827901423568953")
+	if _, err := wgid.InitAgent(); err != nil {
+		t.Fatal(err.Error())
+	}
+	defer wgid.Delete()
+
+	orig := wasabee.Operation{
+		ID:   "waves",
+		Name: "waves",
+		OpPortals: []wasabee.Portal{
+			{ID: "A", Name: "A", Lat: "0", Lon: "0"},
+			{ID: "B", Name: "B", Lat: "0", Lon: "1"},
+			{ID: "C", Name: "C", Lat: "1", Lon: "0"},
+		},
+		Links: []wasabee.Link{
+			{ID: "AB", From: "A", To: "B", AssignedTo: wgid, ThrowOrder: 1},
+			{ID: "BC", From: "B", To: "C", ThrowOrder: 2},
+			{ID: "CA", From: "C", To: "A", AssignedTo: wgid, ThrowOrder: 3},
+		},
+	}
+	j, _ := json.Marshal(orig)
+	if err := wasabee.DrawInsert(j, gid); err != nil {
+		t.Fatal(err.Error())
+	}
+	defer orig.Delete(gid)
+	op := wasabee.Operation{ID: orig.ID}
+
+	links, err := op.LinksByThrowOrder(1, 2)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(links) != 2 || links[0] != "AB" || links[1] != "BC" {
+		t.Fatalf("unexpected links by throw order: %v", links)
+	}
+
+	if _, err := op.SetWave(wgid, wasabee.Wave{Name: "first", At: time.Now().Format(time.RFC3339), Links: links}); err == nil {
+		t.Error("wave set without write access")
+	}
+	if _, err := op.SetWave(gid, wasabee.Wave{Name: "first", At: time.Now().Format(time.RFC3339), Links: []wasabee.LinkID{"XX"}}); err == nil {
+		t.Error("wave with a link not in the op accepted")
+	}
+	if _, err := op.SetWave(gid, wasabee.Wave{Name: "first", At: "soon", Links: links}); err == nil {
+		t.Error("wave with an unparsable time accepted")
+	}
+
+	events, _, cancel := op.ID.Subscribe(0)
+	defer cancel()
+
+	// due now, so only the last mark is sent
+	at := time.Now().UTC().Add(time.Second)
+	w, err := op.SetWave(gid, wasabee.Wave{ID: "first", Name: "first", At: at.Format(time.RFC3339), Links: links})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if w.At != at.Format("2006-01-02 15:04:05") || len(w.Links) != 2 {
+		t.Errorf("unexpected wave: %v", w)
+	}
+
+	timeout := time.After(5 * time.Second)
+	for countdown := false; !countdown; {
+		select {
+		case e := <-events:
+			if e.Type != "waveCountdown" {
+				continue
+			}
+			countdown = true
+			c, ok := e.Data.(wasabee.WaveCountdown)
+			if !ok || c.Wave != "first" || c.Seconds != 0 || len(c.Agents) != 1 || c.Agents[0] != wgid {
+				t.Errorf("unexpected countdown: %v", e.Data)
+			}
+		case <-timeout:
+			t.Fatal("no countdown sent")
+		}
+	}
+
+	if err := op.LinkCompleted("AB", true, wgid); err != nil {
+		t.Fatal(err.Error())
+	}
+	if _, err := op.WaveReport(wgid, "first"); err == nil {
+		t.Error("wave report shown without write access")
+	}
+	r, err := op.WaveReport(gid, "first")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(r.Links) != 2 || r.Links[0].ID != "AB" || r.Links[0].Status != "ontime" || r.Links[1].Status != "pending" {
+		t.Errorf("unexpected report: %v", r.Links)
+	}
+
+	// a link can only be in one wave; the earlier wave finds CA done late
+	if _, err := op.SetWave(gid, wasabee.Wave{ID: "second", Name: "second", At: time.Now().Add(-time.Hour).Format(time.RFC3339), Links: []wasabee.LinkID{"BC", "CA"}}); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := op.LinkCompleted("CA", true, wgid); err != nil {
+		t.Fatal(err.Error())
+	}
+	waves, err := op.ListWaves(gid)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(waves) != 2 || waves[0].ID != "second" || len(waves[1].Links) != 1 || waves[1].Links[0] != "AB" {
+		t.Fatalf("unexpected waves: %v", waves)
+	}
+	r, err = op.WaveReport(gid, "second")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(r.Links) != 2 || r.Links[1].ID != "CA" || r.Links[1].Status != "late" || r.Links[1].Offset < 3600 {
+		t.Errorf("unexpected report: %v", r.Links)
+	}
+
+	// waves are audited as waves, not links
+	waitFor(t, func() bool {
+		entries, err := op.Audit(gid, wasabee.AuditFilter{Type: "wave"})
+		return err == nil && len(entries) >= 2
+	})
+	if entries, err := op.Audit(gid, wasabee.AuditFilter{Type: "link", ObjectID: "first"}); err != nil || len(entries) != 0 {
+		t.Errorf("wave in the link history: %v %v", entries, err)
+	}
+
+	if err := op.DeleteWave(gid, "second"); err != nil {
+		t.Fatal(err.Error())
+	}
+	if waves, _ := op.ListWaves(gid); len(waves) != 1 {
+		t.Errorf("wave not deleted: %v", waves)
+	}
+
+	// CA was done before it joined this wave, so it is not pending
+	if _, err := op.SetWave(gid, wasabee.Wave{ID: "third", Name: "third", At: time.Now().Add(time.Hour).Format(time.RFC3339), Links: []wasabee.LinkID{"CA"}}); err != nil {
+		t.Fatal(err.Error())
+	}
+	r, err = op.WaveReport(gid, "third")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(r.Links) != 1 || r.Links[0].Status != "early" {
+		t.Errorf("link done before joining the wave: %v", r.Links)
+	}
+}
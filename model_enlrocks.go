@@ -147,8 +147,7 @@ func RocksUpdate(id AgentID, agent *RocksAgent) error {
 
 	if agent.Agent != "" {
 		// Log.Debug("Updating Rocks data for ", agent.Agent)
-		_, err := db.Exec("UPDATE agent SET iname = ?, RocksVerified = ? WHERE gid = ?", agent.Agent, agent.Verified, gid)
-
+		err := store.UpdateAgentRocks(gid, agent.Agent, agent.Verified)
 		if err != nil {
 			Log.Error(err)
 			return err
@@ -156,7 +155,7 @@ func RocksUpdate(id AgentID, agent *RocksAgent) error {
 
 		// we trust .rocks to verify telegram info; if it is not already set for a agent, just import it.
 		if agent.TGId > 0 { // negative numbers are group chats, 0 is invalid
-			_, err := db.Exec("INSERT IGNORE INTO telegram (telegramID, telegramName, gid, verified) VALUES (?, 'unused', ?, 1)", agent.TGId, gid)
+			err := store.ImportTelegram(TelegramID(agent.TGId), gid)
 			if err != nil {
 				Log.Error(err)
 				return err
@@ -289,8 +288,7 @@ func (teamID TeamID) RocksCommunityMemberPull() error {
 
 // RocksTeamID takes a rocks community ID and returns an associated teamID
 func RocksTeamID(rockscomm string) (TeamID, error) {
-	var t TeamID
-	err := db.QueryRow("SELECT teamID FROM team WHERE rockscomm = ?", rockscomm).Scan(&t)
+	t, err := store.RocksTeamID(rockscomm)
 	if err != nil && err == sql.ErrNoRows {
 		return "", nil
 	}
@@ -409,14 +407,10 @@ func (teamID TeamID) rocksComm() (string, error) {
 		return "", nil
 	}
 
-	var rc sql.NullString
-	err := db.QueryRow("SELECT rockskey FROM team WHERE teamID = ?", teamID).Scan(&rc)
+	rc, err := store.TeamRocksKey(teamID)
 	if err != nil {
 		Log.Error(err)
 		return "", err
 	}
-	if !rc.Valid {
-		return "", nil
-	}
-	return rc.String, nil
+	return rc, nil
 }
@@ -45,11 +45,6 @@ func (document *SimpleDocument) Store() error {
 		return err
 	}
 
-	var expiration interface{}
-	if (document.Expiration != time.Time{}) {
-		expiration = document.Expiration.UTC().Format("2006-01-02 15:04:05")
-	}
-
 	// Server-Side Encryption
 	key, err := scrypt.Key([]byte(document.ID), []byte(document.Upload.UTC().Format("2006-01-02 15:04:05")), 16384, 8, 1, 24)
 	if err != nil {
@@ -64,12 +59,8 @@ func (document *SimpleDocument) Store() error {
 	databaseID := sha256.Sum256([]byte(document.ID))
 
 	// Write the document to the database
-	_, err = db.Exec(
-		"INSERT INTO document (id, content, upload, expiration, views) VALUES (?, ?, ?, ?, 0)",
-		hex.EncodeToString(databaseID[:]),
-		string(data),
-		document.Upload.UTC().Format("2006-01-02 15:04:05"), // don't use NOW() since this is used in the key...
-		expiration)
+	// don't use NOW() for upload since it is used in the key...
+	err = store.InsertDocument(hex.EncodeToString(databaseID[:]), string(data), document.Upload, document.Expiration)
 	if err != nil {
 		Log.Error(err)
 		return err
@@ -79,12 +70,8 @@ func (document *SimpleDocument) Store() error {
 
 // Request a document from the database by its ID.
 func Request(id string) (SimpleDocument, error) {
-	doc := SimpleDocument{ID: id}
-	var views int
-	var upload, expiration sql.NullString
 	databaseID := sha256.Sum256([]byte(id))
-	err := db.QueryRow("SELECT content, upload, expiration, views FROM document WHERE id = ?", hex.EncodeToString(databaseID[:])).
-		Scan(&doc.Content, &upload, &expiration, &views)
+	doc, err := store.Document(hex.EncodeToString(databaseID[:]))
 	if err != nil {
 		if err != sql.ErrNoRows {
 			Log.Warningf("Error retrieving document: %s", err)
@@ -92,13 +79,11 @@ func Request(id string) (SimpleDocument, error) {
 		return SimpleDocument{}, err
 	}
 
-	_, err = db.Exec("UPDATE document SET views = views + 1 WHERE id = ?", hex.EncodeToString(databaseID[:]))
+	doc.ID = id
+	err = store.DocumentViewed(hex.EncodeToString(databaseID[:]))
 	if err != nil {
 		Log.Error("unable to update document view count")
 	}
-	doc.Views = views
-
-	doc.Upload, _ = time.Parse("2006-01-02 15:04:05", upload.String)
 
 	key, err := scrypt.Key([]byte(id), []byte(doc.Upload.UTC().Format("2006-01-02 15:04:05")), 16384, 8, 1, 24)
 	if err != nil {
@@ -113,20 +98,16 @@ func Request(id string) (SimpleDocument, error) {
 		doc.Content = string(data)
 	}
 
-	if expiration.Valid {
-		doc.Expiration, err = time.Parse("2006-01-02 15:04:05", expiration.String)
+	if !doc.Expiration.IsZero() {
 		if doc.Expiration.Before(time.Unix(0, 1)) {
 			if doc.Views > 0 {
 				// Volatile document
-				_, err = db.Exec("DELETE LOW_PRIORITY FROM document WHERE id = ?", hex.EncodeToString(databaseID[:]))
+				err = store.DeleteDocument(hex.EncodeToString(databaseID[:]))
 				if err != nil {
 					Log.Errorf("couldn't delete volatile document: %s", err)
 				}
 			}
 		} else {
-			if err != nil {
-				return SimpleDocument{}, err
-			}
 			if doc.Expiration.Before(time.Now()) {
 				err = fmt.Errorf("the document has expired")
 				return SimpleDocument{}, err
@@ -138,16 +119,10 @@ func Request(id string) (SimpleDocument, error) {
 }
 
 func simpleDocClean() {
-	// do it this way to get RowsAffected
-	stmt, _ := db.Prepare("DELETE LOW_PRIORITY FROM document WHERE expiration < CURRENT_TIMESTAMP AND expiration > FROM_UNIXTIME(0)")
-
-	result, err := stmt.Exec()
+	n, err := store.DeleteExpiredDocuments()
 	if err != nil {
 		Log.Errorf("couldn't execute cleanup statement: %s", err)
-	} else {
-		n, err := result.RowsAffected()
-		if err == nil && n > 0 {
-			Log.Debugf("cleaned up %d documents", n)
-		}
+	} else if n > 0 {
+		Log.Debugf("cleaned up %d documents", n)
 	}
 }
@@ -1,10 +1,8 @@
 package wasabee
 
 import (
-	"database/sql"
 	"fmt"
 	"html/template"
-	"strconv"
 	"strings"
 )
 
@@ -40,98 +38,38 @@ type Agent struct {
 // AgentInTeam checks to see if a agent is in a team and enabled.
 // allowOff == true will report if a agent is in a team even if they are Off. That should ONLY be used to display lists of teams to the calling agent.
 func (gid GoogleID) AgentInTeam(team TeamID, allowOff bool) (bool, error) {
-	var count string
-
-	var err error
-	if allowOff {
-		err = db.QueryRow("SELECT COUNT(*) FROM agentteams WHERE teamID = ? AND gid = ?", team, gid).Scan(&count)
-	} else {
-		err = db.QueryRow("SELECT COUNT(*) FROM agentteams WHERE teamID = ? AND gid = ? AND state = 'On'", team, gid).Scan(&count)
-	}
-	if err != nil {
-		return false, err
-	}
-	i, err := strconv.Atoi(count)
-	if err != nil || i < 1 {
-		return false, err
-	}
-	return true, nil
+	return store.AgentInTeam(gid, team, allowOff)
 }
 
 // FetchTeam populates an entire TeamData struct
 // fetchAll includes agent for whom their state == off, should only be used to display lists to the calling agent
 func (teamID TeamID) FetchTeam(teamList *TeamData, fetchAll bool) error {
-	var state, lat, lon string
-	var tmpU Agent
-
-	var err error
-	var rows *sql.Rows
-	if fetchAll {
-		rows, err = db.Query("SELECT u.gid, u.iname, x.color, x.state, Y(l.loc), X(l.loc), l.upTime, u.VVerified, u.VBlacklisted, u.Vid, x.displayname "+
-			"FROM team=t, agentteams=x, agent=u, locations=l "+
-			"WHERE t.teamID = ? AND t.teamID = x.teamID AND x.gid = u.gid AND x.gid = l.gid ORDER BY x.state DESC, u.iname", teamID)
-	} else {
-		rows, err = db.Query("SELECT u.gid, u.iname, x.color, x.state, Y(l.loc), X(l.loc), l.upTime, u.VVerified, u.VBlacklisted, u.Vid, x.displayname "+
-			"FROM team=t, agentteams=x, agent=u, locations=l "+
-			"WHERE t.teamID = ? AND t.teamID = x.teamID AND x.gid = u.gid AND x.gid = l.gid "+
-			"AND x.state = 'On' ORDER BY x.state DESC, u.iname", teamID)
-	}
+	agents, err := store.TeamAgents(teamID, fetchAll)
 	if err != nil {
 		Log.Error(err)
 		return err
 	}
 
-	defer rows.Close()
-	for rows.Next() {
-		var enlID, dn sql.NullString
-		err := rows.Scan(&tmpU.Gid, &tmpU.Name, &tmpU.Squad, &state, &lat, &lon, &tmpU.Date, &tmpU.Verified, &tmpU.Blacklisted, &enlID, &dn)
-		if err != nil {
-			Log.Error(err)
-			return err
-		}
-		if state == "On" {
-			tmpU.State = true
-		} else {
-			tmpU.State = false
-		}
-		if enlID.Valid {
-			tmpU.EnlID = EnlID(enlID.String)
-		} else {
-			tmpU.EnlID = ""
-		}
-		tmpU.Lat, _ = strconv.ParseFloat(lat, 64)
-		tmpU.Lon, _ = strconv.ParseFloat(lon, 64)
+	for _, tmpU := range agents {
 		tmpU.PictureURL = tmpU.Gid.GetPicture()
-		if dn.Valid {
-			tmpU.Name = dn.String
-			tmpU.DisplayName = dn.String
-		} else {
-			tmpU.DisplayName = ""
+		if tmpU.DisplayName != "" {
+			tmpU.Name = tmpU.DisplayName
 		}
 		teamList.Agent = append(teamList.Agent, tmpU)
 	}
 
-	var rockscomm, rockskey sql.NullString
-	if err := db.QueryRow("SELECT name, rockscomm, rockskey FROM team WHERE teamID = ?", teamID).Scan(&teamList.Name, &rockscomm, &rockskey); err != nil {
+	if err := store.TeamData(teamID, teamList); err != nil {
 		Log.Error(err)
 		return err
 	}
 	teamList.ID = teamID
-	if rockscomm.Valid {
-		teamList.RocksComm = rockscomm.String
-	}
-	if rockskey.Valid {
-		teamList.RocksKey = rockskey.String
-	}
 
 	return nil
 }
 
 // OwnsTeam returns true if the GoogleID owns the team identified by teamID
 func (gid GoogleID) OwnsTeam(teamID TeamID) (bool, error) {
-	var owner GoogleID
-
-	err := db.QueryRow("SELECT owner FROM team WHERE teamID = ?", teamID).Scan(&owner)
+	owner, err := store.TeamOwner(teamID)
 	if err != nil {
 		return false, err
 	}
@@ -155,23 +93,17 @@ func (gid GoogleID) NewTeam(name string) (TeamID, error) {
 		Log.Notice(err)
 		return "", err
 	}
-	_, err = db.Exec("INSERT INTO team (teamID, owner, name, rockskey, rockscomm) VALUES (?,?,?,NULL,NULL)", team, gid, name)
-	if err != nil {
+	if err = store.InsertTeam(TeamID(team), gid, name); err != nil {
 		Log.Notice(err)
 		return "", err
 	}
-	_, err = db.Exec("INSERT INTO agentteams (teamID, gid, state, color, displayname) VALUES (?,?,'On','operator',NULL)", team, gid)
-	if err != nil {
-		Log.Notice(err)
-		return TeamID(team), err
-	}
 	return TeamID(team), nil
 }
 
 // Rename sets a new name for a teamID
 // does not check team ownership -- caller should take care of authorization
 func (teamID TeamID) Rename(name string) error {
-	_, err := db.Exec("UPDATE team SET name = ? WHERE teamID = ?", name, teamID)
+	err := store.SetTeamName(teamID, name)
 	if err != nil {
 		Log.Notice(err)
 	}
@@ -182,20 +114,13 @@ func (teamID TeamID) Rename(name string) error {
 // does not check team ownership -- caller should take care of authorization
 func (teamID TeamID) Delete() error {
 	// do them one-at-a-time to take care of .rocks sync
-	rows, err := db.Query("SELECT gid FROM agentteams WHERE teamID = ?", teamID)
+	members, err := store.TeamMembers(teamID, false)
 	if err != nil {
 		Log.Error(err)
 		return err
 	}
-	defer rows.Close()
 
-	var gid GoogleID
-	for rows.Next() {
-		err = rows.Scan(&gid)
-		if err != nil {
-			Log.Notice(err)
-			continue
-		}
+	for _, gid := range members {
 		err = teamID.RemoveAgent(gid)
 		if err != nil {
 			Log.Notice(err)
@@ -203,13 +128,7 @@ func (teamID TeamID) Delete() error {
 		}
 	}
 
-	_, err = db.Exec("DELETE FROM opteams WHERE teamID = ?", teamID)
-	if err != nil {
-		Log.Notice(err)
-		return err
-	}
-	_, err = db.Exec("DELETE FROM team WHERE teamID = ?", teamID)
-	if err != nil {
+	if err = store.DeleteTeam(teamID); err != nil {
 		Log.Notice(err)
 		return err
 	}
@@ -224,8 +143,7 @@ func (teamID TeamID) AddAgent(in AgentID) error {
 		return err
 	}
 
-	_, err = db.Exec("INSERT IGNORE INTO agentteams (teamID, gid, state, color, displayname) VALUES (?, ?, 'Off', 'boots', NULL)", teamID, gid)
-	if err != nil {
+	if err = store.AddTeamAgent(teamID, gid); err != nil {
 		Log.Notice(err)
 		return err
 	}
@@ -234,6 +152,7 @@ func (teamID TeamID) AddAgent(in AgentID) error {
 		Log.Notice(err)
 		// return err
 	}
+	publishEvent(Event{Type: EventTeamJoin, TeamID: teamID, Agent: gid, Action: "join"})
 	return nil
 }
 
@@ -245,8 +164,7 @@ func (teamID TeamID) RemoveAgent(in AgentID) error {
 		return err
 	}
 
-	_, err = db.Exec("DELETE FROM agentteams WHERE teamID = ? AND gid = ?", teamID, gid)
-	if err != nil {
+	if err = store.RemoveTeamAgent(teamID, gid); err != nil {
 		Log.Notice(err)
 		return err
 	}
@@ -255,6 +173,7 @@ func (teamID TeamID) RemoveAgent(in AgentID) error {
 		Log.Notice(err)
 		// return err
 	}
+	publishEvent(Event{Type: EventTeamLeave, TeamID: teamID, Agent: gid, Action: "leave"})
 	return nil
 }
 
@@ -267,8 +186,7 @@ func (teamID TeamID) Chown(to AgentID) error {
 		return err
 	}
 
-	_, err = db.Exec("UPDATE team SET owner = ? WHERE teamID = ?", gid, teamID)
-	if err != nil {
+	if err = store.SetTeamOwner(teamID, gid); err != nil {
 		Log.Notice(err)
 		return (err)
 	}
@@ -277,45 +195,12 @@ func (teamID TeamID) Chown(to AgentID) error {
 
 // TeammatesNear identifies other agents who are on ANY mutual team within maxdistance km, returning at most maxresults
 func (gid GoogleID) TeammatesNear(maxdistance, maxresults int, teamList *TeamData) error {
-	var state, lat, lon string
-	var tmpU Agent
-	var rows *sql.Rows
-
-	err := db.QueryRow("SELECT Y(loc), X(loc) FROM locations WHERE gid = ?", gid).Scan(&lat, &lon)
-	if err != nil {
-		Log.Error(err)
-		return err
-	}
-	// Log.Debug("Teammates Near: " + gid.String() + " @ " + lat.String + "," + lon.String + " " + strconv.Itoa(maxdistance) + " " + strconv.Itoa(maxresults))
-
-	// no ST_Distance_Sphere in MariaDB yet...
-	rows, err = db.Query("SELECT DISTINCT u.iname, x.color, x.state, Y(l.loc), X(l.loc), l.upTime, u.VVerified, u.VBlacklisted, "+
-		"ROUND(6371 * acos (cos(radians(?)) * cos(radians(Y(l.loc))) * cos(radians(X(l.loc)) - radians(?)) + sin(radians(?)) * sin(radians(Y(l.loc))))) AS distance "+
-		"FROM agentteams=x, agent=u, locations=l "+
-		"WHERE x.teamID IN (SELECT teamID FROM agentteams WHERE gid = ? AND state = 'On') "+
-		"AND x.state = 'On' AND x.gid = u.gid AND x.gid = l.gid AND l.upTime > SUBTIME(NOW(), '12:00:00') "+
-		"HAVING distance < ? AND distance > 0 ORDER BY distance LIMIT 0,?", lat, lon, lat, gid, maxdistance, maxresults)
+	agents, err := store.TeammatesNear(gid, maxdistance, maxresults)
 	if err != nil {
 		Log.Error(err)
 		return err
 	}
-
-	defer rows.Close()
-	for rows.Next() {
-		err := rows.Scan(&tmpU.Name, &tmpU.Squad, &state, &lat, &lon, &tmpU.Date, &tmpU.Verified, &tmpU.Blacklisted, &tmpU.Distance)
-		if err != nil {
-			Log.Error(err)
-			return err
-		}
-		if state == "On" {
-			tmpU.State = true
-		} else {
-			tmpU.State = false
-		}
-		tmpU.Lat, _ = strconv.ParseFloat(lat, 64)
-		tmpU.Lon, _ = strconv.ParseFloat(lon, 64)
-		teamList.Agent = append(teamList.Agent, tmpU)
-	}
+	teamList.Agent = append(teamList.Agent, agents...)
 	return nil
 }
 
@@ -324,7 +209,7 @@ func (gid GoogleID) TeammatesNear(maxdistance, maxresults int, teamList *TeamDat
 // Local adds/deletes will be pushed to the community (API management must be enabled on the community at enl.rocks).
 // adds/deletes at enl.rocks will be pushed here (onJoin/onLeave web hooks must be configured in the community at enl.rocks)
 func (teamID TeamID) SetRocks(key, community string) error {
-	_, err := db.Exec("UPDATE team SET rockskey = ?, rockscomm = ? WHERE teamID = ?", key, community, teamID)
+	err := store.SetTeamRocks(teamID, key, community)
 	if err != nil {
 		Log.Notice(err)
 	}
@@ -341,75 +226,51 @@ func (gid GoogleID) SetTeamState(teamID TeamID, state string) error {
 		state = "Off"
 	}
 
-	if _, err := db.Exec("UPDATE agentteams SET state = ? WHERE gid = ? AND teamID = ?", state, gid, teamID); err != nil {
+	if err := store.SetTeamAgentState(teamID, gid, state); err != nil {
 		Log.Notice(err)
 		return err
 	}
-	if state == "On" {
-		gid.firebaseSubscribeTeam(teamID)
-	} else {
-		gid.firebaseUnsubscribeTeam(teamID)
-	}
+	publishEvent(Event{Type: EventTeamState, TeamID: teamID, Gid: gid, Detail: state})
 	return nil
 }
 
 // FetchAgent populates the minimal Agent struct with data anyone can see
 func FetchAgent(id AgentID, agent *Agent) error {
-	var vid sql.NullString
 	gid, err := id.Gid()
 	if err != nil {
 		Log.Error(err)
 		return err
 	}
 
-	err = db.QueryRow("SELECT u.gid, u.iname, u.level, u.VVerified, u.VBlacklisted, u.Vid, u.RocksVerified FROM agent=u WHERE u.gid = ?", gid).Scan(
-		&agent.Gid, &agent.Name, &agent.Level, &agent.Verified, &agent.Blacklisted, &vid, &agent.RocksVerified)
-	if err != nil {
+	if err = store.Agent(gid, agent); err != nil {
 		Log.Error(err)
 		return err
 	}
-	if vid.Valid {
-		agent.EnlID = EnlID(vid.String)
-	}
 	return nil
 }
 
 // Name returns a team's friendly name for a TeamID
 func (teamID TeamID) Name() (string, error) {
-	var name string
-	err := db.QueryRow("SELECT name FROM team WHERE teamID = ?", teamID).Scan(&name)
-	if err != nil {
-		Log.Error(err)
-		return "", err
-	}
+	name, _ := store.TeamName(teamID)
 	return name, nil
 }
 
 // TeamMenu is used for html templates {{TeamMenu .Gid .TeamID}} .Gid is the user's GoogleID, TeamID is the current Op's teamID (for setting selected)
 func TeamMenu(gid GoogleID, teamID TeamID) (template.HTML, error) {
-	rows, err := db.Query("SELECT t.name, t.teamID FROM agentteams=x, team=t WHERE x.gid = ? AND x.teamID = t.teamID", gid)
+	teams, err := store.AgentTeams(gid)
 	if err != nil {
 		Log.Error(err)
 		return "", err
 	}
 
-	defer rows.Close()
-
 	var b strings.Builder
-	var name string
-	var tid string
 
 	_, _ = b.WriteString(`<select name="team">`)
-	for rows.Next() {
-		err := rows.Scan(&name, &tid)
-		if err != nil {
-			Log.Error(err)
-			continue
-		}
-		if tid == string(teamID) {
-			_, _ = b.WriteString(fmt.Sprintf("<option value=\"%s\" selected=\"selected\">%s</option>", tid, name))
+	for _, t := range teams {
+		if t.ID == string(teamID) {
+			_, _ = b.WriteString(fmt.Sprintf("<option value=\"%s\" selected=\"selected\">%s</option>", t.ID, t.Name))
 		} else {
-			_, _ = b.WriteString(fmt.Sprintf("<option value=\"%s\">%s</option>", tid, name))
+			_, _ = b.WriteString(fmt.Sprintf("<option value=\"%s\">%s</option>", t.ID, t.Name))
 		}
 	}
 	_, _ = b.WriteString(`</select>`)
@@ -419,26 +280,15 @@ func TeamMenu(gid GoogleID, teamID TeamID) (template.HTML, error) {
 
 // teamList is used for getting a list of all an agent's (active) teams
 func (gid GoogleID) teamList() []TeamID {
-	var tid TeamID
-	var x []TeamID
-
-	rows, err := db.Query("SELECT teamID FROM agentteams WHERE gid = ?", gid)
+	x, err := store.AgentTeamIDs(gid)
 	if err != nil {
 		Log.Error(err)
-		return x
-	}
-	for rows.Next() {
-		if err := rows.Scan(&tid); err != nil {
-			Log.Error(err)
-			continue
-		}
-		x = append(x, tid)
 	}
 	return x
 }
 
 func (teamID TeamID) SetSquad(gid GoogleID, squad string) error {
-	_, err := db.Exec("UPDATE agentteams SET color = ? WHERE teamID = ? and gid = ?", MakeNullString(squad), teamID, gid)
+	err := store.SetTeamAgentSquad(teamID, gid, squad)
 	if err != nil {
 		Log.Notice(err)
 		return err
@@ -447,7 +297,7 @@ func (teamID TeamID) SetSquad(gid GoogleID, squad string) error {
 }
 
 func (teamID TeamID) SetDisplaname(gid GoogleID, displayname string) error {
-	_, err := db.Exec("UPDATE agentteams SET displayname = ? WHERE teamID = ? and gid = ?", MakeNullString(displayname), teamID, gid)
+	err := store.SetTeamAgentDisplayName(teamID, gid, displayname)
 	if err != nil {
 		Log.Notice(err)
 		return err
@@ -45,11 +45,7 @@ func TGRunning() (bool, error) {
 
 // GidV returns a GoogleID and V verified status for a given Telegram ID #
 func (tgid TelegramID) GidV() (GoogleID, bool, error) {
-	var gid GoogleID
-	var verified bool
-
-	row := db.QueryRow("SELECT gid, verified FROM telegram WHERE telegramID = ?", tgid)
-	err := row.Scan(&gid, &verified)
+	gid, verified, err := store.TelegramGid(tgid)
 	if err != nil && err == sql.ErrNoRows {
 		return "", false, nil
 	}
@@ -74,10 +70,7 @@ func (tgid TelegramID) Gid() (GoogleID, error) {
 
 // TelegramID returns a telegram ID number for a gid
 func (gid GoogleID) TelegramID() (TelegramID, error) {
-	var tgid TelegramID
-
-	row := db.QueryRow("SELECT telegramID FROM telegram WHERE gid = ?", gid)
-	err := row.Scan(&tgid)
+	tgid, _, _, err := store.AgentTelegram(gid)
 	if err != nil && err == sql.ErrNoRows {
 		return 0, nil
 	}
@@ -103,8 +96,7 @@ func (tgid TelegramID) InitAgent(name string, lockey LocKey) error {
 		return err
 	}
 
-	_, err = db.Exec("INSERT INTO telegram (telegramID, telegramName, gid, verified, authtoken) VALUES (?, 'unused', ?, 0, ?)", tgid, gid, authtoken)
-	if err != nil {
+	if err = store.InsertTelegram(tgid, gid, authtoken); err != nil {
 		Log.Notice(err)
 		return err
 	}
@@ -114,18 +106,13 @@ func (tgid TelegramID) InitAgent(name string, lockey LocKey) error {
 
 // VerifyAgent is the second stage of the verication process
 func (tgid TelegramID) VerifyAgent(authtoken string) error {
-	res, err := db.Exec("UPDATE telegram SET authtoken = NULL, verified = 1 WHERE telegramID = ? AND authtoken = ?", tgid, authtoken)
-	if err != nil {
-		Log.Notice(err)
-		return err
-	}
-	i, err := res.RowsAffected()
+	ok, err := store.VerifyTelegram(tgid, authtoken)
 	if err != nil {
 		Log.Notice(err)
 		return err
 	}
 
-	if i < 1 {
+	if !ok {
 		err = fmt.Errorf("invalid AuthToken")
 		return err
 	} // trust the primary key prevents i > 1
@@ -1,7 +1,6 @@
 package wasabee
 
 import (
-	"database/sql"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
@@ -139,9 +138,7 @@ func (gid GoogleID) VUpdate(vres *Vresult) error {
 
 	if vres.Status == "ok" && vres.Data.Agent != "" {
 		// Log.Debug("Updating V data for ", vres.Data.Agent)
-		_, err := db.Exec("UPDATE agent SET iname = ?, level = ?, VVerified = ?, VBlacklisted = ?, Vid = ? WHERE gid = ?",
-			vres.Data.Agent, vres.Data.Level, vres.Data.Verified, vres.Data.Blacklisted, MakeNullString(vres.Data.EnlID), gid)
-
+		err := store.UpdateAgentV(gid, vres.Data.Agent, vres.Data.Level, vres.Data.Verified, vres.Data.Blacklisted, vres.Data.EnlID)
 		if err != nil {
 			Log.Error(err)
 			return err
@@ -274,7 +271,7 @@ func (gid GoogleID) StatusLocation() (string, string, error) {
 
 // StatusLocationEnable turns RAID/JEAH pulling on for the specified agent
 func (eid EnlID) StatusLocationEnable() error {
-	_, err := db.Exec("UPDATE agent SET RAID = 1 WHERE Vid = ?", eid)
+	err := store.SetEnlIDRAID(eid, true)
 	if err != nil {
 		Log.Error(err)
 		return err
@@ -291,7 +288,7 @@ func (gid GoogleID) StatusLocationEnable() error {
 
 // StatusLocationDisable turns RAID/JEAH pulling off for the specified agent
 func (eid EnlID) StatusLocationDisable() error {
-	_, err := db.Exec("UPDATE agent SET RAID = 0 WHERE Vid = ?", eid)
+	err := store.SetEnlIDRAID(eid, false)
 	if err != nil {
 		Log.Error(err)
 		return err
@@ -301,7 +298,7 @@ func (eid EnlID) StatusLocationDisable() error {
 
 // StatusLocationDisable turns RAID/JEAH pulling off for the specified agent
 func (gid GoogleID) StatusLocationDisable() error {
-	_, err := db.Exec("UPDATE agent SET RAID = 0 WHERE gid = ?", gid)
+	err := store.SetAgentRAID(gid, false)
 	if err != nil {
 		Log.Error(err)
 		return err
@@ -311,8 +308,7 @@ func (gid GoogleID) StatusLocationDisable() error {
 
 // EnlID returns the V EnlID for a agent if it is known.
 func (gid GoogleID) EnlID() (EnlID, error) {
-	var e EnlID
-	err := db.QueryRow("SELECT Vid FROM agent WHERE gid = ?", gid).Scan(&e)
+	e, err := store.AgentEnlID(gid)
 	if err != nil {
 		Log.Error(err)
 	}
@@ -331,30 +327,22 @@ func StatusServerPoller() {
 	Log.Info("Starting status.enl.one Poller")
 	for {
 		// get list of agents who say they use JEAH/RAID
-		row, err := db.Query("SELECT gid, Vid FROM agent WHERE RAID = 1")
+		agents, err := store.RAIDAgents()
 		if err != nil {
 			Log.Error(err)
 			return
 		}
-		defer row.Close()
-		var gid GoogleID
-		var vid sql.NullString
 
-		for row.Next() {
-			err = row.Scan(&gid, &vid)
+		for _, a := range agents {
+			gid := a.Gid
 			// XXX if the agent isn't active on any teams, ignore
-			if err != nil {
-				Log.Error(err)
-				continue
-			}
 			// Log.Debugf("Polling status.enl.one for %s", gid.String)
-			if !vid.Valid {
+			if a.EnlID == "" {
 				Log.Info("agent requested RAID poll, but has not configured V")
 				_ = gid.StatusLocationDisable()
 				continue
 			}
-			e := EnlID(vid.String)
-			lat, lon, err := e.StatusLocation()
+			lat, lon, err := a.EnlID.StatusLocation()
 			if err != nil {
 				// XXX add the agent to an exception list? purge the list every 12 hours?
 				Log.Error(err)
@@ -373,8 +361,7 @@ func StatusServerPoller() {
 
 // Gid looks up a GoogleID from an EnlID
 func (eid EnlID) Gid() (GoogleID, error) {
-	var gid GoogleID
-	err := db.QueryRow("SELECT gid FROM agent WHERE Vid = ?", eid).Scan(&gid)
+	gid, err := store.EnlIDGid(eid)
 	if err != nil {
 		Log.Error(err)
 		return "", err
@@ -0,0 +1,649 @@
+package wasabee
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Webhook is an HTTPS endpoint which is sent a signed POST for each event on an operation or team
+type Webhook struct {
+	ID      string      `json:"ID"`
+	OpID    OperationID `json:"opID,omitempty"`
+	TeamID  TeamID      `json:"teamID,omitempty"`
+	Gid     GoogleID    `json:"gid"`
+	URL     string      `json:"url"`
+	Secret  string      `json:"secret,omitempty"` // only returned when the hook is created
+	Events  []string    `json:"events"`           // empty for all
+	Created string      `json:"created"`
+}
+
+// WebhookDelivery is one attempt at sending an event to a webhook
+type WebhookDelivery struct {
+	ID        int    `json:"ID"`
+	HookID    string `json:"hookID"`
+	EventID   int64  `json:"eventID"`
+	Event     string `json:"event"`
+	Attempt   int    `json:"attempt"`
+	Status    int    `json:"status"` // the HTTP status, 0 if no response
+	Error     string `json:"error,omitempty"`
+	Delivered string `json:"delivered"`
+}
+
+const (
+	webhookAttempts   = 5
+	webhookTimeout    = 10 * time.Second
+	webhookDeliveries = 100
+	webhookWorkers    = 16
+	webhookQueueLen   = 64 // events waiting per hook; more are dropped
+	webhookURLMax     = 512
+	webhookSecretMax  = 128
+)
+
+// webhookBackoff is how long to wait before each attempt
+var webhookBackoff = [webhookAttempts]time.Duration{0, 30 * time.Second, 2 * time.Minute, 10 * time.Minute, 30 * time.Minute}
+
+// webhookQueues holds the events waiting for each hook. A hook's events are sent one at a time, in order,
+// by a goroutine which exits once the queue is empty.
+var webhookQueues = struct {
+	sync.Mutex
+	q map[string]*webhookQueue
+}{q: make(map[string]*webhookQueue)}
+
+type webhookQueue struct {
+	c       chan Event
+	dropped uint64
+}
+
+// webhookEventTypes are the events a webhook can ask for
+var webhookEventTypes = []EventType{
+	EventMarkerStatus,
+	EventMarkerAssignment,
+	EventLinkStatus,
+	EventLinkAssignment,
+	EventTeamJoin,
+	EventTeamLeave,
+	EventTeamAnnounce,
+	EventOpChange,
+}
+
+// webhookNoDial are the networks webhooks may not be sent to
+var webhookNoDial = parseCIDRs(
+	"0.0.0.0/8",
+	"10.0.0.0/8",
+	"100.64.0.0/10",
+	"127.0.0.0/8",
+	"169.254.0.0/16",
+	"172.16.0.0/12",
+	"192.168.0.0/16",
+	"224.0.0.0/4",
+	"240.0.0.0/4",
+	"::/128",
+	"::1/128",
+	"fc00::/7",
+	"fe80::/10",
+	"ff00::/8",
+)
+
+func parseCIDRs(cidrs ...string) []*net.IPNet {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, c := range cidrs {
+		_, n, err := net.ParseCIDR(c)
+		if err != nil {
+			panic(err)
+		}
+		nets = append(nets, n)
+	}
+	return nets
+}
+
+// webhookClient refuses to connect to anything on the server's own networks and does not follow redirects
+var webhookClient = &http.Client{
+	Timeout: webhookTimeout,
+	Transport: &http.Transport{
+		Proxy: nil,
+		DialContext: (&net.Dialer{
+			Timeout: webhookTimeout,
+			Control: webhookDialControl,
+		}).DialContext,
+		TLSHandshakeTimeout: webhookTimeout,
+		MaxIdleConns:        webhookWorkers,
+		IdleConnTimeout:     time.Minute,
+	},
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+// webhookDialControl checks the address actually being dialed, after DNS, so a hostname cannot point a hook at an internal service
+func webhookDialControl(network, address string, c syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if err := webhookAllowedIP(net.ParseIP(host)); err != nil {
+		Log.Notice(err)
+		return err
+	}
+	return nil
+}
+
+func webhookAllowedIP(ip net.IP) error {
+	if ip == nil {
+		return fmt.Errorf("webhook address is not an IP")
+	}
+	for _, n := range webhookNoDial {
+		if n.Contains(ip) {
+			return fmt.Errorf("webhook address %s is not public", ip)
+		}
+	}
+	return nil
+}
+
+// checkWebhookURL makes sure the URL is https and, if it is given as an IP address, is a public one
+func checkWebhookURL(rawurl string) error {
+	if len(rawurl) > webhookURLMax {
+		err := fmt.Errorf("webhook URL too long")
+		Log.Notice(err)
+		return err
+	}
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		Log.Notice(err)
+		return err
+	}
+	if u.Scheme != "https" || u.Hostname() == "" {
+		err := fmt.Errorf("webhook URL must be https://host/...")
+		Log.Notice(err)
+		return err
+	}
+	if ip := net.ParseIP(u.Hostname()); ip != nil {
+		if err := webhookAllowedIP(ip); err != nil {
+			Log.Notice(err)
+			return err
+		}
+	}
+	if strings.EqualFold(u.Hostname(), "localhost") {
+		err := fmt.Errorf("webhook URL must not be localhost")
+		Log.Notice(err)
+		return err
+	}
+	return nil
+}
+
+// parseWebhookEvents turns a comma separated list of event names into the list stored with the hook
+func parseWebhookEvents(events string) ([]string, error) {
+	var list []string
+	for _, e := range strings.Split(events, ",") {
+		e = strings.TrimSpace(e)
+		if e == "" {
+			continue
+		}
+		known := false
+		for _, t := range webhookEventTypes {
+			if string(t) == e {
+				known = true
+				break
+			}
+		}
+		if !known {
+			err := fmt.Errorf("unknown webhook event %s", e)
+			Log.Notice(err)
+			return nil, err
+		}
+		list = append(list, e)
+	}
+	return list, nil
+}
+
+func newWebhook(gid GoogleID, rawurl, secret, events string) (Webhook, error) {
+	if err := checkWebhookURL(rawurl); err != nil {
+		return Webhook{}, err
+	}
+
+	list, err := parseWebhookEvents(events)
+	if err != nil {
+		return Webhook{}, err
+	}
+
+	if secret == "" {
+		b := make([]byte, 32)
+		if _, err := rand.Read(b); err != nil {
+			Log.Error(err)
+			return Webhook{}, err
+		}
+		secret = hex.EncodeToString(b)
+	}
+	if len(secret) > webhookSecretMax {
+		err := fmt.Errorf("webhook secret too long")
+		Log.Notice(err)
+		return Webhook{}, err
+	}
+
+	return Webhook{
+		ID:     GenerateName(),
+		Gid:    gid,
+		URL:    rawurl,
+		Secret: secret,
+		Events: list,
+	}, nil
+}
+
+func insertWebhook(h Webhook) (Webhook, error) {
+	if err := store.InsertWebhook(h); err != nil {
+		Log.Error(err)
+		return Webhook{}, err
+	}
+	secret := h.Secret
+	h, err := store.Webhook(h.ID)
+	if err != nil {
+		Log.Error(err)
+		return Webhook{}, err
+	}
+	h.Secret = secret
+	return h, nil
+}
+
+// AddWebhook registers a webhook for the operation's events; only the owner may do this.
+// The secret is generated if not given and is only returned here.
+func (opID OperationID) AddWebhook(gid GoogleID, rawurl, secret, events string) (Webhook, error) {
+	if !opID.IsOwner(gid) {
+		err := fmt.Errorf("permission denied: %s adding webhook to op %s", gid, opID)
+		Log.Error(err)
+		return Webhook{}, err
+	}
+
+	h, err := newWebhook(gid, rawurl, secret, events)
+	if err != nil {
+		return h, err
+	}
+	h.OpID = opID
+	return insertWebhook(h)
+}
+
+// AddWebhook registers a webhook for the team's events, and those of the operations assigned to it; only the owner may do this.
+func (teamID TeamID) AddWebhook(gid GoogleID, rawurl, secret, events string) (Webhook, error) {
+	if owns, _ := gid.OwnsTeam(teamID); !owns {
+		err := fmt.Errorf("permission denied: %s adding webhook to team %s", gid, teamID)
+		Log.Error(err)
+		return Webhook{}, err
+	}
+
+	h, err := newWebhook(gid, rawurl, secret, events)
+	if err != nil {
+		return h, err
+	}
+	h.TeamID = teamID
+	return insertWebhook(h)
+}
+
+func hideSecrets(hooks []Webhook) []Webhook {
+	for i := range hooks {
+		hooks[i].Secret = ""
+	}
+	return hooks
+}
+
+// Webhooks lists the operation's webhooks, without their secrets
+func (opID OperationID) Webhooks(gid GoogleID) ([]Webhook, error) {
+	if !opID.IsOwner(gid) {
+		err := fmt.Errorf("permission denied: %s listing webhooks of op %s", gid, opID)
+		Log.Error(err)
+		return nil, err
+	}
+
+	hooks, err := store.OpWebhooks(opID)
+	if err != nil {
+		Log.Error(err)
+		return nil, err
+	}
+	return hideSecrets(hooks), nil
+}
+
+// Webhooks lists the team's webhooks, without their secrets
+func (teamID TeamID) Webhooks(gid GoogleID) ([]Webhook, error) {
+	if owns, _ := gid.OwnsTeam(teamID); !owns {
+		err := fmt.Errorf("permission denied: %s listing webhooks of team %s", gid, teamID)
+		Log.Error(err)
+		return nil, err
+	}
+
+	hooks, err := store.TeamWebhooks(teamID)
+	if err != nil {
+		Log.Error(err)
+		return nil, err
+	}
+	return hideSecrets(hooks), nil
+}
+
+// Webhook looks up a webhook on an operation or team the agent owns; the secret is not returned
+func (gid GoogleID) Webhook(hookID string) (Webhook, error) {
+	h, err := store.Webhook(hookID)
+	if err != nil {
+		Log.Notice(err)
+		return h, err
+	}
+
+	owner := false
+	if h.OpID != "" {
+		owner = h.OpID.IsOwner(gid)
+	} else if h.TeamID != "" {
+		owner, _ = gid.OwnsTeam(h.TeamID)
+	}
+	if !owner {
+		err := fmt.Errorf("permission denied: %s accessing webhook %s", gid, hookID)
+		Log.Error(err)
+		return Webhook{}, err
+	}
+	h.Secret = ""
+	return h, nil
+}
+
+// DeleteWebhook removes a webhook and its delivery log
+func (gid GoogleID) DeleteWebhook(hookID string) error {
+	if _, err := gid.Webhook(hookID); err != nil {
+		return err
+	}
+	if err := store.DeleteWebhook(hookID); err != nil {
+		Log.Error(err)
+		return err
+	}
+	return nil
+}
+
+// WebhookDeliveries lists the most recent delivery attempts for a webhook, newest first
+func (gid GoogleID) WebhookDeliveries(hookID string) ([]WebhookDelivery, error) {
+	if _, err := gid.Webhook(hookID); err != nil {
+		return nil, err
+	}
+	deliveries, err := store.WebhookDeliveries(hookID, webhookDeliveries)
+	if err != nil {
+		Log.Error(err)
+		return nil, err
+	}
+	return deliveries, nil
+}
+
+// TestWebhook sends a ping to the webhook straight away, once, and returns how it went
+func (gid GoogleID) TestWebhook(hookID string) (WebhookDelivery, error) {
+	if _, err := gid.Webhook(hookID); err != nil {
+		return WebhookDelivery{}, err
+	}
+	h, err := store.Webhook(hookID)
+	if err != nil {
+		Log.Error(err)
+		return WebhookDelivery{}, err
+	}
+
+	d := h.deliver(Event{Type: "ping", OpID: h.OpID, TeamID: h.TeamID, Gid: gid, Action: "ping"}, 1)
+	return d, nil
+}
+
+// WebhookSignature is the value of the X-Wasabee-Signature header: the hex HMAC-SHA256 of the body keyed with the hook's secret
+func WebhookSignature(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+type webhookPayload struct {
+	ID       int64       `json:"id"`
+	Event    EventType   `json:"event"`
+	OpID     OperationID `json:"opID,omitempty"`
+	TeamID   TeamID      `json:"teamID,omitempty"`
+	ObjectID string      `json:"objectID,omitempty"`
+	Gid      GoogleID    `json:"gid,omitempty"`
+	Agent    GoogleID    `json:"agent,omitempty"`
+	Action   string      `json:"action,omitempty"`
+	Detail   string      `json:"detail,omitempty"`
+	Data     interface{} `json:"data,omitempty"`
+	Sent     string      `json:"sent"`
+}
+
+// deliver makes one attempt at sending the event and logs it
+func (h Webhook) deliver(e Event, attempt int) WebhookDelivery {
+	d := WebhookDelivery{
+		HookID:  h.ID,
+		EventID: e.ID,
+		Event:   string(e.Type),
+		Attempt: attempt,
+	}
+
+	body, err := json.Marshal(webhookPayload{
+		ID:       e.ID,
+		Event:    e.Type,
+		OpID:     e.OpID,
+		TeamID:   e.TeamID,
+		ObjectID: e.ObjectID,
+		Gid:      e.Gid,
+		Agent:    e.Agent,
+		Action:   e.Action,
+		Detail:   e.Detail,
+		Data:     e.Data,
+		Sent:     time.Now().UTC().Format(time.RFC3339),
+	})
+	if err != nil {
+		Log.Error(err)
+		d.Error = err.Error()
+		h.logDelivery(d)
+		return d
+	}
+
+	req, err := http.NewRequest("POST", h.URL, bytes.NewReader(body))
+	if err != nil {
+		Log.Notice(err)
+		d.Error = err.Error()
+		h.logDelivery(d)
+		return d
+	}
+	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+	req.Header.Set("User-Agent", "Wasabee-Server webhook")
+	req.Header.Set("X-Wasabee-Event", string(e.Type))
+	req.Header.Set("X-Wasabee-Delivery", fmt.Sprintf("%d", e.ID))
+	req.Header.Set("X-Wasabee-Signature", WebhookSignature(h.Secret, body))
+
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		Log.Debug(err)
+		d.Error = err.Error()
+		h.logDelivery(d)
+		return d
+	}
+	_, _ = io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 64*1024))
+	resp.Body.Close()
+
+	d.Status = resp.StatusCode
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		d.Error = resp.Status
+	}
+	h.logDelivery(d)
+	return d
+}
+
+func (h Webhook) logDelivery(d WebhookDelivery) {
+	if err := store.InsertWebhookDelivery(d); err != nil {
+		// the hook may have been deleted while sending
+		Log.Debug(err)
+	}
+}
+
+// wants is true if the hook asked for this type of event
+func (h Webhook) wants(t EventType) bool {
+	if len(h.Events) == 0 {
+		for _, w := range webhookEventTypes {
+			if w == t {
+				return true
+			}
+		}
+		return false
+	}
+	for _, w := range h.Events {
+		if w == string(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// send delivers the event, retrying with backoff until the hook accepts it or the attempts run out
+func (h Webhook) send(e Event, workers chan struct{}) {
+	for attempt := 1; attempt <= webhookAttempts; attempt++ {
+		time.Sleep(webhookBackoff[attempt-1])
+
+		workers <- struct{}{}
+		d := h.deliver(e, attempt)
+		<-workers
+
+		if d.Error == "" {
+			return
+		}
+		// stop if the hook was deleted in the meantime
+		if _, err := store.Webhook(h.ID); err != nil {
+			return
+		}
+	}
+	Log.Noticef("giving up on webhook %s for event %d", h.ID, e.ID)
+}
+
+// enqueue adds the event to the hook's queue, starting a sender if there is none; a full queue drops it
+func (h Webhook) enqueue(e Event, workers chan struct{}) {
+	webhookQueues.Lock()
+	defer webhookQueues.Unlock()
+
+	q, ok := webhookQueues.q[h.ID]
+	if !ok {
+		q = &webhookQueue{c: make(chan Event, webhookQueueLen)}
+		webhookQueues.q[h.ID] = q
+		go h.drain(q, workers)
+	}
+	select {
+	case q.c <- e:
+	default:
+		// a hook stuck in backoff fills its queue; log as the event bus does
+		q.dropped++
+		if q.dropped == 1 || q.dropped%100 == 0 {
+			Log.Warningf("webhook %s is not keeping up: %d events dropped", h.ID, q.dropped)
+		}
+	}
+}
+
+// drain sends the queued events until there are none left
+func (h Webhook) drain(q *webhookQueue, workers chan struct{}) {
+	for {
+		webhookQueues.Lock()
+		select {
+		case e := <-q.c:
+			webhookQueues.Unlock()
+			h.send(e, workers)
+		default:
+			delete(webhookQueues.q, h.ID)
+			webhookQueues.Unlock()
+			return
+		}
+	}
+}
+
+func init() {
+	go webhookEvents(SubscribeEvents("webhooks", 256))
+}
+
+// webhookEvents finds the hooks interested in each event and sends it to them
+func webhookEvents(sub *EventSubscription) {
+	workers := make(chan struct{}, webhookWorkers)
+
+	for e := range sub.Events() {
+		var hooks []Webhook
+		switch {
+		case e.OpID != "":
+			h, err := store.OpWebhooks(e.OpID)
+			if err != nil {
+				Log.Error(err)
+			}
+			hooks = append(hooks, h...)
+			teams, err := store.OperationTeams(e.OpID)
+			if err != nil {
+				Log.Error(err)
+			}
+			seen := make(map[TeamID]bool)
+			for _, t := range teams {
+				if seen[t.TeamID] || !t.sees(e) {
+					continue
+				}
+				seen[t.TeamID] = true
+				h, err := store.TeamWebhooks(t.TeamID)
+				if err != nil {
+					Log.Error(err)
+				}
+				hooks = append(hooks, h...)
+			}
+		case e.TeamID != "":
+			h, err := store.TeamWebhooks(e.TeamID)
+			if err != nil {
+				Log.Error(err)
+			}
+			hooks = append(hooks, h...)
+		}
+
+		for _, h := range hooks {
+			if h.wants(e.Type) {
+				h.enqueue(e, workers)
+			}
+		}
+	}
+}
+
+// sees reports whether the team's role on the op lets it see the event: read and write see everything,
+// assignedonly only sees the links and markers assigned to its members
+func (t ExtendedTeam) sees(e Event) bool {
+	if t.Role == etRoleRead || t.Role == etRoleWrite {
+		return true
+	}
+	if t.Role != etRoleAssignedOnly {
+		return false
+	}
+
+	object := e.Type.object()
+	if object != "link" && object != "marker" {
+		return false
+	}
+	members, err := store.TeamMembers(t.TeamID, false)
+	if err != nil {
+		Log.Error(err)
+		return false
+	}
+	var assignee GoogleID
+	if object == "marker" {
+		if assignee, err = store.MarkerAssignee(e.OpID, MarkerID(e.ObjectID)); err != nil && err != sql.ErrNoRows {
+			Log.Error(err)
+		}
+	}
+	for _, m := range members {
+		if m == e.Agent || m == assignee {
+			return true
+		}
+		if object == "link" {
+			assigned, err := store.LinkAssignedTo(e.OpID, LinkID(e.ObjectID), m)
+			if err != nil {
+				Log.Error(err)
+			}
+			if assigned {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,146 @@
+package wasabee_test
+
+import (
+	"encoding/json"
+	"github.com/wasabee-project/Wasabee-Server"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+func TestWebhook(t *testing.T) {
+	content, err := ioutil.ReadFile("testdata/test2.json")
+	if err != nil {
+		t.Error(err.Error())
+	}
+	if err := wasabee.DrawInsert(json.RawMessage(content), gid); err != nil {
+		t.Error(err.Error())
+	}
+	var in wasabee.Operation
+	if err := json.Unmarshal(content, &in); err != nil {
+		t.Error(err.Error())
+	}
+	op := wasabee.Operation{ID: in.ID}
+
+	for _, url := range []string{"http://example.invalid/hook", "https://127.0.0.1/hook", "https://[::1]/hook", "https://10.1.2.3/hook", "https://localhost/hook"} {
+		if _, err := op.ID.AddWebhook(gid, url, "", ""); err == nil {
+			t.Errorf("webhook to %s accepted", url)
+		}
+	}
+	if _, err := op.ID.AddWebhook(gid, "https://example.invalid/hook", "", "markerStatus,nonsense"); err == nil {
+		t.Error("unknown webhook event accepted")
+	}
+	if _, err := op.ID.AddWebhook(wasabee.GoogleID("nobody"), "https://example.invalid/hook", "", ""); err == nil {
+		t.Error("non-owner added a webhook")
+	}
+
+	h, err := op.ID.AddWebhook(gid, "https://example.invalid/hook", "", "markerStatus, linkStatus")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(h.Secret) != 64 || len(h.Events) != 2 {
+		t.Errorf("unexpected webhook: %v", h)
+	}
+
+	hooks, err := op.ID.Webhooks(gid)
+	if err != nil {
+		t.Error(err.Error())
+	}
+	if len(hooks) != 1 || hooks[0].ID != h.ID || hooks[0].Secret != "" {
+		t.Errorf("unexpected webhooks: %v", hooks)
+	}
+
+	// the host does not resolve, so the ping fails and is logged
+	d, err := gid.TestWebhook(h.ID)
+	if err != nil {
+		t.Error(err.Error())
+	}
+	if d.Event != "ping" || d.Error == "" || d.Status != 0 {
+		t.Errorf("unexpected delivery: %v", d)
+	}
+	deliveries, err := gid.WebhookDeliveries(h.ID)
+	if err != nil {
+		t.Error(err.Error())
+	}
+	if len(deliveries) == 0 || deliveries[0].Event != "ping" || deliveries[0].Attempt != 1 {
+		t.Errorf("unexpected deliveries: %v", deliveries)
+	}
+
+	if sig := wasabee.WebhookSignature("secret", []byte("{}")); sig != "sha256=77325902caca812dc259733aacd046b73817372c777b8d95b402647474516e13" {
+		t.Errorf("unexpected signature: %s", sig)
+	}
+
+	if err := gid.DeleteWebhook(h.ID); err != nil {
+		t.Error(err.Error())
+	}
+	if _, err := gid.Webhook(h.ID); err == nil {
+		t.Error("webhook not deleted")
+	}
+	if err := op.Delete(gid); err != nil {
+		t.Error(err.Error())
+	}
+}
+
+func TestTeamWebhook(t *testing.T) {
+	op := wasabee.Operation{
+		ID:   "teamwebhook",
+		Name: "teamwebhook",
+		OpPortals: []wasabee.Portal{
+			{ID: "A", Name: "Alpha", Lat: "1", Lon: "2"},
+			{ID: "B", Name: "Beta", Lat: "3", Lon: "4"},
+			{ID: "C", Name: "Gamma", Lat: "5", Lon: "6"},
+		},
+		Links: []wasabee.Link{
+			{ID: "AB", From: "A", To: "B"},
+			{ID: "BC", From: "B", To: "C"},
+		},
+	}
+	j, _ := json.Marshal(op)
+	if err := wasabee.DrawInsert(j, gid); err != nil {
+		t.Fatal(err.Error())
+	}
+	defer op.Delete(gid)
+
+	ngid := wasabee.GoogleID("104743827901423568955")
+	if _, err := ngid.InitAgent(); err != nil {
+		t.Fatal(err.Error())
+	}
+	defer ngid.Delete()
+	teamID, err := gid.NewTeam("webhook assigned only")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer teamID.Delete()
+	if err := teamID.AddAgent(ngid); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := op.AddPerm(gid, teamID, "assignedonly"); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := op.AssignLink("AB", ngid, gid); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	h, err := teamID.AddWebhook(gid, "https://example.invalid/hook", "", "linkStatus")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer gid.DeleteWebhook(h.ID)
+
+	// the team only sees AB
+	if err := op.LinkCompleted("BC", true, gid); err != nil {
+		t.Error(err.Error())
+	}
+	time.Sleep(200 * time.Millisecond)
+	if deliveries, _ := gid.WebhookDeliveries(h.ID); len(deliveries) != 0 {
+		t.Errorf("assigned-only team hook was sent an event for a link it cannot see: %v", deliveries)
+	}
+
+	if err := op.LinkCompleted("AB", true, gid); err != nil {
+		t.Error(err.Error())
+	}
+	waitFor(t, func() bool {
+		deliveries, _ := gid.WebhookDeliveries(h.ID)
+		return len(deliveries) > 0
+	})
+}
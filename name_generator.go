@@ -76,24 +76,19 @@ func GenerateSafeName() (string, error) {
 	rows := 1
 
 	for rows > 0 {
-		var i, total int
 		name = GenerateName()
 		if name == "" {
 			err := fmt.Errorf("name generation failed")
 			return "", err
 		}
 		databaseID := sha256.Sum256([]byte(name))
-		err := db.QueryRow("SELECT COUNT(lockey) FROM agent WHERE lockey = ?", hex.EncodeToString(databaseID[:])).Scan(&i)
+		inuse, err := store.NameInUse(hex.EncodeToString(databaseID[:]))
 		if err != nil {
 			return "", err
 		}
-		total = i
-		err = db.QueryRow("SELECT COUNT(teamID) FROM team WHERE teamID = ?", hex.EncodeToString(databaseID[:])).Scan(&i)
-		if err != nil {
-			return "", err
+		if !inuse {
+			rows = 0
 		}
-		total += i
-		rows = total
 	}
 
 	return name, nil
@@ -0,0 +1,233 @@
+package wasabee
+
+import (
+	"time"
+)
+
+// MemoryStoreURI is the database URI which selects the in-memory store instead of MySQL/MariaDB
+const MemoryStoreURI = "memory"
+
+// store is the persistence layer used by all the model functions, set by Connect
+var store Store
+
+// Store is the persistence layer between the model types and the database.
+// Single-row lookups return sql.ErrNoRows when nothing is found so callers behave the same regardless of the implementation.
+// Nothing in the Store checks permissions; that is the job of the model functions.
+type Store interface {
+	agentStore
+	teamStore
+	operationStore
+	webhookStore
+	keyTransferStore
+	blockerStore
+	phaseStore
+	waveStore
+	miscStore
+}
+
+type agentStore interface {
+	InsertAgent(ad *AgentData) error
+	AgentData(gid GoogleID, ud *AgentData) error
+	Agent(gid GoogleID, agent *Agent) error
+	IngressName(gid GoogleID) (string, error)
+	SearchAgentName(name string) (GoogleID, error)
+	AllAgents() ([]GoogleID, error)
+	DeleteAgent(gid GoogleID) error
+	SetAgentRISC(gid GoogleID, risc bool) error
+	AgentRISC(gid GoogleID) (bool, error)
+	SetAgentPicture(gid GoogleID, picurl string) error
+	AgentPicture(gid GoogleID) (string, error)
+	SetAgentLocation(gid GoogleID, lat, lon float64) error
+	AgentLastLocation(gid GoogleID) (float64, float64, error)
+	ExpireLocations(age time.Duration) error
+	AgentOps(gid GoogleID) ([]AdOperation, error)
+	AgentAssignments(gid GoogleID) ([]Assignment, error)
+	UpdateAgentV(gid GoogleID, iname string, level int64, verified, blacklisted bool, enlID EnlID) error
+	UpdateAgentRocks(gid GoogleID, iname string, verified bool) error
+	SetAgentRAID(gid GoogleID, raid bool) error
+	SetEnlIDRAID(eid EnlID, raid bool) error
+	RAIDAgents() ([]Agent, error)
+	AgentEnlID(gid GoogleID) (EnlID, error)
+	EnlIDGid(eid EnlID) (GoogleID, error)
+	LocKeyGid(lockey LocKey) (GoogleID, error)
+	AgentTelegram(gid GoogleID) (TelegramID, bool, string, error)
+	TelegramGid(tgid TelegramID) (GoogleID, bool, error)
+	InsertTelegram(tgid TelegramID, gid GoogleID, authtoken string) error
+	ImportTelegram(tgid TelegramID, gid GoogleID) error
+	VerifyTelegram(tgid TelegramID, authtoken string) (bool, error)
+	FirebaseTokens(gid GoogleID) ([]string, error)
+	InsertFirebaseToken(gid GoogleID, token string) error
+	DeleteFirebaseToken(gid GoogleID, token string) error
+	DeleteFirebaseTokens(gid GoogleID) error
+	DefensiveKeys(gid GoogleID) ([]DefensiveKey, error)
+	SetDefensiveKey(gid GoogleID, portalID PortalID, capID string, count int32) error
+	DeleteDefensiveKey(gid GoogleID, portalID PortalID) error
+}
+
+type teamStore interface {
+	InsertTeam(teamID TeamID, owner GoogleID, name string) error
+	DeleteTeam(teamID TeamID) error
+	TeamData(teamID TeamID, td *TeamData) error
+	TeamName(teamID TeamID) (string, error)
+	SetTeamName(teamID TeamID, name string) error
+	TeamOwner(teamID TeamID) (GoogleID, error)
+	SetTeamOwner(teamID TeamID, gid GoogleID) error
+	SetTeamRocks(teamID TeamID, key, community string) error
+	TeamRocksKey(teamID TeamID) (string, error)
+	RocksTeamID(community string) (TeamID, error)
+	TeamAgents(teamID TeamID, fetchAll bool) ([]Agent, error)
+	TeamMembers(teamID TeamID, activeOnly bool) ([]GoogleID, error)
+	AgentInTeam(gid GoogleID, teamID TeamID, allowOff bool) (bool, error)
+	AddTeamAgent(teamID TeamID, gid GoogleID) error
+	RemoveTeamAgent(teamID TeamID, gid GoogleID) error
+	SetTeamAgentState(teamID TeamID, gid GoogleID, state string) error
+	SetTeamAgentSquad(teamID TeamID, gid GoogleID, squad string) error
+	SetTeamAgentDisplayName(teamID TeamID, gid GoogleID, displayname string) error
+	TeamAgentDisplayName(teamID TeamID, gid GoogleID) (string, error)
+	AgentTeams(gid GoogleID) ([]AdTeam, error)
+	AgentOwnedTeams(gid GoogleID) ([]AdOwnedTeam, error)
+	AgentTeamIDs(gid GoogleID) ([]TeamID, error)
+	TeammatesNear(gid GoogleID, maxdistance, maxresults int) ([]Agent, error)
+	CanSendTo(from, to GoogleID) (bool, error)
+}
+
+type operationStore interface {
+	InsertOperation(o *Operation, gid GoogleID, teamID TeamID) error
+	Operation(o *Operation) error
+	OperationExists(opID OperationID) (bool, error)
+	OperationOwner(opID OperationID) (GoogleID, error)
+	// RestoreOperation replaces everything in the op with o, including assignments and state, atomically
+	RestoreOperation(o *Operation) error
+	InsertOpRevision(opID OperationID, gid GoogleID, description string, version int64, op []byte) error
+	OpRevisions(opID OperationID) ([]OpRevision, error)
+	OpRevision(opID OperationID, revision int) (OpRevision, error)
+	// OpRevisionAt returns the newest revision recorded at or before t
+	OpRevisionAt(opID OperationID, t time.Time) (OpRevision, error)
+	// OpRevisionVersion returns the newest revision of the op at or before version
+	OpRevisionVersion(opID OperationID, version int64) (OpRevision, error)
+	PruneOpRevisions(opID OperationID, keep int) error
+	// UpdateOperation applies a DrawUpdate atomically: if anything is rejected, nothing is changed.
+	// If the op has changed since the client's copy described by pre, errOpModified is returned.
+	UpdateOperation(o *Operation, pre OpPrecondition) ([]RejectedObject, error)
+	SetOperationName(opID OperationID, name string) error
+	SetOperationComment(opID OperationID, comment string) error
+	SetOperationOwner(opID OperationID, gid GoogleID) error
+	TouchOperation(opID OperationID) error
+	DeleteOperation(opID OperationID) error
+	TeamOperationCount(teamID TeamID) (int, error)
+	OperationTeams(opID OperationID) ([]ExtendedTeam, error)
+	AddOperationTeam(opID OperationID, teamID TeamID, role etRole) error
+	DeleteOperationTeam(opID OperationID, teamID TeamID, role etRole) error
+	OperationAgents(opID OperationID) ([]Agent, error)
+
+	InsertPortal(opID OperationID, p Portal) error
+	Portals(opID OperationID) ([]Portal, error)
+	Portal(opID OperationID, portalID PortalID) (Portal, error)
+	SetPortalComment(opID OperationID, portalID PortalID, comment string) error
+	SetPortalHardness(opID OperationID, portalID PortalID, hardness string) error
+	InsertAnchor(opID OperationID, portalID PortalID) error
+	Anchors(opID OperationID) ([]PortalID, error)
+
+	InsertLink(opID OperationID, l Link) error
+	Links(opID OperationID) ([]Link, error)
+	AgentLinks(opID OperationID, gid GoogleID) ([]Link, error)
+	LinkAssignedTo(opID OperationID, linkID LinkID, gid GoogleID) (bool, error)
+	SetLinkAssignment(opID OperationID, linkID LinkID, gid GoogleID) error
+	SetLinkDescription(opID OperationID, linkID LinkID, desc string) error
+	SetLinkCompleted(opID OperationID, linkID LinkID, completed bool) error
+	SetLinkColor(opID OperationID, linkID LinkID, color string) error
+	SetLinkThrowOrder(opID OperationID, linkID LinkID, order int32) error
+	SwapLink(opID OperationID, linkID LinkID) error
+
+	InsertMarker(opID OperationID, m Marker) error
+	Markers(opID OperationID) ([]Marker, error)
+	// MarkerCompleters is the GoogleID of the agent who completed each completed marker
+	MarkerCompleters(opID OperationID) (map[MarkerID]GoogleID, error)
+	AgentMarkers(opID OperationID, gid GoogleID) ([]Marker, error)
+	MarkerAssignee(opID OperationID, markerID MarkerID) (GoogleID, error)
+	SetMarkerAssignment(opID OperationID, markerID MarkerID, gid GoogleID, state string) error
+	// SetAssignments makes all the assignments or none; it returns the IDs of any links or markers no longer assigned to their Previous agent
+	SetAssignments(opID OperationID, assignments []PlannedAssignment) ([]string, error)
+	SetMarkerComment(opID OperationID, markerID MarkerID, comment string) error
+	SetMarkerState(opID OperationID, markerID MarkerID, state string) error
+	SetMarkerCompleted(opID OperationID, markerID MarkerID, completedBy GoogleID) error
+	SetMarkerIncomplete(opID OperationID, markerID MarkerID) error
+	SetMarkerOrder(opID OperationID, markerID MarkerID, order int) error
+
+	InsertKey(opID OperationID, k KeyOnHand) error
+	Keys(opID OperationID) ([]KeyOnHand, error)
+
+	InsertAuditEntry(e AuditEntry) error
+	AuditEntries(opID OperationID, filter AuditFilter) ([]AuditEntry, error)
+}
+
+type webhookStore interface {
+	InsertWebhook(h Webhook) error
+	DeleteWebhook(hookID string) error
+	Webhook(hookID string) (Webhook, error)
+	OpWebhooks(opID OperationID) ([]Webhook, error)
+	TeamWebhooks(teamID TeamID) ([]Webhook, error)
+	InsertWebhookDelivery(d WebhookDelivery) error
+	WebhookDeliveries(hookID string, limit int) ([]WebhookDelivery, error)
+}
+
+type keyTransferStore interface {
+	InsertKeyTransfer(t KeyTransfer) error
+	KeyTransfer(transferID string) (KeyTransfer, error)
+	OpKeyTransfers(opID OperationID) ([]KeyTransfer, error)
+	AgentKeyTransfers(gid GoogleID) ([]KeyTransfer, error)
+	SetKeyTransferState(transferID string, was string, holder GoogleID, state string) error
+	CompleteKeyTransfer(transferID string) error
+}
+
+type blockerStore interface {
+	// InsertBlocker does nothing if the op already has the blocker
+	InsertBlocker(b Blocker) error
+	Blockers(opID OperationID) ([]Blocker, error)
+	Blocker(opID OperationID, blockerID LinkID) (Blocker, error)
+	// TeamBlockers is the blockers of every op the team has any role on
+	TeamBlockers(teamID TeamID) ([]Blocker, error)
+	SetBlockerCleared(opID OperationID, blockerID LinkID, gid GoogleID) error
+	DeleteBlocker(opID OperationID, blockerID LinkID) error
+}
+
+type phaseStore interface {
+	// SetPhase adds the phase or updates it; moving the start means the reminder goes out again
+	SetPhase(opID OperationID, p Phase) error
+	// Phases is ordered by start
+	Phases(opID OperationID) ([]Phase, error)
+	Phase(opID OperationID, phaseID PhaseID) (Phase, error)
+	// DeletePhase also takes the op's links and markers out of the phase
+	DeletePhase(opID OperationID, phaseID PhaseID) error
+	SetLinkPhase(opID OperationID, linkID LinkID, phaseID PhaseID) error
+	SetMarkerPhase(opID OperationID, markerID MarkerID, phaseID PhaseID) error
+	// PhasesToRemind is the phases of any op starting between now and before whose reminder has not gone out
+	PhasesToRemind(before time.Time) ([]Phase, error)
+	SetPhaseReminded(opID OperationID, phaseID PhaseID) error
+}
+
+type waveStore interface {
+	// SetWave adds the wave or updates it, and makes its links exactly w.Links; a link is only ever in one wave.
+	// Moving the time means the countdown starts over.
+	SetWave(opID OperationID, w Wave) error
+	// Waves is ordered by time, each with its links in throw order
+	Waves(opID OperationID) ([]Wave, error)
+	Wave(opID OperationID, waveID WaveID) (Wave, error)
+	DeleteWave(opID OperationID, waveID WaveID) error
+	WaveLinks(opID OperationID, waveID WaveID) ([]WaveLink, error)
+	// SetWaveLinkCompleted records when a link in a wave was first reported done; it does nothing for links in no wave
+	SetWaveLinkCompleted(opID OperationID, linkID LinkID, completed bool) error
+	// WavesBetween is the waves of any op due between from and to
+	WavesBetween(from, to time.Time) ([]Wave, error)
+	SetWaveNotified(opID OperationID, waveID WaveID, mark int) error
+}
+
+type miscStore interface {
+	NameInUse(hashed string) (bool, error)
+	LogMessage(gid GoogleID, message string) error
+	InsertDocument(hashed, content string, upload, expiration time.Time) error
+	Document(hashed string) (SimpleDocument, error)
+	DocumentViewed(hashed string) error
+	DeleteDocument(hashed string) error
+	DeleteExpiredDocuments() (int64, error)
+}
@@ -0,0 +1,457 @@
+package wasabee
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// mariaDBStore is the Store backed by MySQL/MariaDB, all queries run against the package db handle
+type mariaDBStore struct{}
+
+func (s mariaDBStore) InsertAgent(ad *AgentData) error {
+	_, err := db.Exec("INSERT IGNORE INTO agent (gid, iname, level, lockey, VVerified, VBlacklisted, Vid, RocksVerified, RAID, RISC) VALUES (?,?,?,?,?,?,?,?,?,0)",
+		ad.GoogleID, MakeNullString(ad.IngressName), ad.Level, ad.LocationKey, ad.VVerified, ad.VBlacklisted, MakeNullString(ad.Vid), ad.RocksVerified, 0)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("INSERT IGNORE INTO locations (gid, upTime, loc) VALUES (?,NOW(),POINT(0,0))", ad.GoogleID)
+	return err
+}
+
+func (s mariaDBStore) AgentData(gid GoogleID, ud *AgentData) error {
+	var Vid sql.NullString
+	err := db.QueryRow("SELECT u.iname, u.level, u.lockey, u.VVerified, u.VBlacklisted, u.Vid, u.RocksVerified, u.RAID, u.RISC FROM agent=u WHERE u.gid = ?", gid).Scan(&ud.IngressName, &ud.Level, &ud.LocationKey, &ud.VVerified, &ud.VBlacklisted, &Vid, &ud.RocksVerified, &ud.RAID, &ud.RISC)
+	if err != nil {
+		return err
+	}
+	if Vid.Valid {
+		ud.Vid = EnlID(Vid.String)
+	}
+	return nil
+}
+
+func (s mariaDBStore) Agent(gid GoogleID, agent *Agent) error {
+	var vid sql.NullString
+	err := db.QueryRow("SELECT u.gid, u.iname, u.level, u.VVerified, u.VBlacklisted, u.Vid, u.RocksVerified FROM agent=u WHERE u.gid = ?", gid).Scan(
+		&agent.Gid, &agent.Name, &agent.Level, &agent.Verified, &agent.Blacklisted, &vid, &agent.RocksVerified)
+	if err != nil {
+		return err
+	}
+	if vid.Valid {
+		agent.EnlID = EnlID(vid.String)
+	}
+	return nil
+}
+
+func (s mariaDBStore) IngressName(gid GoogleID) (string, error) {
+	var iname string
+	err := db.QueryRow("SELECT iname FROM agent WHERE gid = ?", gid).Scan(&iname)
+	return iname, err
+}
+
+func (s mariaDBStore) SearchAgentName(name string) (GoogleID, error) {
+	var gid GoogleID
+	err := db.QueryRow("SELECT gid FROM agent WHERE LOWER(iname) LIKE LOWER(?)", name).Scan(&gid)
+	return gid, err
+}
+
+func (s mariaDBStore) AllAgents() ([]GoogleID, error) {
+	var gids []GoogleID
+
+	rows, err := db.Query("SELECT gid FROM agent")
+	if err != nil {
+		return gids, err
+	}
+	defer rows.Close()
+
+	var gid GoogleID
+	for rows.Next() {
+		if err = rows.Scan(&gid); err != nil {
+			Log.Error(err)
+			continue
+		}
+		gids = append(gids, gid)
+	}
+	return gids, nil
+}
+
+func (s mariaDBStore) DeleteAgent(gid GoogleID) error {
+	_, err := db.Exec("DELETE FROM agent WHERE gid = ?", gid)
+	if err != nil {
+		return err
+	}
+
+	// the foreign key constraints should take care of these, but just in case...
+	_, _ = db.Exec("DELETE FROM locations WHERE gid = ?", gid)
+	_, _ = db.Exec("DELETE FROM telegram WHERE gid = ?", gid)
+	_, _ = db.Exec("DELETE FROM agentextras WHERE gid = ?", gid)
+	_, _ = db.Exec("DELETE FROM firebase WHERE gid = ?", gid)
+	return nil
+}
+
+func (s mariaDBStore) SetAgentRISC(gid GoogleID, risc bool) error {
+	_, err := db.Exec("UPDATE agent SET RISC = ? WHERE gid = ?", risc, gid)
+	return err
+}
+
+func (s mariaDBStore) AgentRISC(gid GoogleID) (bool, error) {
+	var RISC bool
+	err := db.QueryRow("SELECT RISC FROM agent WHERE gid = ?", gid).Scan(&RISC)
+	return RISC, err
+}
+
+func (s mariaDBStore) SetAgentPicture(gid GoogleID, picurl string) error {
+	_, err := db.Exec("REPLACE INTO agentextras (gid, picurl) VALUES (?,?) ", gid, picurl)
+	return err
+}
+
+func (s mariaDBStore) AgentPicture(gid GoogleID) (string, error) {
+	var url string
+	err := db.QueryRow("SELECT picurl FROM agentextras WHERE gid = ?", gid).Scan(&url)
+	return url, err
+}
+
+func (s mariaDBStore) SetAgentLocation(gid GoogleID, lat, lon float64) error {
+	point := fmt.Sprintf("POINT(%s %s)", strconv.FormatFloat(lon, 'f', 7, 64), strconv.FormatFloat(lat, 'f', 7, 64))
+	_, err := db.Exec("UPDATE locations SET loc = PointFromText(?), upTime = NOW() WHERE gid = ?", point, gid)
+	return err
+}
+
+func (s mariaDBStore) AgentLastLocation(gid GoogleID) (float64, float64, error) {
+	var lat, lon float64
+	err := db.QueryRow("SELECT Y(loc), X(loc) FROM locations WHERE gid = ?", gid).Scan(&lat, &lon)
+	if err == nil && lat == 0 && lon == 0 {
+		// expired
+		err = sql.ErrNoRows
+	}
+	return lat, lon, err
+}
+
+func (s mariaDBStore) ExpireLocations(age time.Duration) error {
+	r, err := db.Query("SELECT gid FROM locations WHERE loc != POINTFROMTEXT(?) AND upTime < DATE_SUB(NOW(), INTERVAL ? SECOND)", "POINT(0 0)", int64(age.Seconds()))
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	var gids []GoogleID
+	var gid GoogleID
+	for r.Next() {
+		err = r.Scan(&gid)
+		if err != nil {
+			Log.Error(err)
+			continue
+		}
+		gids = append(gids, gid)
+	}
+
+	for _, gid := range gids {
+		_, err = db.Exec("UPDATE locations SET loc = POINTFROMTEXT(?), upTime = NOW() WHERE gid = ?", "POINT(0 0)", gid)
+		if err != nil {
+			Log.Error(err)
+			continue
+		}
+	}
+	return nil
+}
+
+func (s mariaDBStore) AgentOps(gid GoogleID) ([]AdOperation, error) {
+	var ops []AdOperation
+	var op AdOperation
+	var g GoogleID
+
+	rows, err := db.Query("SELECT o.ID, o.Name, o.Gid, o.Color, t.Name, p.teamID FROM operation=o, team=t, agentteams=x, opteams=p WHERE p.opID = o.ID AND x.gid = ? AND x.teamID = p.teamID AND x.teamID = t.teamID AND x.state = 'On' ORDER BY o.Name, t.Name", gid)
+	if err != nil {
+		return ops, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		err := rows.Scan(&op.ID, &op.Name, &g, &op.Color, &op.TeamName, &op.TeamID)
+		if err != nil {
+			return ops, err
+		}
+		op.IsOwner = gid == g
+		ops = append(ops, op)
+	}
+	return ops, nil
+}
+
+// AgentAssignments leaves out ops where everything the agent has is in a phase which has ended; phase times are UTC
+func (s mariaDBStore) AgentAssignments(gid GoogleID) ([]Assignment, error) {
+	var assignments []Assignment
+	var a Assignment
+
+	a.Type = "Marker"
+	rows, err := db.Query("SELECT DISTINCT o.Name, o.ID FROM marker=m JOIN operation=o ON m.opID = o.ID LEFT JOIN phase=p ON p.opID = m.opID AND p.ID = m.phase WHERE m.gid = ? AND (p.ID IS NULL OR p.end >= UTC_TIMESTAMP()) ORDER BY o.Name", gid)
+	if err != nil {
+		return assignments, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		err := rows.Scan(&a.OperationName, &a.OpID)
+		if err != nil {
+			return assignments, err
+		}
+		assignments = append(assignments, a)
+	}
+
+	a.Type = "Link"
+	rows2, err := db.Query("SELECT DISTINCT o.Name, o.ID FROM link=l JOIN operation=o ON l.opID = o.ID LEFT JOIN phase=p ON p.opID = l.opID AND p.ID = l.phase WHERE l.gid = ? AND (p.ID IS NULL OR p.end >= UTC_TIMESTAMP()) ORDER BY o.Name", gid)
+	if err != nil {
+		return assignments, err
+	}
+	defer rows2.Close()
+	for rows2.Next() {
+		err := rows2.Scan(&a.OperationName, &a.OpID)
+		if err != nil {
+			return assignments, err
+		}
+		assignments = append(assignments, a)
+	}
+	return assignments, nil
+}
+
+func (s mariaDBStore) UpdateAgentV(gid GoogleID, iname string, level int64, verified, blacklisted bool, enlID EnlID) error {
+	_, err := db.Exec("UPDATE agent SET iname = ?, level = ?, VVerified = ?, VBlacklisted = ?, Vid = ? WHERE gid = ?",
+		iname, level, verified, blacklisted, MakeNullString(enlID), gid)
+	return err
+}
+
+func (s mariaDBStore) UpdateAgentRocks(gid GoogleID, iname string, verified bool) error {
+	_, err := db.Exec("UPDATE agent SET iname = ?, RocksVerified = ? WHERE gid = ?", iname, verified, gid)
+	return err
+}
+
+func (s mariaDBStore) SetAgentRAID(gid GoogleID, raid bool) error {
+	_, err := db.Exec("UPDATE agent SET RAID = ? WHERE gid = ?", raid, gid)
+	return err
+}
+
+func (s mariaDBStore) SetEnlIDRAID(eid EnlID, raid bool) error {
+	_, err := db.Exec("UPDATE agent SET RAID = ? WHERE Vid = ?", raid, eid)
+	return err
+}
+
+func (s mariaDBStore) RAIDAgents() ([]Agent, error) {
+	var agents []Agent
+
+	rows, err := db.Query("SELECT gid, Vid FROM agent WHERE RAID = 1")
+	if err != nil {
+		return agents, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var a Agent
+		var vid sql.NullString
+		if err = rows.Scan(&a.Gid, &vid); err != nil {
+			Log.Error(err)
+			continue
+		}
+		if vid.Valid {
+			a.EnlID = EnlID(vid.String)
+		}
+		agents = append(agents, a)
+	}
+	return agents, nil
+}
+
+func (s mariaDBStore) AgentEnlID(gid GoogleID) (EnlID, error) {
+	var e EnlID
+	err := db.QueryRow("SELECT Vid FROM agent WHERE gid = ?", gid).Scan(&e)
+	return e, err
+}
+
+func (s mariaDBStore) EnlIDGid(eid EnlID) (GoogleID, error) {
+	var gid GoogleID
+	err := db.QueryRow("SELECT gid FROM agent WHERE Vid = ?", eid).Scan(&gid)
+	return gid, err
+}
+
+func (s mariaDBStore) LocKeyGid(lockey LocKey) (GoogleID, error) {
+	var gid GoogleID
+	err := db.QueryRow("SELECT gid FROM agent WHERE lockey = ?", lockey).Scan(&gid)
+	return gid, err
+}
+
+func (s mariaDBStore) AgentTelegram(gid GoogleID) (TelegramID, bool, string, error) {
+	var tgid TelegramID
+	var verified bool
+	var authtoken sql.NullString
+
+	err := db.QueryRow("SELECT telegramID, verified, authtoken FROM telegram WHERE gid = ?", gid).Scan(&tgid, &verified, &authtoken)
+	if err != nil {
+		return 0, false, "", err
+	}
+	return tgid, verified, authtoken.String, nil
+}
+
+func (s mariaDBStore) TelegramGid(tgid TelegramID) (GoogleID, bool, error) {
+	var gid GoogleID
+	var verified bool
+	err := db.QueryRow("SELECT gid, verified FROM telegram WHERE telegramID = ?", tgid).Scan(&gid, &verified)
+	return gid, verified, err
+}
+
+func (s mariaDBStore) InsertTelegram(tgid TelegramID, gid GoogleID, authtoken string) error {
+	_, err := db.Exec("INSERT INTO telegram (telegramID, telegramName, gid, verified, authtoken) VALUES (?, 'unused', ?, 0, ?)", tgid, gid, authtoken)
+	return err
+}
+
+func (s mariaDBStore) ImportTelegram(tgid TelegramID, gid GoogleID) error {
+	_, err := db.Exec("INSERT IGNORE INTO telegram (telegramID, telegramName, gid, verified) VALUES (?, 'unused', ?, 1)", tgid, gid)
+	return err
+}
+
+func (s mariaDBStore) VerifyTelegram(tgid TelegramID, authtoken string) (bool, error) {
+	res, err := db.Exec("UPDATE telegram SET authtoken = NULL, verified = 1 WHERE telegramID = ? AND authtoken = ?", tgid, authtoken)
+	if err != nil {
+		return false, err
+	}
+	i, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return i > 0, nil
+}
+
+func (s mariaDBStore) FirebaseTokens(gid GoogleID) ([]string, error) {
+	var token string
+	var toks []string
+
+	rows, err := db.Query("SELECT DISTINCT token FROM firebase WHERE gid = ?", gid)
+	if err != nil {
+		return toks, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		err := rows.Scan(&token)
+		if err != nil {
+			Log.Error(err)
+			continue
+		}
+		toks = append(toks, token)
+	}
+	return toks, nil
+}
+
+func (s mariaDBStore) InsertFirebaseToken(gid GoogleID, token string) error {
+	// XXX ensure the token is unique, maybe add a unique key for it?
+	_, err := db.Exec("INSERT INTO firebase (gid, token) VALUES (?, ?)", gid, token)
+	return err
+}
+
+func (s mariaDBStore) DeleteFirebaseToken(gid GoogleID, token string) error {
+	_, err := db.Exec("DELETE FROM firebase WHERE gid = ? AND token = ?", gid, token)
+	return err
+}
+
+func (s mariaDBStore) DeleteFirebaseTokens(gid GoogleID) error {
+	_, err := db.Exec("DELETE FROM firebase WHERE gid = ?", gid)
+	return err
+}
+
+func (s mariaDBStore) DefensiveKeys(gid GoogleID) ([]DefensiveKey, error) {
+	var dks []DefensiveKey
+
+	rows, err := db.Query("SELECT gid, portalID, capID, count FROM defensivekeys WHERE gid IN (SELECT DISTINCT x.gid FROM agentteams=x, agentteams=y WHERE y.gid = ? AND y.state = 'On' AND x.teamID = y.teamID AND x.state = 'On')", gid)
+	if err != nil {
+		return dks, err
+	}
+	defer rows.Close()
+
+	var dk DefensiveKey
+	var capID sql.NullString
+	for rows.Next() {
+		err := rows.Scan(&dk.GID, &dk.PortalID, &capID, &dk.Count)
+		if err != nil {
+			return dks, err
+		}
+		dk.CapID = capID.String
+		dks = append(dks, dk)
+	}
+	return dks, nil
+}
+
+func (s mariaDBStore) SetDefensiveKey(gid GoogleID, portalID PortalID, capID string, count int32) error {
+	_, err := db.Exec("INSERT INTO defensivekeys (gid, portalID, capID, count) VALUES (?, ?, ?, ?) ON DUPLICATE KEY UPDATE capID = ?, count = ?", gid, portalID, capID, count, capID, count)
+	return err
+}
+
+func (s mariaDBStore) DeleteDefensiveKey(gid GoogleID, portalID PortalID) error {
+	_, err := db.Exec("DELETE FROM defensivekeys WHERE gid = ? AND portalID = ?", gid, portalID)
+	return err
+}
+
+func (s mariaDBStore) NameInUse(hashed string) (bool, error) {
+	var i, total int
+	err := db.QueryRow("SELECT COUNT(lockey) FROM agent WHERE lockey = ?", hashed).Scan(&i)
+	if err != nil {
+		return true, err
+	}
+	total = i
+	err = db.QueryRow("SELECT COUNT(teamID) FROM team WHERE teamID = ?", hashed).Scan(&i)
+	if err != nil {
+		return true, err
+	}
+	total += i
+	return total > 0, nil
+}
+
+func (s mariaDBStore) LogMessage(gid GoogleID, message string) error {
+	_, err := db.Exec("INSERT INTO messagelog (gid, message) VALUES (?, ?)", gid, message)
+	return err
+}
+
+func (s mariaDBStore) InsertDocument(hashed, content string, upload, expiration time.Time) error {
+	var exp interface{}
+	if (expiration != time.Time{}) {
+		exp = expiration.UTC().Format("2006-01-02 15:04:05")
+	}
+
+	_, err := db.Exec("INSERT INTO document (id, content, upload, expiration, views) VALUES (?, ?, ?, ?, 0)",
+		hashed, content, upload.UTC().Format("2006-01-02 15:04:05"), exp)
+	return err
+}
+
+func (s mariaDBStore) Document(hashed string) (SimpleDocument, error) {
+	var doc SimpleDocument
+	var upload, expiration sql.NullString
+
+	err := db.QueryRow("SELECT content, upload, expiration, views FROM document WHERE id = ?", hashed).
+		Scan(&doc.Content, &upload, &expiration, &doc.Views)
+	if err != nil {
+		return doc, err
+	}
+	doc.Upload, _ = time.Parse("2006-01-02 15:04:05", upload.String)
+	if expiration.Valid {
+		doc.Expiration, err = time.Parse("2006-01-02 15:04:05", expiration.String)
+		if err != nil {
+			// unparsable (zero) dates are volatile documents
+			doc.Expiration = time.Unix(0, 0)
+		}
+	}
+	return doc, nil
+}
+
+func (s mariaDBStore) DocumentViewed(hashed string) error {
+	_, err := db.Exec("UPDATE document SET views = views + 1 WHERE id = ?", hashed)
+	return err
+}
+
+func (s mariaDBStore) DeleteDocument(hashed string) error {
+	_, err := db.Exec("DELETE LOW_PRIORITY FROM document WHERE id = ?", hashed)
+	return err
+}
+
+func (s mariaDBStore) DeleteExpiredDocuments() (int64, error) {
+	// do it this way to get RowsAffected
+	result, err := db.Exec("DELETE LOW_PRIORITY FROM document WHERE expiration < CURRENT_TIMESTAMP AND expiration > FROM_UNIXTIME(0)")
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
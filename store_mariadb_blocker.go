@@ -0,0 +1,65 @@
+package wasabee
+
+import (
+	"database/sql"
+)
+
+func (s mariaDBStore) InsertBlocker(b Blocker) error {
+	_, err := db.Exec("INSERT IGNORE INTO blocker (ID, opID, fromPortalID, toPortalID, fromName, fromLat, fromLon, toName, toLat, toLon, reportedBy, reported, state) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, NOW(), ?)",
+		b.ID, b.OpID, b.From, b.To, b.FromName, b.FromLat, b.FromLon, b.ToName, b.ToLat, b.ToLon, MakeNullString(string(b.ReportedBy)), b.State)
+	return err
+}
+
+const blockerColumns = "b.ID, b.opID, b.fromPortalID, b.toPortalID, b.fromName, b.fromLat, b.fromLon, b.toName, b.toLat, b.toLon, b.reportedBy, b.reported, b.state, b.clearedBy, b.cleared"
+
+func scanBlocker(row interface{ Scan(...interface{}) error }) (Blocker, error) {
+	var b Blocker
+	var reportedBy, clearedBy, cleared sql.NullString
+	err := row.Scan(&b.ID, &b.OpID, &b.From, &b.To, &b.FromName, &b.FromLat, &b.FromLon, &b.ToName, &b.ToLat, &b.ToLon, &reportedBy, &b.Reported, &b.State, &clearedBy, &cleared)
+	b.ReportedBy = GoogleID(reportedBy.String)
+	b.ClearedBy = GoogleID(clearedBy.String)
+	b.Cleared = cleared.String
+	return b, err
+}
+
+func (s mariaDBStore) Blockers(opID OperationID) ([]Blocker, error) {
+	return queryBlockers("SELECT "+blockerColumns+" FROM blocker=b WHERE b.opID = ? ORDER BY b.reported", opID)
+}
+
+func (s mariaDBStore) Blocker(opID OperationID, blockerID LinkID) (Blocker, error) {
+	return scanBlocker(db.QueryRow("SELECT "+blockerColumns+" FROM blocker=b WHERE b.opID = ? AND b.ID = ?", opID, blockerID))
+}
+
+func (s mariaDBStore) TeamBlockers(teamID TeamID) ([]Blocker, error) {
+	return queryBlockers("SELECT DISTINCT "+blockerColumns+" FROM blocker=b, opteams=t WHERE t.teamID = ? AND t.opID = b.opID ORDER BY b.reported", teamID)
+}
+
+func queryBlockers(query string, args ...interface{}) ([]Blocker, error) {
+	var blockers []Blocker
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return blockers, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		b, err := scanBlocker(rows)
+		if err != nil {
+			Log.Error(err)
+			continue
+		}
+		blockers = append(blockers, b)
+	}
+	return blockers, nil
+}
+
+func (s mariaDBStore) SetBlockerCleared(opID OperationID, blockerID LinkID, gid GoogleID) error {
+	_, err := db.Exec("UPDATE blocker SET state = ?, clearedBy = ?, cleared = NOW() WHERE opID = ? AND ID = ?", BlockerDestroyed, MakeNullString(string(gid)), opID, blockerID)
+	return err
+}
+
+func (s mariaDBStore) DeleteBlocker(opID OperationID, blockerID LinkID) error {
+	_, err := db.Exec("DELETE FROM blocker WHERE opID = ? AND ID = ?", opID, blockerID)
+	return err
+}
@@ -0,0 +1,112 @@
+package wasabee
+
+import (
+	"database/sql"
+)
+
+func (s mariaDBStore) InsertKeyTransfer(t KeyTransfer) error {
+	_, err := db.Exec("INSERT INTO keytransfer (ID, opID, portalID, fromgid, togid, count, state, created, updated) VALUES (?, ?, ?, ?, ?, ?, ?, NOW(), NOW())",
+		t.ID, t.OpID, t.PortalID, MakeNullString(string(t.From)), t.To, t.Count, t.State)
+	return err
+}
+
+const keyTransferColumns = "k.ID, k.opID, o.name, k.portalID, k.fromgid, k.togid, k.count, k.state, k.created, k.updated"
+
+func scanKeyTransfer(row interface{ Scan(...interface{}) error }) (KeyTransfer, error) {
+	var t KeyTransfer
+	var from sql.NullString
+	err := row.Scan(&t.ID, &t.OpID, &t.OperationName, &t.PortalID, &from, &t.To, &t.Count, &t.State, &t.Created, &t.Updated)
+	t.From = GoogleID(from.String)
+	return t, err
+}
+
+func (s mariaDBStore) KeyTransfer(transferID string) (KeyTransfer, error) {
+	return scanKeyTransfer(db.QueryRow("SELECT "+keyTransferColumns+" FROM keytransfer=k, operation=o WHERE k.ID = ? AND k.opID = o.ID", transferID))
+}
+
+func (s mariaDBStore) OpKeyTransfers(opID OperationID) ([]KeyTransfer, error) {
+	return queryKeyTransfers("SELECT "+keyTransferColumns+" FROM keytransfer=k, operation=o WHERE k.opID = ? AND k.opID = o.ID ORDER BY k.created", opID)
+}
+
+func (s mariaDBStore) AgentKeyTransfers(gid GoogleID) ([]KeyTransfer, error) {
+	return queryKeyTransfers("SELECT "+keyTransferColumns+" FROM keytransfer=k, operation=o WHERE (k.fromgid = ? OR k.togid = ?) AND k.state IN ('requested', 'accepted') AND k.opID = o.ID ORDER BY k.created", gid, gid)
+}
+
+func queryKeyTransfers(query string, args ...interface{}) ([]KeyTransfer, error) {
+	var transfers []KeyTransfer
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return transfers, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		t, err := scanKeyTransfer(rows)
+		if err != nil {
+			Log.Error(err)
+			continue
+		}
+		transfers = append(transfers, t)
+	}
+	return transfers, nil
+}
+
+// SetKeyTransferState moves a transfer on from the state it was in; if someone else moved it first, nothing is changed
+func (s mariaDBStore) SetKeyTransferState(transferID string, was string, holder GoogleID, state string) error {
+	r, err := db.Exec("UPDATE keytransfer SET fromgid = ?, state = ?, updated = NOW() WHERE ID = ? AND state = ?", MakeNullString(string(holder)), state, transferID, was)
+	if err != nil {
+		return err
+	}
+	if n, err := r.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrKeyTransferState
+	}
+	return nil
+}
+
+// CompleteKeyTransfer moves the keys from the holder to the requester and marks the transfer completed, all or nothing
+func (s mariaDBStore) CompleteKeyTransfer(transferID string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var t KeyTransfer
+	var from sql.NullString
+	err = tx.QueryRow("SELECT opID, portalID, fromgid, togid, count, state FROM keytransfer WHERE ID = ? FOR UPDATE", transferID).Scan(&t.OpID, &t.PortalID, &from, &t.To, &t.Count, &t.State)
+	if err != nil {
+		return err
+	}
+	if t.State != KeyTransferAccepted || !from.Valid {
+		return ErrKeyTransferState
+	}
+
+	var onhand int32
+	err = tx.QueryRow("SELECT onhand FROM opkeys WHERE opID = ? AND portalID = ? AND gid = ? FOR UPDATE", t.OpID, t.PortalID, from.String).Scan(&onhand)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	if onhand < t.Count {
+		return errKeysShort
+	}
+
+	if _, err = tx.Exec("UPDATE opkeys SET onhand = onhand - ? WHERE opID = ? AND portalID = ? AND gid = ?", t.Count, t.OpID, t.PortalID, from.String); err != nil {
+		return err
+	}
+	if _, err = tx.Exec("INSERT INTO opkeys (opID, portalID, gid, onhand) VALUES (?, ?, ?, ?) ON DUPLICATE KEY UPDATE onhand = onhand + ?", t.OpID, t.PortalID, t.To, t.Count, t.Count); err != nil {
+		return err
+	}
+	r, err := tx.Exec("UPDATE keytransfer SET state = ?, updated = NOW() WHERE ID = ? AND state = ?", KeyTransferCompleted, transferID, KeyTransferAccepted)
+	if err != nil {
+		return err
+	}
+	if n, err := r.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrKeyTransferState
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,810 @@
+package wasabee
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+)
+
+func (s mariaDBStore) InsertOperation(o *Operation, gid GoogleID, teamID TeamID) error {
+	_, err := db.Exec("INSERT INTO operation (ID, name, gid, color, teamID, modified, comment) VALUES (?, ?, ?, ?, ?, NOW(), ?)", o.ID, o.Name, gid, o.Color, teamID.String(), MakeNullString(o.Comment))
+	return err
+}
+
+func (s mariaDBStore) Operation(o *Operation) error {
+	var comment sql.NullString
+	err := db.QueryRow("SELECT name, gid, color, teamID, modified, version, comment FROM operation WHERE ID = ?", o.ID).Scan(&o.Name, &o.Gid, &o.Color, &o.TeamIDdep, &o.Modified, &o.Version, &comment)
+	if err != nil {
+		return err
+	}
+	if comment.Valid {
+		o.Comment = comment.String
+	}
+	return nil
+}
+
+func (s mariaDBStore) OperationExists(opID OperationID) (bool, error) {
+	var count int
+	err := db.QueryRow("SELECT COUNT(*) FROM operation WHERE ID = ?", opID).Scan(&count)
+	if err != nil {
+		return false, err
+	}
+	return count != 0, nil
+}
+
+func (s mariaDBStore) OperationOwner(opID OperationID) (GoogleID, error) {
+	var gid GoogleID
+	err := db.QueryRow("SELECT gid FROM operation WHERE ID = ?", opID).Scan(&gid)
+	return gid, err
+}
+
+func (s mariaDBStore) UpdateOperation(o *Operation, pre OpPrecondition) ([]RejectedObject, error) {
+	var rejected []RejectedObject
+
+	tx, err := db.Begin()
+	if err != nil {
+		return rejected, err
+	}
+	// a no-op once committed
+	defer tx.Rollback()
+
+	if !pre.unconditional() {
+		// lock the row so nobody else can slip an update in between the check and the commit
+		var modified string
+		var version int64
+		if err := tx.QueryRow("SELECT modified, version FROM operation WHERE ID = ? FOR UPDATE", o.ID).Scan(&modified, &version); err != nil {
+			return rejected, err
+		}
+		m, err := time.ParseInLocation(opTimeFormat, modified, time.UTC)
+		if err != nil {
+			return rejected, err
+		}
+		if pre.changed(version, m) {
+			return rejected, errOpModified
+		}
+	}
+
+	if _, err := tx.Exec("UPDATE operation SET name = ?, color = ?, comment = ?, modified = NOW(), version = version + 1 WHERE ID = ?", o.Name, o.Color, MakeNullString(o.Comment), o.ID); err != nil {
+		return rejected, err
+	}
+
+	curPortals, err := txIDs(tx, "SELECT ID FROM portal WHERE opID = ?", o.ID)
+	if err != nil {
+		return rejected, err
+	}
+	for _, p := range o.OpPortals {
+		_, err := tx.Exec("REPLACE INTO portal (ID, opID, name, loc, comment, hardness) VALUES (?, ?, ?, POINT(?, ?), ?, ?)",
+			p.ID, o.ID, p.Name, p.Lon, p.Lat, MakeNullString(p.Comment), MakeNullString(p.Hardness))
+		if err != nil {
+			rejected = append(rejected, RejectedObject{Type: "portal", ID: string(p.ID), Reason: err.Error()})
+			continue
+		}
+		delete(curPortals, string(p.ID))
+	}
+	for id := range curPortals {
+		if _, err := tx.Exec("DELETE FROM portal WHERE ID = ? AND opID = ?", id, o.ID); err != nil {
+			return rejected, err
+		}
+	}
+
+	curMarkers, err := txIDs(tx, "SELECT ID FROM marker WHERE opID = ?", o.ID)
+	if err != nil {
+		return rejected, err
+	}
+	for _, m := range o.Markers {
+		// assignments, status and order are not overwritten
+		_, err := tx.Exec("INSERT INTO marker (ID, opID, PortalID, type, gid, comment, state, oporder, phase) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?) ON DUPLICATE KEY UPDATE type = ?, PortalID = ?, comment = ?",
+			m.ID, o.ID, m.PortalID, m.Type, MakeNullString(m.AssignedTo), MakeNullString(m.Comment), m.State, m.Order, MakeNullString(string(m.Phase)), m.Type, m.PortalID, MakeNullString(m.Comment))
+		if err != nil {
+			rejected = append(rejected, RejectedObject{Type: "marker", ID: string(m.ID), Reason: err.Error()})
+			continue
+		}
+		delete(curMarkers, string(m.ID))
+	}
+	for id := range curMarkers {
+		if _, err := tx.Exec("DELETE FROM marker WHERE opID = ? and ID = ?", o.ID, id); err != nil {
+			return rejected, err
+		}
+	}
+
+	curLinks, err := txIDs(tx, "SELECT ID FROM link WHERE opID = ?", o.ID)
+	if err != nil {
+		return rejected, err
+	}
+	for _, l := range o.Links {
+		// assignments, status and order are not overwritten
+		_, err := tx.Exec("INSERT INTO link (ID, fromPortalID, toPortalID, opID, description, gid, throworder, completed, color, phase) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?) ON DUPLICATE KEY UPDATE fromPortalID = ?, toPortalID = ?, description = ?, color=?",
+			l.ID, l.From, l.To, o.ID, MakeNullString(l.Desc), MakeNullString(l.AssignedTo), l.ThrowOrder, l.Completed, l.Color, MakeNullString(string(l.Phase)),
+			l.From, l.To, MakeNullString(l.Desc), l.Color)
+		if err != nil {
+			rejected = append(rejected, RejectedObject{Type: "link", ID: string(l.ID), Reason: err.Error()})
+			continue
+		}
+		delete(curLinks, string(l.ID))
+	}
+	for id := range curLinks {
+		if _, err := tx.Exec("DELETE FROM link WHERE OpID = ? and ID = ?", o.ID, id); err != nil {
+			return rejected, err
+		}
+	}
+
+	if o.Phases != nil {
+		curPhases, err := txIDs(tx, "SELECT ID FROM phase WHERE opID = ?", o.ID)
+		if err != nil {
+			return rejected, err
+		}
+		for _, p := range o.Phases {
+			// reminded is set before start, so it compares against the old start
+			_, err := tx.Exec("INSERT INTO phase (ID, opID, name, start, end) VALUES (?, ?, ?, ?, ?) ON DUPLICATE KEY UPDATE reminded = IF(start = VALUES(start), reminded, 0), name = VALUES(name), start = VALUES(start), end = VALUES(end)",
+				p.ID, o.ID, p.Name, p.Start, p.End)
+			if err != nil {
+				rejected = append(rejected, RejectedObject{Type: "phase", ID: string(p.ID), Reason: err.Error()})
+				continue
+			}
+			delete(curPhases, string(p.ID))
+		}
+		for id := range curPhases {
+			if _, err := tx.Exec("UPDATE link SET phase = NULL WHERE opID = ? AND phase = ?", o.ID, id); err != nil {
+				return rejected, err
+			}
+			if _, err := tx.Exec("UPDATE marker SET phase = NULL WHERE opID = ? AND phase = ?", o.ID, id); err != nil {
+				return rejected, err
+			}
+			if _, err := tx.Exec("DELETE FROM phase WHERE opID = ? AND ID = ?", o.ID, id); err != nil {
+				return rejected, err
+			}
+		}
+	}
+
+	// blockers are only ever added
+	for _, b := range o.newBlockers {
+		_, err := tx.Exec("INSERT IGNORE INTO blocker (ID, opID, fromPortalID, toPortalID, fromName, fromLat, fromLon, toName, toLat, toLon, reportedBy, reported, state) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, NOW(), ?)",
+			b.ID, b.OpID, b.From, b.To, b.FromName, b.FromLat, b.FromLon, b.ToName, b.ToLat, b.ToLon, MakeNullString(string(b.ReportedBy)), b.State)
+		if err != nil {
+			rejected = append(rejected, RejectedObject{Type: "blocker", ID: string(b.ID), Reason: err.Error()})
+		}
+	}
+
+	// anchors are easy, just delete and re-add them all.
+	if _, err := tx.Exec("DELETE FROM anchor WHERE OpID = ?", o.ID); err != nil {
+		return rejected, err
+	}
+	for _, a := range o.Anchors {
+		if _, err := tx.Exec("INSERT IGNORE INTO anchor (opID, portalID) VALUES (?, ?)", o.ID, a); err != nil {
+			rejected = append(rejected, RejectedObject{Type: "anchor", ID: string(a), Reason: err.Error()})
+		}
+	}
+
+	if len(rejected) > 0 {
+		return rejected, nil
+	}
+	return rejected, tx.Commit()
+}
+
+func (s mariaDBStore) RestoreOperation(o *Operation) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	// a no-op once committed
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("UPDATE operation SET name = ?, color = ?, comment = ?, modified = NOW(), version = version + 1 WHERE ID = ?", o.Name, o.Color, MakeNullString(o.Comment), o.ID); err != nil {
+		return err
+	}
+	for _, table := range []string{"marker", "link", "anchor", "portal"} {
+		if _, err := tx.Exec("DELETE FROM "+table+" WHERE opID = ?", o.ID); err != nil {
+			return err
+		}
+	}
+
+	for _, p := range o.OpPortals {
+		_, err := tx.Exec("INSERT INTO portal (ID, opID, name, loc, comment, hardness) VALUES (?, ?, ?, POINT(?, ?), ?, ?)",
+			p.ID, o.ID, p.Name, p.Lon, p.Lat, MakeNullString(p.Comment), MakeNullString(p.Hardness))
+		if err != nil {
+			return err
+		}
+	}
+	// agents may have been deleted since the revision was recorded; the sub-selects turn their assignments and completions into NULL
+	for _, m := range o.Markers {
+		_, err := tx.Exec("INSERT INTO marker (ID, opID, PortalID, type, gid, comment, state, completedby, oporder, phase) VALUES (?, ?, ?, ?, (SELECT gid FROM agent WHERE gid = ?), ?, ?, (SELECT gid FROM agent WHERE gid = ?), ?, ?)",
+			m.ID, o.ID, m.PortalID, m.Type, m.AssignedTo, MakeNullString(m.Comment), m.State, MakeNullString(m.CompletedBy), m.Order, MakeNullString(string(m.Phase)))
+		if err != nil {
+			return err
+		}
+	}
+	for _, l := range o.Links {
+		_, err := tx.Exec("INSERT INTO link (ID, fromPortalID, toPortalID, opID, description, gid, throworder, completed, color, phase) VALUES (?, ?, ?, ?, ?, (SELECT gid FROM agent WHERE gid = ?), ?, ?, ?, ?)",
+			l.ID, l.From, l.To, o.ID, MakeNullString(l.Desc), l.AssignedTo, l.ThrowOrder, l.Completed, l.Color, MakeNullString(string(l.Phase)))
+		if err != nil {
+			return err
+		}
+	}
+	for _, a := range o.Anchors {
+		if _, err := tx.Exec("INSERT IGNORE INTO anchor (opID, portalID) VALUES (?, ?)", o.ID, a); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s mariaDBStore) InsertOpRevision(opID OperationID, gid GoogleID, description string, version int64, op []byte) error {
+	_, err := db.Exec("INSERT INTO oprevision (opID, gid, description, recorded, version, op) VALUES (?, ?, ?, NOW(), ?, ?)", opID, gid, description, version, string(op))
+	return err
+}
+
+func (s mariaDBStore) OpRevisions(opID OperationID) ([]OpRevision, error) {
+	var revisions []OpRevision
+
+	rows, err := db.Query("SELECT ID, gid, description, recorded, version FROM oprevision WHERE opID = ? ORDER BY ID DESC", opID)
+	if err != nil {
+		return revisions, err
+	}
+	defer rows.Close()
+
+	var r OpRevision
+	for rows.Next() {
+		if err := rows.Scan(&r.ID, &r.Gid, &r.Description, &r.Recorded, &r.Version); err != nil {
+			Log.Error(err)
+			continue
+		}
+		revisions = append(revisions, r)
+	}
+	return revisions, nil
+}
+
+func (s mariaDBStore) OpRevision(opID OperationID, revision int) (OpRevision, error) {
+	var r OpRevision
+	var op string
+	err := db.QueryRow("SELECT ID, gid, description, recorded, version, op FROM oprevision WHERE opID = ? AND ID = ?", opID, revision).Scan(&r.ID, &r.Gid, &r.Description, &r.Recorded, &r.Version, &op)
+	r.Op = json.RawMessage(op)
+	return r, err
+}
+
+func (s mariaDBStore) OpRevisionAt(opID OperationID, t time.Time) (OpRevision, error) {
+	var r OpRevision
+	var op string
+	err := db.QueryRow("SELECT ID, gid, description, recorded, version, op FROM oprevision WHERE opID = ? AND recorded <= ? ORDER BY ID DESC LIMIT 1", opID, t.UTC().Format(opTimeFormat)).Scan(&r.ID, &r.Gid, &r.Description, &r.Recorded, &r.Version, &op)
+	r.Op = json.RawMessage(op)
+	return r, err
+}
+
+func (s mariaDBStore) OpRevisionVersion(opID OperationID, version int64) (OpRevision, error) {
+	var r OpRevision
+	var op string
+	err := db.QueryRow("SELECT ID, gid, description, recorded, version, op FROM oprevision WHERE opID = ? AND version <= ? ORDER BY ID DESC LIMIT 1", opID, version).Scan(&r.ID, &r.Gid, &r.Description, &r.Recorded, &r.Version, &op)
+	r.Op = json.RawMessage(op)
+	return r, err
+}
+
+func (s mariaDBStore) PruneOpRevisions(opID OperationID, keep int) error {
+	// MariaDB will not take a LIMIT in a sub-select on the table being deleted from, so find the cut-off first
+	var cutoff int
+	err := db.QueryRow("SELECT ID FROM oprevision WHERE opID = ? ORDER BY ID DESC LIMIT 1 OFFSET ?", opID, keep).Scan(&cutoff)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("DELETE FROM oprevision WHERE opID = ? AND ID <= ?", opID, cutoff)
+	return err
+}
+
+// txIDs reads a single column of IDs into a set; the rows are closed before returning so the tx can be reused
+func txIDs(tx *sql.Tx, query string, opID OperationID) (map[string]bool, error) {
+	ids := make(map[string]bool)
+
+	rows, err := tx.Query(query, opID)
+	if err != nil {
+		return ids, err
+	}
+	defer rows.Close()
+
+	var id string
+	for rows.Next() {
+		if err := rows.Scan(&id); err != nil {
+			return ids, err
+		}
+		ids[id] = true
+	}
+	return ids, rows.Err()
+}
+
+func (s mariaDBStore) SetOperationName(opID OperationID, name string) error {
+	_, err := db.Exec("UPDATE operation SET name = ? WHERE ID = ?", name, opID)
+	return err
+}
+
+func (s mariaDBStore) SetOperationComment(opID OperationID, comment string) error {
+	_, err := db.Exec("UPDATE operation SET comment = ? WHERE ID = ?", comment, opID)
+	return err
+}
+
+func (s mariaDBStore) SetOperationOwner(opID OperationID, gid GoogleID) error {
+	_, err := db.Exec("UPDATE operation SET gid = ? WHERE ID = ?", gid, opID)
+	return err
+}
+
+func (s mariaDBStore) TouchOperation(opID OperationID) error {
+	_, err := db.Exec("UPDATE operation SET modified = NOW(), version = version + 1 WHERE ID = ?", opID)
+	return err
+}
+
+func (s mariaDBStore) DeleteOperation(opID OperationID) error {
+	_, err := db.Exec("DELETE FROM operation WHERE ID = ?", opID)
+	if err != nil {
+		return err
+	}
+	// the foreign key constraints should take care of these, but just in case...
+	_, _ = db.Exec("DELETE FROM marker WHERE opID = ?", opID)
+	_, _ = db.Exec("DELETE FROM link WHERE opID = ?", opID)
+	_, _ = db.Exec("DELETE FROM portal WHERE opID = ?", opID)
+	_, _ = db.Exec("DELETE FROM anchor WHERE opID = ?", opID)
+	_, _ = db.Exec("DELETE FROM opkeys WHERE opID = ?", opID)
+	_, _ = db.Exec("DELETE FROM opteams WHERE opID = ?", opID)
+	_, _ = db.Exec("DELETE FROM oprevision WHERE opID = ?", opID)
+	_, _ = db.Exec("DELETE FROM webhook WHERE opID = ?", opID)
+	return nil
+}
+
+func (s mariaDBStore) TeamOperationCount(teamID TeamID) (int, error) {
+	var teamOps int
+	err := db.QueryRow("SELECT COUNT(*) FROM operation WHERE teamID = ?", teamID).Scan(&teamOps)
+	return teamOps, err
+}
+
+func (s mariaDBStore) OperationTeams(opID OperationID) ([]ExtendedTeam, error) {
+	var teams []ExtendedTeam
+
+	rows, err := db.Query("SELECT teamID, permission FROM opteams WHERE opID = ?", opID)
+	if err != nil {
+		return teams, err
+	}
+	defer rows.Close()
+
+	var tid, role string
+	for rows.Next() {
+		err := rows.Scan(&tid, &role)
+		if err != nil {
+			Log.Notice(err)
+			continue
+		}
+		teams = append(teams, ExtendedTeam{
+			TeamID: TeamID(tid),
+			Role:   etRole(role),
+		})
+	}
+	return teams, nil
+}
+
+func (s mariaDBStore) AddOperationTeam(opID OperationID, teamID TeamID, role etRole) error {
+	_, err := db.Exec("INSERT INTO opteams VALUES (?,?,?)", teamID, opID, role)
+	return err
+}
+
+func (s mariaDBStore) DeleteOperationTeam(opID OperationID, teamID TeamID, role etRole) error {
+	_, err := db.Exec("DELETE FROM opteams WHERE teamID = ? AND opID = ? AND permission = ? LIMIT 1", teamID, opID, role)
+	return err
+}
+
+func (s mariaDBStore) OperationAgents(opID OperationID) ([]Agent, error) {
+	var agents []Agent
+
+	rows, err := db.Query("SELECT DISTINCT a.iname, a.gid, x.displayname FROM agentteams=x, agent=a, opteams=p WHERE x.teamID = p.teamID AND p.opID =  ? AND x.gid = a.gid ORDER BY a.iname", opID)
+	if err != nil {
+		return agents, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var a Agent
+		var dn sql.NullString
+		err := rows.Scan(&a.Name, &a.Gid, &dn)
+		if err != nil {
+			Log.Error(err)
+			continue
+		}
+		a.DisplayName = dn.String
+		agents = append(agents, a)
+	}
+	return agents, nil
+}
+
+func (s mariaDBStore) InsertPortal(opID OperationID, p Portal) error {
+	_, err := db.Exec("INSERT IGNORE INTO portal (ID, opID, name, loc, comment, hardness) VALUES (?, ?, ?, POINT(?, ?), ?, ?)",
+		p.ID, opID, p.Name, p.Lon, p.Lat, MakeNullString(p.Comment), MakeNullString(p.Hardness))
+	return err
+}
+
+func (s mariaDBStore) Portals(opID OperationID) ([]Portal, error) {
+	var portals []Portal
+	var tmpPortal Portal
+	var comment, hardness sql.NullString
+
+	rows, err := db.Query("SELECT ID, name, Y(loc) AS lat, X(loc) AS lon, comment, hardness FROM portal WHERE opID = ? ORDER BY name", opID)
+	if err != nil {
+		return portals, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		err := rows.Scan(&tmpPortal.ID, &tmpPortal.Name, &tmpPortal.Lat, &tmpPortal.Lon, &comment, &hardness)
+		if err != nil {
+			Log.Error(err)
+			continue
+		}
+		tmpPortal.Comment = comment.String
+		tmpPortal.Hardness = hardness.String
+		portals = append(portals, tmpPortal)
+	}
+	return portals, nil
+}
+
+func (s mariaDBStore) Portal(opID OperationID, portalID PortalID) (Portal, error) {
+	var p Portal
+	var comment, hardness sql.NullString
+
+	p.ID = portalID
+	err := db.QueryRow("SELECT name, Y(loc) AS lat, X(loc) AS lon, comment, hardness FROM portal WHERE opID = ? AND ID = ?", opID, portalID).Scan(&p.Name, &p.Lat, &p.Lon, &comment, &hardness)
+	if err != nil {
+		return p, err
+	}
+	p.Comment = comment.String
+	p.Hardness = hardness.String
+	return p, nil
+}
+
+func (s mariaDBStore) SetPortalComment(opID OperationID, portalID PortalID, comment string) error {
+	_, err := db.Exec("UPDATE portal SET comment = ? WHERE ID = ? AND opID = ?", MakeNullString(comment), portalID, opID)
+	return err
+}
+
+func (s mariaDBStore) SetPortalHardness(opID OperationID, portalID PortalID, hardness string) error {
+	_, err := db.Exec("UPDATE portal SET hardness = ? WHERE ID = ? AND opID = ?", MakeNullString(hardness), portalID, opID)
+	return err
+}
+
+func (s mariaDBStore) InsertAnchor(opID OperationID, portalID PortalID) error {
+	_, err := db.Exec("INSERT IGNORE INTO anchor (opID, portalID) VALUES (?, ?)", opID, portalID)
+	return err
+}
+
+func (s mariaDBStore) Anchors(opID OperationID) ([]PortalID, error) {
+	var anchors []PortalID
+	var anchor PortalID
+
+	rows, err := db.Query("SELECT portalID FROM anchor WHERE opID = ?", opID)
+	if err != nil {
+		return anchors, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		err := rows.Scan(&anchor)
+		if err != nil {
+			Log.Error(err)
+			continue
+		}
+		anchors = append(anchors, anchor)
+	}
+	return anchors, nil
+}
+
+func (s mariaDBStore) InsertLink(opID OperationID, l Link) error {
+	_, err := db.Exec("INSERT INTO link (ID, fromPortalID, toPortalID, opID, description, gid, throworder, completed, color, phase) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		l.ID, l.From, l.To, opID, MakeNullString(l.Desc), MakeNullString(l.AssignedTo), l.ThrowOrder, l.Completed, l.Color, MakeNullString(string(l.Phase)))
+	return err
+}
+
+func (s mariaDBStore) Links(opID OperationID) ([]Link, error) {
+	var links []Link
+	var tmpLink Link
+	var description, gid, iname, phase sql.NullString
+
+	rows, err := db.Query("SELECT l.ID, l.fromPortalID, l.toPortalID, l.description, l.gid, l.throworder, l.completed, a.iname, l.color, l.phase FROM link=l LEFT JOIN agent=a ON l.gid=a.gid WHERE l.opID = ? ORDER BY l.throworder", opID)
+	if err != nil {
+		return links, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		err := rows.Scan(&tmpLink.ID, &tmpLink.From, &tmpLink.To, &description, &gid, &tmpLink.ThrowOrder, &tmpLink.Completed, &iname, &tmpLink.Color, &phase)
+		if err != nil {
+			Log.Error(err)
+			continue
+		}
+		tmpLink.Desc = description.String
+		tmpLink.AssignedTo = GoogleID(gid.String)
+		tmpLink.Iname = iname.String
+		tmpLink.Phase = PhaseID(phase.String)
+		links = append(links, tmpLink)
+	}
+	return links, nil
+}
+
+func (s mariaDBStore) AgentLinks(opID OperationID, gid GoogleID) ([]Link, error) {
+	var links []Link
+	var tmpLink Link
+	var description, phase sql.NullString
+
+	rows, err := db.Query("SELECT ID, fromPortalID, toPortalID, description, throworder, completed, phase FROM link WHERE opID = ? AND gid = ? ORDER BY throworder", opID, gid)
+	if err != nil {
+		return links, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		err := rows.Scan(&tmpLink.ID, &tmpLink.From, &tmpLink.To, &description, &tmpLink.ThrowOrder, &tmpLink.Completed, &phase)
+		if err != nil {
+			Log.Error(err)
+			continue
+		}
+		tmpLink.Desc = description.String
+		tmpLink.Phase = PhaseID(phase.String)
+		links = append(links, tmpLink)
+	}
+	return links, nil
+}
+
+func (s mariaDBStore) LinkAssignedTo(opID OperationID, linkID LinkID, gid GoogleID) (bool, error) {
+	var x int
+	err := db.QueryRow("SELECT COUNT(*) FROM link WHERE opID = ? AND ID = ? AND gid = ?", opID, linkID, gid).Scan(&x)
+	if err != nil {
+		return false, err
+	}
+	return x == 1, nil
+}
+
+func (s mariaDBStore) SetLinkAssignment(opID OperationID, linkID LinkID, gid GoogleID) error {
+	_, err := db.Exec("UPDATE link SET gid = ? WHERE ID = ? AND opID = ?", MakeNullString(gid), linkID, opID)
+	return err
+}
+
+func (s mariaDBStore) SetLinkDescription(opID OperationID, linkID LinkID, desc string) error {
+	_, err := db.Exec("UPDATE link SET description = ? WHERE ID = ? AND opID = ?", MakeNullString(desc), linkID, opID)
+	return err
+}
+
+func (s mariaDBStore) SetLinkCompleted(opID OperationID, linkID LinkID, completed bool) error {
+	_, err := db.Exec("UPDATE link SET completed = ? WHERE ID = ? AND opID = ?", completed, linkID, opID)
+	return err
+}
+
+func (s mariaDBStore) SetLinkColor(opID OperationID, linkID LinkID, color string) error {
+	_, err := db.Exec("UPDATE link SET color = ? WHERE ID = ? and opID = ?", color, linkID, opID)
+	return err
+}
+
+func (s mariaDBStore) SetLinkThrowOrder(opID OperationID, linkID LinkID, order int32) error {
+	_, err := db.Exec("UPDATE link SET throworder = ? WHERE opID = ? AND ID = ?", order, opID, linkID)
+	return err
+}
+
+func (s mariaDBStore) SwapLink(opID OperationID, linkID LinkID) error {
+	var tmpLink Link
+
+	err := db.QueryRow("SELECT fromPortalID, toPortalID FROM link WHERE opID = ? AND ID = ?", opID, linkID).Scan(&tmpLink.From, &tmpLink.To)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("UPDATE link SET fromPortalID = ?, toPortalID = ? WHERE ID = ? and opID = ?", tmpLink.To, tmpLink.From, linkID, opID)
+	return err
+}
+
+func (s mariaDBStore) InsertMarker(opID OperationID, m Marker) error {
+	_, err := db.Exec("INSERT INTO marker (ID, opID, PortalID, type, gid, comment, state, oporder, phase) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		m.ID, opID, m.PortalID, m.Type, MakeNullString(m.AssignedTo), MakeNullString(m.Comment), m.State, m.Order, MakeNullString(string(m.Phase)))
+	return err
+}
+
+func (s mariaDBStore) Markers(opID OperationID) ([]Marker, error) {
+	var markers []Marker
+	var tmpMarker Marker
+	var assignedGid, comment, assignedNick, completedBy, phase sql.NullString
+
+	// XXX join with portals table, get name and order by name, don't expose it in this json -- will make the friendly in the https module easier
+	rows, err := db.Query("SELECT m.ID, m.PortalID, m.type, m.gid, m.comment, m.state, a.iname AS assignedTo, b.iname AS completedBy, m.oporder, m.phase FROM marker=m LEFT JOIN agent=a ON m.gid = a.gid LEFT JOIN agent=b on m.completedby = b.gid WHERE m.opID = ? ORDER BY m.oporder, m.type", opID)
+	if err != nil {
+		return markers, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		err := rows.Scan(&tmpMarker.ID, &tmpMarker.PortalID, &tmpMarker.Type, &assignedGid, &comment, &tmpMarker.State, &assignedNick, &completedBy, &tmpMarker.Order, &phase)
+		if err != nil {
+			Log.Error(err)
+			continue
+		}
+		tmpMarker.AssignedTo = GoogleID(assignedGid.String)
+		tmpMarker.IngressName = assignedNick.String
+		tmpMarker.Comment = comment.String
+		tmpMarker.CompletedBy = completedBy.String
+		tmpMarker.Phase = PhaseID(phase.String)
+		markers = append(markers, tmpMarker)
+	}
+	return markers, nil
+}
+
+func (s mariaDBStore) MarkerCompleters(opID OperationID) (map[MarkerID]GoogleID, error) {
+	completers := make(map[MarkerID]GoogleID)
+
+	rows, err := db.Query("SELECT ID, completedby FROM marker WHERE opID = ? AND completedby IS NOT NULL", opID)
+	if err != nil {
+		return completers, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id MarkerID
+		var gid GoogleID
+		if err := rows.Scan(&id, &gid); err != nil {
+			Log.Error(err)
+			continue
+		}
+		completers[id] = gid
+	}
+	return completers, nil
+}
+
+func (s mariaDBStore) AgentMarkers(opID OperationID, gid GoogleID) ([]Marker, error) {
+	var markers []Marker
+	var tmpMarker Marker
+	var comment, phase sql.NullString
+
+	rows, err := db.Query("SELECT ID, PortalID, type, gid, comment, state, phase FROM marker WHERE opID = ? AND gid = ?", opID, gid)
+	if err != nil {
+		return markers, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		err := rows.Scan(&tmpMarker.ID, &tmpMarker.PortalID, &tmpMarker.Type, &tmpMarker.AssignedTo, &comment, &tmpMarker.State, &phase)
+		if err != nil {
+			Log.Error(err)
+			continue
+		}
+		tmpMarker.Comment = comment.String
+		tmpMarker.Phase = PhaseID(phase.String)
+		markers = append(markers, tmpMarker)
+	}
+	return markers, nil
+}
+
+func (s mariaDBStore) MarkerAssignee(opID OperationID, markerID MarkerID) (GoogleID, error) {
+	var ns sql.NullString
+	err := db.QueryRow("SELECT gid FROM marker WHERE ID = ? and opID = ?", markerID, opID).Scan(&ns)
+	return GoogleID(ns.String), err
+}
+
+func (s mariaDBStore) SetMarkerAssignment(opID OperationID, markerID MarkerID, gid GoogleID, state string) error {
+	_, err := db.Exec("UPDATE marker SET gid = ?, state = ? WHERE ID = ? AND opID = ?", MakeNullString(gid), state, markerID, opID)
+	return err
+}
+
+func (s mariaDBStore) SetAssignments(opID OperationID, assignments []PlannedAssignment) ([]string, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	// a no-op once committed
+	defer tx.Rollback()
+
+	changed := []string{}
+	for _, a := range assignments {
+		if a.Agent == a.Previous {
+			continue
+		}
+		// only if still assigned as planned, <=> so an unassigned item matches NULL
+		var res sql.Result
+		if a.Type == "link" {
+			res, err = tx.Exec("UPDATE link SET gid = ? WHERE ID = ? AND opID = ? AND gid <=> ?", MakeNullString(a.Agent), a.ID, opID, MakeNullString(a.Previous))
+		} else {
+			res, err = tx.Exec("UPDATE marker SET gid = ?, state = ? WHERE ID = ? AND opID = ? AND gid <=> ?", MakeNullString(a.Agent), "assigned", a.ID, opID, MakeNullString(a.Previous))
+		}
+		if err != nil {
+			return nil, err
+		}
+		if n, err := res.RowsAffected(); err != nil {
+			return nil, err
+		} else if n == 0 {
+			changed = append(changed, a.ID)
+		}
+	}
+	if len(changed) > 0 {
+		return changed, nil
+	}
+	return nil, tx.Commit()
+}
+
+func (s mariaDBStore) SetMarkerComment(opID OperationID, markerID MarkerID, comment string) error {
+	_, err := db.Exec("UPDATE marker SET comment = ? WHERE ID = ? AND opID = ?", MakeNullString(comment), markerID, opID)
+	return err
+}
+
+func (s mariaDBStore) SetMarkerState(opID OperationID, markerID MarkerID, state string) error {
+	_, err := db.Exec("UPDATE marker SET state = ? WHERE ID = ? AND opID = ?", state, markerID, opID)
+	return err
+}
+
+func (s mariaDBStore) SetMarkerCompleted(opID OperationID, markerID MarkerID, completedBy GoogleID) error {
+	_, err := db.Exec("UPDATE marker SET state = ?, completedby = ? WHERE ID = ? AND opID = ?", "completed", completedBy, markerID, opID)
+	return err
+}
+
+func (s mariaDBStore) SetMarkerIncomplete(opID OperationID, markerID MarkerID) error {
+	_, err := db.Exec("UPDATE marker SET state = ?, completedby = NULL WHERE ID = ? AND opID = ?", "assigned", markerID, opID)
+	return err
+}
+
+func (s mariaDBStore) SetMarkerOrder(opID OperationID, markerID MarkerID, order int) error {
+	_, err := db.Exec("UPDATE marker SET oporder = ? WHERE opID = ? AND ID = ?", order, opID, markerID)
+	return err
+}
+
+func (s mariaDBStore) InsertKey(opID OperationID, k KeyOnHand) error {
+	_, err := db.Exec("INSERT INTO opkeys (opID, portalID, gid, onhand, capsule) VALUES (?, ?, ?, ?, ?) ON DUPLICATE KEY UPDATE onhand = ?, capsule = ?",
+		opID, k.ID, k.Gid, k.Onhand, MakeNullString(k.Capsule), k.Onhand, MakeNullString(k.Capsule))
+	return err
+}
+
+func (s mariaDBStore) Keys(opID OperationID) ([]KeyOnHand, error) {
+	var keys []KeyOnHand
+	var k KeyOnHand
+	var capsule sql.NullString
+
+	rows, err := db.Query("SELECT portalID, gid, onhand, capsule FROM opkeys WHERE opID = ?", opID)
+	if err != nil {
+		return keys, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		err := rows.Scan(&k.ID, &k.Gid, &k.Onhand, &capsule)
+		if err != nil {
+			Log.Error(err)
+			continue
+		}
+		k.Capsule = capsule.String
+		keys = append(keys, k)
+	}
+	return keys, nil
+}
+
+func (s mariaDBStore) InsertAuditEntry(e AuditEntry) error {
+	_, err := db.Exec("INSERT INTO opaudit (opID, gid, recorded, action, objtype, objID, detail) VALUES (?, ?, NOW(), ?, ?, ?, ?)",
+		e.OpID, e.Gid, e.Action, e.Type, e.ObjectID, MakeNullString(e.Detail))
+	return err
+}
+
+func (s mariaDBStore) AuditEntries(opID OperationID, filter AuditFilter) ([]AuditEntry, error) {
+	var entries []AuditEntry
+
+	query := "SELECT x.ID, x.opID, x.gid, a.iname, x.recorded, x.action, x.objtype, x.objID, x.detail FROM opaudit=x LEFT JOIN agent=a ON x.gid = a.gid WHERE x.opID = ?"
+	args := []interface{}{opID}
+	if filter.Gid != "" {
+		query += " AND x.gid = ?"
+		args = append(args, filter.Gid)
+	}
+	if filter.Type != "" {
+		query += " AND x.objtype = ?"
+		args = append(args, filter.Type)
+	}
+	if filter.ObjectID != "" {
+		query += " AND x.objID = ?"
+		args = append(args, filter.ObjectID)
+	}
+	query += " ORDER BY x.ID DESC LIMIT ? OFFSET ?"
+	args = append(args, filter.Limit, filter.Offset)
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return entries, err
+	}
+	defer rows.Close()
+
+	var e AuditEntry
+	var iname, detail sql.NullString
+	for rows.Next() {
+		if err := rows.Scan(&e.ID, &e.OpID, &e.Gid, &iname, &e.Recorded, &e.Action, &e.Type, &e.ObjectID, &detail); err != nil {
+			Log.Error(err)
+			continue
+		}
+		e.Name = iname.String
+		e.Detail = detail.String
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
@@ -0,0 +1,82 @@
+package wasabee
+
+import (
+	"time"
+)
+
+func (s mariaDBStore) SetPhase(opID OperationID, p Phase) error {
+	// reminded is set before start, so it compares against the old start
+	_, err := db.Exec("INSERT INTO phase (ID, opID, name, start, end) VALUES (?, ?, ?, ?, ?) ON DUPLICATE KEY UPDATE reminded = IF(start = VALUES(start), reminded, 0), name = VALUES(name), start = VALUES(start), end = VALUES(end)",
+		p.ID, opID, p.Name, p.Start, p.End)
+	return err
+}
+
+func (s mariaDBStore) Phases(opID OperationID) ([]Phase, error) {
+	return queryPhases("SELECT ID, opID, name, start, end FROM phase WHERE opID = ? ORDER BY start, ID", opID)
+}
+
+func (s mariaDBStore) Phase(opID OperationID, phaseID PhaseID) (Phase, error) {
+	var p Phase
+	err := db.QueryRow("SELECT ID, opID, name, start, end FROM phase WHERE opID = ? AND ID = ?", opID, phaseID).Scan(&p.ID, &p.OpID, &p.Name, &p.Start, &p.End)
+	return p, err
+}
+
+func queryPhases(query string, args ...interface{}) ([]Phase, error) {
+	var phases []Phase
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return phases, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var p Phase
+		if err := rows.Scan(&p.ID, &p.OpID, &p.Name, &p.Start, &p.End); err != nil {
+			Log.Error(err)
+			continue
+		}
+		phases = append(phases, p)
+	}
+	return phases, nil
+}
+
+func (s mariaDBStore) DeletePhase(opID OperationID, phaseID PhaseID) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	// a no-op once committed
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("UPDATE link SET phase = NULL WHERE opID = ? AND phase = ?", opID, phaseID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("UPDATE marker SET phase = NULL WHERE opID = ? AND phase = ?", opID, phaseID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM phase WHERE opID = ? AND ID = ?", opID, phaseID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s mariaDBStore) SetLinkPhase(opID OperationID, linkID LinkID, phaseID PhaseID) error {
+	_, err := db.Exec("UPDATE link SET phase = ? WHERE ID = ? AND opID = ?", MakeNullString(string(phaseID)), linkID, opID)
+	return err
+}
+
+func (s mariaDBStore) SetMarkerPhase(opID OperationID, markerID MarkerID, phaseID PhaseID) error {
+	_, err := db.Exec("UPDATE marker SET phase = ? WHERE ID = ? AND opID = ?", MakeNullString(string(phaseID)), markerID, opID)
+	return err
+}
+
+func (s mariaDBStore) PhasesToRemind(before time.Time) ([]Phase, error) {
+	// phase times are UTC
+	return queryPhases("SELECT ID, opID, name, start, end FROM phase WHERE reminded = 0 AND start > UTC_TIMESTAMP() AND start <= ? ORDER BY start, ID", before.UTC().Format(phaseTimeFormat))
+}
+
+func (s mariaDBStore) SetPhaseReminded(opID OperationID, phaseID PhaseID) error {
+	_, err := db.Exec("UPDATE phase SET reminded = 1 WHERE opID = ? AND ID = ?", opID, phaseID)
+	return err
+}
@@ -0,0 +1,299 @@
+package wasabee
+
+import (
+	"database/sql"
+	"strconv"
+)
+
+func (s mariaDBStore) InsertTeam(teamID TeamID, owner GoogleID, name string) error {
+	_, err := db.Exec("INSERT INTO team (teamID, owner, name, rockskey, rockscomm) VALUES (?,?,?,NULL,NULL)", teamID, owner, name)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("INSERT INTO agentteams (teamID, gid, state, color, displayname) VALUES (?,?,'On','operator',NULL)", teamID, owner)
+	return err
+}
+
+func (s mariaDBStore) DeleteTeam(teamID TeamID) error {
+	_, err := db.Exec("DELETE FROM opteams WHERE teamID = ?", teamID)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("DELETE FROM webhook WHERE teamID = ?", teamID)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("DELETE FROM team WHERE teamID = ?", teamID)
+	return err
+}
+
+func (s mariaDBStore) TeamData(teamID TeamID, td *TeamData) error {
+	var rockscomm, rockskey sql.NullString
+	if err := db.QueryRow("SELECT name, rockscomm, rockskey FROM team WHERE teamID = ?", teamID).Scan(&td.Name, &rockscomm, &rockskey); err != nil {
+		return err
+	}
+	if rockscomm.Valid {
+		td.RocksComm = rockscomm.String
+	}
+	if rockskey.Valid {
+		td.RocksKey = rockskey.String
+	}
+	return nil
+}
+
+func (s mariaDBStore) TeamName(teamID TeamID) (string, error) {
+	var name string
+	err := db.QueryRow("SELECT name FROM team WHERE teamID = ?", teamID).Scan(&name)
+	return name, err
+}
+
+func (s mariaDBStore) SetTeamName(teamID TeamID, name string) error {
+	_, err := db.Exec("UPDATE team SET name = ? WHERE teamID = ?", name, teamID)
+	return err
+}
+
+func (s mariaDBStore) TeamOwner(teamID TeamID) (GoogleID, error) {
+	var owner GoogleID
+	err := db.QueryRow("SELECT owner FROM team WHERE teamID = ?", teamID).Scan(&owner)
+	return owner, err
+}
+
+func (s mariaDBStore) SetTeamOwner(teamID TeamID, gid GoogleID) error {
+	_, err := db.Exec("UPDATE team SET owner = ? WHERE teamID = ?", gid, teamID)
+	return err
+}
+
+func (s mariaDBStore) SetTeamRocks(teamID TeamID, key, community string) error {
+	_, err := db.Exec("UPDATE team SET rockskey = ?, rockscomm = ? WHERE teamID = ?", key, community, teamID)
+	return err
+}
+
+func (s mariaDBStore) TeamRocksKey(teamID TeamID) (string, error) {
+	var rc sql.NullString
+	err := db.QueryRow("SELECT rockskey FROM team WHERE teamID = ?", teamID).Scan(&rc)
+	return rc.String, err
+}
+
+func (s mariaDBStore) RocksTeamID(community string) (TeamID, error) {
+	var t TeamID
+	err := db.QueryRow("SELECT teamID FROM team WHERE rockscomm = ?", community).Scan(&t)
+	return t, err
+}
+
+func (s mariaDBStore) TeamAgents(teamID TeamID, fetchAll bool) ([]Agent, error) {
+	var agents []Agent
+	var state, lat, lon string
+	var tmpU Agent
+
+	var err error
+	var rows *sql.Rows
+	if fetchAll {
+		rows, err = db.Query("SELECT u.gid, u.iname, x.color, x.state, Y(l.loc), X(l.loc), l.upTime, u.VVerified, u.VBlacklisted, u.Vid, x.displayname "+
+			"FROM team=t, agentteams=x, agent=u, locations=l "+
+			"WHERE t.teamID = ? AND t.teamID = x.teamID AND x.gid = u.gid AND x.gid = l.gid ORDER BY x.state DESC, u.iname", teamID)
+	} else {
+		rows, err = db.Query("SELECT u.gid, u.iname, x.color, x.state, Y(l.loc), X(l.loc), l.upTime, u.VVerified, u.VBlacklisted, u.Vid, x.displayname "+
+			"FROM team=t, agentteams=x, agent=u, locations=l "+
+			"WHERE t.teamID = ? AND t.teamID = x.teamID AND x.gid = u.gid AND x.gid = l.gid "+
+			"AND x.state = 'On' ORDER BY x.state DESC, u.iname", teamID)
+	}
+	if err != nil {
+		return agents, err
+	}
+
+	defer rows.Close()
+	for rows.Next() {
+		var enlID, dn sql.NullString
+		err := rows.Scan(&tmpU.Gid, &tmpU.Name, &tmpU.Squad, &state, &lat, &lon, &tmpU.Date, &tmpU.Verified, &tmpU.Blacklisted, &enlID, &dn)
+		if err != nil {
+			return agents, err
+		}
+		tmpU.State = state == "On"
+		tmpU.EnlID = EnlID(enlID.String)
+		tmpU.Lat, _ = strconv.ParseFloat(lat, 64)
+		tmpU.Lon, _ = strconv.ParseFloat(lon, 64)
+		tmpU.DisplayName = dn.String
+		agents = append(agents, tmpU)
+	}
+	return agents, nil
+}
+
+func (s mariaDBStore) TeamMembers(teamID TeamID, activeOnly bool) ([]GoogleID, error) {
+	var gids []GoogleID
+
+	var err error
+	var rows *sql.Rows
+	if activeOnly {
+		rows, err = db.Query("SELECT gid FROM agentteams WHERE teamID = ? AND state != 'Off'", teamID)
+	} else {
+		rows, err = db.Query("SELECT gid FROM agentteams WHERE teamID = ?", teamID)
+	}
+	if err != nil {
+		return gids, err
+	}
+	defer rows.Close()
+
+	var gid GoogleID
+	for rows.Next() {
+		if err = rows.Scan(&gid); err != nil {
+			Log.Error(err)
+			continue
+		}
+		gids = append(gids, gid)
+	}
+	return gids, nil
+}
+
+func (s mariaDBStore) AgentInTeam(gid GoogleID, teamID TeamID, allowOff bool) (bool, error) {
+	var count int
+
+	var err error
+	if allowOff {
+		err = db.QueryRow("SELECT COUNT(*) FROM agentteams WHERE teamID = ? AND gid = ?", teamID, gid).Scan(&count)
+	} else {
+		err = db.QueryRow("SELECT COUNT(*) FROM agentteams WHERE teamID = ? AND gid = ? AND state = 'On'", teamID, gid).Scan(&count)
+	}
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+func (s mariaDBStore) AddTeamAgent(teamID TeamID, gid GoogleID) error {
+	_, err := db.Exec("INSERT IGNORE INTO agentteams (teamID, gid, state, color, displayname) VALUES (?, ?, 'Off', 'boots', NULL)", teamID, gid)
+	return err
+}
+
+func (s mariaDBStore) RemoveTeamAgent(teamID TeamID, gid GoogleID) error {
+	_, err := db.Exec("DELETE FROM agentteams WHERE teamID = ? AND gid = ?", teamID, gid)
+	return err
+}
+
+func (s mariaDBStore) SetTeamAgentState(teamID TeamID, gid GoogleID, state string) error {
+	_, err := db.Exec("UPDATE agentteams SET state = ? WHERE gid = ? AND teamID = ?", state, gid, teamID)
+	return err
+}
+
+func (s mariaDBStore) SetTeamAgentSquad(teamID TeamID, gid GoogleID, squad string) error {
+	_, err := db.Exec("UPDATE agentteams SET color = ? WHERE teamID = ? and gid = ?", MakeNullString(squad), teamID, gid)
+	return err
+}
+
+func (s mariaDBStore) SetTeamAgentDisplayName(teamID TeamID, gid GoogleID, displayname string) error {
+	_, err := db.Exec("UPDATE agentteams SET displayname = ? WHERE teamID = ? and gid = ?", MakeNullString(displayname), teamID, gid)
+	return err
+}
+
+func (s mariaDBStore) TeamAgentDisplayName(teamID TeamID, gid GoogleID) (string, error) {
+	var displayname sql.NullString
+	err := db.QueryRow("SELECT displayname FROM agentteams WHERE teamID = ? AND gid = ?", teamID, gid).Scan(&displayname)
+	return displayname.String, err
+}
+
+func (s mariaDBStore) AgentTeams(gid GoogleID) ([]AdTeam, error) {
+	var teams []AdTeam
+
+	rows, err := db.Query("SELECT t.teamID, t.name, x.state, t.rockscomm FROM team=t, agentteams=x WHERE x.gid = ? AND x.teamID = t.teamID ORDER BY t.name", gid)
+	if err != nil {
+		return teams, err
+	}
+	defer rows.Close()
+
+	var adteam AdTeam
+	var rc sql.NullString
+	for rows.Next() {
+		err := rows.Scan(&adteam.ID, &adteam.Name, &adteam.State, &rc)
+		if err != nil {
+			return teams, err
+		}
+		adteam.RocksComm = rc.String
+		teams = append(teams, adteam)
+	}
+	return teams, nil
+}
+
+func (s mariaDBStore) AgentOwnedTeams(gid GoogleID) ([]AdOwnedTeam, error) {
+	var teams []AdOwnedTeam
+
+	rows, err := db.Query("SELECT teamID, name, rockscomm, rockskey FROM team WHERE owner = ? ORDER BY name", gid)
+	if err != nil {
+		return teams, err
+	}
+	defer rows.Close()
+
+	var ownedTeam AdOwnedTeam
+	var rc, rockskey sql.NullString
+	for rows.Next() {
+		err := rows.Scan(&ownedTeam.ID, &ownedTeam.Name, &rc, &rockskey)
+		if err != nil {
+			return teams, err
+		}
+		ownedTeam.RocksComm = rc.String
+		ownedTeam.RocksKey = rockskey.String
+		teams = append(teams, ownedTeam)
+	}
+	return teams, nil
+}
+
+func (s mariaDBStore) AgentTeamIDs(gid GoogleID) ([]TeamID, error) {
+	var teams []TeamID
+
+	rows, err := db.Query("SELECT teamID FROM agentteams WHERE gid = ?", gid)
+	if err != nil {
+		return teams, err
+	}
+	defer rows.Close()
+
+	var tid TeamID
+	for rows.Next() {
+		if err := rows.Scan(&tid); err != nil {
+			Log.Error(err)
+			continue
+		}
+		teams = append(teams, tid)
+	}
+	return teams, nil
+}
+
+func (s mariaDBStore) TeammatesNear(gid GoogleID, maxdistance, maxresults int) ([]Agent, error) {
+	var agents []Agent
+	var state, lat, lon string
+	var tmpU Agent
+
+	err := db.QueryRow("SELECT Y(loc), X(loc) FROM locations WHERE gid = ?", gid).Scan(&lat, &lon)
+	if err != nil {
+		return agents, err
+	}
+
+	// no ST_Distance_Sphere in MariaDB yet...
+	rows, err := db.Query("SELECT DISTINCT u.iname, x.color, x.state, Y(l.loc), X(l.loc), l.upTime, u.VVerified, u.VBlacklisted, "+
+		"ROUND(6371 * acos (cos(radians(?)) * cos(radians(Y(l.loc))) * cos(radians(X(l.loc)) - radians(?)) + sin(radians(?)) * sin(radians(Y(l.loc))))) AS distance "+
+		"FROM agentteams=x, agent=u, locations=l "+
+		"WHERE x.teamID IN (SELECT teamID FROM agentteams WHERE gid = ? AND state = 'On') "+
+		"AND x.state = 'On' AND x.gid = u.gid AND x.gid = l.gid AND l.upTime > SUBTIME(NOW(), '12:00:00') "+
+		"HAVING distance < ? AND distance > 0 ORDER BY distance LIMIT 0,?", lat, lon, lat, gid, maxdistance, maxresults)
+	if err != nil {
+		return agents, err
+	}
+
+	defer rows.Close()
+	for rows.Next() {
+		err := rows.Scan(&tmpU.Name, &tmpU.Squad, &state, &lat, &lon, &tmpU.Date, &tmpU.Verified, &tmpU.Blacklisted, &tmpU.Distance)
+		if err != nil {
+			return agents, err
+		}
+		tmpU.State = state == "On"
+		tmpU.Lat, _ = strconv.ParseFloat(lat, 64)
+		tmpU.Lon, _ = strconv.ParseFloat(lon, 64)
+		agents = append(agents, tmpU)
+	}
+	return agents, nil
+}
+
+func (s mariaDBStore) CanSendTo(from, to GoogleID) (bool, error) {
+	var count int
+	if err := db.QueryRow("SELECT COUNT(x.gid) FROM agentteams=x, team=t WHERE t.teamID = x.teamID AND t.owner = ? AND x.state != 'Off' AND x.gid = ?", from, to).Scan(&count); err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
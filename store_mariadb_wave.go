@@ -0,0 +1,166 @@
+package wasabee
+
+import (
+	"database/sql"
+	"time"
+)
+
+func (s mariaDBStore) SetWave(opID OperationID, w Wave) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	// a no-op once committed
+	defer tx.Rollback()
+
+	// notified is set before at, so it compares against the old time
+	if _, err := tx.Exec("INSERT INTO wave (ID, opID, name, at) VALUES (?, ?, ?, ?) ON DUPLICATE KEY UPDATE notified = IF(at = VALUES(at), notified, NULL), name = VALUES(name), at = VALUES(at)",
+		w.ID, opID, w.Name, w.At); err != nil {
+		return err
+	}
+
+	keep := make(map[LinkID]bool)
+	for _, l := range w.Links {
+		keep[l] = true
+	}
+	rows, err := tx.Query("SELECT linkID FROM wavelink WHERE opID = ? AND waveID = ?", opID, w.ID)
+	if err != nil {
+		return err
+	}
+	var drop []LinkID
+	for rows.Next() {
+		var l LinkID
+		if err := rows.Scan(&l); err != nil {
+			rows.Close()
+			return err
+		}
+		if !keep[l] {
+			drop = append(drop, l)
+		}
+	}
+	rows.Close()
+	for _, l := range drop {
+		if _, err := tx.Exec("DELETE FROM wavelink WHERE opID = ? AND linkID = ?", opID, l); err != nil {
+			return err
+		}
+	}
+	for l := range keep {
+		// a link already in this wave keeps its completion; one done before it joined counts as done when it joined
+		if _, err := tx.Exec("INSERT INTO wavelink (opID, linkID, waveID, completed) SELECT ?, ?, ?, IF(l.completed, UTC_TIMESTAMP(), NULL) FROM link=l WHERE l.opID = ? AND l.ID = ? ON DUPLICATE KEY UPDATE completed = IF(waveID = VALUES(waveID), completed, VALUES(completed)), waveID = VALUES(waveID)",
+			opID, l, w.ID, opID, l); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s mariaDBStore) Waves(opID OperationID) ([]Wave, error) {
+	return queryWaves("SELECT ID, opID, name, at, notified FROM wave WHERE opID = ? ORDER BY at, ID", opID)
+}
+
+func (s mariaDBStore) Wave(opID OperationID, waveID WaveID) (Wave, error) {
+	var w Wave
+	err := db.QueryRow("SELECT ID, opID, name, at, notified FROM wave WHERE opID = ? AND ID = ?", opID, waveID).Scan(&w.ID, &w.OpID, &w.Name, &w.At, &w.notified)
+	if err != nil {
+		return w, err
+	}
+	err = w.fillLinks()
+	return w, err
+}
+
+func queryWaves(query string, args ...interface{}) ([]Wave, error) {
+	var waves []Wave
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return waves, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var w Wave
+		if err := rows.Scan(&w.ID, &w.OpID, &w.Name, &w.At, &w.notified); err != nil {
+			Log.Error(err)
+			continue
+		}
+		waves = append(waves, w)
+	}
+	// rows must be closed before the links can be read
+	rows.Close()
+
+	for i := range waves {
+		if err := waves[i].fillLinks(); err != nil {
+			return waves, err
+		}
+	}
+	return waves, nil
+}
+
+func (w *Wave) fillLinks() error {
+	links, err := store.WaveLinks(w.OpID, w.ID)
+	if err != nil {
+		return err
+	}
+	w.Links = []LinkID{}
+	for _, l := range links {
+		w.Links = append(w.Links, l.ID)
+	}
+	return nil
+}
+
+func (s mariaDBStore) DeleteWave(opID OperationID, waveID WaveID) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	// a no-op once committed
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM wavelink WHERE opID = ? AND waveID = ?", opID, waveID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM wave WHERE opID = ? AND ID = ?", opID, waveID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s mariaDBStore) WaveLinks(opID OperationID, waveID WaveID) ([]WaveLink, error) {
+	var links []WaveLink
+
+	rows, err := db.Query("SELECT wavelink.linkID, wavelink.waveID, wavelink.completed FROM wavelink LEFT JOIN link ON wavelink.linkID = link.ID AND wavelink.opID = link.opID WHERE wavelink.opID = ? AND wavelink.waveID = ? ORDER BY link.throworder, wavelink.linkID", opID, waveID)
+	if err != nil {
+		return links, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var wl WaveLink
+		var completed sql.NullString
+		if err := rows.Scan(&wl.ID, &wl.Wave, &completed); err != nil {
+			Log.Error(err)
+			continue
+		}
+		if completed.Valid {
+			wl.Completed = completed.String
+		}
+		links = append(links, wl)
+	}
+	return links, nil
+}
+
+func (s mariaDBStore) SetWaveLinkCompleted(opID OperationID, linkID LinkID, completed bool) error {
+	// reporting it done again does not move the time it was first done
+	_, err := db.Exec("UPDATE wavelink SET completed = IF(?, COALESCE(completed, UTC_TIMESTAMP()), NULL) WHERE opID = ? AND linkID = ?", completed, opID, linkID)
+	return err
+}
+
+func (s mariaDBStore) WavesBetween(from, to time.Time) ([]Wave, error) {
+	// wave times are UTC
+	return queryWaves("SELECT ID, opID, name, at, notified FROM wave WHERE at >= ? AND at <= ? ORDER BY at, ID", from.UTC().Format(phaseTimeFormat), to.UTC().Format(phaseTimeFormat))
+}
+
+func (s mariaDBStore) SetWaveNotified(opID OperationID, waveID WaveID, mark int) error {
+	_, err := db.Exec("UPDATE wave SET notified = ? WHERE opID = ? AND ID = ?", mark, opID, waveID)
+	return err
+}
@@ -0,0 +1,94 @@
+package wasabee
+
+import (
+	"database/sql"
+	"strings"
+)
+
+func (s mariaDBStore) InsertWebhook(h Webhook) error {
+	_, err := db.Exec("INSERT INTO webhook (ID, opID, teamID, gid, url, secret, events) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		h.ID, MakeNullString(string(h.OpID)), MakeNullString(string(h.TeamID)), h.Gid, h.URL, h.Secret, strings.Join(h.Events, ","))
+	return err
+}
+
+func (s mariaDBStore) DeleteWebhook(hookID string) error {
+	_, err := db.Exec("DELETE FROM webhook WHERE ID = ?", hookID)
+	return err
+}
+
+const webhookColumns = "ID, opID, teamID, gid, url, secret, events, created"
+
+func scanWebhook(row interface{ Scan(...interface{}) error }) (Webhook, error) {
+	var h Webhook
+	var opID, teamID sql.NullString
+	var events string
+	if err := row.Scan(&h.ID, &opID, &teamID, &h.Gid, &h.URL, &h.Secret, &events, &h.Created); err != nil {
+		return h, err
+	}
+	h.OpID = OperationID(opID.String)
+	h.TeamID = TeamID(teamID.String)
+	if events != "" {
+		h.Events = strings.Split(events, ",")
+	}
+	return h, nil
+}
+
+func (s mariaDBStore) Webhook(hookID string) (Webhook, error) {
+	return scanWebhook(db.QueryRow("SELECT "+webhookColumns+" FROM webhook WHERE ID = ?", hookID))
+}
+
+func (s mariaDBStore) OpWebhooks(opID OperationID) ([]Webhook, error) {
+	return queryWebhooks("SELECT "+webhookColumns+" FROM webhook WHERE opID = ? ORDER BY created", opID)
+}
+
+func (s mariaDBStore) TeamWebhooks(teamID TeamID) ([]Webhook, error) {
+	return queryWebhooks("SELECT "+webhookColumns+" FROM webhook WHERE teamID = ? ORDER BY created", teamID)
+}
+
+func queryWebhooks(query string, args ...interface{}) ([]Webhook, error) {
+	var hooks []Webhook
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return hooks, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		h, err := scanWebhook(rows)
+		if err != nil {
+			Log.Error(err)
+			continue
+		}
+		hooks = append(hooks, h)
+	}
+	return hooks, nil
+}
+
+func (s mariaDBStore) InsertWebhookDelivery(d WebhookDelivery) error {
+	_, err := db.Exec("INSERT INTO webhookdelivery (hookID, eventID, event, attempt, status, error, delivered) VALUES (?, ?, ?, ?, ?, ?, NOW())",
+		d.HookID, d.EventID, d.Event, d.Attempt, d.Status, MakeNullString(d.Error))
+	return err
+}
+
+func (s mariaDBStore) WebhookDeliveries(hookID string, limit int) ([]WebhookDelivery, error) {
+	var deliveries []WebhookDelivery
+
+	rows, err := db.Query("SELECT ID, hookID, eventID, event, attempt, status, error, delivered FROM webhookdelivery WHERE hookID = ? ORDER BY ID DESC LIMIT ?", hookID, limit)
+	if err != nil {
+		return deliveries, err
+	}
+	defer rows.Close()
+
+	var d WebhookDelivery
+	var e sql.NullString
+	for rows.Next() {
+		if err := rows.Scan(&d.ID, &d.HookID, &d.EventID, &d.Event, &d.Attempt, &d.Status, &e, &d.Delivered); err != nil {
+			Log.Error(err)
+			continue
+		}
+		d.Error = e.String
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, nil
+}
@@ -0,0 +1,673 @@
+package wasabee
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// memoryStore keeps everything in process memory. It is intended for development and for running the tests without a MariaDB server.
+// Nothing is persisted; restarting the server loses all data.
+type memoryStore struct {
+	mu         sync.RWMutex
+	agents     map[GoogleID]*memAgent
+	telegram   map[TelegramID]*memTelegram
+	firebase   map[GoogleID][]string
+	defensive  map[GoogleID]map[PortalID]DefensiveKey
+	teams      map[TeamID]*memTeam
+	agentteams map[TeamID]map[GoogleID]*memTeamAgent
+	ops        map[OperationID]*memOperation
+	documents  map[string]*SimpleDocument
+	revisionID int
+	audit      []AuditEntry
+	webhooks   map[string]*Webhook
+	deliveries []WebhookDelivery
+}
+
+type memAgent struct {
+	AgentData
+	picture string
+	lat     float64
+	lon     float64
+	upTime  time.Time
+}
+
+type memTelegram struct {
+	gid       GoogleID
+	verified  bool
+	authtoken string
+}
+
+// memTimeFormat matches what MariaDB returns for datetime columns
+const memTimeFormat = "2006-01-02 15:04:05"
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{
+		agents:     make(map[GoogleID]*memAgent),
+		telegram:   make(map[TelegramID]*memTelegram),
+		firebase:   make(map[GoogleID][]string),
+		defensive:  make(map[GoogleID]map[PortalID]DefensiveKey),
+		teams:      make(map[TeamID]*memTeam),
+		agentteams: make(map[TeamID]map[GoogleID]*memTeamAgent),
+		ops:        make(map[OperationID]*memOperation),
+		documents:  make(map[string]*SimpleDocument),
+		webhooks:   make(map[string]*Webhook),
+	}
+}
+
+func (s *memoryStore) InsertAgent(ad *AgentData) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.agents[ad.GoogleID]; ok {
+		return nil
+	}
+	a := memAgent{AgentData: *ad, upTime: time.Now()}
+	a.OwnedTeams, a.Teams, a.Ops, a.OwnedOps, a.Assignments = nil, nil, nil, nil, nil
+	s.agents[ad.GoogleID] = &a
+	return nil
+}
+
+func (s *memoryStore) AgentData(gid GoogleID, ud *AgentData) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	a, ok := s.agents[gid]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	ud.IngressName = a.IngressName
+	ud.Level = a.Level
+	ud.LocationKey = a.LocationKey
+	ud.VVerified = a.VVerified
+	ud.VBlacklisted = a.VBlacklisted
+	ud.Vid = a.Vid
+	ud.RocksVerified = a.RocksVerified
+	ud.RAID = a.RAID
+	ud.RISC = a.RISC
+	return nil
+}
+
+func (s *memoryStore) Agent(gid GoogleID, agent *Agent) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	a, ok := s.agents[gid]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	agent.Gid = gid
+	agent.Name = a.IngressName
+	agent.Level = a.Level
+	agent.Verified = a.VVerified
+	agent.Blacklisted = a.VBlacklisted
+	agent.EnlID = a.Vid
+	agent.RocksVerified = a.RocksVerified
+	return nil
+}
+
+func (s *memoryStore) IngressName(gid GoogleID) (string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	a, ok := s.agents[gid]
+	if !ok {
+		return "", sql.ErrNoRows
+	}
+	return a.IngressName, nil
+}
+
+func (s *memoryStore) SearchAgentName(name string) (GoogleID, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for gid, a := range s.agents {
+		if strings.EqualFold(a.IngressName, name) {
+			return gid, nil
+		}
+	}
+	return "", sql.ErrNoRows
+}
+
+func (s *memoryStore) AllAgents() ([]GoogleID, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var gids []GoogleID
+	for gid := range s.agents {
+		gids = append(gids, gid)
+	}
+	return gids, nil
+}
+
+// DeleteAgent mirrors the foreign key cascades of the MariaDB schema
+func (s *memoryStore) DeleteAgent(gid GoogleID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.agents, gid)
+	delete(s.firebase, gid)
+	delete(s.defensive, gid)
+	for tgid, t := range s.telegram {
+		if t.gid == gid {
+			delete(s.telegram, tgid)
+		}
+	}
+	for teamID, t := range s.teams {
+		if t.owner == gid {
+			s.deleteTeam(teamID)
+		}
+	}
+	for _, members := range s.agentteams {
+		delete(members, gid)
+	}
+	for opID, o := range s.ops {
+		if o.Gid == gid {
+			delete(s.ops, opID)
+			continue
+		}
+		for id, l := range o.links {
+			if l.AssignedTo == gid {
+				l.AssignedTo = ""
+				o.links[id] = l
+			}
+		}
+		for id, m := range o.markers {
+			if m.AssignedTo == gid {
+				m.AssignedTo = ""
+				o.markers[id] = m
+			}
+		}
+	}
+	return nil
+}
+
+func (s *memoryStore) SetAgentRISC(gid GoogleID, risc bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if a, ok := s.agents[gid]; ok {
+		a.RISC = risc
+	}
+	return nil
+}
+
+func (s *memoryStore) AgentRISC(gid GoogleID) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	a, ok := s.agents[gid]
+	if !ok {
+		return false, sql.ErrNoRows
+	}
+	return a.RISC, nil
+}
+
+func (s *memoryStore) SetAgentPicture(gid GoogleID, picurl string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	a, ok := s.agents[gid]
+	if !ok {
+		return fmt.Errorf("unknown agent: %s", gid)
+	}
+	a.picture = picurl
+	return nil
+}
+
+func (s *memoryStore) AgentPicture(gid GoogleID) (string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	a, ok := s.agents[gid]
+	if !ok || a.picture == "" {
+		return "", sql.ErrNoRows
+	}
+	return a.picture, nil
+}
+
+func (s *memoryStore) SetAgentLocation(gid GoogleID, lat, lon float64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if a, ok := s.agents[gid]; ok {
+		a.lat = lat
+		a.lon = lon
+		a.upTime = time.Now()
+	}
+	return nil
+}
+
+func (s *memoryStore) AgentLastLocation(gid GoogleID) (float64, float64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	a, ok := s.agents[gid]
+	if !ok || (a.lat == 0 && a.lon == 0) {
+		return 0, 0, sql.ErrNoRows
+	}
+	return a.lat, a.lon, nil
+}
+
+func (s *memoryStore) ExpireLocations(age time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cutoff := time.Now().Add(-age)
+	for _, a := range s.agents {
+		if (a.lat != 0 || a.lon != 0) && a.upTime.Before(cutoff) {
+			a.lat = 0
+			a.lon = 0
+			a.upTime = time.Now()
+		}
+	}
+	return nil
+}
+
+func (s *memoryStore) AgentOps(gid GoogleID) ([]AdOperation, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var ops []AdOperation
+	for _, o := range s.ops {
+		for _, et := range o.teams {
+			x, ok := s.agentteams[et.TeamID][gid]
+			if !ok || x.state != "On" {
+				continue
+			}
+			t, ok := s.teams[et.TeamID]
+			if !ok {
+				continue
+			}
+			ops = append(ops, AdOperation{
+				ID:       string(o.ID),
+				Name:     o.Name,
+				IsOwner:  o.Gid == gid,
+				Color:    o.Color,
+				TeamName: t.name,
+				TeamID:   et.TeamID,
+			})
+		}
+	}
+	sort.SliceStable(ops, func(i, j int) bool {
+		if ops[i].Name != ops[j].Name {
+			return ops[i].Name < ops[j].Name
+		}
+		return ops[i].TeamName < ops[j].TeamName
+	})
+	return ops, nil
+}
+
+func (s *memoryStore) AgentAssignments(gid GoogleID) ([]Assignment, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	now := time.Now()
+	// anything in a phase which has ended does not count
+	current := func(o *memOperation, id PhaseID) bool {
+		p, ok := o.phases[id]
+		return !ok || !p.ended(now)
+	}
+
+	var markers, links []Assignment
+	for _, o := range s.ops {
+		for _, m := range o.markers {
+			if m.AssignedTo == gid && current(o, m.Phase) {
+				markers = append(markers, Assignment{OpID: o.ID, OperationName: o.Name, Type: "Marker"})
+				break
+			}
+		}
+		for _, l := range o.links {
+			if l.AssignedTo == gid && current(o, l.Phase) {
+				links = append(links, Assignment{OpID: o.ID, OperationName: o.Name, Type: "Link"})
+				break
+			}
+		}
+	}
+	byName := func(a []Assignment) func(i, j int) bool {
+		return func(i, j int) bool { return a[i].OperationName < a[j].OperationName }
+	}
+	sort.SliceStable(markers, byName(markers))
+	sort.SliceStable(links, byName(links))
+	return append(markers, links...), nil
+}
+
+func (s *memoryStore) UpdateAgentV(gid GoogleID, iname string, level int64, verified, blacklisted bool, enlID EnlID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if a, ok := s.agents[gid]; ok {
+		a.IngressName = iname
+		a.Level = level
+		a.VVerified = verified
+		a.VBlacklisted = blacklisted
+		a.Vid = enlID
+	}
+	return nil
+}
+
+func (s *memoryStore) UpdateAgentRocks(gid GoogleID, iname string, verified bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if a, ok := s.agents[gid]; ok {
+		a.IngressName = iname
+		a.RocksVerified = verified
+	}
+	return nil
+}
+
+func (s *memoryStore) SetAgentRAID(gid GoogleID, raid bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if a, ok := s.agents[gid]; ok {
+		a.RAID = raid
+	}
+	return nil
+}
+
+func (s *memoryStore) SetEnlIDRAID(eid EnlID, raid bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, a := range s.agents {
+		if a.Vid == eid {
+			a.RAID = raid
+		}
+	}
+	return nil
+}
+
+func (s *memoryStore) RAIDAgents() ([]Agent, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var agents []Agent
+	for gid, a := range s.agents {
+		if a.RAID {
+			agents = append(agents, Agent{Gid: gid, EnlID: a.Vid})
+		}
+	}
+	return agents, nil
+}
+
+func (s *memoryStore) AgentEnlID(gid GoogleID) (EnlID, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	a, ok := s.agents[gid]
+	if !ok {
+		return "", sql.ErrNoRows
+	}
+	return a.Vid, nil
+}
+
+func (s *memoryStore) EnlIDGid(eid EnlID) (GoogleID, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for gid, a := range s.agents {
+		if a.Vid != "" && a.Vid == eid {
+			return gid, nil
+		}
+	}
+	return "", sql.ErrNoRows
+}
+
+func (s *memoryStore) LocKeyGid(lockey LocKey) (GoogleID, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for gid, a := range s.agents {
+		if a.LocationKey != "" && a.LocationKey == string(lockey) {
+			return gid, nil
+		}
+	}
+	return "", sql.ErrNoRows
+}
+
+func (s *memoryStore) AgentTelegram(gid GoogleID) (TelegramID, bool, string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for tgid, t := range s.telegram {
+		if t.gid == gid {
+			return tgid, t.verified, t.authtoken, nil
+		}
+	}
+	return 0, false, "", sql.ErrNoRows
+}
+
+func (s *memoryStore) TelegramGid(tgid TelegramID) (GoogleID, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	t, ok := s.telegram[tgid]
+	if !ok {
+		return "", false, sql.ErrNoRows
+	}
+	return t.gid, t.verified, nil
+}
+
+func (s *memoryStore) telegramInUse(tgid TelegramID, gid GoogleID) bool {
+	if _, ok := s.telegram[tgid]; ok {
+		return true
+	}
+	for _, t := range s.telegram {
+		if t.gid == gid {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *memoryStore) InsertTelegram(tgid TelegramID, gid GoogleID, authtoken string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.telegramInUse(tgid, gid) {
+		return fmt.Errorf("duplicate telegram entry for %d / %s", tgid, gid)
+	}
+	s.telegram[tgid] = &memTelegram{gid: gid, authtoken: authtoken}
+	return nil
+}
+
+func (s *memoryStore) ImportTelegram(tgid TelegramID, gid GoogleID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.telegramInUse(tgid, gid) {
+		s.telegram[tgid] = &memTelegram{gid: gid, verified: true}
+	}
+	return nil
+}
+
+func (s *memoryStore) VerifyTelegram(tgid TelegramID, authtoken string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, ok := s.telegram[tgid]
+	if !ok || t.authtoken == "" || t.authtoken != authtoken {
+		return false, nil
+	}
+	t.authtoken = ""
+	t.verified = true
+	return true, nil
+}
+
+func (s *memoryStore) FirebaseTokens(gid GoogleID) ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var toks []string
+	seen := make(map[string]bool)
+	for _, t := range s.firebase[gid] {
+		if !seen[t] {
+			seen[t] = true
+			toks = append(toks, t)
+		}
+	}
+	return toks, nil
+}
+
+func (s *memoryStore) InsertFirebaseToken(gid GoogleID, token string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.firebase[gid] = append(s.firebase[gid], token)
+	return nil
+}
+
+func (s *memoryStore) DeleteFirebaseToken(gid GoogleID, token string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var toks []string
+	for _, t := range s.firebase[gid] {
+		if t != token {
+			toks = append(toks, t)
+		}
+	}
+	s.firebase[gid] = toks
+	return nil
+}
+
+func (s *memoryStore) DeleteFirebaseTokens(gid GoogleID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.firebase, gid)
+	return nil
+}
+
+func (s *memoryStore) DefensiveKeys(gid GoogleID) ([]DefensiveKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	// everyone who is On for any team on which gid is also On
+	visible := make(map[GoogleID]bool)
+	for _, members := range s.agentteams {
+		if x, ok := members[gid]; !ok || x.state != "On" {
+			continue
+		}
+		for g, x := range members {
+			if x.state == "On" {
+				visible[g] = true
+			}
+		}
+	}
+
+	var dks []DefensiveKey
+	for g := range visible {
+		for _, dk := range s.defensive[g] {
+			dks = append(dks, dk)
+		}
+	}
+	return dks, nil
+}
+
+func (s *memoryStore) SetDefensiveKey(gid GoogleID, portalID PortalID, capID string, count int32) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.defensive[gid]; !ok {
+		s.defensive[gid] = make(map[PortalID]DefensiveKey)
+	}
+	s.defensive[gid][portalID] = DefensiveKey{GID: gid, PortalID: portalID, CapID: capID, Count: count}
+	return nil
+}
+
+func (s *memoryStore) DeleteDefensiveKey(gid GoogleID, portalID PortalID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.defensive[gid], portalID)
+	return nil
+}
+
+func (s *memoryStore) NameInUse(hashed string) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if _, ok := s.teams[TeamID(hashed)]; ok {
+		return true, nil
+	}
+	for _, a := range s.agents {
+		if a.LocationKey == hashed {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// LogMessage only logs at debug level, the in-memory store does not keep a message log
+func (s *memoryStore) LogMessage(gid GoogleID, message string) error {
+	Log.Debugf("message to %s: %s", gid, message)
+	return nil
+}
+
+func (s *memoryStore) InsertDocument(hashed, content string, upload, expiration time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.documents[hashed]; ok {
+		return fmt.Errorf("duplicate document: %s", hashed)
+	}
+	s.documents[hashed] = &SimpleDocument{
+		Content:    content,
+		Upload:     upload.UTC().Round(time.Second),
+		Expiration: expiration.UTC().Round(time.Second),
+	}
+	if expiration.IsZero() {
+		s.documents[hashed].Expiration = time.Time{}
+	}
+	return nil
+}
+
+func (s *memoryStore) Document(hashed string) (SimpleDocument, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	d, ok := s.documents[hashed]
+	if !ok {
+		return SimpleDocument{}, sql.ErrNoRows
+	}
+	return *d, nil
+}
+
+func (s *memoryStore) DocumentViewed(hashed string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if d, ok := s.documents[hashed]; ok {
+		d.Views++
+	}
+	return nil
+}
+
+func (s *memoryStore) DeleteDocument(hashed string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.documents, hashed)
+	return nil
+}
+
+func (s *memoryStore) DeleteExpiredDocuments() (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var n int64
+	now := time.Now()
+	for id, d := range s.documents {
+		if !d.Expiration.IsZero() && d.Expiration.Before(now) && d.Expiration.After(time.Unix(0, 0)) {
+			delete(s.documents, id)
+			n++
+		}
+	}
+	return n, nil
+}
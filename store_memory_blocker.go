@@ -0,0 +1,113 @@
+package wasabee
+
+import (
+	"database/sql"
+	"sort"
+	"time"
+)
+
+func (s *memoryStore) InsertBlocker(b Blocker) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	m := s.op(b.OpID)
+	if m == nil {
+		// matches the foreign key
+		return sql.ErrNoRows
+	}
+	if _, ok := m.blockers[b.ID]; ok {
+		return nil
+	}
+	if m.blockers == nil {
+		m.blockers = make(map[LinkID]Blocker)
+	}
+	b.Reported = time.Now().UTC().Format(memTimeFormat)
+	m.blockers[b.ID] = b
+	return nil
+}
+
+func sortBlockers(blockers []Blocker) {
+	sort.Slice(blockers, func(i, j int) bool {
+		if blockers[i].Reported != blockers[j].Reported {
+			return blockers[i].Reported < blockers[j].Reported
+		}
+		if blockers[i].OpID != blockers[j].OpID {
+			return blockers[i].OpID < blockers[j].OpID
+		}
+		return blockers[i].ID < blockers[j].ID
+	})
+}
+
+func (s *memoryStore) Blockers(opID OperationID) ([]Blocker, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var blockers []Blocker
+	if m := s.op(opID); m != nil {
+		for _, b := range m.blockers {
+			blockers = append(blockers, b)
+		}
+	}
+	sortBlockers(blockers)
+	return blockers, nil
+}
+
+func (s *memoryStore) Blocker(opID OperationID, blockerID LinkID) (Blocker, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if m := s.op(opID); m != nil {
+		if b, ok := m.blockers[blockerID]; ok {
+			return b, nil
+		}
+	}
+	return Blocker{}, sql.ErrNoRows
+}
+
+func (s *memoryStore) TeamBlockers(teamID TeamID) ([]Blocker, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var blockers []Blocker
+	for _, m := range s.ops {
+		shared := false
+		for _, t := range m.teams {
+			shared = shared || t.TeamID == teamID
+		}
+		if !shared {
+			continue
+		}
+		for _, b := range m.blockers {
+			blockers = append(blockers, b)
+		}
+	}
+	sortBlockers(blockers)
+	return blockers, nil
+}
+
+func (s *memoryStore) SetBlockerCleared(opID OperationID, blockerID LinkID, gid GoogleID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	m := s.op(opID)
+	if m == nil {
+		return nil
+	}
+	if b, ok := m.blockers[blockerID]; ok {
+		b.State = BlockerDestroyed
+		b.ClearedBy = gid
+		b.Cleared = time.Now().UTC().Format(memTimeFormat)
+		m.blockers[blockerID] = b
+	}
+	return nil
+}
+
+func (s *memoryStore) DeleteBlocker(opID OperationID, blockerID LinkID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if m := s.op(opID); m != nil {
+		delete(m.blockers, blockerID)
+	}
+	return nil
+}
@@ -0,0 +1,141 @@
+package wasabee
+
+import (
+	"database/sql"
+	"sort"
+	"time"
+)
+
+func (s *memoryStore) InsertKeyTransfer(t KeyTransfer) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	m := s.op(t.OpID)
+	if m == nil {
+		// matches the foreign key
+		return sql.ErrNoRows
+	}
+	t.Created = time.Now().UTC().Format(memTimeFormat)
+	t.Updated = t.Created
+	if m.transfers == nil {
+		m.transfers = make(map[string]*KeyTransfer)
+	}
+	m.transfers[t.ID] = &t
+	return nil
+}
+
+// keyTransfer finds a transfer in any op; the caller must hold the lock
+func (s *memoryStore) keyTransfer(transferID string) (*memOperation, *KeyTransfer) {
+	for _, m := range s.ops {
+		if t, ok := m.transfers[transferID]; ok {
+			return m, t
+		}
+	}
+	return nil, nil
+}
+
+func (s *memoryStore) KeyTransfer(transferID string) (KeyTransfer, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	m, t := s.keyTransfer(transferID)
+	if t == nil {
+		return KeyTransfer{}, sql.ErrNoRows
+	}
+	c := *t
+	c.OperationName = m.Name
+	return c, nil
+}
+
+func (s *memoryStore) OpKeyTransfers(opID OperationID) ([]KeyTransfer, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var transfers []KeyTransfer
+	if m := s.op(opID); m != nil {
+		for _, t := range m.transfers {
+			c := *t
+			c.OperationName = m.Name
+			transfers = append(transfers, c)
+		}
+	}
+	sortKeyTransfers(transfers)
+	return transfers, nil
+}
+
+func (s *memoryStore) AgentKeyTransfers(gid GoogleID) ([]KeyTransfer, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var transfers []KeyTransfer
+	for _, m := range s.ops {
+		for _, t := range m.transfers {
+			if (t.From == gid || t.To == gid) && (t.State == KeyTransferRequested || t.State == KeyTransferAccepted) {
+				c := *t
+				c.OperationName = m.Name
+				transfers = append(transfers, c)
+			}
+		}
+	}
+	sortKeyTransfers(transfers)
+	return transfers, nil
+}
+
+func sortKeyTransfers(transfers []KeyTransfer) {
+	sort.SliceStable(transfers, func(i, j int) bool {
+		if transfers[i].Created != transfers[j].Created {
+			return transfers[i].Created < transfers[j].Created
+		}
+		return transfers[i].ID < transfers[j].ID
+	})
+}
+
+func (s *memoryStore) SetKeyTransferState(transferID string, was string, holder GoogleID, state string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, t := s.keyTransfer(transferID)
+	if t == nil {
+		return sql.ErrNoRows
+	}
+	if t.State != was {
+		return ErrKeyTransferState
+	}
+	t.From = holder
+	t.State = state
+	t.Updated = time.Now().UTC().Format(memTimeFormat)
+	return nil
+}
+
+func (s *memoryStore) CompleteKeyTransfer(transferID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	m, t := s.keyTransfer(transferID)
+	if t == nil {
+		return sql.ErrNoRows
+	}
+	if t.State != KeyTransferAccepted || t.From == "" {
+		return ErrKeyTransferState
+	}
+
+	from := memKeyID{t.PortalID, t.From}
+	to := memKeyID{t.PortalID, t.To}
+	fk := m.keys[from]
+	if fk.Onhand < t.Count {
+		return errKeysShort
+	}
+	fk.Onhand -= t.Count
+	m.keys[from] = fk
+
+	tk, ok := m.keys[to]
+	if !ok {
+		tk = KeyOnHand{ID: t.PortalID, Gid: t.To}
+	}
+	tk.Onhand += t.Count
+	m.keys[to] = tk
+
+	t.State = KeyTransferCompleted
+	t.Updated = time.Now().UTC().Format(memTimeFormat)
+	return nil
+}
@@ -0,0 +1,985 @@
+package wasabee
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+)
+
+type memOperation struct {
+	ID        OperationID
+	Name      string
+	Gid       GoogleID
+	Color     string
+	TeamID    TeamID
+	Modified  time.Time
+	Version   int64
+	Comment   string
+	teams     []ExtendedTeam
+	portals   map[PortalID]Portal
+	anchors   map[PortalID]bool
+	links     map[LinkID]Link
+	markers   map[MarkerID]Marker
+	keys      map[memKeyID]KeyOnHand
+	revisions []OpRevision
+	transfers map[string]*KeyTransfer
+	blockers  map[LinkID]Blocker
+	phases    map[PhaseID]memPhase
+	waves     map[WaveID]Wave
+	wavelinks map[LinkID]WaveLink
+}
+
+type memKeyID struct {
+	portalID PortalID
+	gid      GoogleID
+}
+
+// op returns the operation or nil; the caller must hold the lock
+func (s *memoryStore) op(opID OperationID) *memOperation {
+	return s.ops[opID]
+}
+
+func (s *memoryStore) InsertOperation(o *Operation, gid GoogleID, teamID TeamID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.ops[o.ID]; ok {
+		return fmt.Errorf("duplicate operation: %s", o.ID)
+	}
+	s.ops[o.ID] = &memOperation{
+		ID:       o.ID,
+		Name:     o.Name,
+		Gid:      gid,
+		Color:    o.Color,
+		TeamID:   teamID,
+		Modified: time.Now(),
+		Version:  1,
+		Comment:  o.Comment,
+		portals:  make(map[PortalID]Portal),
+		anchors:  make(map[PortalID]bool),
+		links:    make(map[LinkID]Link),
+		markers:  make(map[MarkerID]Marker),
+		keys:     make(map[memKeyID]KeyOnHand),
+	}
+	return nil
+}
+
+func (s *memoryStore) Operation(o *Operation) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	m := s.op(o.ID)
+	if m == nil {
+		return sql.ErrNoRows
+	}
+	o.Name = m.Name
+	o.Gid = m.Gid
+	o.Color = m.Color
+	o.TeamIDdep = m.TeamID
+	o.Modified = m.Modified.UTC().Format(memTimeFormat)
+	o.Version = m.Version
+	o.Comment = m.Comment
+	return nil
+}
+
+func (s *memoryStore) OperationExists(opID OperationID) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.op(opID) != nil, nil
+}
+
+func (s *memoryStore) OperationOwner(opID OperationID) (GoogleID, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	m := s.op(opID)
+	if m == nil {
+		return "", sql.ErrNoRows
+	}
+	return m.Gid, nil
+}
+
+func (s *memoryStore) UpdateOperation(o *Operation, pre OpPrecondition) ([]RejectedObject, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var rejected []RejectedObject
+	m := s.op(o.ID)
+	if m == nil {
+		return rejected, fmt.Errorf("unknown operation: %s", o.ID)
+	}
+	// compare at the same one-second resolution the database has
+	if !pre.unconditional() && pre.changed(m.Version, m.Modified.Truncate(time.Second)) {
+		return rejected, errOpModified
+	}
+
+	// build the new state aside and swap it in, so nothing is half-applied
+	portals := make(map[PortalID]Portal)
+	for _, p := range o.OpPortals {
+		portals[p.ID] = p
+	}
+
+	markers := make(map[MarkerID]Marker)
+	for _, mk := range o.Markers {
+		if cur, ok := m.markers[mk.ID]; ok {
+			// assignments, status and order are not overwritten
+			cur.Type = mk.Type
+			cur.PortalID = mk.PortalID
+			cur.Comment = mk.Comment
+			markers[mk.ID] = cur
+			continue
+		}
+		mk.IngressName = ""
+		mk.CompletedBy = ""
+		markers[mk.ID] = mk
+	}
+
+	links := make(map[LinkID]Link)
+	for _, l := range o.Links {
+		if cur, ok := m.links[l.ID]; ok {
+			// assignments, status and order are not overwritten
+			cur.From = l.From
+			cur.To = l.To
+			cur.Desc = l.Desc
+			cur.Color = l.Color
+			links[l.ID] = cur
+			continue
+		}
+		l.Iname = ""
+		links[l.ID] = l
+	}
+
+	anchors := make(map[PortalID]bool)
+	for _, a := range o.Anchors {
+		anchors[a] = true
+	}
+
+	phases := m.phases
+	if o.Phases != nil {
+		phases = make(map[PhaseID]memPhase)
+		for _, p := range o.Phases {
+			p.OpID = o.ID
+			cur, ok := m.phases[p.ID]
+			phases[p.ID] = memPhase{Phase: p, reminded: ok && cur.reminded && cur.Start == p.Start}
+		}
+		for id, l := range links {
+			if _, ok := phases[l.Phase]; !ok && l.Phase != "" {
+				l.Phase = ""
+				links[id] = l
+			}
+		}
+		for id, mk := range markers {
+			if _, ok := phases[mk.Phase]; !ok && mk.Phase != "" {
+				mk.Phase = ""
+				markers[id] = mk
+			}
+		}
+	}
+
+	// blockers are only ever added
+	for _, b := range o.newBlockers {
+		if _, ok := m.blockers[b.ID]; ok {
+			continue
+		}
+		if m.blockers == nil {
+			m.blockers = make(map[LinkID]Blocker)
+		}
+		b.Reported = time.Now().UTC().Format(memTimeFormat)
+		m.blockers[b.ID] = b
+	}
+
+	m.Name = o.Name
+	m.Color = o.Color
+	m.Comment = o.Comment
+	m.portals = portals
+	m.markers = markers
+	m.links = links
+	m.anchors = anchors
+	m.phases = phases
+	m.Modified = time.Now()
+	m.Version++
+	return rejected, nil
+}
+
+func (s *memoryStore) RestoreOperation(o *Operation) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	m := s.op(o.ID)
+	if m == nil {
+		return fmt.Errorf("unknown operation: %s", o.ID)
+	}
+
+	portals := make(map[PortalID]Portal)
+	for _, p := range o.OpPortals {
+		portals[p.ID] = p
+	}
+	// agents may have been deleted since the revision was recorded
+	markers := make(map[MarkerID]Marker)
+	for _, mk := range o.Markers {
+		if _, ok := s.agents[mk.AssignedTo]; !ok {
+			mk.AssignedTo = ""
+		}
+		if _, ok := s.agents[GoogleID(mk.CompletedBy)]; !ok {
+			mk.CompletedBy = ""
+		}
+		mk.IngressName = ""
+		markers[mk.ID] = mk
+	}
+	links := make(map[LinkID]Link)
+	for _, l := range o.Links {
+		if _, ok := s.agents[l.AssignedTo]; !ok {
+			l.AssignedTo = ""
+		}
+		l.Iname = ""
+		links[l.ID] = l
+	}
+	anchors := make(map[PortalID]bool)
+	for _, a := range o.Anchors {
+		anchors[a] = true
+	}
+
+	phases := m.phases
+	if o.Phases != nil {
+		phases = make(map[PhaseID]memPhase)
+		for _, p := range o.Phases {
+			p.OpID = o.ID
+			cur, ok := m.phases[p.ID]
+			phases[p.ID] = memPhase{Phase: p, reminded: ok && cur.reminded && cur.Start == p.Start}
+		}
+		for id, l := range links {
+			if _, ok := phases[l.Phase]; !ok && l.Phase != "" {
+				l.Phase = ""
+				links[id] = l
+			}
+		}
+		for id, mk := range markers {
+			if _, ok := phases[mk.Phase]; !ok && mk.Phase != "" {
+				mk.Phase = ""
+				markers[id] = mk
+			}
+		}
+	}
+
+	// blockers are only ever added
+	for _, b := range o.newBlockers {
+		if _, ok := m.blockers[b.ID]; ok {
+			continue
+		}
+		if m.blockers == nil {
+			m.blockers = make(map[LinkID]Blocker)
+		}
+		b.Reported = time.Now().UTC().Format(memTimeFormat)
+		m.blockers[b.ID] = b
+	}
+
+	m.Name = o.Name
+	m.Color = o.Color
+	m.Comment = o.Comment
+	m.portals = portals
+	m.markers = markers
+	m.links = links
+	m.anchors = anchors
+	m.Modified = time.Now()
+	m.Version++
+	return nil
+}
+
+func (s *memoryStore) InsertOpRevision(opID OperationID, gid GoogleID, description string, version int64, op []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	m := s.op(opID)
+	if m == nil {
+		return fmt.Errorf("unknown operation: %s", opID)
+	}
+	s.revisionID++
+	m.revisions = append(m.revisions, OpRevision{
+		ID:          s.revisionID,
+		Gid:         gid,
+		Description: description,
+		Recorded:    time.Now().UTC().Format(memTimeFormat),
+		Version:     version,
+		Op:          append(json.RawMessage(nil), op...),
+	})
+	return nil
+}
+
+func (s *memoryStore) OpRevisions(opID OperationID) ([]OpRevision, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var revisions []OpRevision
+	m := s.op(opID)
+	if m == nil {
+		return revisions, nil
+	}
+	for i := len(m.revisions) - 1; i >= 0; i-- {
+		r := m.revisions[i]
+		r.Op = nil
+		revisions = append(revisions, r)
+	}
+	return revisions, nil
+}
+
+func (s *memoryStore) OpRevision(opID OperationID, revision int) (OpRevision, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if m := s.op(opID); m != nil {
+		for _, r := range m.revisions {
+			if r.ID == revision {
+				return r, nil
+			}
+		}
+	}
+	return OpRevision{}, sql.ErrNoRows
+}
+
+func (s *memoryStore) OpRevisionAt(opID OperationID, t time.Time) (OpRevision, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	cutoff := t.UTC().Format(memTimeFormat)
+	if m := s.op(opID); m != nil {
+		for i := len(m.revisions) - 1; i >= 0; i-- {
+			if m.revisions[i].Recorded <= cutoff {
+				return m.revisions[i], nil
+			}
+		}
+	}
+	return OpRevision{}, sql.ErrNoRows
+}
+
+func (s *memoryStore) OpRevisionVersion(opID OperationID, version int64) (OpRevision, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if m := s.op(opID); m != nil {
+		for i := len(m.revisions) - 1; i >= 0; i-- {
+			if m.revisions[i].Version <= version {
+				return m.revisions[i], nil
+			}
+		}
+	}
+	return OpRevision{}, sql.ErrNoRows
+}
+
+func (s *memoryStore) PruneOpRevisions(opID OperationID, keep int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if m := s.op(opID); m != nil && len(m.revisions) > keep {
+		m.revisions = append([]OpRevision(nil), m.revisions[len(m.revisions)-keep:]...)
+	}
+	return nil
+}
+
+func (s *memoryStore) SetOperationName(opID OperationID, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if m := s.op(opID); m != nil {
+		m.Name = name
+	}
+	return nil
+}
+
+func (s *memoryStore) SetOperationComment(opID OperationID, comment string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if m := s.op(opID); m != nil {
+		m.Comment = comment
+	}
+	return nil
+}
+
+func (s *memoryStore) SetOperationOwner(opID OperationID, gid GoogleID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if m := s.op(opID); m != nil {
+		m.Gid = gid
+	}
+	return nil
+}
+
+func (s *memoryStore) TouchOperation(opID OperationID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if m := s.op(opID); m != nil {
+		m.Modified = time.Now()
+		m.Version++
+	}
+	return nil
+}
+
+func (s *memoryStore) DeleteOperation(opID OperationID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.ops, opID)
+	for id, h := range s.webhooks {
+		if h.OpID == opID {
+			s.deleteWebhook(id)
+		}
+	}
+	return nil
+}
+
+func (s *memoryStore) TeamOperationCount(teamID TeamID) (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var count int
+	for _, m := range s.ops {
+		if m.TeamID == teamID {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (s *memoryStore) OperationTeams(opID OperationID) ([]ExtendedTeam, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var teams []ExtendedTeam
+	if m := s.op(opID); m != nil {
+		teams = append(teams, m.teams...)
+	}
+	return teams, nil
+}
+
+func (s *memoryStore) AddOperationTeam(opID OperationID, teamID TeamID, role etRole) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	m := s.op(opID)
+	if m == nil {
+		return fmt.Errorf("unknown operation: %s", opID)
+	}
+	if _, ok := s.teams[teamID]; !ok {
+		return fmt.Errorf("unknown team: %s", teamID)
+	}
+	m.teams = append(m.teams, ExtendedTeam{TeamID: teamID, Role: role})
+	return nil
+}
+
+func (s *memoryStore) DeleteOperationTeam(opID OperationID, teamID TeamID, role etRole) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	m := s.op(opID)
+	if m == nil {
+		return nil
+	}
+	for i, t := range m.teams {
+		if t.TeamID == teamID && t.Role == role {
+			m.teams = append(m.teams[:i], m.teams[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+func (s *memoryStore) OperationAgents(opID OperationID) ([]Agent, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var agents []Agent
+	m := s.op(opID)
+	if m == nil {
+		return agents, nil
+	}
+	type row struct {
+		gid GoogleID
+		dn  string
+	}
+	seen := make(map[row]bool)
+	for _, t := range m.teams {
+		for gid, x := range s.agentteams[t.TeamID] {
+			a, ok := s.agents[gid]
+			r := row{gid, x.displayname}
+			if !ok || seen[r] {
+				continue
+			}
+			seen[r] = true
+			agents = append(agents, Agent{Gid: gid, Name: a.IngressName, DisplayName: x.displayname})
+		}
+	}
+	sort.SliceStable(agents, func(i, j int) bool { return agents[i].Name < agents[j].Name })
+	return agents, nil
+}
+
+func (s *memoryStore) InsertPortal(opID OperationID, p Portal) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	m := s.op(opID)
+	if m == nil {
+		return fmt.Errorf("unknown operation: %s", opID)
+	}
+	if _, ok := m.portals[p.ID]; !ok {
+		m.portals[p.ID] = p
+	}
+	return nil
+}
+
+func (s *memoryStore) Portals(opID OperationID) ([]Portal, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var portals []Portal
+	m := s.op(opID)
+	if m == nil {
+		return portals, nil
+	}
+	for _, p := range m.portals {
+		portals = append(portals, p)
+	}
+	sort.SliceStable(portals, func(i, j int) bool { return portals[i].Name < portals[j].Name })
+	return portals, nil
+}
+
+func (s *memoryStore) Portal(opID OperationID, portalID PortalID) (Portal, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	m := s.op(opID)
+	if m == nil {
+		return Portal{ID: portalID}, sql.ErrNoRows
+	}
+	p, ok := m.portals[portalID]
+	if !ok {
+		return Portal{ID: portalID}, sql.ErrNoRows
+	}
+	return p, nil
+}
+
+func (s *memoryStore) SetPortalComment(opID OperationID, portalID PortalID, comment string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if m := s.op(opID); m != nil {
+		if p, ok := m.portals[portalID]; ok {
+			p.Comment = comment
+			m.portals[portalID] = p
+		}
+	}
+	return nil
+}
+
+func (s *memoryStore) SetPortalHardness(opID OperationID, portalID PortalID, hardness string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if m := s.op(opID); m != nil {
+		if p, ok := m.portals[portalID]; ok {
+			p.Hardness = hardness
+			m.portals[portalID] = p
+		}
+	}
+	return nil
+}
+
+func (s *memoryStore) InsertAnchor(opID OperationID, portalID PortalID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	m := s.op(opID)
+	if m == nil {
+		return fmt.Errorf("unknown operation: %s", opID)
+	}
+	m.anchors[portalID] = true
+	return nil
+}
+
+func (s *memoryStore) Anchors(opID OperationID) ([]PortalID, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var anchors []PortalID
+	m := s.op(opID)
+	if m == nil {
+		return anchors, nil
+	}
+	for a := range m.anchors {
+		anchors = append(anchors, a)
+	}
+	sort.Slice(anchors, func(i, j int) bool { return anchors[i] < anchors[j] })
+	return anchors, nil
+}
+
+func (s *memoryStore) InsertLink(opID OperationID, l Link) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	m := s.op(opID)
+	if m == nil {
+		return fmt.Errorf("unknown operation: %s", opID)
+	}
+	if _, ok := m.links[l.ID]; ok {
+		return fmt.Errorf("duplicate link: %s", l.ID)
+	}
+	l.Iname = ""
+	m.links[l.ID] = l
+	return nil
+}
+
+// iname returns the agent's name or "" if unknown; the caller must hold the lock
+func (s *memoryStore) iname(gid GoogleID) string {
+	if a, ok := s.agents[gid]; ok {
+		return a.IngressName
+	}
+	return ""
+}
+
+func (s *memoryStore) Links(opID OperationID) ([]Link, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var links []Link
+	m := s.op(opID)
+	if m == nil {
+		return links, nil
+	}
+	for _, l := range m.links {
+		l.Iname = s.iname(l.AssignedTo)
+		links = append(links, l)
+	}
+	sort.SliceStable(links, func(i, j int) bool {
+		if links[i].ThrowOrder != links[j].ThrowOrder {
+			return links[i].ThrowOrder < links[j].ThrowOrder
+		}
+		return links[i].ID < links[j].ID
+	})
+	return links, nil
+}
+
+func (s *memoryStore) AgentLinks(opID OperationID, gid GoogleID) ([]Link, error) {
+	links, err := s.Links(opID)
+	if err != nil {
+		return links, err
+	}
+
+	var assigned []Link
+	for _, l := range links {
+		if l.AssignedTo == gid {
+			assigned = append(assigned, Link{ID: l.ID, From: l.From, To: l.To, Desc: l.Desc, ThrowOrder: l.ThrowOrder, Completed: l.Completed, Phase: l.Phase})
+		}
+	}
+	return assigned, nil
+}
+
+func (s *memoryStore) LinkAssignedTo(opID OperationID, linkID LinkID, gid GoogleID) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	m := s.op(opID)
+	if m == nil {
+		return false, nil
+	}
+	l, ok := m.links[linkID]
+	return ok && gid != "" && l.AssignedTo == gid, nil
+}
+
+// updateLink applies f to a link if it exists
+func (s *memoryStore) updateLink(opID OperationID, linkID LinkID, f func(l *Link)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if m := s.op(opID); m != nil {
+		if l, ok := m.links[linkID]; ok {
+			f(&l)
+			m.links[linkID] = l
+		}
+	}
+	return nil
+}
+
+func (s *memoryStore) SetLinkAssignment(opID OperationID, linkID LinkID, gid GoogleID) error {
+	return s.updateLink(opID, linkID, func(l *Link) { l.AssignedTo = gid })
+}
+
+func (s *memoryStore) SetLinkDescription(opID OperationID, linkID LinkID, desc string) error {
+	return s.updateLink(opID, linkID, func(l *Link) { l.Desc = desc })
+}
+
+func (s *memoryStore) SetLinkCompleted(opID OperationID, linkID LinkID, completed bool) error {
+	return s.updateLink(opID, linkID, func(l *Link) { l.Completed = completed })
+}
+
+func (s *memoryStore) SetLinkColor(opID OperationID, linkID LinkID, color string) error {
+	return s.updateLink(opID, linkID, func(l *Link) { l.Color = color })
+}
+
+func (s *memoryStore) SetLinkThrowOrder(opID OperationID, linkID LinkID, order int32) error {
+	return s.updateLink(opID, linkID, func(l *Link) { l.ThrowOrder = order })
+}
+
+func (s *memoryStore) SwapLink(opID OperationID, linkID LinkID) error {
+	s.mu.RLock()
+	m := s.op(opID)
+	found := false
+	if m != nil {
+		_, found = m.links[linkID]
+	}
+	s.mu.RUnlock()
+
+	if !found {
+		return sql.ErrNoRows
+	}
+	return s.updateLink(opID, linkID, func(l *Link) { l.From, l.To = l.To, l.From })
+}
+
+func (s *memoryStore) InsertMarker(opID OperationID, mk Marker) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	m := s.op(opID)
+	if m == nil {
+		return fmt.Errorf("unknown operation: %s", opID)
+	}
+	if _, ok := m.markers[mk.ID]; ok {
+		return fmt.Errorf("duplicate marker: %s", mk.ID)
+	}
+	mk.IngressName = ""
+	mk.CompletedBy = ""
+	m.markers[mk.ID] = mk
+	return nil
+}
+
+func (s *memoryStore) MarkerCompleters(opID OperationID) (map[MarkerID]GoogleID, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	completers := make(map[MarkerID]GoogleID)
+	if m := s.op(opID); m != nil {
+		for id, mk := range m.markers {
+			if mk.CompletedBy != "" {
+				completers[id] = GoogleID(mk.CompletedBy)
+			}
+		}
+	}
+	return completers, nil
+}
+
+// Markers returns the markers with the agent names filled in; internally CompletedBy holds the GoogleID
+func (s *memoryStore) Markers(opID OperationID) ([]Marker, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var markers []Marker
+	m := s.op(opID)
+	if m == nil {
+		return markers, nil
+	}
+	for _, mk := range m.markers {
+		mk.IngressName = s.iname(mk.AssignedTo)
+		mk.CompletedBy = s.iname(GoogleID(mk.CompletedBy))
+		markers = append(markers, mk)
+	}
+	sort.SliceStable(markers, func(i, j int) bool {
+		if markers[i].Order != markers[j].Order {
+			return markers[i].Order < markers[j].Order
+		}
+		if markers[i].Type != markers[j].Type {
+			return markers[i].Type < markers[j].Type
+		}
+		return markers[i].ID < markers[j].ID
+	})
+	return markers, nil
+}
+
+func (s *memoryStore) AgentMarkers(opID OperationID, gid GoogleID) ([]Marker, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var markers []Marker
+	m := s.op(opID)
+	if m == nil {
+		return markers, nil
+	}
+	for _, mk := range m.markers {
+		if mk.AssignedTo == gid {
+			markers = append(markers, Marker{ID: mk.ID, PortalID: mk.PortalID, Type: mk.Type, AssignedTo: mk.AssignedTo, Comment: mk.Comment, State: mk.State, Phase: mk.Phase})
+		}
+	}
+	return markers, nil
+}
+
+func (s *memoryStore) MarkerAssignee(opID OperationID, markerID MarkerID) (GoogleID, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	m := s.op(opID)
+	if m == nil {
+		return "", sql.ErrNoRows
+	}
+	mk, ok := m.markers[markerID]
+	if !ok {
+		return "", sql.ErrNoRows
+	}
+	return mk.AssignedTo, nil
+}
+
+// updateMarker applies f to a marker if it exists
+func (s *memoryStore) updateMarker(opID OperationID, markerID MarkerID, f func(mk *Marker)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if m := s.op(opID); m != nil {
+		if mk, ok := m.markers[markerID]; ok {
+			f(&mk)
+			m.markers[markerID] = mk
+		}
+	}
+	return nil
+}
+
+func (s *memoryStore) SetMarkerAssignment(opID OperationID, markerID MarkerID, gid GoogleID, state string) error {
+	return s.updateMarker(opID, markerID, func(mk *Marker) {
+		mk.AssignedTo = gid
+		mk.State = state
+	})
+}
+
+func (s *memoryStore) SetAssignments(opID OperationID, assignments []PlannedAssignment) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	m := s.op(opID)
+	if m == nil {
+		return nil, sql.ErrNoRows
+	}
+	changed := []string{}
+	for _, a := range assignments {
+		if a.Agent == a.Previous {
+			continue
+		}
+		if a.Type == "link" {
+			if l, ok := m.links[LinkID(a.ID)]; !ok || l.AssignedTo != a.Previous {
+				changed = append(changed, a.ID)
+			}
+		} else if mk, ok := m.markers[MarkerID(a.ID)]; !ok || mk.AssignedTo != a.Previous {
+			changed = append(changed, a.ID)
+		}
+	}
+	if len(changed) > 0 {
+		return changed, nil
+	}
+
+	for _, a := range assignments {
+		if a.Agent == a.Previous {
+			continue
+		}
+		if a.Type == "link" {
+			l := m.links[LinkID(a.ID)]
+			l.AssignedTo = a.Agent
+			m.links[l.ID] = l
+		} else {
+			mk := m.markers[MarkerID(a.ID)]
+			mk.AssignedTo = a.Agent
+			mk.State = "assigned"
+			m.markers[mk.ID] = mk
+		}
+	}
+	return nil, nil
+}
+
+func (s *memoryStore) SetMarkerComment(opID OperationID, markerID MarkerID, comment string) error {
+	return s.updateMarker(opID, markerID, func(mk *Marker) { mk.Comment = comment })
+}
+
+func (s *memoryStore) SetMarkerState(opID OperationID, markerID MarkerID, state string) error {
+	return s.updateMarker(opID, markerID, func(mk *Marker) { mk.State = state })
+}
+
+func (s *memoryStore) SetMarkerCompleted(opID OperationID, markerID MarkerID, completedBy GoogleID) error {
+	return s.updateMarker(opID, markerID, func(mk *Marker) {
+		mk.State = "completed"
+		mk.CompletedBy = string(completedBy)
+	})
+}
+
+func (s *memoryStore) SetMarkerIncomplete(opID OperationID, markerID MarkerID) error {
+	return s.updateMarker(opID, markerID, func(mk *Marker) {
+		mk.State = "assigned"
+		mk.CompletedBy = ""
+	})
+}
+
+func (s *memoryStore) SetMarkerOrder(opID OperationID, markerID MarkerID, order int) error {
+	return s.updateMarker(opID, markerID, func(mk *Marker) { mk.Order = order })
+}
+
+func (s *memoryStore) InsertKey(opID OperationID, k KeyOnHand) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	m := s.op(opID)
+	if m == nil {
+		return fmt.Errorf("unknown operation: %s", opID)
+	}
+	m.keys[memKeyID{k.ID, k.Gid}] = k
+	return nil
+}
+
+func (s *memoryStore) Keys(opID OperationID) ([]KeyOnHand, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var keys []KeyOnHand
+	m := s.op(opID)
+	if m == nil {
+		return keys, nil
+	}
+	for _, k := range m.keys {
+		keys = append(keys, k)
+	}
+	sort.SliceStable(keys, func(i, j int) bool {
+		if keys[i].ID != keys[j].ID {
+			return keys[i].ID < keys[j].ID
+		}
+		return keys[i].Gid < keys[j].Gid
+	})
+	return keys, nil
+}
+
+func (s *memoryStore) InsertAuditEntry(e AuditEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e.ID = len(s.audit) + 1
+	e.Name = ""
+	e.Recorded = time.Now().UTC().Format(memTimeFormat)
+	s.audit = append(s.audit, e)
+	return nil
+}
+
+func (s *memoryStore) AuditEntries(opID OperationID, filter AuditFilter) ([]AuditEntry, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var entries []AuditEntry
+	skip := filter.Offset
+	for i := len(s.audit) - 1; i >= 0 && len(entries) < filter.Limit; i-- {
+		e := s.audit[i]
+		if e.OpID != opID || (filter.Gid != "" && e.Gid != filter.Gid) || (filter.Type != "" && e.Type != filter.Type) || (filter.ObjectID != "" && e.ObjectID != filter.ObjectID) {
+			continue
+		}
+		if skip > 0 {
+			skip--
+			continue
+		}
+		e.Name = s.iname(e.Gid)
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
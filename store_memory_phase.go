@@ -0,0 +1,128 @@
+package wasabee
+
+import (
+	"database/sql"
+	"sort"
+	"time"
+)
+
+type memPhase struct {
+	Phase
+	reminded bool
+}
+
+func (s *memoryStore) SetPhase(opID OperationID, p Phase) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	m := s.op(opID)
+	if m == nil {
+		// matches the foreign key
+		return sql.ErrNoRows
+	}
+	if m.phases == nil {
+		m.phases = make(map[PhaseID]memPhase)
+	}
+	p.OpID = opID
+	cur, ok := m.phases[p.ID]
+	m.phases[p.ID] = memPhase{Phase: p, reminded: ok && cur.reminded && cur.Start == p.Start}
+	return nil
+}
+
+func sortPhases(phases []Phase) {
+	sort.Slice(phases, func(i, j int) bool {
+		if phases[i].Start != phases[j].Start {
+			return phases[i].Start < phases[j].Start
+		}
+		return phases[i].ID < phases[j].ID
+	})
+}
+
+func (s *memoryStore) Phases(opID OperationID) ([]Phase, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var phases []Phase
+	if m := s.op(opID); m != nil {
+		for _, p := range m.phases {
+			phases = append(phases, p.Phase)
+		}
+	}
+	sortPhases(phases)
+	return phases, nil
+}
+
+func (s *memoryStore) Phase(opID OperationID, phaseID PhaseID) (Phase, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if m := s.op(opID); m != nil {
+		if p, ok := m.phases[phaseID]; ok {
+			return p.Phase, nil
+		}
+	}
+	return Phase{}, sql.ErrNoRows
+}
+
+func (s *memoryStore) DeletePhase(opID OperationID, phaseID PhaseID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	m := s.op(opID)
+	if m == nil {
+		return nil
+	}
+	delete(m.phases, phaseID)
+	for id, l := range m.links {
+		if l.Phase == phaseID {
+			l.Phase = ""
+			m.links[id] = l
+		}
+	}
+	for id, mk := range m.markers {
+		if mk.Phase == phaseID {
+			mk.Phase = ""
+			m.markers[id] = mk
+		}
+	}
+	return nil
+}
+
+func (s *memoryStore) SetLinkPhase(opID OperationID, linkID LinkID, phaseID PhaseID) error {
+	return s.updateLink(opID, linkID, func(l *Link) { l.Phase = phaseID })
+}
+
+func (s *memoryStore) SetMarkerPhase(opID OperationID, markerID MarkerID, phaseID PhaseID) error {
+	return s.updateMarker(opID, markerID, func(mk *Marker) { mk.Phase = phaseID })
+}
+
+func (s *memoryStore) PhasesToRemind(before time.Time) ([]Phase, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	now := time.Now().UTC().Format(phaseTimeFormat)
+	until := before.UTC().Format(phaseTimeFormat)
+	var phases []Phase
+	for _, m := range s.ops {
+		for _, p := range m.phases {
+			if !p.reminded && p.Start > now && p.Start <= until {
+				phases = append(phases, p.Phase)
+			}
+		}
+	}
+	sortPhases(phases)
+	return phases, nil
+}
+
+func (s *memoryStore) SetPhaseReminded(opID OperationID, phaseID PhaseID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if m := s.op(opID); m != nil {
+		if p, ok := m.phases[phaseID]; ok {
+			p.reminded = true
+			m.phases[phaseID] = p
+		}
+	}
+	return nil
+}
@@ -0,0 +1,417 @@
+package wasabee
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+type memTeam struct {
+	owner     GoogleID
+	name      string
+	rockskey  string
+	rockscomm string
+}
+
+type memTeamAgent struct {
+	state       string
+	squad       string
+	displayname string
+}
+
+func (s *memoryStore) InsertTeam(teamID TeamID, owner GoogleID, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.teams[teamID]; ok {
+		return fmt.Errorf("duplicate team: %s", teamID)
+	}
+	s.teams[teamID] = &memTeam{owner: owner, name: name}
+	s.agentteams[teamID] = map[GoogleID]*memTeamAgent{
+		owner: {state: "On", squad: "operator"},
+	}
+	return nil
+}
+
+func (s *memoryStore) DeleteTeam(teamID TeamID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.deleteTeam(teamID)
+	return nil
+}
+
+// deleteTeam removes a team and everything that references it; the caller must hold the lock
+func (s *memoryStore) deleteTeam(teamID TeamID) {
+	delete(s.teams, teamID)
+	delete(s.agentteams, teamID)
+	for id, h := range s.webhooks {
+		if h.TeamID == teamID {
+			s.deleteWebhook(id)
+		}
+	}
+	for _, o := range s.ops {
+		var teams []ExtendedTeam
+		for _, t := range o.teams {
+			if t.TeamID != teamID {
+				teams = append(teams, t)
+			}
+		}
+		o.teams = teams
+	}
+}
+
+func (s *memoryStore) TeamData(teamID TeamID, td *TeamData) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	t, ok := s.teams[teamID]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	td.Name = t.name
+	td.RocksComm = t.rockscomm
+	td.RocksKey = t.rockskey
+	return nil
+}
+
+func (s *memoryStore) TeamName(teamID TeamID) (string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	t, ok := s.teams[teamID]
+	if !ok {
+		return "", sql.ErrNoRows
+	}
+	return t.name, nil
+}
+
+func (s *memoryStore) SetTeamName(teamID TeamID, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if t, ok := s.teams[teamID]; ok {
+		t.name = name
+	}
+	return nil
+}
+
+func (s *memoryStore) TeamOwner(teamID TeamID) (GoogleID, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	t, ok := s.teams[teamID]
+	if !ok {
+		return "", sql.ErrNoRows
+	}
+	return t.owner, nil
+}
+
+func (s *memoryStore) SetTeamOwner(teamID TeamID, gid GoogleID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if t, ok := s.teams[teamID]; ok {
+		t.owner = gid
+	}
+	return nil
+}
+
+func (s *memoryStore) SetTeamRocks(teamID TeamID, key, community string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if t, ok := s.teams[teamID]; ok {
+		t.rockskey = key
+		t.rockscomm = community
+	}
+	return nil
+}
+
+func (s *memoryStore) TeamRocksKey(teamID TeamID) (string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	t, ok := s.teams[teamID]
+	if !ok {
+		return "", sql.ErrNoRows
+	}
+	return t.rockskey, nil
+}
+
+func (s *memoryStore) RocksTeamID(community string) (TeamID, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for teamID, t := range s.teams {
+		if t.rockscomm != "" && t.rockscomm == community {
+			return teamID, nil
+		}
+	}
+	return "", sql.ErrNoRows
+}
+
+func (s *memoryStore) TeamAgents(teamID TeamID, fetchAll bool) ([]Agent, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var agents []Agent
+	if _, ok := s.teams[teamID]; !ok {
+		return agents, nil
+	}
+	for gid, x := range s.agentteams[teamID] {
+		if !fetchAll && x.state != "On" {
+			continue
+		}
+		a, ok := s.agents[gid]
+		if !ok {
+			continue
+		}
+		agents = append(agents, Agent{
+			Gid:         gid,
+			Name:        a.IngressName,
+			Squad:       x.squad,
+			State:       x.state == "On",
+			Lat:         a.lat,
+			Lon:         a.lon,
+			Date:        a.upTime.UTC().Format(memTimeFormat),
+			Verified:    a.VVerified,
+			Blacklisted: a.VBlacklisted,
+			EnlID:       a.Vid,
+			DisplayName: x.displayname,
+		})
+	}
+	sort.SliceStable(agents, func(i, j int) bool {
+		if agents[i].State != agents[j].State {
+			return agents[i].State
+		}
+		return agents[i].Name < agents[j].Name
+	})
+	return agents, nil
+}
+
+func (s *memoryStore) TeamMembers(teamID TeamID, activeOnly bool) ([]GoogleID, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var gids []GoogleID
+	for gid, x := range s.agentteams[teamID] {
+		if activeOnly && x.state == "Off" {
+			continue
+		}
+		gids = append(gids, gid)
+	}
+	return gids, nil
+}
+
+func (s *memoryStore) AgentInTeam(gid GoogleID, teamID TeamID, allowOff bool) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	x, ok := s.agentteams[teamID][gid]
+	if !ok {
+		return false, nil
+	}
+	return allowOff || x.state == "On", nil
+}
+
+func (s *memoryStore) AddTeamAgent(teamID TeamID, gid GoogleID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.teams[teamID]; !ok {
+		return fmt.Errorf("unknown team: %s", teamID)
+	}
+	if _, ok := s.agents[gid]; !ok {
+		return fmt.Errorf("unknown agent: %s", gid)
+	}
+	if _, ok := s.agentteams[teamID][gid]; ok {
+		return nil
+	}
+	if s.agentteams[teamID] == nil {
+		s.agentteams[teamID] = make(map[GoogleID]*memTeamAgent)
+	}
+	s.agentteams[teamID][gid] = &memTeamAgent{state: "Off", squad: "boots"}
+	return nil
+}
+
+func (s *memoryStore) RemoveTeamAgent(teamID TeamID, gid GoogleID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.agentteams[teamID], gid)
+	return nil
+}
+
+func (s *memoryStore) SetTeamAgentState(teamID TeamID, gid GoogleID, state string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if x, ok := s.agentteams[teamID][gid]; ok {
+		x.state = state
+	}
+	return nil
+}
+
+func (s *memoryStore) SetTeamAgentSquad(teamID TeamID, gid GoogleID, squad string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if x, ok := s.agentteams[teamID][gid]; ok {
+		x.squad = squad
+	}
+	return nil
+}
+
+func (s *memoryStore) SetTeamAgentDisplayName(teamID TeamID, gid GoogleID, displayname string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if x, ok := s.agentteams[teamID][gid]; ok {
+		x.displayname = displayname
+	}
+	return nil
+}
+
+func (s *memoryStore) TeamAgentDisplayName(teamID TeamID, gid GoogleID) (string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	x, ok := s.agentteams[teamID][gid]
+	if !ok {
+		return "", sql.ErrNoRows
+	}
+	return x.displayname, nil
+}
+
+func (s *memoryStore) AgentTeams(gid GoogleID) ([]AdTeam, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var teams []AdTeam
+	for teamID, members := range s.agentteams {
+		x, ok := members[gid]
+		if !ok {
+			continue
+		}
+		t, ok := s.teams[teamID]
+		if !ok {
+			continue
+		}
+		teams = append(teams, AdTeam{
+			ID:        string(teamID),
+			Name:      t.name,
+			State:     x.state,
+			RocksComm: t.rockscomm,
+		})
+	}
+	sort.SliceStable(teams, func(i, j int) bool { return teams[i].Name < teams[j].Name })
+	return teams, nil
+}
+
+func (s *memoryStore) AgentOwnedTeams(gid GoogleID) ([]AdOwnedTeam, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var teams []AdOwnedTeam
+	for teamID, t := range s.teams {
+		if t.owner != gid {
+			continue
+		}
+		teams = append(teams, AdOwnedTeam{
+			ID:        string(teamID),
+			Name:      t.name,
+			RocksComm: t.rockscomm,
+			RocksKey:  t.rockskey,
+		})
+	}
+	sort.SliceStable(teams, func(i, j int) bool { return teams[i].Name < teams[j].Name })
+	return teams, nil
+}
+
+func (s *memoryStore) AgentTeamIDs(gid GoogleID) ([]TeamID, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var teams []TeamID
+	for teamID, members := range s.agentteams {
+		if _, ok := members[gid]; ok {
+			teams = append(teams, teamID)
+		}
+	}
+	return teams, nil
+}
+
+func (s *memoryStore) TeammatesNear(gid GoogleID, maxdistance, maxresults int) ([]Agent, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var agents []Agent
+	me, ok := s.agents[gid]
+	if !ok {
+		return agents, sql.ErrNoRows
+	}
+
+	cutoff := time.Now().Add(-12 * time.Hour)
+	seen := make(map[GoogleID]bool)
+	for _, members := range s.agentteams {
+		if x, ok := members[gid]; !ok || x.state != "On" {
+			continue
+		}
+		for g, x := range members {
+			a, ok := s.agents[g]
+			if seen[g] || !ok || x.state != "On" || a.upTime.Before(cutoff) {
+				continue
+			}
+			distance := math.Round(memDistance(me.lat, me.lon, a.lat, a.lon))
+			if distance <= 0 || distance >= float64(maxdistance) {
+				continue
+			}
+			seen[g] = true
+			agents = append(agents, Agent{
+				Name:        a.IngressName,
+				Squad:       x.squad,
+				State:       true,
+				Lat:         a.lat,
+				Lon:         a.lon,
+				Date:        a.upTime.UTC().Format(memTimeFormat),
+				Verified:    a.VVerified,
+				Blacklisted: a.VBlacklisted,
+				Distance:    distance,
+			})
+		}
+	}
+	sort.SliceStable(agents, func(i, j int) bool { return agents[i].Distance < agents[j].Distance })
+	if len(agents) > maxresults {
+		agents = agents[:maxresults]
+	}
+	return agents, nil
+}
+
+// memDistance is the same spherical law of cosines used by the MariaDB query, in km
+func memDistance(lat1, lon1, lat2, lon2 float64) float64 {
+	rad := math.Pi / 180
+	d := math.Cos(lat1*rad)*math.Cos(lat2*rad)*math.Cos(lon2*rad-lon1*rad) + math.Sin(lat1*rad)*math.Sin(lat2*rad)
+	if d > 1 {
+		d = 1
+	}
+	return 6371 * math.Acos(d)
+}
+
+func (s *memoryStore) CanSendTo(from, to GoogleID) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for teamID, t := range s.teams {
+		if t.owner != from {
+			continue
+		}
+		if x, ok := s.agentteams[teamID][to]; ok && !strings.EqualFold(x.state, "Off") {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,192 @@
+package wasabee
+
+import (
+	"database/sql"
+	"sort"
+	"time"
+)
+
+func (s *memoryStore) SetWave(opID OperationID, w Wave) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	m := s.op(opID)
+	if m == nil {
+		// matches the foreign key
+		return sql.ErrNoRows
+	}
+	if m.waves == nil {
+		m.waves = make(map[WaveID]Wave)
+		m.wavelinks = make(map[LinkID]WaveLink)
+	}
+	w.OpID = opID
+	if cur, ok := m.waves[w.ID]; ok && cur.At == w.At {
+		w.notified = cur.notified
+	} else {
+		w.notified = sql.NullInt64{}
+	}
+	keep := make(map[LinkID]bool)
+	for _, l := range w.Links {
+		keep[l] = true
+	}
+	for id, wl := range m.wavelinks {
+		if wl.Wave == w.ID && !keep[id] {
+			delete(m.wavelinks, id)
+		}
+	}
+	now := time.Now().UTC().Format(phaseTimeFormat)
+	for l := range keep {
+		// a link already in this wave keeps its completion; one done before it joined counts as done when it joined
+		if wl, ok := m.wavelinks[l]; !ok || wl.Wave != w.ID {
+			wl = WaveLink{ID: l, Wave: w.ID}
+			if m.links[l].Completed {
+				wl.Completed = now
+			}
+			m.wavelinks[l] = wl
+		}
+	}
+	w.Links = nil
+	m.waves[w.ID] = w
+	return nil
+}
+
+// memWaveLinks is the links in the wave in throw order; the lock must be held
+func (m *memOperation) memWaveLinks(waveID WaveID) []WaveLink {
+	var links []WaveLink
+	for _, wl := range m.wavelinks {
+		if wl.Wave == waveID {
+			links = append(links, wl)
+		}
+	}
+	sort.Slice(links, func(i, j int) bool {
+		a, b := m.links[links[i].ID].ThrowOrder, m.links[links[j].ID].ThrowOrder
+		if a != b {
+			return a < b
+		}
+		return links[i].ID < links[j].ID
+	})
+	return links
+}
+
+func (m *memOperation) memWave(w Wave) Wave {
+	w.Links = []LinkID{}
+	for _, wl := range m.memWaveLinks(w.ID) {
+		w.Links = append(w.Links, wl.ID)
+	}
+	return w
+}
+
+func sortWaves(waves []Wave) {
+	sort.Slice(waves, func(i, j int) bool {
+		if waves[i].At != waves[j].At {
+			return waves[i].At < waves[j].At
+		}
+		return waves[i].ID < waves[j].ID
+	})
+}
+
+func (s *memoryStore) Waves(opID OperationID) ([]Wave, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var waves []Wave
+	if m := s.op(opID); m != nil {
+		for _, w := range m.waves {
+			waves = append(waves, m.memWave(w))
+		}
+	}
+	sortWaves(waves)
+	return waves, nil
+}
+
+func (s *memoryStore) Wave(opID OperationID, waveID WaveID) (Wave, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if m := s.op(opID); m != nil {
+		if w, ok := m.waves[waveID]; ok {
+			return m.memWave(w), nil
+		}
+	}
+	return Wave{}, sql.ErrNoRows
+}
+
+func (s *memoryStore) DeleteWave(opID OperationID, waveID WaveID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	m := s.op(opID)
+	if m == nil {
+		return nil
+	}
+	delete(m.waves, waveID)
+	for id, wl := range m.wavelinks {
+		if wl.Wave == waveID {
+			delete(m.wavelinks, id)
+		}
+	}
+	return nil
+}
+
+func (s *memoryStore) WaveLinks(opID OperationID, waveID WaveID) ([]WaveLink, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if m := s.op(opID); m != nil {
+		return m.memWaveLinks(waveID), nil
+	}
+	return nil, nil
+}
+
+func (s *memoryStore) SetWaveLinkCompleted(opID OperationID, linkID LinkID, completed bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	m := s.op(opID)
+	if m == nil {
+		return nil
+	}
+	wl, ok := m.wavelinks[linkID]
+	if !ok {
+		return nil
+	}
+	// reporting it done again does not move the time it was first done
+	if !completed {
+		wl.Completed = ""
+	} else if wl.Completed == "" {
+		wl.Completed = time.Now().UTC().Format(phaseTimeFormat)
+	}
+	m.wavelinks[linkID] = wl
+	return nil
+}
+
+func (s *memoryStore) WavesBetween(from, to time.Time) ([]Wave, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	f := from.UTC().Format(phaseTimeFormat)
+	t := to.UTC().Format(phaseTimeFormat)
+	var waves []Wave
+	for _, m := range s.ops {
+		for _, w := range m.waves {
+			if w.At >= f && w.At <= t {
+				waves = append(waves, m.memWave(w))
+			}
+		}
+	}
+	sortWaves(waves)
+	return waves, nil
+}
+
+func (s *memoryStore) SetWaveNotified(opID OperationID, waveID WaveID, mark int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if m := s.op(opID); m != nil {
+		if w, ok := m.waves[waveID]; ok {
+			w.notified = sql.NullInt64{Int64: int64(mark), Valid: true}
+			m.waves[waveID] = w
+		}
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package wasabee
+
+import (
+	"database/sql"
+	"sort"
+	"time"
+)
+
+func (s *memoryStore) InsertWebhook(h Webhook) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	h.Created = time.Now().UTC().Format(memTimeFormat)
+	s.webhooks[h.ID] = &h
+	return nil
+}
+
+func (s *memoryStore) DeleteWebhook(hookID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.deleteWebhook(hookID)
+	return nil
+}
+
+// deleteWebhook removes a webhook and its delivery log; the caller must hold the lock
+func (s *memoryStore) deleteWebhook(hookID string) {
+	delete(s.webhooks, hookID)
+	var deliveries []WebhookDelivery
+	for _, d := range s.deliveries {
+		if d.HookID != hookID {
+			deliveries = append(deliveries, d)
+		}
+	}
+	s.deliveries = deliveries
+}
+
+func (s *memoryStore) Webhook(hookID string) (Webhook, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	h, ok := s.webhooks[hookID]
+	if !ok {
+		return Webhook{}, sql.ErrNoRows
+	}
+	return *h, nil
+}
+
+func (s *memoryStore) OpWebhooks(opID OperationID) ([]Webhook, error) {
+	return s.findWebhooks(func(h *Webhook) bool { return h.OpID == opID }), nil
+}
+
+func (s *memoryStore) TeamWebhooks(teamID TeamID) ([]Webhook, error) {
+	return s.findWebhooks(func(h *Webhook) bool { return h.TeamID == teamID }), nil
+}
+
+func (s *memoryStore) findWebhooks(match func(h *Webhook) bool) []Webhook {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var hooks []Webhook
+	for _, h := range s.webhooks {
+		if match(h) {
+			hooks = append(hooks, *h)
+		}
+	}
+	sort.SliceStable(hooks, func(i, j int) bool {
+		if hooks[i].Created != hooks[j].Created {
+			return hooks[i].Created < hooks[j].Created
+		}
+		return hooks[i].ID < hooks[j].ID
+	})
+	return hooks
+}
+
+func (s *memoryStore) InsertWebhookDelivery(d WebhookDelivery) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.webhooks[d.HookID]; !ok {
+		// matches the foreign key
+		return sql.ErrNoRows
+	}
+	d.ID = 1
+	if n := len(s.deliveries); n > 0 {
+		d.ID = s.deliveries[n-1].ID + 1
+	}
+	d.Delivered = time.Now().UTC().Format(memTimeFormat)
+	s.deliveries = append(s.deliveries, d)
+	return nil
+}
+
+func (s *memoryStore) WebhookDeliveries(hookID string, limit int) ([]WebhookDelivery, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var deliveries []WebhookDelivery
+	for i := len(s.deliveries) - 1; i >= 0 && len(deliveries) < limit; i-- {
+		if s.deliveries[i].HookID == hookID {
+			deliveries = append(deliveries, s.deliveries[i])
+		}
+	}
+	return deliveries, nil
+}
@@ -2,6 +2,7 @@ package wasabee
 
 import (
 	"bytes"
+	"fmt"
 	"html/template"
 	"io/ioutil"
 	"path"
@@ -74,8 +75,15 @@ func (gid GoogleID) ExecuteTemplate(name string, data interface{}) (string, erro
 	// XXX lookup agent's language setting
 	lang := "en"
 
+	t, ok := ts[lang]
+	if !ok {
+		err := fmt.Errorf("templates not loaded")
+		Log.Notice(err)
+		return "", err
+	}
+
 	var tpBuffer bytes.Buffer
-	if err := ts[lang].ExecuteTemplate(&tpBuffer, name, data); err != nil {
+	if err := t.ExecuteTemplate(&tpBuffer, name, data); err != nil {
 		Log.Notice(err)
 		return "", err
 	}